@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/greenpau/cni-plugins/pkg/firewall"
+	"github.com/greenpau/cni-plugins/pkg/utils"
 	"github.com/greenpau/versioned"
 	"os"
 )
@@ -32,17 +33,54 @@ func init() {
 }
 
 func main() {
-	var isShowVersion bool
+	var isShowVersion, isExplain bool
 
 	flag.BoolVar(&isShowVersion, "version", false, "version information")
+	flag.BoolVar(&isExplain, "explain", false, "explain failed checks with the rules of the container, as CNI_NFTABLES_EXPLAIN=true does")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "\n%s - %s\n\n", app.Name, app.Description)
-		fmt.Fprintf(os.Stderr, "Usage: %s [arguments]\n\n", app.Name)
+		fmt.Fprintf(os.Stderr, "Usage: %s [arguments]\n", app.Name)
+		fmt.Fprintf(os.Stderr, "       %s stats [-format table|json] [-table name] [container-id]\n", app.Name)
+		fmt.Fprintf(os.Stderr, "       %s inspect [-format text|json] [-tables names] [-network name] [-family ipv4|ipv6] [container-id-prefix]\n", app.Name)
+		fmt.Fprintf(os.Stderr, "       %s validate [-type name] <conflist-or-config-file>\n", app.Name)
+		fmt.Fprintf(os.Stderr, "       %s serve-metrics [-listen addr] [-textfile-dir dir] [-interval duration] [-table name]\n\n", app.Name)
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nDocumentation: %s\n\n", app.Documentation)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "stats" {
+		if err := firewall.Stats(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "inspect" {
+		if err := firewall.Inspect(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		if err := firewall.Validate(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "serve-metrics" {
+		if err := firewall.ServeMetrics(os.Args[2:], app.Name); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	flag.Parse()
 
 	if isShowVersion {
@@ -50,9 +88,16 @@ func main() {
 		os.Exit(0)
 	}
 
+	check := firewall.Check
+	if explain, err := utils.IsExplainEnabled(); err != nil {
+		check = func(*skel.CmdArgs) error { return err }
+	} else if isExplain || explain {
+		check = firewall.ExplainCheck
+	}
+
 	skel.PluginMain(
 		firewall.Add,
-		firewall.Check,
+		check,
 		firewall.Delete,
 		firewall.GetSupportedVersions(),
 		fmt.Sprintf("CNI %s plugin %s", app.Name, app.Version),
@@ -7,6 +7,7 @@ require (
 	github.com/containernetworking/plugins v1.0.1
 	github.com/google/nftables v0.1.0
 	github.com/greenpau/versioned v1.0.28
+	github.com/mdlayher/netlink v1.7.1
 	github.com/vishvananda/netlink v1.1.1-0.20210330154013-f5de75959ad5
 	github.com/vishvananda/netns v0.0.4
 	golang.org/x/sys v0.6.0
@@ -15,7 +16,6 @@ require (
 require (
 	github.com/google/go-cmp v0.5.9 // indirect
 	github.com/josharian/native v1.1.0 // indirect
-	github.com/mdlayher/netlink v1.7.1 // indirect
 	github.com/mdlayher/socket v0.4.0 // indirect
 	golang.org/x/net v0.8.0 // indirect
 	golang.org/x/sync v0.1.0 // indirect
@@ -0,0 +1,97 @@
+package firewall
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/containernetworking/cni/libcni"
+	current "github.com/containernetworking/cni/pkg/types/040"
+	"github.com/greenpau/cni-plugins/pkg/utils"
+)
+
+// resolveAttachmentParent returns the parent device of the macvlan or
+// ipvlan interfaces of the provided network, if any. The network
+// configuration is in the provided directory. A network without
+// configuration in the directory has no parent device.
+func resolveAttachmentParent(dir, network string) (string, error) {
+	confList, err := libcni.LoadConfList(dir, network)
+	switch err.(type) {
+	case nil:
+	case libcni.NotFoundError, libcni.NoConfigsFoundError:
+		return "", nil
+	default:
+		return "", fmt.Errorf("failed loading configuration of %s network: %s", network, err)
+	}
+	for _, plugin := range confList.Plugins {
+		if plugin.Network == nil {
+			continue
+		}
+		if plugin.Network.Type != "macvlan" && plugin.Network.Type != "ipvlan" {
+			continue
+		}
+		parentConf := struct {
+			Master string `json:"master"`
+		}{}
+		if err := json.Unmarshal(plugin.Bytes, &parentConf); err != nil {
+			return "", fmt.Errorf("failed parsing configuration of %s network: %s", network, err)
+		}
+		if parentConf.Master == "" {
+			return "", fmt.Errorf("network %s has no %s master device", network, plugin.Network.Type)
+		}
+		return parentConf.Master, nil
+	}
+	return "", nil
+}
+
+// resolveAttachment completes the attachment of the container found in the
+// result passed to the plugin. Without host side interfaces, the container
+// has a macvlan or ipvlan interface when the network has a parent device.
+func (p *Plugin) resolveAttachment(conf *Config) error {
+	if p.attachment != utils.AttachmentHostDevice {
+		return nil
+	}
+	parent, err := resolveAttachmentParent(conf.NetConfDir, conf.Name)
+	if err != nil {
+		return err
+	}
+	if parent != "" {
+		p.attachment = utils.AttachmentMacvlan
+		p.attachmentIntfName = parent
+	}
+	return nil
+}
+
+// addParentIngressRules creates the ingress chain of the parent device of
+// a macvlan or ipvlan attachment, when missing, and the rules of the
+// container in the chain.
+func (p *Plugin) addParentIngressRules(conf *Config) error {
+	chainName := utils.GetChainName("ing", p.attachmentIntfName)
+	exists, err := utils.IsNetdevChainExists(p.netdevTableName, chainName)
+	if err != nil {
+		return fmt.Errorf("failed obtaining %s chain info of netdev %s table: %s", chainName, p.netdevTableName, err)
+	}
+	if !exists {
+		if err := utils.CreateNetdevIngressChain(p.netdevTableName, chainName, p.attachmentIntfName); err != nil {
+			return err
+		}
+	}
+
+	addrs := []*current.IPConfig{}
+	for _, targetInterface := range p.getTargetInterfaces() {
+		addrs = append(addrs, targetInterface.addrs...)
+	}
+
+	if err := utils.SetNetdevIngressRules(
+		p.netdevTableName,
+		chainName,
+		conf.ContainerID,
+		addrs,
+		conf.ContainerPolicy,
+	); err != nil {
+		return fmt.Errorf(
+			"failed creating ingress rules in %s chain of netdev %s table: %s",
+			chainName, p.netdevTableName, err,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package firewall
+
+import (
+	"net"
+
+	"github.com/greenpau/cni-plugins/pkg/utils"
+)
+
+// addBandwidthRules creates the quota of the container, if any, and the
+// rules dropping the traffic of the container over its bandwidth limits
+// and its quota in the chain of the container.
+func (p *Plugin) addBandwidthRules(conf *Config, ffwChain, intfName string, counters *utils.ContainerCounters) error {
+	addrs := []net.IP{}
+	for _, targetInterface := range p.getTargetInterfaces() {
+		for _, addr := range targetInterface.addrs {
+			addrs = append(addrs, addr.Address.IP)
+		}
+	}
+
+	quotaName := ""
+	if conf.QuotaBytes > 0 {
+		quotaName = utils.GetContainerQuotaName(conf.ContainerID)
+	}
+	for _, v := range p.getTargetIPVersions() {
+		if quotaName != "" {
+			if err := utils.SetQuota(v, p.filterTableName, quotaName, conf.QuotaBytes); err != nil {
+				return err
+			}
+		}
+		if err := utils.SetFilterForwardBandwidthRules(
+			v,
+			p.filterTableName,
+			ffwChain,
+			intfName,
+			addrs,
+			conf.ContainerBandwidth,
+			quotaName,
+			counters,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeQuota deletes the quota of the container, if any. The quota is
+// deleted regardless of the quota_bytes option, because the option might
+// have been unset.
+func (p *Plugin) removeQuota(v, containerID string) error {
+	quotaName := utils.GetContainerQuotaName(containerID)
+	quota, err := utils.GetQuota(v, p.filterTableName, quotaName)
+	if err != nil {
+		return err
+	}
+	if quota == nil {
+		return nil
+	}
+	return utils.DeleteQuota(v, p.filterTableName, quotaName)
+}
@@ -3,10 +3,12 @@ package firewall
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/040"
 	"github.com/containernetworking/cni/pkg/version"
+	"github.com/greenpau/cni-plugins/pkg/utils"
 )
 
 // Config holds the configuration for the Plugin.
@@ -17,6 +19,108 @@ type Config struct {
 	ForwardFilterChainName  string `json:"forward_chain_name"`
 	NatTableName            string `json:"nat_table_name"`
 	PostRoutingNatChainName string `json:"postrouting_nat_chain_name"`
+	ForwardMode             string `json:"forward_mode"`
+
+	// SourceNat is the source NAT of the traffic of the containers. Without
+	// it, the IPv4 traffic is masqueraded.
+	SourceNat *utils.SourceNatConfig `json:"source_nat,omitempty"`
+
+	// IPv4NatMode and IPv6NatMode are the source NAT modes of the IPv4
+	// and IPv6 traffic of the containers, i.e. masquerade, snat, or
+	// none (routed). They override the mode of SourceNat.
+	IPv4NatMode string `json:"ipv4_nat_mode,omitempty"`
+	IPv6NatMode string `json:"ipv6_nat_mode,omitempty"`
+
+	// NoMasqueradeDestinations are the destination networks of the traffic
+	// of the containers keeping the source addresses of the containers.
+	NoMasqueradeDestinations []string `json:"noMasqueradeDestinations,omitempty"`
+
+	// ForwardDeny is the logging and the verdict of the packets reaching
+	// the end of the forwarding chain of filter table.
+	ForwardDeny *utils.DenyRuleConfig `json:"forward_deny,omitempty"`
+
+	// Policy is the firewall policy of the containers, unless overridden
+	// by the firewallPolicy runtime config or the FIREWALL_POLICY argument
+	// referring to one of the named Policies.
+	Policy        *utils.FirewallPolicy            `json:"policy,omitempty"`
+	Policies      map[string]*utils.FirewallPolicy `json:"policies,omitempty"`
+	RuntimeConfig struct {
+		FirewallPolicy *utils.FirewallPolicy `json:"firewallPolicy,omitempty"`
+		Mark           *uint32               `json:"mark,omitempty"`
+		DSCP           *uint8                `json:"dscp,omitempty"`
+		Bandwidth      *utils.BandwidthEntry `json:"bandwidth,omitempty"`
+	} `json:"runtimeConfig,omitempty"`
+
+	// Bandwidth is the bandwidth limits of the containers, unless
+	// overridden by the bandwidth capability in the runtime config. The
+	// traffic over the limits is dropped in the chain of the container.
+	// QuotaBytes is the number of bytes of the traffic of a container,
+	// per IP version, after which the traffic is dropped.
+	Bandwidth  *utils.BandwidthEntry `json:"bandwidth,omitempty"`
+	QuotaBytes uint64                `json:"quota_bytes,omitempty"`
+
+	// Mark and DSCP are the packet mark and the DSCP set on the traffic
+	// from the containers in the prerouting chain of mangle table, unless
+	// overridden by the mark and dscp runtime config. The mark is saved
+	// in the connection and restored on the replies.
+	Mark                      *uint32 `json:"mark,omitempty"`
+	DSCP                      *uint8  `json:"dscp,omitempty"`
+	MangleTableName           string  `json:"mangle_table_name"`
+	ManglePreRoutingChainName string  `json:"mangle_prerouting_chain_name"`
+
+	// Isolation drops the traffic forwarded between the bridge of the
+	// network and the other isolated bridges, except for the bridges of
+	// the networks in IsolationPeers. The bridges of the peer networks
+	// are looked up in the network configurations in NetConfDir.
+	Isolation          bool     `json:"isolation"`
+	IsolationPeers     []string `json:"isolation_peers,omitempty"`
+	IsolationChainName string   `json:"isolation_chain_name"`
+	NetConfDir         string   `json:"net_conf_dir"`
+
+	// IntraBridge is the handling of the traffic between the containers
+	// of the bridge, i.e. allow, deny, or peers. In peers mode, the traffic
+	// is allowed only from and to IntraBridgePeers. The BridgeRules option
+	// enforces the mode in the bridge family table too.
+	IntraBridge            string   `json:"intra_bridge"`
+	IntraBridgePeers       []string `json:"intra_bridge_peers,omitempty"`
+	BridgeRules            bool     `json:"bridge_rules"`
+	BridgeTableName        string   `json:"bridge_table_name"`
+	BridgeForwardChainName string   `json:"bridge_forward_chain_name"`
+
+	// AntiSpoofing drops the packets from the host interface of the
+	// container having a source address other than the addresses of the
+	// container. The MAC and IP addresses of the frames from the interface
+	// are pinned in the bridge family table too.
+	AntiSpoofing              bool   `json:"anti_spoofing"`
+	BridgePreRoutingChainName string `json:"bridge_prerouting_chain_name"`
+
+	// Flowtable offloads the established connections of the containers
+	// to the flowtable FlowtableName in filter table. The devices of the
+	// flowtable are the host interfaces of the containers and the uplink
+	// devices in FlowtableDevices.
+	Flowtable        bool     `json:"flowtable"`
+	FlowtableName    string   `json:"flowtable_name"`
+	FlowtableDevices []string `json:"flowtable_devices,omitempty"`
+
+	// NetdevTableName is the netdev family table holding the ingress
+	// chains of the parent devices of macvlan and ipvlan attachments. The
+	// parent devices are looked up in the network configurations in
+	// NetConfDir.
+	NetdevTableName string `json:"netdev_table_name"`
+
+	// DryRun writes the changes of ADD and DEL as an nft script instead
+	// of applying them. The CNI_NFTABLES_DRY_RUN environment variable
+	// enables the mode too.
+	DryRun *utils.DryRunConfig `json:"dry_run,omitempty"`
+
+	// ContainerPolicy is the firewall policy applied to the container.
+	ContainerPolicy *utils.FirewallPolicy `json:"-"`
+	// ContainerMark and ContainerDSCP are the packet mark and the DSCP
+	// applied to the container.
+	ContainerMark *uint32 `json:"-"`
+	ContainerDSCP *uint8  `json:"-"`
+	// ContainerBandwidth is the bandwidth limits applied to the container.
+	ContainerBandwidth *utils.BandwidthEntry `json:"-"`
 }
 
 func parseConfigFromBytes(data []byte) (*Config, *current.Result, error) {
@@ -29,26 +133,113 @@ func parseConfigFromBytes(data []byte) (*Config, *current.Result, error) {
 		return nil, nil, fmt.Errorf("unsupported CNI version %s", conf.CNIVersion)
 	}
 
-	// Default the filter table name to filter
-	if conf.FilterTableName == "" {
-		conf.FilterTableName = "filter"
+	setDefaultNames(conf)
+
+	if len(conf.IsolationPeers) > 0 && !conf.Isolation {
+		return nil, nil, fmt.Errorf("isolation peers require isolation")
 	}
 
-	// Default the forwarding chain name to forward
-	if conf.ForwardFilterChainName == "" {
-		conf.ForwardFilterChainName = "forward"
+	if conf.Flowtable && len(conf.FlowtableDevices) == 0 {
+		return nil, nil, fmt.Errorf("flowtable requires flowtable devices")
 	}
 
-	// Default the nat table name to nat
-	if conf.NatTableName == "" {
-		conf.NatTableName = "nat"
+	if err := utils.ValidateDSCP(conf.DSCP); err != nil {
+		return nil, nil, fmt.Errorf("invalid dscp: %v", err)
+	}
+	if err := utils.ValidateDSCP(conf.RuntimeConfig.DSCP); err != nil {
+		return nil, nil, fmt.Errorf("invalid runtime dscp: %v", err)
+	}
+	conf.ContainerMark = conf.Mark
+	if conf.RuntimeConfig.Mark != nil {
+		conf.ContainerMark = conf.RuntimeConfig.Mark
+	}
+	conf.ContainerDSCP = conf.DSCP
+	if conf.RuntimeConfig.DSCP != nil {
+		conf.ContainerDSCP = conf.RuntimeConfig.DSCP
 	}
 
-	// Default the postrouting chain name to postrouting
-	if conf.PostRoutingNatChainName == "" {
-		conf.PostRoutingNatChainName = "postrouting"
+	if err := utils.ValidateBandwidth(conf.Bandwidth); err != nil {
+		return nil, nil, fmt.Errorf("invalid bandwidth: %v", err)
+	}
+	if err := utils.ValidateBandwidth(conf.RuntimeConfig.Bandwidth); err != nil {
+		return nil, nil, fmt.Errorf("invalid runtime bandwidth: %v", err)
+	}
+	conf.ContainerBandwidth = conf.Bandwidth
+	if conf.RuntimeConfig.Bandwidth != nil {
+		conf.ContainerBandwidth = conf.RuntimeConfig.Bandwidth
+	}
+
+	// Default the intra bridge mode to allow
+	if conf.IntraBridge == "" {
+		conf.IntraBridge = "allow"
+	}
+	if err := utils.ValidateIntraInterfaceMode(conf.IntraBridge, conf.IntraBridgePeers); err != nil {
+		return nil, nil, err
+	}
+
+	// Default the forwarding mode to per-container chains
+	switch conf.ForwardMode {
+	case "":
+		conf.ForwardMode = "chain"
+	case "chain", "set":
+	default:
+		return nil, nil, fmt.Errorf("unsupported forward mode %s", conf.ForwardMode)
+	}
+
+	if err := utils.ValidateSourceNatConfig(conf.SourceNat); err != nil {
+		return nil, nil, fmt.Errorf("invalid source nat config: %v", err)
 	}
 
+	ipv4NatMode, err := utils.ValidateNatMode("4", conf.IPv4NatMode, conf.SourceNat)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid nat mode: %v", err)
+	}
+	conf.IPv4NatMode = ipv4NatMode
+	ipv6NatMode, err := utils.ValidateNatMode("6", conf.IPv6NatMode, conf.SourceNat)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid nat mode: %v", err)
+	}
+	conf.IPv6NatMode = ipv6NatMode
+
+	for _, destination := range conf.NoMasqueradeDestinations {
+		if _, err := utils.ParseMappingSource(destination); err != nil {
+			return nil, nil, fmt.Errorf("invalid no masquerade destination: %v", err)
+		}
+	}
+
+	if err := utils.ValidateDenyRuleConfig(conf.ForwardDeny); err != nil {
+		return nil, nil, fmt.Errorf("invalid forward deny config: %v", err)
+	}
+
+	// Validate firewall policies
+	if err := utils.ValidateFirewallPolicy(conf.Policy); err != nil {
+		return nil, nil, fmt.Errorf("invalid policy: %v", err)
+	}
+	for name, policy := range conf.Policies {
+		if err := utils.ValidateFirewallPolicy(policy); err != nil {
+			return nil, nil, fmt.Errorf("invalid %s policy: %v", name, err)
+		}
+	}
+	if err := utils.ValidateFirewallPolicy(conf.RuntimeConfig.FirewallPolicy); err != nil {
+		return nil, nil, fmt.Errorf("invalid runtime policy: %v", err)
+	}
+	conf.ContainerPolicy = conf.Policy
+	if conf.RuntimeConfig.FirewallPolicy != nil {
+		conf.ContainerPolicy = conf.RuntimeConfig.FirewallPolicy
+	}
+	if conf.ContainerPolicy != nil && conf.ForwardMode == "set" {
+		return nil, nil, fmt.Errorf("firewall policies are not supported in set forward mode")
+	}
+	if (conf.ContainerBandwidth != nil || conf.QuotaBytes > 0) && conf.ForwardMode == "set" {
+		return nil, nil, fmt.Errorf("bandwidth limits and quotas are not supported in set forward mode")
+	}
+
+	dryRun, err := utils.GetDryRunConfig(conf.DryRun)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid dry-run config: %v", err)
+	}
+	conf.DryRun = dryRun
+
 	// Parse previous result.
 	if conf.RawPrevResult == nil {
 		// return early if there was no previous result, which is allowed for DEL calls
@@ -57,7 +248,6 @@ func parseConfigFromBytes(data []byte) (*Config, *current.Result, error) {
 
 	// Parse previous result.
 	var result *current.Result
-	var err error
 	if err = version.ParsePrevResult(&conf.NetConf); err != nil {
 		return nil, nil, fmt.Errorf("could not parse prevResult: %v", err)
 	}
@@ -69,3 +259,98 @@ func parseConfigFromBytes(data []byte) (*Config, *current.Result, error) {
 
 	return conf, result, nil
 }
+
+// setDefaultNames sets the default names of the tables, the chains, and
+// the flowtable of the plugin, and the default network configuration
+// directory.
+func setDefaultNames(conf *Config) {
+	// Default the filter table name to filter
+	if conf.FilterTableName == "" {
+		conf.FilterTableName = "filter"
+	}
+
+	// Default the forwarding chain name to forward
+	if conf.ForwardFilterChainName == "" {
+		conf.ForwardFilterChainName = "forward"
+	}
+
+	// Default the nat table name to nat
+	if conf.NatTableName == "" {
+		conf.NatTableName = "nat"
+	}
+
+	// Default the postrouting chain name to postrouting
+	if conf.PostRoutingNatChainName == "" {
+		conf.PostRoutingNatChainName = "postrouting"
+	}
+
+	// Default the isolation chain name to cni-isolation
+	if conf.IsolationChainName == "" {
+		conf.IsolationChainName = "cni-isolation"
+	}
+
+	// Default the network configuration directory to /etc/cni/net.d
+	if conf.NetConfDir == "" {
+		conf.NetConfDir = "/etc/cni/net.d"
+	}
+
+	// Default the bridge family table name to filter
+	if conf.BridgeTableName == "" {
+		conf.BridgeTableName = "filter"
+	}
+
+	// Default the bridge family forwarding chain name to forward
+	if conf.BridgeForwardChainName == "" {
+		conf.BridgeForwardChainName = "forward"
+	}
+
+	// Default the bridge family prerouting chain name to prerouting
+	if conf.BridgePreRoutingChainName == "" {
+		conf.BridgePreRoutingChainName = "prerouting"
+	}
+
+	// Default the flowtable name to cni-ft
+	if conf.FlowtableName == "" {
+		conf.FlowtableName = "cni-ft"
+	}
+
+	// Default the netdev family table name to filter
+	if conf.NetdevTableName == "" {
+		conf.NetdevTableName = "filter"
+	}
+
+	// Default the mangle table name to mangle
+	if conf.MangleTableName == "" {
+		conf.MangleTableName = "mangle"
+	}
+
+	// Default the mangle prerouting chain name to prerouting
+	if conf.ManglePreRoutingChainName == "" {
+		conf.ManglePreRoutingChainName = "prerouting"
+	}
+}
+
+// applyPolicyArgs selects the named policy referred to by the FIREWALL_POLICY
+// argument, e.g. CNI_ARGS="FIREWALL_POLICY=restricted". The policy in the
+// runtime config takes precedence over the argument.
+func applyPolicyArgs(conf *Config, cniArgs string) error {
+	if conf.RuntimeConfig.FirewallPolicy != nil {
+		return nil
+	}
+	for _, pair := range strings.Split(cniArgs, ";") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) != "FIREWALL_POLICY" {
+			continue
+		}
+		name := strings.TrimSpace(kv[1])
+		policy, exists := conf.Policies[name]
+		if !exists {
+			return fmt.Errorf("unknown firewall policy %s", name)
+		}
+		if conf.ForwardMode == "set" {
+			return fmt.Errorf("firewall policies are not supported in set forward mode")
+		}
+		conf.ContainerPolicy = policy
+	}
+	return nil
+}
@@ -30,6 +30,144 @@ func TestValidateInput(t *testing.T) {
 			cniVersion: "0.4.0",
 			shouldErr:  true,
 		},
+		{
+			name:       "set_forward_mode",
+			path:       "testdata/firewall/results/result4.json",
+			cniVersion: "0.4.0",
+			shouldErr:  false,
+		},
+		{
+			name:       "unsupported_forward_mode",
+			path:       "testdata/firewall/results/result5.json",
+			cniVersion: "0.4.0",
+			shouldErr:  true,
+		},
+		{
+			name:       "policy",
+			path:       "testdata/firewall/results/result6.json",
+			cniVersion: "0.4.0",
+			shouldErr:  false,
+		},
+		{
+			name:       "invalid_policy",
+			path:       "testdata/firewall/results/result7.json",
+			cniVersion: "0.4.0",
+			shouldErr:  true,
+		},
+		{
+			name:       "isolation",
+			path:       "testdata/firewall/results/result8.json",
+			cniVersion: "0.4.0",
+			shouldErr:  false,
+		},
+		{
+			name:       "isolation_peers_without_isolation",
+			path:       "testdata/firewall/results/result9.json",
+			cniVersion: "0.4.0",
+			shouldErr:  true,
+		},
+		{
+			name:       "intra_bridge_peers",
+			path:       "testdata/firewall/results/result14.json",
+			cniVersion: "0.4.0",
+			shouldErr:  false,
+		},
+		{
+			name:       "intra_bridge_peers_in_deny_mode",
+			path:       "testdata/firewall/results/result15.json",
+			cniVersion: "0.4.0",
+			shouldErr:  true,
+		},
+		{
+			name:       "anti_spoofing",
+			path:       "testdata/firewall/results/result16.json",
+			cniVersion: "0.4.0",
+			shouldErr:  false,
+		},
+		{
+			name:       "forward_deny",
+			path:       "testdata/firewall/results/result17.json",
+			cniVersion: "0.4.0",
+			shouldErr:  false,
+		},
+		{
+			name:       "forward_deny_log_level_and_group",
+			path:       "testdata/firewall/results/result18.json",
+			cniVersion: "0.4.0",
+			shouldErr:  true,
+		},
+		{
+			name:       "source_nat",
+			path:       "testdata/firewall/results/result19.json",
+			cniVersion: "0.4.0",
+			shouldErr:  false,
+		},
+		{
+			name:       "source_nat_without_addresses",
+			path:       "testdata/firewall/results/result20.json",
+			cniVersion: "0.4.0",
+			shouldErr:  true,
+		},
+		{
+			name:       "no_masquerade_destinations",
+			path:       "testdata/firewall/results/result21.json",
+			cniVersion: "0.4.0",
+			shouldErr:  false,
+		},
+		{
+			name:       "invalid_no_masquerade_destination",
+			path:       "testdata/firewall/results/result22.json",
+			cniVersion: "0.4.0",
+			shouldErr:  true,
+		},
+		{
+			name:       "nat_modes",
+			path:       "testdata/firewall/results/result23.json",
+			cniVersion: "0.4.0",
+			shouldErr:  false,
+		},
+		{
+			name:       "snat_mode_without_address",
+			path:       "testdata/firewall/results/result24.json",
+			cniVersion: "0.4.0",
+			shouldErr:  true,
+		},
+		{
+			name:       "flowtable",
+			path:       "testdata/firewall/results/result25.json",
+			cniVersion: "0.4.0",
+			shouldErr:  false,
+		},
+		{
+			name:       "flowtable_without_devices",
+			path:       "testdata/firewall/results/result26.json",
+			cniVersion: "0.4.0",
+			shouldErr:  true,
+		},
+		{
+			name:       "mark_and_dscp",
+			path:       "testdata/firewall/results/result27.json",
+			cniVersion: "0.4.0",
+			shouldErr:  false,
+		},
+		{
+			name:       "dscp_out_of_range",
+			path:       "testdata/firewall/results/result28.json",
+			cniVersion: "0.4.0",
+			shouldErr:  true,
+		},
+		{
+			name:       "bandwidth_and_quota",
+			path:       "testdata/firewall/results/result29.json",
+			cniVersion: "0.4.0",
+			shouldErr:  false,
+		},
+		{
+			name:       "bandwidth_burst_without_rate",
+			path:       "testdata/firewall/results/result30.json",
+			cniVersion: "0.4.0",
+			shouldErr:  true,
+		},
 	}
 
 	for _, test := range tests {
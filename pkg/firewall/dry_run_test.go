@@ -0,0 +1,81 @@
+package firewall
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/greenpau/cni-plugins/pkg/utils"
+)
+
+func TestDryRun(t *testing.T) {
+	var tests = []struct {
+		name string
+		path string
+		want string
+	}{
+		{
+			name: "single dual stack interface",
+			path: "testdata/firewall/results/result10.json",
+			want: "testdata/firewall/nftables/config10.add.nft",
+		},
+		{
+			name: "two dual stack interfaces",
+			path: "testdata/firewall/results/result11.json",
+			want: "testdata/firewall/nftables/config11.add.nft",
+		},
+		{
+			name: "single ipv4 only interface",
+			path: "testdata/firewall/results/result12.json",
+			want: "testdata/firewall/nftables/config12.add.nft",
+		},
+		{
+			name: "single ipv6 only interface",
+			path: "testdata/firewall/results/result13.json",
+			want: "testdata/firewall/nftables/config13.add.nft",
+		},
+		{
+			name: "set forward mode",
+			path: "testdata/firewall/results/result4.json",
+			want: "testdata/firewall/nftables/config4.add.nft",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "plan.nft")
+			t.Setenv(utils.DryRunEnv, "true")
+			t.Setenv(utils.DryRunOutputEnv, output)
+			t.Setenv(utils.DryRunFormatEnv, "nft")
+			t.Setenv(utils.DryRunBaselineEnv, "empty")
+
+			b, err := utils.LoadDataFromFilePath(test.path)
+			if err != nil {
+				t.Fatalf("failed loading %s: %s", test.path, err)
+			}
+			containerID := "3e03fd5faebf8b7d8ca83d8"
+			args := &skel.CmdArgs{
+				ContainerID: containerID,
+				IfName:      "dummy0",
+				StdinData:   b,
+			}
+			if err := Add(args); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			got, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatalf("failed reading dry-run output: %s", err)
+			}
+			want, err := utils.LoadDataFromFilePath(test.want)
+			if err != nil {
+				t.Fatalf("failed loading %s: %s", test.want, err)
+			}
+			if strings.TrimSpace(string(got)) != strings.TrimSpace(string(want)) {
+				t.Fatalf("dry-run output mismatch\ngot:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package firewall
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/greenpau/cni-plugins/pkg/utils"
+)
+
+// addFlowOffload adds the host interface of the container and the uplink
+// devices to the flowtable, and the rules offloading the established
+// connections of the container to the flowtable.
+func (p *Plugin) addFlowOffload(containerID, intfName string) error {
+	addrs := []net.IP{}
+	for _, targetInterface := range p.getTargetInterfaces() {
+		for _, addr := range targetInterface.addrs {
+			addrs = append(addrs, addr.Address.IP)
+		}
+	}
+
+	devices := append([]string{intfName}, p.flowtableDevices...)
+	for _, v := range p.getTargetIPVersions() {
+		if err := utils.AddFlowtableDevices(v, p.filterTableName, p.flowtableName, devices); err != nil {
+			return err
+		}
+		if err := utils.SetFilterForwardFlowOffloadRules(
+			v,
+			p.filterTableName,
+			p.forwardFilterChainName,
+			p.flowtableName,
+			intfName,
+			containerID,
+			addrs,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeFlowOffload removes the rules offloading the established
+// connections of the container to the flowtable. The host interface of the
+// container is removed from the flowtable with the last container of the
+// interface, and the flowtable is deleted with the last container.
+func (p *Plugin) removeFlowOffload(v, containerID, intfName string) error {
+	if err := utils.SetFilterForwardFlowOffloadRules(
+		v,
+		p.filterTableName,
+		p.forwardFilterChainName,
+		p.flowtableName,
+		intfName,
+		containerID,
+		nil,
+	); err != nil {
+		return err
+	}
+
+	ft, err := utils.GetFlowtable(v, p.filterTableName, p.flowtableName)
+	if err != nil {
+		return fmt.Errorf("failed obtaining ipv%s flowtable %s info: %s", v, p.flowtableName, err)
+	}
+	if ft == nil {
+		return nil
+	}
+
+	interfaces, err := utils.GetFlowOffloadInterfaces(v, p.filterTableName, p.forwardFilterChainName)
+	if err != nil {
+		return err
+	}
+	if len(interfaces) == 0 {
+		return utils.DeleteFlowtable(v, p.filterTableName, p.flowtableName)
+	}
+	if interfaces[intfName] > 0 {
+		return nil
+	}
+	for _, device := range p.flowtableDevices {
+		if device == intfName {
+			return nil
+		}
+	}
+	for _, device := range ft.Devices {
+		if device == intfName {
+			return utils.RemoveFlowtableDevices(v, p.filterTableName, p.flowtableName, []string{intfName})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package firewall
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/containernetworking/cni/libcni"
+	"github.com/greenpau/cni-plugins/pkg/utils"
+)
+
+// defaultBridgeName is the bridge name used by the bridge plugin
+// when its configuration does not have one.
+const defaultBridgeName = "cni0"
+
+// resolvePeerBridges returns the names of the bridges of the provided
+// networks. The network configurations are in the provided directory.
+func resolvePeerBridges(dir string, networks []string) ([]string, error) {
+	bridges := []string{}
+	for _, network := range networks {
+		confList, err := libcni.LoadConfList(dir, network)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading configuration of %s network: %s", network, err)
+		}
+		found := false
+		for _, plugin := range confList.Plugins {
+			if plugin.Network == nil || plugin.Network.Type != "bridge" {
+				continue
+			}
+			bridgeConf := struct {
+				Bridge string `json:"bridge"`
+			}{}
+			if err := json.Unmarshal(plugin.Bytes, &bridgeConf); err != nil {
+				return nil, fmt.Errorf("failed parsing configuration of %s network: %s", network, err)
+			}
+			if bridgeConf.Bridge == "" {
+				bridgeConf.Bridge = defaultBridgeName
+			}
+			bridges = append(bridges, bridgeConf.Bridge)
+			found = true
+			break
+		}
+		if !found {
+			return nil, fmt.Errorf("network %s has no bridge", network)
+		}
+	}
+	return bridges, nil
+}
+
+// addBridgeIsolation isolates a bridge from the other bridges managed by
+// the plugin, except for its peers.
+func (p *Plugin) addBridgeIsolation(v, bridgeIntfName string, peers []string) error {
+	exists, err := utils.IsChainExists(v, p.filterTableName, p.isolationChainName)
+	if err != nil {
+		return fmt.Errorf("failed obtaining ipv%s %s chain info: %s", v, p.isolationChainName, err)
+	}
+	if !exists {
+		if err := utils.CreateFilterForwardIsolationChain(v, p.filterTableName, p.isolationChainName); err != nil {
+			return fmt.Errorf("failed creating ipv%s %s chain: %s", v, p.isolationChainName, err)
+		}
+	}
+	if err := utils.AddBridgeIsolation(v, p.filterTableName, p.isolationChainName, bridgeIntfName, peers); err != nil {
+		return fmt.Errorf("failed isolating ipv%s bridge %s: %s", v, bridgeIntfName, err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package firewall
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/greenpau/cni-plugins/pkg/utils"
+)
+
+// addMangleRules creates the prerouting chain of mangle table, when
+// missing, and the chain of the container setting the packet mark and the
+// DSCP of the traffic from the host interface of the container.
+func (p *Plugin) addMangleRules(conf *Config, intfName string) error {
+	mglChain := utils.GetChainName("mgl", conf.ContainerID)
+	addrs := []net.IP{}
+	for _, targetInterface := range p.getTargetInterfaces() {
+		for _, addr := range targetInterface.addrs {
+			addrs = append(addrs, addr.Address.IP)
+		}
+	}
+
+	for _, v := range p.getTargetIPVersions() {
+		exists, err := utils.IsTableExist(v, p.mangleTableName)
+		if err != nil {
+			return fmt.Errorf("failed obtaining ipv%s mangle table info: %s", v, err)
+		}
+		if !exists {
+			if err := utils.CreateTable(v, p.mangleTableName); err != nil {
+				return fmt.Errorf("failed creating ipv%s mangle table: %s", v, err)
+			}
+		}
+
+		exists, err = utils.IsChainExists(v, p.mangleTableName, p.manglePreRoutingChainName)
+		if err != nil {
+			return fmt.Errorf("failed obtaining ipv%s prerouting mangle chain info: %s", v, err)
+		}
+		if !exists {
+			if err := utils.CreateManglePreRoutingChain(v, p.mangleTableName, p.manglePreRoutingChainName); err != nil {
+				return fmt.Errorf("failed creating ipv%s prerouting mangle chain: %s", v, err)
+			}
+		}
+
+		exists, err = utils.IsChainExists(v, p.mangleTableName, mglChain)
+		if err != nil {
+			return fmt.Errorf("failed obtaining ipv%s mangle %s chain info: %s", v, mglChain, err)
+		}
+		if !exists {
+			if err := utils.CreateChain(v, p.mangleTableName, mglChain, "none", "none", "none"); err != nil {
+				return fmt.Errorf("failed creating ipv%s mangle %s chain: %s", v, mglChain, err)
+			}
+		}
+
+		jumpRule, err := utils.GetJumpRule(v, p.mangleTableName, p.manglePreRoutingChainName, mglChain)
+		if err != nil {
+			return err
+		}
+		if jumpRule == nil {
+			if err := utils.CreateJumpRule(v, p.mangleTableName, p.manglePreRoutingChainName, mglChain); err != nil {
+				return fmt.Errorf("failed creating jump rule to ipv%s mangle %s chain: %s", v, mglChain, err)
+			}
+		}
+
+		if err := utils.SetManglePreRoutingRules(
+			v,
+			p.mangleTableName,
+			mglChain,
+			intfName,
+			addrs,
+			conf.ContainerMark,
+			conf.ContainerDSCP,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeMangleRules deletes the chain of the container in mangle table and
+// the jump rule to the chain, if any. The chain is deleted regardless of
+// the mark and dscp options, because the options might have been unset.
+func (p *Plugin) removeMangleRules(v, containerID string) error {
+	mglChain := utils.GetChainName("mgl", containerID)
+	exists, err := utils.IsTableExist(v, p.mangleTableName)
+	if err != nil {
+		return fmt.Errorf("error checking ipv%s mangle table %s info: %s", v, p.mangleTableName, err)
+	}
+	if !exists {
+		return nil
+	}
+	exists, err = utils.IsChainExists(v, p.mangleTableName, mglChain)
+	if err != nil {
+		return fmt.Errorf("error checking ipv%s mangle container chain %s info: %s", v, mglChain, err)
+	}
+	if !exists {
+		return nil
+	}
+	exists, err = utils.IsChainExists(v, p.mangleTableName, p.manglePreRoutingChainName)
+	if err != nil {
+		return fmt.Errorf(
+			"error checking ipv%s prerouting chain %s info: %s",
+			v, p.manglePreRoutingChainName, err,
+		)
+	}
+	if exists {
+		if err := utils.DeleteJumpRule(v, p.mangleTableName, p.manglePreRoutingChainName, mglChain); err != nil {
+			return err
+		}
+	}
+	return utils.DeleteChain(v, p.mangleTableName, mglChain)
+}
@@ -0,0 +1,36 @@
+package firewall
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/greenpau/cni-plugins/pkg/utils"
+)
+
+// ServeMetrics exports the traffic counters and the quotas of the
+// containers in the Prometheus text format, i.e. the serve-metrics
+// subcommand. The counters
+// are served over HTTP, written to a node_exporter textfile directory, or
+// both.
+func ServeMetrics(args []string, name string) error {
+	fs := flag.NewFlagSet("serve-metrics", flag.ContinueOnError)
+	listen := fs.String("listen", "", "address serving the metrics over HTTP, e.g. :9742")
+	textfileDir := fs.String("textfile-dir", "", "node_exporter textfile collector directory")
+	interval := fs.Duration("interval", 15*time.Second, "interval of the writes to the textfile directory")
+	tableName := fs.String("table", "filter", "filter table holding the chains of the containers")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("serve-metrics accepts no arguments, found %d arguments", fs.NArg())
+	}
+
+	return utils.ServeMetrics(name, *listen, *textfileDir, *interval, func() (*utils.ContainerMetrics, error) {
+		metrics, err := utils.GetContainerMetrics(*tableName, []string{"ffw"})
+		if err != nil {
+			return nil, fmt.Errorf("failed obtaining counters: %s", err)
+		}
+		return metrics, nil
+	})
+}
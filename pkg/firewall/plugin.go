@@ -2,6 +2,8 @@ package firewall
 
 import (
 	"fmt"
+	"net"
+	"sort"
 
 	current "github.com/containernetworking/cni/pkg/types/040"
 	"github.com/greenpau/cni-plugins/pkg/utils"
@@ -15,33 +17,98 @@ type Interface struct {
 
 // Plugin represents the nftables firewall/filter CNI plugin.
 type Plugin struct {
-	name                    string
-	cniVersion              string
-	supportedVersions       []string
-	filterTableName         string
-	forwardFilterChainName  string
-	natTableName            string
-	postRoutingNatChainName string
-	interfaceChain          []string
-	targetInterfaces        map[string]*Interface
-	targetIPVersions        map[string]bool
+	name                      string
+	cniVersion                string
+	supportedVersions         []string
+	filterTableName           string
+	forwardFilterChainName    string
+	natTableName              string
+	postRoutingNatChainName   string
+	forwardMode               string
+	forwardDeny               *utils.DenyRuleConfig
+	isolation                 bool
+	isolationChainName        string
+	intraBridge               string
+	intraBridgePeers          []string
+	bridgeRules               bool
+	bridgeTableName           string
+	bridgeForwardChainName    string
+	antiSpoofing              bool
+	bridgePreRoutingChain     string
+	netdevTableName           string
+	flowtable                 bool
+	flowtableName             string
+	flowtableDevices          []string
+	mangleTableName           string
+	manglePreRoutingChainName string
+	attachment                string
+	attachmentIntfName        string
+	hostInterfaceName         string
+	containerMac              string
+	interfaceChain            []string
+	targetInterfaces          map[string]*Interface
+	targetIPVersions          map[string]bool
 }
 
 // NewPlugin returns an instance of Plugin.
 func NewPlugin(conf *Config) *Plugin {
 	return &Plugin{
-		name:                    "cni-nftables-firewall",
-		cniVersion:              "0.4.0",
-		supportedVersions:       supportedVersions,
-		filterTableName:         conf.FilterTableName,
-		forwardFilterChainName:  conf.ForwardFilterChainName,
-		natTableName:            conf.NatTableName,
-		postRoutingNatChainName: conf.PostRoutingNatChainName,
-		targetIPVersions:        make(map[string]bool),
-		interfaceChain:          []string{},
+		name:                      "cni-nftables-firewall",
+		cniVersion:                "0.4.0",
+		supportedVersions:         supportedVersions,
+		filterTableName:           conf.FilterTableName,
+		forwardFilterChainName:    conf.ForwardFilterChainName,
+		natTableName:              conf.NatTableName,
+		postRoutingNatChainName:   conf.PostRoutingNatChainName,
+		forwardMode:               conf.ForwardMode,
+		forwardDeny:               conf.ForwardDeny,
+		isolation:                 conf.Isolation,
+		isolationChainName:        conf.IsolationChainName,
+		intraBridge:               conf.IntraBridge,
+		intraBridgePeers:          conf.IntraBridgePeers,
+		bridgeRules:               conf.BridgeRules,
+		bridgeTableName:           conf.BridgeTableName,
+		bridgeForwardChainName:    conf.BridgeForwardChainName,
+		antiSpoofing:              conf.AntiSpoofing,
+		bridgePreRoutingChain:     conf.BridgePreRoutingChainName,
+		netdevTableName:           conf.NetdevTableName,
+		flowtable:                 conf.Flowtable,
+		flowtableName:             conf.FlowtableName,
+		flowtableDevices:          conf.FlowtableDevices,
+		mangleTableName:           conf.MangleTableName,
+		manglePreRoutingChainName: conf.ManglePreRoutingChainName,
+		targetIPVersions:          make(map[string]bool),
+		interfaceChain:            []string{},
 	}
 }
 
+// getTargetIPVersions returns the IP versions of the addresses of the
+// container in order, so that the rules are added in the same order
+// every time.
+func (p *Plugin) getTargetIPVersions() []string {
+	versions := []string{}
+	for v := range p.targetIPVersions {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// getTargetInterfaces returns the interfaces with the addresses of the
+// container in the order of their names.
+func (p *Plugin) getTargetInterfaces() []*Interface {
+	names := []string{}
+	for name := range p.targetInterfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	intfs := []*Interface{}
+	for _, name := range names {
+		intfs = append(intfs, p.targetInterfaces[name])
+	}
+	return intfs
+}
+
 // Add adds firewall rules.
 func (p *Plugin) Add(conf *Config, result *current.Result) error {
 	if err := p.execAdd(conf, result); err != nil {
@@ -70,8 +137,22 @@ func (p *Plugin) execAdd(conf *Config, prevResult *current.Result) error {
 	if err := p.validateInput(prevResult); err != nil {
 		return fmt.Errorf("failed validating input: %s", err)
 	}
+	if err := p.resolveAttachment(conf); err != nil {
+		return fmt.Errorf("failed resolving attachment: %s", err)
+	}
 
-	for v := range p.targetIPVersions {
+	switch p.attachment {
+	case utils.AttachmentHostDevice:
+		// The traffic of the container does not traverse the host.
+		return nil
+	case utils.AttachmentMacvlan:
+		return p.addParentIngressRules(conf)
+	}
+	if p.isolation && p.attachment != utils.AttachmentBridge {
+		return fmt.Errorf("isolation requires a bridge attachment, found %s", p.attachment)
+	}
+
+	for _, v := range p.getTargetIPVersions() {
 		exists, err := utils.IsTableExist(v, p.filterTableName)
 		if err != nil {
 			return fmt.Errorf("failed obtaining ipv%s filter table info: %s", v, err)
@@ -86,9 +167,15 @@ func (p *Plugin) execAdd(conf *Config, prevResult *current.Result) error {
 			return fmt.Errorf("failed obtaining ipv%s forward chain info: %s", v, err)
 		}
 		if !exists {
-			if err := utils.CreateFilterForwardChain(v, p.filterTableName, p.forwardFilterChainName); err != nil {
+			if err := utils.CreateFilterForwardChain(v, p.filterTableName, p.forwardFilterChainName, p.forwardDeny); err != nil {
 				return fmt.Errorf("failed creating ipv%s forward chain: %s", v, err)
 			}
+		} else if p.forwardDeny != nil {
+			if err := utils.SetFilterForwardDenyRules(v, p.filterTableName, p.forwardFilterChainName, p.forwardDeny); err != nil {
+				return fmt.Errorf("failed updating ipv%s forward chain default deny rules: %s", v, err)
+			}
+		} else if _, err := utils.RepairFilterForwardChain(v, p.filterTableName, p.forwardFilterChainName); err != nil {
+			return fmt.Errorf("failed repairing ipv%s forward chain: %s", v, err)
 		}
 
 		// NAT Table and Chains Setup
@@ -120,62 +207,80 @@ func (p *Plugin) execAdd(conf *Config, prevResult *current.Result) error {
 
 	}
 
-	// Set bridge interface name
-	bridgeIntfName := p.interfaceChain[0]
+	// Set the interface name, i.e. the bridge or, for a ptp attachment,
+	// the host side of the veth pair
+	bridgeIntfName := p.attachmentIntfName
+
+	// Isolate the bridge from the other bridges, or remove the isolation
+	// of the bridge when the option is no longer set.
+	var peers []string
+	if p.isolation {
+		var err error
+		if peers, err = resolvePeerBridges(conf.NetConfDir, conf.IsolationPeers); err != nil {
+			return err
+		}
+	}
+	for _, v := range p.getTargetIPVersions() {
+		if p.isolation {
+			if err := p.addBridgeIsolation(v, bridgeIntfName, peers); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := utils.RemoveBridgeIsolation(v, p.filterTableName, p.isolationChainName, bridgeIntfName); err != nil {
+			return fmt.Errorf("failed removing isolation of ipv%s bridge %s: %s", v, bridgeIntfName, err)
+		}
+	}
+
 	ffwChain := utils.GetChainName("ffw", conf.ContainerID)
 	npoChain := utils.GetChainName("npo", conf.ContainerID)
+	counters := utils.GetContainerCounters(conf.ContainerID)
 
-	for _, targetInterface := range p.targetInterfaces {
-		for _, addr := range targetInterface.addrs {
-			exists, err := utils.IsChainExists(addr.Version, p.filterTableName, ffwChain)
-			if err != nil {
-				return fmt.Errorf(
-					"failed obtaining ipv%s filter %s chain info: %s",
-					addr.Version, ffwChain, err,
-				)
-			}
+	ffsSet := utils.GetChainName("ffs", bridgeIntfName)
 
-			if !exists {
-				if err := utils.CreateChain(
+	for _, targetInterface := range p.getTargetInterfaces() {
+		for _, addr := range targetInterface.addrs {
+			if p.forwardMode == "set" {
+				if err := utils.AddFilterForwardSetRules(
 					addr.Version,
 					p.filterTableName,
-					ffwChain,
-					"none", "none", "none",
+					p.forwardFilterChainName,
+					ffsSet,
+					addr,
+					bridgeIntfName,
 				); err != nil {
 					return fmt.Errorf(
-						"failed creating ipv%s filter %s chain: %s",
-						addr.Version, ffwChain, err,
+						"failed creating set-based filter rules in ipv%s %s chain of %s table: %s",
+						addr.Version, p.forwardFilterChainName, p.filterTableName, err,
 					)
 				}
+			} else if err := p.addContainerFilterRules(conf, addr, ffwChain, bridgeIntfName, counters); err != nil {
+				return err
 			}
 
-			if err := utils.CreateJumpRule(
-				addr.Version,
-				p.filterTableName,
-				p.forwardFilterChainName,
-				ffwChain,
-			); err != nil {
-				return fmt.Errorf(
-					"failed creating jump rule to ipv%s filter %s chain: %s",
-					addr.Version, ffwChain, err,
-				)
+			// Restrict the traffic between the containers of the bridge
+			intraChain := ffwChain
+			if p.forwardMode == "set" {
+				intraChain = p.forwardFilterChainName
 			}
-
-			if err := utils.AddFilterForwardRules(
-				addr.Version,
-				p.filterTableName,
-				ffwChain,
-				addr,
-				bridgeIntfName,
-			); err != nil {
-				return fmt.Errorf(
-					"failed creating filter rules in ipv%s %s chain of %s table: %s",
-					addr.Version, ffwChain, p.filterTableName, err,
-				)
+			if p.attachment == utils.AttachmentBridge {
+				if err := utils.SetFilterForwardIntraInterfaceRules(
+					addr.Version,
+					p.filterTableName,
+					intraChain,
+					bridgeIntfName,
+					p.intraBridge,
+					p.intraBridgePeers,
+				); err != nil {
+					return fmt.Errorf(
+						"failed creating intra bridge filter rules in ipv%s %s chain of %s table: %s",
+						addr.Version, intraChain, p.filterTableName, err,
+					)
+				}
 			}
 
 			// Add postrouting nat rules
-			exists, err = utils.IsChainExists(addr.Version, p.natTableName, npoChain)
+			exists, err := utils.IsChainExists(addr.Version, p.natTableName, npoChain)
 			if err != nil {
 				return fmt.Errorf(
 					"failed obtaining ipv%s postrouting %s chain info: %s",
@@ -215,13 +320,39 @@ func (p *Plugin) execAdd(conf *Config, prevResult *current.Result) error {
 				)
 			}
 
+			natMode := conf.IPv4NatMode
+			if addr.Version == "6" {
+				natMode = conf.IPv6NatMode
+			}
+
+			// Keep the source addresses of the traffic to the
+			// no masquerade destinations
+			nmdSet := ""
+			if len(conf.NoMasqueradeDestinations) > 0 {
+				nmdSet = utils.GetChainName("nmd", bridgeIntfName)
+				if err := utils.SetNoMasqueradeDestinations(
+					addr.Version,
+					p.natTableName,
+					nmdSet,
+					conf.NoMasqueradeDestinations,
+				); err != nil {
+					return fmt.Errorf(
+						"failed setting no masquerade destinations in ipv%s %s table: %s",
+						addr.Version, p.natTableName, err,
+					)
+				}
+			}
+
 			if err := utils.AddPostRoutingRules(
 				map[string]interface{}{
-					"version":          addr.Version,
-					"table":            p.natTableName,
-					"chain":            npoChain,
-					"bridge_interface": bridgeIntfName,
-					"ip_address":       addr,
+					"version":           addr.Version,
+					"table":             p.natTableName,
+					"chain":             npoChain,
+					"bridge_interface":  bridgeIntfName,
+					"ip_address":        addr,
+					"source_nat":        conf.SourceNat,
+					"no_masquerade_set": nmdSet,
+					"nat_mode":          natMode,
 				},
 			); err != nil {
 				return fmt.Errorf(
@@ -232,6 +363,197 @@ func (p *Plugin) execAdd(conf *Config, prevResult *current.Result) error {
 		}
 	}
 
+	if p.forwardMode == "chain" {
+		if err := p.addBandwidthRules(conf, ffwChain, bridgeIntfName, counters); err != nil {
+			return fmt.Errorf("failed creating bandwidth rules: %s", err)
+		}
+	}
+
+	if p.antiSpoofing {
+		if err := p.addAntiSpoofingRules(ffwChain); err != nil {
+			return err
+		}
+	}
+
+	if p.flowtable {
+		if err := p.addFlowOffload(conf.ContainerID, bridgeIntfName); err != nil {
+			return fmt.Errorf("failed creating flow offload: %s", err)
+		}
+	}
+
+	if conf.ContainerMark != nil || conf.ContainerDSCP != nil {
+		if err := p.addMangleRules(conf, bridgeIntfName); err != nil {
+			return fmt.Errorf("failed creating mangle rules: %s", err)
+		}
+	}
+
+	if p.bridgeRules && p.attachment == utils.AttachmentBridge {
+		if err := utils.SetBridgeIntraInterfaceRules(
+			p.bridgeTableName,
+			p.bridgeForwardChainName,
+			bridgeIntfName,
+			p.intraBridge,
+			p.intraBridgePeers,
+		); err != nil {
+			return fmt.Errorf(
+				"failed creating intra bridge rules in %s chain of bridge %s table: %s",
+				p.bridgeForwardChainName, p.bridgeTableName, err,
+			)
+		}
+	}
+
+	return nil
+}
+
+// addAntiSpoofingRules adds the rules dropping the packets from the host
+// interface of the container having a source address other than the
+// addresses of the container, and the rules pinning the MAC and IP
+// addresses of the frames from the interface in the bridge family table.
+func (p *Plugin) addAntiSpoofingRules(ffwChain string) error {
+	if p.hostInterfaceName == "" {
+		return fmt.Errorf("anti-spoofing requires the host interface of the container")
+	}
+	var mac net.HardwareAddr
+	if p.attachment == utils.AttachmentBridge {
+		var err error
+		if mac, err = net.ParseMAC(p.containerMac); err != nil {
+			return fmt.Errorf("anti-spoofing requires the MAC address of the container: %s", err)
+		}
+	}
+
+	addrs := []net.IP{}
+	for _, targetInterface := range p.getTargetInterfaces() {
+		for _, addr := range targetInterface.addrs {
+			addrs = append(addrs, addr.Address.IP)
+		}
+	}
+
+	chainName := ffwChain
+	if p.forwardMode == "set" {
+		chainName = p.forwardFilterChainName
+	}
+	for _, v := range p.getTargetIPVersions() {
+		if err := utils.SetFilterForwardAntiSpoofingRules(
+			v,
+			p.filterTableName,
+			chainName,
+			p.hostInterfaceName,
+			addrs,
+		); err != nil {
+			return fmt.Errorf(
+				"failed creating anti-spoofing rules in ipv%s %s chain of %s table: %s",
+				v, chainName, p.filterTableName, err,
+			)
+		}
+	}
+
+	// The frames of a ptp attachment do not traverse a bridge.
+	if p.attachment != utils.AttachmentBridge {
+		return nil
+	}
+	if err := utils.SetBridgeAntiSpoofingRules(
+		p.bridgeTableName,
+		p.bridgePreRoutingChain,
+		p.hostInterfaceName,
+		mac,
+		addrs,
+	); err != nil {
+		return fmt.Errorf(
+			"failed creating anti-spoofing rules in %s chain of bridge %s table: %s",
+			p.bridgePreRoutingChain, p.bridgeTableName, err,
+		)
+	}
+	return nil
+}
+
+// addContainerFilterRules creates the per-container chain in filter
+// table, the jump rule to the chain, and the rules in the chain. When
+// the container has a firewall policy, the rules implement the policy.
+// The rules count the accepted and the dropped traffic of the container in
+// its named counters. The jump rule, created once per IP version, carries
+// the ID and the network of the container.
+func (p *Plugin) addContainerFilterRules(conf *Config, addr *current.IPConfig, ffwChain, bridgeIntfName string, counters *utils.ContainerCounters) error {
+	policy := conf.ContainerPolicy
+
+	if err := utils.AddCounters(addr.Version, p.filterTableName, counters.Names()); err != nil {
+		return err
+	}
+
+	exists, err := utils.IsChainExists(addr.Version, p.filterTableName, ffwChain)
+	if err != nil {
+		return fmt.Errorf(
+			"failed obtaining ipv%s filter %s chain info: %s",
+			addr.Version, ffwChain, err,
+		)
+	}
+
+	if !exists {
+		if err := utils.CreateChain(
+			addr.Version,
+			p.filterTableName,
+			ffwChain,
+			"none", "none", "none",
+		); err != nil {
+			return fmt.Errorf(
+				"failed creating ipv%s filter %s chain: %s",
+				addr.Version, ffwChain, err,
+			)
+		}
+	}
+
+	// The chain is shared by the addresses of the container of an IP
+	// version, and so is the jump rule to the chain.
+	if r, err := utils.GetJumpRule(addr.Version, p.filterTableName, p.forwardFilterChainName, ffwChain); err == nil && r == nil {
+		if err := utils.CreateJumpRuleWithComment(
+			addr.Version,
+			p.filterTableName,
+			p.forwardFilterChainName,
+			ffwChain,
+			utils.GetContainerInfoComment(conf.ContainerID, conf.Name),
+		); err != nil {
+			return fmt.Errorf(
+				"failed creating jump rule to ipv%s filter %s chain: %s",
+				addr.Version, ffwChain, err,
+			)
+		}
+	} else if err != nil {
+		return fmt.Errorf(
+			"failed check for jump rule to ipv%s filter %s chain: %s",
+			addr.Version, ffwChain, err,
+		)
+	}
+
+	if policy != nil {
+		if err := utils.AddFilterForwardPolicyRules(
+			addr.Version,
+			p.filterTableName,
+			ffwChain,
+			addr,
+			bridgeIntfName,
+			policy,
+			counters,
+		); err != nil {
+			return fmt.Errorf(
+				"failed creating policy filter rules in ipv%s %s chain of %s table: %s",
+				addr.Version, ffwChain, p.filterTableName, err,
+			)
+		}
+		return nil
+	}
+
+	if err := utils.AddFilterForwardRules(
+		addr.Version,
+		p.filterTableName,
+		ffwChain,
+		addr,
+		bridgeIntfName,
+		counters,
+	); err != nil {
+		return fmt.Errorf(
+			"failed creating filter rules in ipv%s %s chain of %s table: %s",
+			addr.Version, ffwChain, p.filterTableName, err,
+		)
+	}
 	return nil
 }
 
@@ -239,8 +561,26 @@ func (p *Plugin) execCheck(conf *Config, prevResult *current.Result) error {
 	if err := p.validateInput(prevResult); err != nil {
 		return fmt.Errorf("failed validating input: %s", err)
 	}
+	if err := p.resolveAttachment(conf); err != nil {
+		return fmt.Errorf("failed resolving attachment: %s", err)
+	}
 
-	for v := range p.targetIPVersions {
+	switch p.attachment {
+	case utils.AttachmentHostDevice:
+		return nil
+	case utils.AttachmentMacvlan:
+		chainName := utils.GetChainName("ing", p.attachmentIntfName)
+		exists, err := utils.IsNetdevChainExists(p.netdevTableName, chainName)
+		if err != nil {
+			return fmt.Errorf("failed obtaining %s chain info of netdev %s table: %s", chainName, p.netdevTableName, err)
+		}
+		if !exists {
+			return fmt.Errorf("chain %s in netdev table %s does not exist", chainName, p.netdevTableName)
+		}
+		return nil
+	}
+
+	for _, v := range p.getTargetIPVersions() {
 		exists, err := utils.IsTableExist(v, p.filterTableName)
 		if err != nil {
 			return fmt.Errorf("failed obtaining ipv%s filter table %s info: %s", v, p.filterTableName, err)
@@ -261,28 +601,63 @@ func (p *Plugin) execCheck(conf *Config, prevResult *current.Result) error {
 				v, p.forwardFilterChainName, p.filterTableName,
 			)
 		}
+		ordered, err := utils.IsFilterForwardChainOrdered(v, p.filterTableName, p.forwardFilterChainName)
+		if err != nil {
+			return fmt.Errorf(
+				"failed obtaining ipv%s forward chain %s rules: %s",
+				v, p.forwardFilterChainName, err,
+			)
+		}
+		if !ordered {
+			return fmt.Errorf(
+				"ipv%s chain %s in filter table %s has rules after its default deny rules",
+				v, p.forwardFilterChainName, p.filterTableName,
+			)
+		}
 	}
 
-	for _, targetInterface := range p.targetInterfaces {
-		for _, addr := range targetInterface.addrs {
-			chainName := utils.GetChainName("ffw", conf.ContainerID)
-			exists, err := utils.IsChainExists(addr.Version, p.filterTableName, chainName)
-			if err != nil {
-				return fmt.Errorf(
-					"failed obtaining ipv%s filter %s chain info: %s",
-					addr.Version, chainName, err,
-				)
+	if p.isolation {
+		for _, v := range p.getTargetIPVersions() {
+			if err := utils.CheckBridgeIsolation(v, p.filterTableName, p.isolationChainName, p.attachmentIntfName); err != nil {
+				return err
 			}
-			if !exists {
-				return fmt.Errorf(
-					"ipv%s filter %s chain does not exist in %s table",
-					addr.Version, chainName, p.filterTableName,
-				)
+		}
+	}
+
+	ffsSet := utils.GetChainName("ffs", p.attachmentIntfName)
+
+	for _, targetInterface := range p.getTargetInterfaces() {
+		for _, addr := range targetInterface.addrs {
+			if p.forwardMode == "set" {
+				if err := utils.CheckFilterForwardSetRules(
+					addr.Version,
+					p.filterTableName,
+					p.forwardFilterChainName,
+					ffsSet,
+					addr,
+				); err != nil {
+					return err
+				}
+			} else {
+				chainName := utils.GetChainName("ffw", conf.ContainerID)
+				exists, err := utils.IsChainExists(addr.Version, p.filterTableName, chainName)
+				if err != nil {
+					return fmt.Errorf(
+						"failed obtaining ipv%s filter %s chain info: %s",
+						addr.Version, chainName, err,
+					)
+				}
+				if !exists {
+					return fmt.Errorf(
+						"ipv%s filter %s chain does not exist in %s table",
+						addr.Version, chainName, p.filterTableName,
+					)
+				}
 			}
 
 			// check postrouting nat rules
-			chainName = utils.GetChainName("npo", conf.ContainerID)
-			exists, err = utils.IsChainExists(addr.Version, p.natTableName, chainName)
+			chainName := utils.GetChainName("npo", conf.ContainerID)
+			exists, err := utils.IsChainExists(addr.Version, p.natTableName, chainName)
 			if err != nil {
 				return fmt.Errorf(
 					"failed obtaining ipv%s filter %s chain info: %s",
@@ -308,11 +683,26 @@ func (p *Plugin) execDelete(conf *Config, prevResult *current.Result) error {
 	if err := p.validateInput(prevResult); err != nil {
 		return fmt.Errorf("failed validating input: %s", err)
 	}
+	if err := p.resolveAttachment(conf); err != nil {
+		return fmt.Errorf("failed resolving attachment: %s", err)
+	}
+
+	switch p.attachment {
+	case utils.AttachmentHostDevice:
+		return nil
+	case utils.AttachmentMacvlan:
+		return utils.RemoveNetdevIngressRules(
+			p.netdevTableName,
+			utils.GetChainName("ing", p.attachmentIntfName),
+			conf.ContainerID,
+		)
+	}
 
 	ffwChain := utils.GetChainName("ffw", conf.ContainerID)
 	npoChain := utils.GetChainName("npo", conf.ContainerID)
+	ffsSet := utils.GetChainName("ffs", p.attachmentIntfName)
 
-	for v := range p.targetIPVersions {
+	for _, v := range p.getTargetIPVersions() {
 
 		if natTableExists, err = utils.IsTableExist(v, p.natTableName); natTableExists && err == nil {
 			if postRoutingNatChainExists, err = utils.IsChainExists(v, p.natTableName, p.postRoutingNatChainName); err != nil {
@@ -342,7 +732,7 @@ func (p *Plugin) execDelete(conf *Config, prevResult *current.Result) error {
 			)
 		}
 
-		for _, targetInterface := range p.targetInterfaces {
+		for _, targetInterface := range p.getTargetInterfaces() {
 			for _, addr := range targetInterface.addrs {
 				if v != addr.Version {
 					continue
@@ -362,6 +752,44 @@ func (p *Plugin) execDelete(conf *Config, prevResult *current.Result) error {
 					)
 				}
 
+				if filterTableExists && p.forwardMode == "set" {
+					if err := utils.RemoveFilterForwardSetRules(
+						addr.Version,
+						p.filterTableName,
+						p.forwardFilterChainName,
+						ffsSet,
+						addr,
+					); err != nil {
+						return err
+					}
+					// Remove the intra bridge rules with the last container
+					setExists, err := utils.IsSetExists(addr.Version, p.filterTableName, ffsSet)
+					if err != nil {
+						return err
+					}
+					if !setExists && forwardFilterChainExists {
+						if err := utils.RemoveFilterForwardIntraInterfaceRules(
+							addr.Version,
+							p.filterTableName,
+							p.forwardFilterChainName,
+							p.attachmentIntfName,
+						); err != nil {
+							return err
+						}
+					}
+				}
+
+				if forwardFilterChainExists && p.forwardMode == "set" && p.hostInterfaceName != "" {
+					if err := utils.RemoveFilterForwardAntiSpoofingRules(
+						addr.Version,
+						p.filterTableName,
+						p.forwardFilterChainName,
+						p.hostInterfaceName,
+					); err != nil {
+						return err
+					}
+				}
+
 				if filterTableExists && ffwExsists {
 					if forwardFilterChainExists {
 						if err := utils.DeleteJumpRule(addr.Version, p.filterTableName, p.forwardFilterChainName, ffwChain); err != nil {
@@ -384,6 +812,38 @@ func (p *Plugin) execDelete(conf *Config, prevResult *current.Result) error {
 				}
 			}
 		}
+
+		// The flow offload rules are removed regardless of the flowtable
+		// option, because the option might have been unset.
+		if forwardFilterChainExists {
+			if err := p.removeFlowOffload(v, conf.ContainerID, p.attachmentIntfName); err != nil {
+				return fmt.Errorf("failed removing ipv%s flow offload: %s", v, err)
+			}
+		}
+
+		// The quota and the counters are deleted after the chain of the
+		// container referring to them.
+		if filterTableExists {
+			if err := p.removeQuota(v, conf.ContainerID); err != nil {
+				return fmt.Errorf("failed removing ipv%s quota: %s", v, err)
+			}
+			if err := utils.DeleteCounters(v, p.filterTableName, utils.GetContainerCounters(conf.ContainerID).Names(), ""); err != nil {
+				return fmt.Errorf("failed removing ipv%s counters: %s", v, err)
+			}
+		}
+
+		if err := p.removeMangleRules(v, conf.ContainerID); err != nil {
+			return fmt.Errorf("failed removing ipv%s mangle rules: %s", v, err)
+		}
+	}
+
+	// The rules pinning the addresses of the host interface are removed
+	// regardless of the anti_spoofing option, because the name of the
+	// interface might be reused.
+	if p.hostInterfaceName != "" {
+		if err := utils.RemoveBridgeAntiSpoofingRules(p.bridgeTableName, p.bridgePreRoutingChain, p.hostInterfaceName); err != nil {
+			return err
+		}
 	}
 	return nil
 }
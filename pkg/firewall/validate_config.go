@@ -0,0 +1,183 @@
+package firewall
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/greenpau/cni-plugins/pkg/utils"
+)
+
+// Validate checks the configurations of the plugin in a conflist or in a
+// single plugin configuration without applying them, i.e. the validate
+// subcommand. The arguments are the flags of the subcommand followed by
+// the path to the file, or "-" for the standard input. It lists every
+// problem found.
+func Validate(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	pluginType := fs.String("type", "cni-nftables-firewall", "type of the plugin in the conflist")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("validate requires the path to a network configuration file, found %d arguments", fs.NArg())
+	}
+	fp := fs.Arg(0)
+
+	entries, err := utils.ReadPluginConfigs(fp, *pluginType)
+	if err != nil {
+		return fmt.Errorf("%s: %s", fp, err)
+	}
+	problems := []utils.ConfigProblems{}
+	for _, entry := range entries {
+		problems = append(problems, validateConfig(entry.Data))
+	}
+	if count := utils.WriteConfigProblems(w, fp, entries, problems); count > 0 {
+		return fmt.Errorf("%s: found %d problems", fp, count)
+	}
+	return nil
+}
+
+// validateConfig returns the problems of a plugin configuration. It runs
+// the checks of parseConfigFromBytes, without stopping at the first
+// failing one, and the checks of the names of the tables and the chains,
+// the interfaces, and the networks, which would otherwise fail when a
+// container is added.
+func validateConfig(data []byte) utils.ConfigProblems {
+	problems := utils.ConfigProblems{}
+	conf := &Config{}
+	if err := json.Unmarshal(data, conf); err != nil {
+		problems.Add("failed to load conf: %v", err)
+		return problems
+	}
+
+	if _, exists := supportedVersionsMap[conf.CNIVersion]; !exists {
+		problems.Addf("unsupported CNI version %s", conf.CNIVersion)
+	}
+
+	setDefaultNames(conf)
+	if len(conf.IsolationPeers) > 0 && !conf.Isolation {
+		problems.Addf("isolation peers require isolation")
+	}
+	if conf.Flowtable && len(conf.FlowtableDevices) == 0 {
+		problems.Addf("flowtable requires flowtable devices")
+	}
+
+	problems.Add("invalid dscp: %v", utils.ValidateDSCP(conf.DSCP))
+	problems.Add("invalid runtime dscp: %v", utils.ValidateDSCP(conf.RuntimeConfig.DSCP))
+	problems.Add("invalid bandwidth: %v", utils.ValidateBandwidth(conf.Bandwidth))
+	problems.Add("invalid runtime bandwidth: %v", utils.ValidateBandwidth(conf.RuntimeConfig.Bandwidth))
+	bandwidth := conf.Bandwidth
+	if conf.RuntimeConfig.Bandwidth != nil {
+		bandwidth = conf.RuntimeConfig.Bandwidth
+	}
+
+	if conf.IntraBridge == "" {
+		conf.IntraBridge = "allow"
+	}
+	problems.Add("", utils.ValidateIntraInterfaceMode(conf.IntraBridge, conf.IntraBridgePeers))
+
+	switch conf.ForwardMode {
+	case "", "chain", "set":
+	default:
+		problems.Addf("unsupported forward mode %s", conf.ForwardMode)
+	}
+
+	if err := utils.ValidateSourceNatConfig(conf.SourceNat); err != nil {
+		problems.Add("invalid source nat config: %v", err)
+	} else {
+		_, err := utils.ValidateNatMode("4", conf.IPv4NatMode, conf.SourceNat)
+		problems.Add("invalid nat mode: %v", err)
+		_, err = utils.ValidateNatMode("6", conf.IPv6NatMode, conf.SourceNat)
+		problems.Add("invalid nat mode: %v", err)
+	}
+	problems.CheckNetworks("invalid no masquerade destination: %v", conf.NoMasqueradeDestinations)
+
+	problems.Add("invalid forward deny config: %v", utils.ValidateDenyRuleConfig(conf.ForwardDeny))
+
+	problems.Add("invalid policy: %v", utils.ValidateFirewallPolicy(conf.Policy))
+	names := []string{}
+	for name := range conf.Policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := utils.ValidateFirewallPolicy(conf.Policies[name]); err != nil {
+			problems.Addf("invalid %s policy: %v", name, err)
+		}
+	}
+	problems.Add("invalid runtime policy: %v", utils.ValidateFirewallPolicy(conf.RuntimeConfig.FirewallPolicy))
+	if conf.ForwardMode == "set" {
+		if conf.Policy != nil || conf.RuntimeConfig.FirewallPolicy != nil {
+			problems.Addf("firewall policies are not supported in set forward mode")
+		}
+		if bandwidth != nil || conf.QuotaBytes > 0 {
+			problems.Addf("bandwidth limits and quotas are not supported in set forward mode")
+		}
+	}
+
+	if conf.DryRun != nil {
+		problems.Add("invalid dry-run config: %v", utils.ValidateDryRunConfig(conf.DryRun))
+	}
+
+	for _, name := range [][2]string{
+		{"filter_table_name", conf.FilterTableName},
+		{"forward_chain_name", conf.ForwardFilterChainName},
+		{"nat_table_name", conf.NatTableName},
+		{"postrouting_nat_chain_name", conf.PostRoutingNatChainName},
+		{"isolation_chain_name", conf.IsolationChainName},
+		{"mangle_table_name", conf.MangleTableName},
+		{"mangle_prerouting_chain_name", conf.ManglePreRoutingChainName},
+		{"bridge_table_name", conf.BridgeTableName},
+		{"bridge_forward_chain_name", conf.BridgeForwardChainName},
+		{"bridge_prerouting_chain_name", conf.BridgePreRoutingChainName},
+		{"flowtable_name", conf.FlowtableName},
+		{"netdev_table_name", conf.NetdevTableName},
+	} {
+		problems.CheckNftName(name[0], name[1])
+	}
+	for i, device := range conf.FlowtableDevices {
+		problems.CheckInterfaceName(fmt.Sprintf("flowtable_devices[%d]", i), device)
+	}
+	problems.CheckChains(getConfigChains(conf))
+
+	// The checks above cover the ones of parseConfigFromBytes. Its error,
+	// if not among the problems yet, is a check missing above, e.g. the
+	// parsing of the previous result.
+	if _, _, err := parseConfigFromBytes(data); err != nil {
+		problems.Add("", err)
+	}
+	return problems
+}
+
+// getConfigChains returns the chains the plugin creates in its tables
+// with the provided configuration.
+func getConfigChains(conf *Config) []*utils.ConfigChain {
+	chains := []*utils.ConfigChain{}
+	addChain := func(family, tableOption, table, chainOption, chain string) {
+		chains = append(chains, &utils.ConfigChain{
+			Family:      family,
+			TableOption: tableOption,
+			Table:       table,
+			ChainOption: chainOption,
+			Chain:       chain,
+		})
+	}
+	addChain("ip", "filter_table_name", conf.FilterTableName, "forward_chain_name", conf.ForwardFilterChainName)
+	addChain("ip", "nat_table_name", conf.NatTableName, "postrouting_nat_chain_name", conf.PostRoutingNatChainName)
+	if conf.Isolation {
+		addChain("ip", "filter_table_name", conf.FilterTableName, "isolation_chain_name", conf.IsolationChainName)
+	}
+	if conf.Mark != nil || conf.DSCP != nil || conf.RuntimeConfig.Mark != nil || conf.RuntimeConfig.DSCP != nil {
+		addChain("ip", "mangle_table_name", conf.MangleTableName, "mangle_prerouting_chain_name", conf.ManglePreRoutingChainName)
+	}
+	if conf.BridgeRules {
+		addChain("bridge", "bridge_table_name", conf.BridgeTableName, "bridge_forward_chain_name", conf.BridgeForwardChainName)
+	}
+	if conf.AntiSpoofing {
+		addChain("bridge", "bridge_table_name", conf.BridgeTableName, "bridge_prerouting_chain_name", conf.BridgePreRoutingChainName)
+	}
+	return chains
+}
@@ -0,0 +1,86 @@
+package firewall
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/greenpau/cni-plugins/pkg/utils"
+)
+
+func TestValidateConfig(t *testing.T) {
+	var tests = []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "valid conflist",
+			data: `{"cniVersion": "0.4.0", "name": "test", "plugins": [
+				{"type": "bridge", "bridge": "cni0"},
+				{"type": "cni-nftables-firewall", "forward_mode": "chain"}
+			]}`,
+		},
+		{
+			name: "all problems listed",
+			data: `{"cniVersion": "0.4.0", "name": "test", "plugins": [
+				{
+					"type": "cni-nftables-firewall",
+					"forward_mode": "routed",
+					"isolation_peers": ["other"],
+					"noMasqueradeDestinations": ["10.0.0.0/33", "192.168.0.0/16"],
+					"policy": {"egress": {"rules": [{"protocol": "gre", "action": "accept"}]}}
+				}
+			]}`,
+			want: []string{
+				"isolation peers require isolation",
+				"unsupported forward mode routed",
+				"invalid no masquerade destination: invalid source network: 10.0.0.0/33",
+				"invalid policy: unsupported egress rule 0 protocol: gre",
+			},
+		},
+		{
+			name: "conflicting names",
+			data: `{"cniVersion": "0.4.0", "name": "test", "plugins": [
+				{
+					"type": "cni-nftables-firewall",
+					"nat_table_name": "filter",
+					"postrouting_nat_chain_name": "forward",
+					"isolation": true,
+					"isolation_chain_name": "nat",
+					"flowtable": true,
+					"flowtable_devices": ["eth0", "a-very-long-interface-name"]
+				}
+			]}`,
+			want: []string{
+				"flowtable_devices[1]: invalid interface name: a-very-long-interface-name",
+				"forward_chain_name and postrouting_nat_chain_name refer to the same chain forward of ip table filter",
+			},
+		},
+		{
+			name: "chain name of a table",
+			data: `{"cniVersion": "0.4.0", "name": "test", "type": "cni-nftables-firewall", "forward_chain_name": "nat"}`,
+			want: []string{
+				"forward_chain_name nat clashes with nat_table_name nat",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries, err := utils.ParsePluginConfigs([]byte(test.data), "cni-nftables-firewall")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			got := []string{}
+			for _, entry := range entries {
+				got = append(got, validateConfig(entry.Data)...)
+			}
+			if test.want == nil {
+				test.want = []string{}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("unexpected problems\ngot:  %q\nwant: %q", got, test.want)
+			}
+		})
+	}
+}
@@ -2,7 +2,9 @@ package firewall
 
 import (
 	"fmt"
+
 	current "github.com/containernetworking/cni/pkg/types/040"
+	"github.com/greenpau/cni-plugins/pkg/utils"
 )
 
 func (p *Plugin) validateInput(result *current.Result) error {
@@ -26,8 +28,15 @@ func (p *Plugin) validateInput(result *current.Result) error {
 		}
 		p.targetInterfaces[intf.Name] = targetInterface
 		intfMap[i] = intf.Name
+		// The host interface of the container, e.g. the host side of
+		// a veth pair, is the last interface outside of the sandbox.
+		if intf.Sandbox == "" {
+			p.hostInterfaceName = intf.Name
+		}
 	}
 
+	p.attachment, p.attachmentIntfName = utils.GetAttachment(result)
+
 	if len(result.IPs) == 0 {
 		return fmt.Errorf("the data passed to firewall plugin has no IP addresses")
 	}
@@ -42,6 +51,9 @@ func (p *Plugin) validateInput(result *current.Result) error {
 		intfName := intfMap[*addr.Interface]
 		targetInterface := p.targetInterfaces[intfName]
 		targetInterface.addrs = append(targetInterface.addrs, addr)
+		if intf := result.Interfaces[*addr.Interface]; intf.Sandbox != "" && intf.Mac != "" {
+			p.containerMac = intf.Mac
+		}
 		p.targetIPVersions[addr.Version] = true
 	}
 
@@ -5,6 +5,7 @@ import (
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/040"
+	"github.com/greenpau/cni-plugins/pkg/utils"
 )
 
 // Add initializes an instance of Plugin and adds necessary
@@ -25,8 +26,12 @@ func Add(args *skel.CmdArgs) error {
 		return types.PrintResult(conf.PrevResult, conf.CNIVersion)
 	}
 
+	autoHostPorts := hasAutoHostPorts(conf)
+
 	p := NewPlugin(conf)
-	if err := p.Add(conf, result); err != nil {
+	if err := utils.DryRun(conf.DryRun, dryRunHeader(conf.Type, "ADD", args), func() error {
+		return p.Add(conf, result)
+	}); err != nil {
 		return err
 	}
 
@@ -34,6 +39,15 @@ func Add(args *skel.CmdArgs) error {
 		result = &current.Result{}
 	}
 
+	// Report the allocated host ports back to the runtime.
+	if autoHostPorts {
+		result.CNIVersion = conf.CNIVersion
+		return (&Result{
+			Result:       result,
+			PortMappings: conf.RuntimeConfig.PortMaps,
+		}).Print()
+	}
+
 	return types.PrintResult(result, conf.CNIVersion)
 }
 
@@ -64,6 +78,21 @@ func Check(args *skel.CmdArgs) error {
 	return nil
 }
 
+// ExplainCheck performs the checks of Check and, when the checks fail,
+// adds the chains of the container with their rules in nft syntax to the
+// error.
+func ExplainCheck(args *skel.CmdArgs) error {
+	err := Check(args)
+	if err == nil {
+		return nil
+	}
+	conf, _, parseErr := parseConfigFromBytes(args.StdinData, args.IfName)
+	if parseErr != nil {
+		return err
+	}
+	return utils.ExplainError(err, []string{conf.NatTableName, conf.FilterTableName}, args.ContainerID)
+}
+
 // Delete initializes an instance of Plugin and removes
 // port mapping rules, if any.
 func Delete(args *skel.CmdArgs) error {
@@ -84,9 +113,17 @@ func Delete(args *skel.CmdArgs) error {
 	}
 
 	p := NewPlugin(conf)
-	if err := p.Delete(conf, result); err != nil {
+	if err := utils.DryRun(conf.DryRun, dryRunHeader(conf.Type, "DEL", args), func() error {
+		return p.Delete(conf, result)
+	}); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// dryRunHeader returns the header of the changes written in dry-run mode,
+// i.e. the plugin, the command, the container and its interface.
+func dryRunHeader(pluginType, command string, args *skel.CmdArgs) string {
+	return fmt.Sprintf("%s %s %s %s", pluginType, command, args.ContainerID, args.IfName)
+}
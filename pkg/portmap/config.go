@@ -20,7 +20,8 @@ type Config struct {
 	MarkMasqBit          *int      `json:"markMasqBit"`
 	ExternalSetMarkChain *string   `json:"externalSetMarkChain"`
 	RuntimeConfig        struct {
-		PortMaps []utils.MappingEntry `json:"portMappings,omitempty"`
+		PortMaps         []utils.MappingEntry `json:"portMappings,omitempty"`
+		LoadBalanceGroup string               `json:"loadBalanceGroup,omitempty"`
 	} `json:"runtimeConfig,omitempty"`
 
 	// These are fields parsed out of the config or the environment;
@@ -38,6 +39,29 @@ type Config struct {
 	PreRoutingRawChainName  string `json:"prerouting_raw_chain_name"`
 	FilterTableName         string `json:"filter_table_name"`
 	ForwardFilterChainName  string `json:"forward_filter_chain_name"`
+
+	// ForwardDeny is the logging and the verdict of the packets reaching
+	// the end of the forwarding chain of filter table.
+	ForwardDeny *utils.DenyRuleConfig `json:"forward_deny,omitempty"`
+
+	// LoadBalanceMode is the way a backend of a load-balanced port mapping
+	// group is picked for a new connection, i.e. "numgen" or "jhash".
+	LoadBalanceMode string `json:"loadBalanceMode"`
+
+	// AllowedSources is the list of source addresses or networks allowed
+	// to reach the published ports not having their own list.
+	AllowedSources []string `json:"allowedSources"`
+
+	// HostPortRange is the range of the host ports allocated to the port
+	// mappings with host port 0, e.g. 32768-60999.
+	HostPortRange string `json:"hostPortRange"`
+	HostPortMin   int    `json:"-"`
+	HostPortMax   int    `json:"-"`
+
+	// DryRun writes the changes of ADD and DEL as an nft script instead
+	// of applying them. The CNI_NFTABLES_DRY_RUN environment variable
+	// enables the mode too.
+	DryRun *utils.DryRunConfig `json:"dry_run,omitempty"`
 }
 
 // DefaultMarkBit is the default mark bit to signal that
@@ -54,39 +78,18 @@ func parseConfigFromBytes(data []byte, intfName string) (*Config, *current.Resul
 		return nil, nil, fmt.Errorf("unsupported CNI version %s", conf.CNIVersion)
 	}
 
-	// Set default values
-	if conf.NatTableName == "" {
-		conf.NatTableName = "nat"
-	}
-	if conf.RawTableName == "" {
-		conf.RawTableName = "raw"
-	}
-	if conf.PostRoutingNatChainName == "" {
-		conf.PostRoutingNatChainName = "postrouting"
-	}
-	if conf.PreRoutingNatChainName == "" {
-		conf.PreRoutingNatChainName = "prerouting"
-	}
-	if conf.OutputNatChainName == "" {
-		conf.OutputNatChainName = "output"
-	}
+	setDefaultNames(conf)
 
-	if conf.InputNatChainName == "" {
-		conf.InputNatChainName = "input"
-	}
-
-	if conf.RawTableName == "" {
-		conf.RawTableName = "raw"
-	}
-	if conf.PreRoutingRawChainName == "" {
-		conf.PreRoutingRawChainName = "prerouting"
+	if err := utils.ValidateDenyRuleConfig(conf.ForwardDeny); err != nil {
+		return nil, nil, fmt.Errorf("invalid forward deny config: %v", err)
 	}
 
-	if conf.FilterTableName == "" {
-		conf.FilterTableName = "filter"
-	}
-	if conf.ForwardFilterChainName == "" {
-		conf.ForwardFilterChainName = "forward"
+	switch conf.LoadBalanceMode {
+	case "":
+		conf.LoadBalanceMode = "numgen"
+	case "numgen", "jhash":
+	default:
+		return nil, nil, fmt.Errorf("unsupported load balancing mode %s", conf.LoadBalanceMode)
 	}
 
 	// Parse previous result.
@@ -126,9 +129,55 @@ func parseConfigFromBytes(data []byte, intfName string) (*Config, *current.Resul
 		if pm.ContainerPort <= 0 {
 			return nil, nil, fmt.Errorf("Invalid container port number: %d", pm.ContainerPort)
 		}
-		if pm.HostPort <= 0 {
+		if pm.HostPort < 0 || pm.HostPort > 65535 {
 			return nil, nil, fmt.Errorf("Invalid host port number: %d", pm.HostPort)
 		}
+		if pm.HostPort == 0 && conf.RuntimeConfig.LoadBalanceGroup != "" {
+			return nil, nil, fmt.Errorf("Host port allocation is not supported for load balancing group %s", conf.RuntimeConfig.LoadBalanceGroup)
+		}
+		if pm.HostInterface != "" {
+			if err := utils.ValidateInterfaceNameMatch(pm.HostInterface); err != nil {
+				return nil, nil, fmt.Errorf("Invalid host interface for host port %d: %v", pm.HostPort, err)
+			}
+		}
+		if err := utils.ValidateMappingLimit(pm.Limit); err != nil {
+			return nil, nil, fmt.Errorf("Invalid limit for host port %d: %v", pm.HostPort, err)
+		}
+	}
+
+	if conf.HostPortRange == "" {
+		conf.HostPortRange = DefaultHostPortRange
+	}
+	hostPortMin, hostPortMax, err := parseHostPortRange(conf.HostPortRange)
+	if err != nil {
+		return nil, nil, err
+	}
+	conf.HostPortMin = hostPortMin
+	conf.HostPortMax = hostPortMax
+
+	dryRun, err := utils.GetDryRunConfig(conf.DryRun)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid dry-run config: %v", err)
+	}
+	conf.DryRun = dryRun
+
+	// Reject invalid source addresses and apply the plugin-wide
+	// allowed sources to the port mappings without their own.
+	for _, s := range conf.AllowedSources {
+		if _, err := utils.ParseMappingSource(s); err != nil {
+			return nil, nil, err
+		}
+	}
+	for i, pm := range conf.RuntimeConfig.PortMaps {
+		if len(pm.AllowedSources) == 0 {
+			conf.RuntimeConfig.PortMaps[i].AllowedSources = conf.AllowedSources
+			continue
+		}
+		for _, s := range pm.AllowedSources {
+			if _, err := utils.ParseMappingSource(s); err != nil {
+				return nil, nil, err
+			}
+		}
 	}
 
 	if conf.PrevResult != nil {
@@ -161,3 +210,38 @@ func parseConfigFromBytes(data []byte, intfName string) (*Config, *current.Resul
 	}
 	return conf, result, nil
 }
+
+// setDefaultNames sets the default names of the tables and the chains of
+// the plugin.
+func setDefaultNames(conf *Config) {
+	if conf.NatTableName == "" {
+		conf.NatTableName = "nat"
+	}
+	if conf.PostRoutingNatChainName == "" {
+		conf.PostRoutingNatChainName = "postrouting"
+	}
+	if conf.PreRoutingNatChainName == "" {
+		conf.PreRoutingNatChainName = "prerouting"
+	}
+	if conf.OutputNatChainName == "" {
+		conf.OutputNatChainName = "output"
+	}
+
+	if conf.InputNatChainName == "" {
+		conf.InputNatChainName = "input"
+	}
+
+	if conf.RawTableName == "" {
+		conf.RawTableName = "raw"
+	}
+	if conf.PreRoutingRawChainName == "" {
+		conf.PreRoutingRawChainName = "prerouting"
+	}
+
+	if conf.FilterTableName == "" {
+		conf.FilterTableName = "filter"
+	}
+	if conf.ForwardFilterChainName == "" {
+		conf.ForwardFilterChainName = "forward"
+	}
+}
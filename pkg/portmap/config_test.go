@@ -0,0 +1,61 @@
+package portmap
+
+import (
+	"testing"
+)
+
+func TestParseConfigLoadBalanceMode(t *testing.T) {
+	var tests = []struct {
+		name      string
+		data      string
+		want      string
+		shouldErr bool
+	}{
+		{
+			name: "numgen by default",
+			data: `{"cniVersion": "0.4.0", "name": "test", "type": "cni-nftables-portmap"}`,
+			want: "numgen",
+		},
+		{
+			name: "numgen",
+			data: `{"cniVersion": "0.4.0", "name": "test", "type": "cni-nftables-portmap", "loadBalanceMode": "numgen"}`,
+			want: "numgen",
+		},
+		{
+			name: "jhash",
+			data: `{"cniVersion": "0.4.0", "name": "test", "type": "cni-nftables-portmap", "loadBalanceMode": "jhash"}`,
+			want: "jhash",
+		},
+		{
+			name:      "unsupported mode",
+			data:      `{"cniVersion": "0.4.0", "name": "test", "type": "cni-nftables-portmap", "loadBalanceMode": "random"}`,
+			shouldErr: true,
+		},
+		{
+			name: "host port allocation in a group",
+			data: `{"cniVersion": "0.4.0", "name": "test", "type": "cni-nftables-portmap", "runtimeConfig": {
+				"loadBalanceGroup": "web",
+				"portMappings": [{"hostPort": 0, "containerPort": 80, "protocol": "tcp"}]
+			}}`,
+			shouldErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf, _, err := parseConfigFromBytes([]byte(test.data), "eth0")
+			if test.shouldErr {
+				if err == nil {
+					t.Fatalf("expected error, got mode %s", conf.LoadBalanceMode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if conf.LoadBalanceMode != test.want {
+				t.Fatalf("unexpected mode %s, want %s", conf.LoadBalanceMode, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package portmap
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/greenpau/cni-plugins/pkg/utils"
+)
+
+func TestDryRun(t *testing.T) {
+	var tests = []struct {
+		name string
+		path string
+		want string
+	}{
+		{
+			name: "single ipv4 port mapping",
+			path: "testdata/portmap/stdindata/stdindata2.json",
+			want: "testdata/portmap/nftables/config2.add.nft",
+		},
+		{
+			name: "dual stack port mappings with sources, limits, and host addresses",
+			path: "testdata/portmap/stdindata/stdindata3.json",
+			want: "testdata/portmap/nftables/config3.add.nft",
+		},
+		{
+			name: "member of a load-balanced group",
+			path: "testdata/portmap/stdindata/stdindata4.json",
+			want: "testdata/portmap/nftables/config4.add.nft",
+		},
+	}
+
+	// The rules jumping to the chains of the container match the addresses
+	// of the host.
+	defer func(f func(string) ([]net.IP, error)) { getHostAddrs = f }(getHostAddrs)
+	getHostAddrs = func(string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("192.0.2.1"), net.ParseIP("::1")}, nil
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "plan.nft")
+			t.Setenv(utils.DryRunEnv, "true")
+			t.Setenv(utils.DryRunOutputEnv, output)
+			t.Setenv(utils.DryRunFormatEnv, "nft")
+			t.Setenv(utils.DryRunBaselineEnv, "empty")
+
+			b, err := utils.LoadDataFromFilePath(test.path)
+			if err != nil {
+				t.Fatalf("failed loading %s: %s", test.path, err)
+			}
+			args := &skel.CmdArgs{
+				ContainerID: "78f486a1c7999cfe8e0526d",
+				IfName:      "dummy0",
+				StdinData:   b,
+			}
+			if err := Add(args); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			got, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatalf("failed reading dry-run output: %s", err)
+			}
+			want, err := utils.LoadDataFromFilePath(test.want)
+			if err != nil {
+				t.Fatalf("failed loading %s: %s", test.want, err)
+			}
+			if strings.TrimSpace(string(got)) != strings.TrimSpace(string(want)) {
+				t.Fatalf("dry-run output mismatch\ngot:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
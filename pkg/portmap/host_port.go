@@ -0,0 +1,243 @@
+package portmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	current "github.com/containernetworking/cni/pkg/types/040"
+	"github.com/greenpau/cni-plugins/pkg/utils"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultHostPortRange is the default range of the host ports
+// allocated to the port mappings with host port 0.
+const DefaultHostPortRange = "32768-60999"
+
+// portMappingLockFile is the file locked while allocating host ports and
+// while updating the backends of a load-balanced port mapping group, so
+// that concurrent invocations of the plugin neither pick the same port
+// nor drop a backend of a group.
+var portMappingLockFile = "/run/cni-nftables-portmap.lock"
+
+// Result is the result of the plugin. It extends the result of the
+// previous plugin with the port mappings, including the host ports
+// allocated by the plugin.
+type Result struct {
+	*current.Result
+	PortMappings []utils.MappingEntry `json:"portMappings,omitempty"`
+}
+
+// Print prints the result.
+func (r *Result) Print() error {
+	return r.PrintTo(os.Stdout)
+}
+
+// PrintTo writes the result to the provided writer.
+func (r *Result) PrintTo(writer io.Writer) error {
+	data, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data)
+	return err
+}
+
+// parseHostPortRange parses a host port range, e.g. 32768-60999.
+func parseHostPortRange(s string) (int, int, error) {
+	arr := strings.SplitN(s, "-", 2)
+	if len(arr) != 2 {
+		return 0, 0, fmt.Errorf("invalid host port range: %s", s)
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(arr[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid host port range: %s", s)
+	}
+	end, err := strconv.Atoi(strings.TrimSpace(arr[1]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid host port range: %s", s)
+	}
+	if start <= 0 || end > 65535 || start > end {
+		return 0, 0, fmt.Errorf("invalid host port range: %s", s)
+	}
+	return start, end, nil
+}
+
+// hasAutoHostPorts checks whether any of the port mappings requires
+// host port allocation.
+func hasAutoHostPorts(conf *Config) bool {
+	for _, pm := range conf.RuntimeConfig.PortMaps {
+		if pm.HostPort == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// lockPortMappings takes an exclusive lock on the host port allocation
+// and on the load-balanced port mapping groups. The returned function
+// releases the lock. The lock is not reentrant. It is taken at most once
+// per invocation, as the host ports of a load-balanced group are never
+// allocated.
+func lockPortMappings() (func(), error) {
+	f, err := os.OpenFile(portMappingLockFile, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening port mapping lock file %s: %s", portMappingLockFile, err)
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed locking port mapping lock file %s: %s", portMappingLockFile, err)
+	}
+	return func() {
+		unix.Flock(int(f.Fd()), unix.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// isHostPortListening checks whether a local process listens on a port.
+func isHostPortListening(protocol string, port int) bool {
+	addr := ":" + strconv.Itoa(port)
+	switch protocol {
+	case "tcp":
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return true
+		}
+		l.Close()
+	case "udp":
+		l, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return true
+		}
+		l.Close()
+	}
+	return false
+}
+
+// allocateHostPorts allocates a free host port from the configured range
+// to each of the port mappings with host port 0. A port is free when no
+// destination NAT rule in the NAT tables uses it and no local process
+// listens on it.
+func (p *Plugin) allocateHostPorts(conf *Config) error {
+	used := map[string]map[int]bool{
+		"tcp": {},
+		"udp": {},
+	}
+	for _, v := range p.getTargetIPVersions() {
+		exists, err := utils.IsTableExist(v, p.natTableName)
+		if err != nil {
+			return fmt.Errorf("failed obtaining ipv%s %s table info: %s", v, p.natTableName, err)
+		}
+		if !exists {
+			continue
+		}
+		ports, err := utils.GetDestinationNatHostPorts(v, p.natTableName)
+		if err != nil {
+			return fmt.Errorf("failed obtaining host ports in ipv%s %s table: %s", v, p.natTableName, err)
+		}
+		for protocol, entries := range ports {
+			for port := range entries {
+				used[protocol][port] = true
+			}
+		}
+	}
+	return assignHostPorts(conf, used, isHostPortListening)
+}
+
+// assignHostPorts sets the host ports of the port mappings with host
+// port 0 to the first ports of the configured range neither in use, by
+// protocol, nor listening.
+func assignHostPorts(conf *Config, used map[string]map[int]bool, isListening func(string, int) bool) error {
+	for _, pm := range conf.RuntimeConfig.PortMaps {
+		if pm.HostPort != 0 {
+			if _, exists := used[pm.Protocol]; exists {
+				used[pm.Protocol][pm.HostPort] = true
+			}
+		}
+	}
+
+	for i, pm := range conf.RuntimeConfig.PortMaps {
+		if pm.HostPort != 0 {
+			continue
+		}
+		if _, exists := used[pm.Protocol]; !exists {
+			return fmt.Errorf("unsupported protocol: %s", pm.Protocol)
+		}
+		for port := conf.HostPortMin; port <= conf.HostPortMax; port++ {
+			if used[pm.Protocol][port] {
+				continue
+			}
+			if isListening(pm.Protocol, port) {
+				continue
+			}
+			conf.RuntimeConfig.PortMaps[i].HostPort = port
+			used[pm.Protocol][port] = true
+			break
+		}
+		if conf.RuntimeConfig.PortMaps[i].HostPort == 0 {
+			return fmt.Errorf(
+				"no free %s host port in %d-%d range for container port %d",
+				pm.Protocol, conf.HostPortMin, conf.HostPortMax, pm.ContainerPort,
+			)
+		}
+	}
+	return nil
+}
+
+// resolveHostPorts sets the host ports of the port mappings with host
+// port 0 to the ports allocated when the container was added. The ports
+// are recovered from the destination NAT rules of the container.
+func (p *Plugin) resolveHostPorts(conf *Config, v string) error {
+	nprChain := utils.GetChainName("npr", conf.ContainerID)
+	exists, err := utils.IsChainExists(v, p.natTableName, nprChain)
+	if err != nil {
+		return fmt.Errorf(
+			"failed obtaining ipv%s prerouting %s chain info: %s",
+			v, nprChain, err,
+		)
+	}
+	if !exists {
+		return nil
+	}
+	pms, err := utils.GetDestinationNatPorts(v, p.natTableName, nprChain)
+	if err != nil {
+		return err
+	}
+	recoverHostPorts(conf, pms)
+	return nil
+}
+
+// recoverHostPorts sets the host ports of the port mappings with host
+// port 0 to the host ports of the provided destination NAT port mappings
+// of the container with the same protocol, container port, host IP, and
+// host interface.
+func recoverHostPorts(conf *Config, entries []utils.MappingEntry) {
+	for i, pm := range conf.RuntimeConfig.PortMaps {
+		if pm.HostPort != 0 {
+			continue
+		}
+		for _, entry := range entries {
+			if isSameMappingKey(pm, entry) {
+				conf.RuntimeConfig.PortMaps[i].HostPort = entry.HostPort
+				break
+			}
+		}
+	}
+}
+
+// isSameMappingKey checks whether two port mappings have the same
+// protocol, container port, host IP, and host interface, i.e. whether
+// they differ by the host port only.
+func isSameMappingKey(a, b utils.MappingEntry) bool {
+	if a.Protocol != b.Protocol || a.ContainerPort != b.ContainerPort || a.HostInterface != b.HostInterface {
+		return false
+	}
+	if a.HostIP == "" || b.HostIP == "" {
+		return a.HostIP == b.HostIP
+	}
+	return net.ParseIP(a.HostIP).Equal(net.ParseIP(b.HostIP))
+}
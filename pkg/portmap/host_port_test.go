@@ -0,0 +1,153 @@
+package portmap
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/greenpau/cni-plugins/pkg/utils"
+)
+
+func TestParseHostPortRange(t *testing.T) {
+	var tests = []struct {
+		input     string
+		wantStart int
+		wantEnd   int
+		shouldErr bool
+	}{
+		{input: DefaultHostPortRange, wantStart: 32768, wantEnd: 60999},
+		{input: " 1000 - 1000 ", wantStart: 1000, wantEnd: 1000},
+		{input: "1-65535", wantStart: 1, wantEnd: 65535},
+		{input: "8080", shouldErr: true},
+		{input: "a-b", shouldErr: true},
+		{input: "0-100", shouldErr: true},
+		{input: "100-65536", shouldErr: true},
+		{input: "200-100", shouldErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			start, end, err := parseHostPortRange(test.input)
+			if test.shouldErr {
+				if err == nil {
+					t.Fatalf("expected error, got range %d-%d", start, end)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if start != test.wantStart || end != test.wantEnd {
+				t.Fatalf("unexpected range %d-%d, want %d-%d", start, end, test.wantStart, test.wantEnd)
+			}
+		})
+	}
+}
+
+func TestAssignHostPorts(t *testing.T) {
+	var tests = []struct {
+		name      string
+		pms       []utils.MappingEntry
+		used      []int
+		listening []int
+		want      []int
+		shouldErr bool
+	}{
+		{
+			name: "first free port of the range",
+			pms: []utils.MappingEntry{
+				{ContainerPort: 80, Protocol: "tcp"},
+				{ContainerPort: 443, Protocol: "tcp"},
+			},
+			want: []int{32000, 32001},
+		},
+		{
+			name: "ports used by rules, mappings, and listeners are skipped",
+			pms: []utils.MappingEntry{
+				{HostPort: 32001, ContainerPort: 8080, Protocol: "tcp"},
+				{ContainerPort: 80, Protocol: "tcp"},
+				{ContainerPort: 53, Protocol: "udp"},
+			},
+			used:      []int{32000},
+			listening: []int{32002},
+			want:      []int{32001, 32003, 32000},
+		},
+		{
+			name: "range exhausted",
+			pms: []utils.MappingEntry{
+				{ContainerPort: 80, Protocol: "tcp"},
+			},
+			used:      []int{32000, 32001, 32002},
+			listening: []int{32003},
+			shouldErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := &Config{HostPortMin: 32000, HostPortMax: 32003}
+			conf.RuntimeConfig.PortMaps = append([]utils.MappingEntry{}, test.pms...)
+			used := map[string]map[int]bool{"tcp": {}, "udp": {}}
+			for _, port := range test.used {
+				used["tcp"][port] = true
+			}
+			isListening := func(protocol string, port int) bool {
+				for _, p := range test.listening {
+					if protocol == "tcp" && p == port {
+						return true
+					}
+				}
+				return false
+			}
+			err := assignHostPorts(conf, used, isListening)
+			if test.shouldErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", conf.RuntimeConfig.PortMaps)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			got := []int{}
+			for _, pm := range conf.RuntimeConfig.PortMaps {
+				got = append(got, pm.HostPort)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("unexpected host ports %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRecoverHostPorts(t *testing.T) {
+	// The destination NAT rules of a container publishing its port 80 on
+	// two host addresses, and on an interface, with allocated host ports.
+	entries := []utils.MappingEntry{
+		{HostPort: 32768, ContainerPort: 80, Protocol: "tcp", HostIP: "192.0.2.1"},
+		{HostPort: 32769, ContainerPort: 80, Protocol: "tcp", HostIP: "192.0.2.2"},
+		{HostPort: 32770, ContainerPort: 80, Protocol: "tcp", HostInterface: "eth*"},
+		{HostPort: 32771, ContainerPort: 80, Protocol: "udp"},
+		{HostPort: 32772, ContainerPort: 80, Protocol: "tcp", HostIP: "2001:db8::1"},
+	}
+	pms := []utils.MappingEntry{
+		{ContainerPort: 80, Protocol: "tcp", HostIP: "192.0.2.2"},
+		{ContainerPort: 80, Protocol: "tcp", HostIP: "192.0.2.1"},
+		{ContainerPort: 80, Protocol: "tcp", HostInterface: "eth*"},
+		{ContainerPort: 80, Protocol: "udp"},
+		{ContainerPort: 80, Protocol: "tcp", HostIP: "2001:0db8::0001"},
+		{ContainerPort: 80, Protocol: "tcp"},
+		{HostPort: 8080, ContainerPort: 80, Protocol: "tcp", HostIP: "192.0.2.1"},
+	}
+	want := []int{32769, 32768, 32770, 32771, 32772, 0, 8080}
+
+	conf := &Config{}
+	conf.RuntimeConfig.PortMaps = pms
+	recoverHostPorts(conf, entries)
+	got := []int{}
+	for _, pm := range conf.RuntimeConfig.PortMaps {
+		got = append(got, pm.HostPort)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected host ports %v, want %v", got, want)
+	}
+}
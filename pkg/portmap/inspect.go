@@ -0,0 +1,41 @@
+package portmap
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/greenpau/cni-plugins/pkg/utils"
+)
+
+// Inspect prints the chains of the containers with their rules and the
+// jump rules to the chains, i.e. the inspect subcommand. The arguments are
+// the flags of the subcommand followed by an optional container ID prefix.
+func Inspect(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	format := fs.String("format", "text", "output format, i.e. text or json")
+	tableNames := fs.String("tables", "nat,filter", "comma-separated tables holding the chains of the containers")
+	filter := &utils.InspectFilter{}
+	fs.StringVar(&filter.Network, "network", "", "network of the containers")
+	fs.StringVar(&filter.Family, "family", "", "ip family of the chains, i.e. ipv4 or ipv6")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return fmt.Errorf("inspect accepts at most one container ID prefix, found %d arguments", fs.NArg())
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unsupported inspect format: %s", *format)
+	}
+	if filter.Family != "" && filter.Family != "ipv4" && filter.Family != "ipv6" {
+		return fmt.Errorf("unsupported inspect family: %s", filter.Family)
+	}
+	filter.ContainerID = fs.Arg(0)
+
+	entries, err := utils.InspectContainers(strings.Split(*tableNames, ","), filter)
+	if err != nil {
+		return fmt.Errorf("failed obtaining chains: %s", err)
+	}
+	return utils.WriteInspectContainers(w, entries, *format)
+}
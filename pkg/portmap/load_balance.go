@@ -0,0 +1,222 @@
+package portmap
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/greenpau/cni-plugins/pkg/utils"
+)
+
+// getDestNatGroupChainName returns the name of the chain and of the map
+// holding the destination NAT rules and the backends of a load-balanced
+// port mapping group.
+func getDestNatGroupChainName(groupName string) string {
+	return utils.GetChainName("nlb", groupName)
+}
+
+// addDestNatGroupMember adds a container to a load-balanced port mapping
+// group. When the group does not exist, the function creates the chain
+// and the map of the group, and the jump rules to the chain.
+func (p *Plugin) addDestNatGroupMember(conf *Config, v string, destAddr net.IPNet, bridgeIntfName string) error {
+	nlbChain := getDestNatGroupChainName(conf.RuntimeConfig.LoadBalanceGroup)
+
+	// The backends are read, updated, and written back. The lock keeps
+	// concurrent invocations from dropping each other's backends.
+	unlock, err := lockPortMappings()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	chainExists, err := utils.IsChainExists(v, p.natTableName, nlbChain)
+	if err != nil {
+		return fmt.Errorf(
+			"failed obtaining ipv%s load balancing %s chain info: %s",
+			v, nlbChain, err,
+		)
+	}
+	if !chainExists {
+		if err := utils.CreateChain(v, p.natTableName, nlbChain, "none", "none", "none"); err != nil {
+			return fmt.Errorf(
+				"failed creating ipv%s load balancing %s chain: %s",
+				v, nlbChain, err,
+			)
+		}
+	}
+
+	mapExists, err := utils.IsSetExists(v, p.natTableName, nlbChain)
+	if err != nil {
+		return fmt.Errorf(
+			"failed obtaining ipv%s load balancing %s map info: %s",
+			v, nlbChain, err,
+		)
+	}
+	if !mapExists {
+		if err := utils.CreateDestinationNatGroupMap(v, p.natTableName, nlbChain); err != nil {
+			return err
+		}
+	}
+
+	members, err := utils.GetDestinationNatGroupMembers(v, p.natTableName, nlbChain)
+	if err != nil {
+		return fmt.Errorf(
+			"failed obtaining ipv%s load balancing %s map members: %s",
+			v, nlbChain, err,
+		)
+	}
+
+	found := false
+	for _, member := range members {
+		if member.Equal(destAddr.IP) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		members = append(members, destAddr.IP)
+	}
+
+	if err := utils.SetDestinationNatGroupMembers(
+		map[string]interface{}{
+			"version":          v,
+			"table":            p.natTableName,
+			"chain":            nlbChain,
+			"map":              nlbChain,
+			"bridge_interface": bridgeIntfName,
+			"members":          members,
+			"port_mappings":    conf.RuntimeConfig.PortMaps,
+			"mode":             p.loadBalanceMode,
+		},
+	); err != nil {
+		return err
+	}
+
+	if !chainExists {
+		if err := p.addHostJumpRules(v, nlbChain, bridgeIntfName, ""); err != nil {
+			return err
+		}
+		if err := p.addHostInterfaceJumpRule(conf, v, nlbChain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDestNatGroupMember checks whether a container is a backend of
+// a load-balanced port mapping group.
+func (p *Plugin) checkDestNatGroupMember(conf *Config, v string, destAddr net.IPNet) error {
+	nlbChain := getDestNatGroupChainName(conf.RuntimeConfig.LoadBalanceGroup)
+
+	exists, err := utils.IsChainExists(v, p.natTableName, nlbChain)
+	if err != nil {
+		return fmt.Errorf(
+			"failed obtaining ipv%s load balancing %s chain info: %s",
+			v, nlbChain, err,
+		)
+	}
+	if !exists {
+		return fmt.Errorf(
+			"ipv%s chain %s in %s table does not exist",
+			v, nlbChain, p.natTableName,
+		)
+	}
+
+	members, err := utils.GetDestinationNatGroupMembers(v, p.natTableName, nlbChain)
+	if err != nil {
+		return fmt.Errorf(
+			"failed obtaining ipv%s load balancing %s map members: %s",
+			v, nlbChain, err,
+		)
+	}
+	for _, member := range members {
+		if member.Equal(destAddr.IP) {
+			return nil
+		}
+	}
+	return fmt.Errorf(
+		"ipv%s load balancing group %s has no %s backend",
+		v, conf.RuntimeConfig.LoadBalanceGroup, destAddr.IP,
+	)
+}
+
+// removeDestNatGroupMember removes a container from a load-balanced port
+// mapping group. When the group becomes empty, the function removes the
+// jump rules to the chain of the group, the chain, and the map.
+func (p *Plugin) removeDestNatGroupMember(conf *Config, v string, destAddr net.IPNet, bridgeIntfName string) error {
+	nlbChain := getDestNatGroupChainName(conf.RuntimeConfig.LoadBalanceGroup)
+
+	// The backends are read, updated, and written back. The lock keeps
+	// concurrent invocations from dropping each other's backends.
+	unlock, err := lockPortMappings()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	exists, err := utils.IsSetExists(v, p.natTableName, nlbChain)
+	if err != nil {
+		return fmt.Errorf(
+			"failed obtaining ipv%s load balancing %s map info: %s",
+			v, nlbChain, err,
+		)
+	}
+	if !exists {
+		return nil
+	}
+
+	members, err := utils.GetDestinationNatGroupMembers(v, p.natTableName, nlbChain)
+	if err != nil {
+		return fmt.Errorf(
+			"failed obtaining ipv%s load balancing %s map members: %s",
+			v, nlbChain, err,
+		)
+	}
+
+	remaining := []net.IP{}
+	for _, member := range members {
+		if member.Equal(destAddr.IP) {
+			continue
+		}
+		remaining = append(remaining, member)
+	}
+
+	if len(remaining) > 0 {
+		return utils.SetDestinationNatGroupMembers(
+			map[string]interface{}{
+				"version":          v,
+				"table":            p.natTableName,
+				"chain":            nlbChain,
+				"map":              nlbChain,
+				"bridge_interface": bridgeIntfName,
+				"members":          remaining,
+				"port_mappings":    conf.RuntimeConfig.PortMaps,
+				"mode":             p.loadBalanceMode,
+			},
+		)
+	}
+
+	for _, chainName := range []string{p.preRoutingNatChainName, p.outputNatChainName} {
+		exists, err := utils.IsChainExists(v, p.natTableName, chainName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if err := utils.DeleteJumpRule(v, p.natTableName, chainName, nlbChain); err != nil {
+			return err
+		}
+	}
+
+	exists, err = utils.IsChainExists(v, p.natTableName, nlbChain)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if err := utils.DeleteChain(v, p.natTableName, nlbChain); err != nil {
+			return err
+		}
+	}
+
+	return utils.DeleteSet(v, p.natTableName, nlbChain)
+}
@@ -3,6 +3,7 @@ package portmap
 import (
 	"fmt"
 	"net"
+	"sort"
 
 	current "github.com/containernetworking/cni/pkg/types/040"
 	"github.com/greenpau/cni-plugins/pkg/utils"
@@ -28,7 +29,11 @@ type Plugin struct {
 	preRoutingRawChainName  string
 	filterTableName         string
 	forwardFilterChainName  string
+	loadBalanceMode         string
+	forwardDeny             *utils.DenyRuleConfig
 	interfaceChain          []string
+	attachment              string
+	attachmentIntfName      string
 	targetInterfaces        map[string]*Interface
 	targetIPVersions        map[string]bool
 }
@@ -48,11 +53,40 @@ func NewPlugin(conf *Config) *Plugin {
 		preRoutingRawChainName:  conf.PreRoutingRawChainName,
 		filterTableName:         conf.FilterTableName,
 		forwardFilterChainName:  conf.ForwardFilterChainName,
+		loadBalanceMode:         conf.LoadBalanceMode,
+		forwardDeny:             conf.ForwardDeny,
 		targetIPVersions:        make(map[string]bool),
 		interfaceChain:          []string{},
 	}
 }
 
+// getTargetIPVersions returns the IP versions of the addresses of the
+// container in order, so that the rules are added in the same order
+// every time.
+func (p *Plugin) getTargetIPVersions() []string {
+	versions := []string{}
+	for v := range p.targetIPVersions {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// getTargetInterfaces returns the interfaces with the addresses of the
+// container in the order of their names.
+func (p *Plugin) getTargetInterfaces() []*Interface {
+	names := []string{}
+	for name := range p.targetInterfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	intfs := []*Interface{}
+	for _, name := range names {
+		intfs = append(intfs, p.targetInterfaces[name])
+	}
+	return intfs
+}
+
 // Add adds portmap rules.
 func (p *Plugin) Add(conf *Config, result *current.Result) error {
 	if err := p.execAdd(conf, result); err != nil {
@@ -81,8 +115,17 @@ func (p *Plugin) execAdd(conf *Config, prevResult *current.Result) error {
 	if err := p.validateInput(conf, prevResult); err != nil {
 		return fmt.Errorf("failed validating input: %s", err)
 	}
+	// The host does not forward the traffic of macvlan, ipvlan, and
+	// host-device attachments, i.e. it cannot translate the traffic to
+	// the container.
+	if p.attachment == utils.AttachmentHostDevice {
+		if len(conf.RuntimeConfig.PortMaps) > 0 {
+			return fmt.Errorf("port mappings require a bridge or ptp attachment")
+		}
+		return nil
+	}
 
-	for v := range p.targetIPVersions {
+	for _, v := range p.getTargetIPVersions() {
 		// NAT Table and Chains Setup
 		exists, err := utils.IsTableExist(v, p.natTableName)
 		if err != nil {
@@ -206,19 +249,45 @@ func (p *Plugin) execAdd(conf *Config, prevResult *current.Result) error {
 			)
 		}
 		if !exists {
-			if err := utils.CreateFilterForwardChain(v, p.filterTableName, p.forwardFilterChainName); err != nil {
+			if err := utils.CreateFilterForwardChain(v, p.filterTableName, p.forwardFilterChainName, p.forwardDeny); err != nil {
 				return fmt.Errorf(
 					"failed creating ipv%s %s chain in %s table: %s",
 					v, p.forwardFilterChainName, p.filterTableName, err,
 				)
 			}
+		} else if p.forwardDeny != nil {
+			if err := utils.SetFilterForwardDenyRules(v, p.filterTableName, p.forwardFilterChainName, p.forwardDeny); err != nil {
+				return fmt.Errorf(
+					"failed updating default deny rules in ipv%s %s chain of %s table: %s",
+					v, p.forwardFilterChainName, p.filterTableName, err,
+				)
+			}
+		} else if _, err := utils.RepairFilterForwardChain(v, p.filterTableName, p.forwardFilterChainName); err != nil {
+			return fmt.Errorf(
+				"failed repairing ipv%s %s chain in %s table: %s",
+				v, p.forwardFilterChainName, p.filterTableName, err,
+			)
 		}
 	}
 
-	// Set bridge interface name
-	bridgeIntfName := p.interfaceChain[0]
+	// Allocate host ports to the port mappings with host port 0. The lock
+	// is held until the rules using the ports are in place.
+	if hasAutoHostPorts(conf) {
+		unlock, err := lockPortMappings()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		if err := p.allocateHostPorts(conf); err != nil {
+			return fmt.Errorf("failed allocating host ports: %s", err)
+		}
+	}
+
+	// Set the interface name, i.e. the bridge or, for a ptp attachment,
+	// the host side of the veth pair
+	bridgeIntfName := p.attachmentIntfName
 
-	for _, targetInterface := range p.targetInterfaces {
+	for _, targetInterface := range p.getTargetInterfaces() {
 		for _, addr := range targetInterface.addrs {
 
 			if len(conf.RuntimeConfig.PortMaps) == 0 {
@@ -241,24 +310,31 @@ func (p *Plugin) execAdd(conf *Config, prevResult *current.Result) error {
 			nprChain := utils.GetChainName("npr", conf.ContainerID)
 			npoChain := utils.GetChainName("npo", conf.ContainerID)
 
-			// Add NPR chain.
-			if exists, err := utils.IsChainExists(addr.Version, p.natTableName, nprChain); !exists && err == nil {
-				if err := utils.CreateChain(
-					addr.Version,
-					p.natTableName,
-					nprChain,
-					"none", "none", "none",
-				); err != nil {
+			if conf.RuntimeConfig.LoadBalanceGroup != "" {
+				// Add the container to the load-balanced port mapping group.
+				if err := p.addDestNatGroupMember(conf, addr.Version, destAddr, bridgeIntfName); err != nil {
+					return err
+				}
+			} else {
+				// Add NPR chain.
+				if exists, err := utils.IsChainExists(addr.Version, p.natTableName, nprChain); !exists && err == nil {
+					if err := utils.CreateChain(
+						addr.Version,
+						p.natTableName,
+						nprChain,
+						"none", "none", "none",
+					); err != nil {
+						return fmt.Errorf(
+							"failed creating ipv%s prerouting %s chain: %s",
+							addr.Version, nprChain, err,
+						)
+					}
+				} else if err != nil {
 					return fmt.Errorf(
-						"failed creating ipv%s prerouting %s chain: %s",
+						"failed obtaining ipv%s prerouting %s chain info: %s",
 						addr.Version, nprChain, err,
 					)
 				}
-			} else if err != nil {
-				return fmt.Errorf(
-					"failed obtaining ipv%s prerouting %s chain info: %s",
-					addr.Version, nprChain, err,
-				)
 			}
 
 			// Add postrouting chain
@@ -301,20 +377,28 @@ func (p *Plugin) execAdd(conf *Config, prevResult *current.Result) error {
 			}
 
 			for _, pm := range conf.RuntimeConfig.PortMaps {
-				if err := utils.AddDestinationNatRules(
-					map[string]interface{}{
-						"version":          addr.Version,
-						"table":            p.natTableName,
-						"chain":            nprChain,
-						"bridge_interface": bridgeIntfName,
-						"ip_address":       destAddr,
-						"port_mapping":     pm,
-					},
-				); err != nil {
-					return fmt.Errorf(
-						"failed creating destination NAT rules in %s chain of %s table for %v: %s",
-						nprChain, p.natTableName, pm, err,
-					)
+				if conf.RuntimeConfig.LoadBalanceGroup == "" {
+					// Count the traffic translated to the port mapping
+					counterName := utils.GetMappingCounterName(pm, conf.ContainerID)
+					if err := utils.AddCounters(addr.Version, p.natTableName, []string{counterName}); err != nil {
+						return err
+					}
+					if err := utils.AddDestinationNatRules(
+						map[string]interface{}{
+							"version":          addr.Version,
+							"table":            p.natTableName,
+							"chain":            nprChain,
+							"bridge_interface": bridgeIntfName,
+							"ip_address":       destAddr,
+							"port_mapping":     pm,
+							"counter":          counterName,
+						},
+					); err != nil {
+						return fmt.Errorf(
+							"failed creating destination NAT rules in %s chain of %s table for %v: %s",
+							nprChain, p.natTableName, pm, err,
+						)
+					}
 				}
 
 				// Check whether the rule allowing traffic to leave out of
@@ -353,76 +437,132 @@ func (p *Plugin) execAdd(conf *Config, prevResult *current.Result) error {
 				)
 			}
 
-			// Loop through local IP addresses for the next two rule
-			// creation functions. The loops are blatently stolen from
-			// https://stackoverflow.com/questions/23558425/how-do-i-get-the-local-ip-address-in-go
-			hostInterfaces, err := net.Interfaces()
-			if err != nil {
-				return fmt.Errorf("Failed to get local interfaces: %s", err)
+			if conf.RuntimeConfig.LoadBalanceGroup == "" {
+				if err := p.addHostJumpRules(addr.Version, nprChain, bridgeIntfName, utils.GetContainerInfoComment(conf.ContainerID, conf.Name)); err != nil {
+					return err
+				}
+				if err := p.addHostInterfaceJumpRule(conf, addr.Version, nprChain); err != nil {
+					return err
+				}
 			}
+		}
+	}
+	return nil
+}
 
-			for _, i := range hostInterfaces {
+// addHostJumpRules adds `ip daddr` jump rules for each of the local IP
+// addresses from NAT prerouting and output chains to the provided chain.
+// The rules carry the provided comment.
+func (p *Plugin) addHostJumpRules(v, dstChainName, bridgeIntfName, comment string) error {
+	hostAddrs, err := getHostAddrs(bridgeIntfName)
+	if err != nil {
+		return err
+	}
 
-				// Skip the container bridge interface
-				if i.Name == bridgeIntfName {
-					continue
-				}
+	for _, hostAddr := range hostAddrs {
+		// Skip IPv6 addresses when working with IPv4, and vice versa.
+		if v == "4" && hostAddr.To4() == nil {
+			continue
+		}
+		if v == "6" && hostAddr.To4() != nil {
+			continue
+		}
 
-				hostIPAddrs, err := i.Addrs()
-				if err != nil {
-					return fmt.Errorf(
-						"Failed to get IP addresses for interface %s: %s",
-						i.Name, err,
-					)
-				}
-				for _, hostIPAddr := range hostIPAddrs {
-					var hostAddr net.IP
-					switch foo := hostIPAddr.(type) {
-					case *net.IPNet:
-						hostAddr = foo.IP
-					case *net.IPAddr:
-						hostAddr = foo.IP
-					}
+		// Add an `ip daddr` jump rule to the NAT prerouting chain.
+		if err := utils.CreateJumpRuleWithIPDaddrMatch(
+			v,
+			p.natTableName,
+			p.preRoutingNatChainName,
+			dstChainName,
+			hostAddr,
+			comment,
+		); err != nil {
+			return fmt.Errorf(
+				"failed creating jump rule from ipv%s prerouting %s chain: %s",
+				v, dstChainName, err,
+			)
+		}
 
-					// Skip IPv6 addresses when working with IPv4, and vice versa.
-					if addr.Version == "4" && hostAddr.To4() == nil {
-						continue
-					}
-					if addr.Version == "6" && hostAddr.To16() == nil {
-						continue
-					}
+		// Add an `ip daddr` jump rule to the NAT output chain.
+		if err := utils.CreateJumpRuleWithIPDaddrMatch(
+			v,
+			p.natTableName,
+			p.outputNatChainName,
+			dstChainName,
+			hostAddr,
+			comment,
+		); err != nil {
+			return fmt.Errorf(
+				"failed creating jump rule from ipv%s output %s chain: %s",
+				v, dstChainName, err,
+			)
+		}
+	}
+	return nil
+}
 
-					// Add an `ip daddr` jump rule to the NAT prerouting chain.
-					if err := utils.CreateJumpRuleWithIPDaddrMatch(
-						addr.Version,
-						p.natTableName,
-						p.preRoutingNatChainName,
-						nprChain,
-						hostAddr,
-					); err != nil {
-						return fmt.Errorf(
-							"failed creating jump rule from ipv%s prerouting %s chain: %s",
-							addr.Version, nprChain, err,
-						)
-					}
+// getHostAddrs returns the IP addresses of the host interfaces, except
+// the provided container bridge interface. It is a variable, so that the
+// rules the tests expect do not depend on the addresses of the host.
+var getHostAddrs = func(bridgeIntfName string) ([]net.IP, error) {
+	// The loops are blatently stolen from
+	// https://stackoverflow.com/questions/23558425/how-do-i-get-the-local-ip-address-in-go
+	hostInterfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get local interfaces: %s", err)
+	}
 
-					// Add an `ip daddr` jump rule to the NAT output chain.
-					if err := utils.CreateJumpRuleWithIPDaddrMatch(
-						addr.Version,
-						p.natTableName,
-						p.outputNatChainName,
-						nprChain,
-						hostAddr,
-					); err != nil {
-						return fmt.Errorf(
-							"failed creating jump rule from ipv%s output %s chain: %s",
-							addr.Version, nprChain, err,
-						)
-					}
-				}
+	hostAddrs := []net.IP{}
+	for _, i := range hostInterfaces {
+
+		// Skip the container bridge interface
+		if i.Name == bridgeIntfName {
+			continue
+		}
+
+		hostIPAddrs, err := i.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf(
+				"Failed to get IP addresses for interface %s: %s",
+				i.Name, err,
+			)
+		}
+		for _, hostIPAddr := range hostIPAddrs {
+			switch foo := hostIPAddr.(type) {
+			case *net.IPNet:
+				hostAddrs = append(hostAddrs, foo.IP)
+			case *net.IPAddr:
+				hostAddrs = append(hostAddrs, foo.IP)
 			}
 		}
 	}
+	return hostAddrs, nil
+}
+
+// addHostInterfaceJumpRule adds a `fib daddr type local` jump rule from
+// NAT prerouting chain to the provided chain, when a port is published on
+// a host interface. The port is then reachable whatever the IP addresses
+// of the interface are. The rule is not added to NAT output chain, because
+// the locally generated packets have no input interface.
+func (p *Plugin) addHostInterfaceJumpRule(conf *Config, v, dstChainName string) error {
+	for _, pm := range conf.RuntimeConfig.PortMaps {
+		if pm.HostInterface == "" {
+			continue
+		}
+		if err := utils.CreateJumpRuleWithLocalDaddrMatch(
+			v,
+			p.natTableName,
+			p.preRoutingNatChainName,
+			dstChainName,
+			utils.GetContainerInfoComment(conf.ContainerID, conf.Name),
+		); err != nil {
+			return fmt.Errorf(
+				"failed creating local address jump rule from ipv%s prerouting %s chain: %s",
+				v, dstChainName, err,
+			)
+		}
+		break
+	}
 	return nil
 }
 
@@ -430,8 +570,11 @@ func (p *Plugin) execCheck(conf *Config, prevResult *current.Result) error {
 	if err := p.validateInput(conf, prevResult); err != nil {
 		return fmt.Errorf("failed validating input: %s", err)
 	}
+	if p.attachment == utils.AttachmentHostDevice {
+		return nil
+	}
 
-	for v := range p.targetIPVersions {
+	for _, v := range p.getTargetIPVersions() {
 		// Check NAT table
 		exists, err := utils.IsTableExist(v, p.natTableName)
 		if err != nil {
@@ -542,6 +685,32 @@ func (p *Plugin) execCheck(conf *Config, prevResult *current.Result) error {
 				v, p.forwardFilterChainName, p.filterTableName,
 			)
 		}
+
+		// Check allocated host ports
+		if hasAutoHostPorts(conf) {
+			if err := p.resolveHostPorts(conf, v); err != nil {
+				return err
+			}
+			for _, pm := range conf.RuntimeConfig.PortMaps {
+				if pm.HostPort == 0 {
+					return fmt.Errorf(
+						"ipv%s %s container port %d has no allocated host port",
+						v, pm.Protocol, pm.ContainerPort,
+					)
+				}
+			}
+		}
+
+		// Check load balancing group
+		if conf.RuntimeConfig.LoadBalanceGroup != "" {
+			destAddr := conf.ContIPv4
+			if v == "6" {
+				destAddr = conf.ContIPv6
+			}
+			if err := p.checkDestNatGroupMember(conf, v, destAddr); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -554,12 +723,15 @@ func (p *Plugin) execDelete(conf *Config, prevResult *current.Result) error {
 	if err := p.validateInput(conf, prevResult); err != nil {
 		return fmt.Errorf("failed validating input: %s", err)
 	}
+	if p.attachment == utils.AttachmentHostDevice {
+		return nil
+	}
 
 	nprChain := utils.GetChainName("npr", conf.ContainerID)
 	npoChain := utils.GetChainName("npo", conf.ContainerID)
-	bridgeIntfName := p.interfaceChain[0]
+	bridgeIntfName := p.attachmentIntfName
 
-	for v := range p.targetIPVersions {
+	for _, v := range p.getTargetIPVersions() {
 
 		if natTableExists, err = utils.IsTableExist(v, p.natTableName); natTableExists && err == nil {
 			if preRoutingNatChainExists, err = utils.IsChainExists(v, p.natTableName, p.preRoutingNatChainName); err != nil {
@@ -603,7 +775,15 @@ func (p *Plugin) execDelete(conf *Config, prevResult *current.Result) error {
 			)
 		}
 
-		for _, targetInterface := range p.targetInterfaces {
+		// Recover the host ports allocated to the port mappings
+		// with host port 0.
+		if natTableExists && hasAutoHostPorts(conf) {
+			if err := p.resolveHostPorts(conf, v); err != nil {
+				return err
+			}
+		}
+
+		for _, targetInterface := range p.getTargetInterfaces() {
 			for _, addr := range targetInterface.addrs {
 				if v != addr.Version {
 					continue
@@ -639,6 +819,11 @@ func (p *Plugin) execDelete(conf *Config, prevResult *current.Result) error {
 							return err
 						}
 					}
+					// The counters of the port mappings are deleted after
+					// the chain referring to them.
+					if err := utils.DeleteCounters(addr.Version, p.natTableName, nil, utils.GetContainerKey(conf.ContainerID)); err != nil {
+						return err
+					}
 					if npoExists {
 						if postRoutingNatChainExists {
 							if err := utils.DeleteJumpRule(addr.Version, p.natTableName, p.postRoutingNatChainName, npoChain); err != nil {
@@ -658,6 +843,15 @@ func (p *Plugin) execDelete(conf *Config, prevResult *current.Result) error {
 					destAddr = conf.ContIPv6
 				}
 
+				if natTableExists && conf.RuntimeConfig.LoadBalanceGroup != "" {
+					if err := p.removeDestNatGroupMember(conf, addr.Version, destAddr, bridgeIntfName); err != nil {
+						return fmt.Errorf(
+							"failed removing ipv%s %s from load balancing group %s: %s",
+							addr.Version, destAddr.IP, conf.RuntimeConfig.LoadBalanceGroup, err,
+						)
+					}
+				}
+
 				if filterTableExists && forwardFilterChainExists {
 					for _, pm := range conf.RuntimeConfig.PortMaps {
 						if err := utils.RemoveFilterForwardMappedPortRules(
@@ -115,7 +115,7 @@ func TestPlugin(t *testing.T) {
 				}
 
 				err = testutils.CmdCheckWithArgs(args, func() error {
-					return Check(args)
+					return ExplainCheck(args)
 				})
 				if err != nil {
 					return err
@@ -0,0 +1,33 @@
+package portmap
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/greenpau/cni-plugins/pkg/utils"
+)
+
+// Stats prints the named counters of the containers, i.e. the stats
+// subcommand. The arguments are the flags of the subcommand followed by
+// an optional container ID.
+func Stats(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	format := fs.String("format", "table", "output format, i.e. table or json")
+	tableName := fs.String("table", "nat", "nat table holding the DNAT counters")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return fmt.Errorf("stats accepts at most one container ID, found %d arguments", fs.NArg())
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unsupported stats format: %s", *format)
+	}
+
+	entries, err := utils.GetCounterStats(*tableName, fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed obtaining counters: %s", err)
+	}
+	return utils.WriteCounterStats(w, entries, *format)
+}
@@ -0,0 +1,161 @@
+package portmap
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/greenpau/cni-plugins/pkg/utils"
+)
+
+// Validate checks the configurations of the plugin in a conflist or in a
+// single plugin configuration without applying them, i.e. the validate
+// subcommand. The arguments are the flags of the subcommand followed by
+// the path to the file, or "-" for the standard input. It lists every
+// problem found.
+func Validate(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	pluginType := fs.String("type", "cni-nftables-portmap", "type of the plugin in the conflist")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("validate requires the path to a network configuration file, found %d arguments", fs.NArg())
+	}
+	fp := fs.Arg(0)
+
+	entries, err := utils.ReadPluginConfigs(fp, *pluginType)
+	if err != nil {
+		return fmt.Errorf("%s: %s", fp, err)
+	}
+	problems := []utils.ConfigProblems{}
+	for _, entry := range entries {
+		problems = append(problems, validateConfig(entry.Data))
+	}
+	if count := utils.WriteConfigProblems(w, fp, entries, problems); count > 0 {
+		return fmt.Errorf("%s: found %d problems", fp, count)
+	}
+	return nil
+}
+
+// validateConfig returns the problems of a plugin configuration. It runs
+// the checks of parseConfigFromBytes, without stopping at the first
+// failing one, and the checks of the names of the tables and the chains,
+// the protocols, and the addresses of the port mappings, which would
+// otherwise fail when a container is added.
+func validateConfig(data []byte) utils.ConfigProblems {
+	problems := utils.ConfigProblems{}
+	conf := &Config{}
+	if err := json.Unmarshal(data, conf); err != nil {
+		problems.Add("failed to load conf: %v", err)
+		return problems
+	}
+
+	if _, exists := supportedVersionsMap[conf.CNIVersion]; !exists {
+		problems.Addf("unsupported CNI version %s", conf.CNIVersion)
+	}
+
+	setDefaultNames(conf)
+	problems.Add("invalid forward deny config: %v", utils.ValidateDenyRuleConfig(conf.ForwardDeny))
+
+	switch conf.LoadBalanceMode {
+	case "", "numgen", "jhash":
+	default:
+		problems.Addf("unsupported load balancing mode %s", conf.LoadBalanceMode)
+	}
+
+	if conf.MarkMasqBit != nil && conf.ExternalSetMarkChain != nil {
+		problems.Addf("Cannot specify externalSetMarkChain and markMasqBit")
+	}
+	if conf.MarkMasqBit != nil && (*conf.MarkMasqBit < 0 || *conf.MarkMasqBit > 31) {
+		problems.Addf("MasqMarkBit must be between 0 and 31")
+	}
+
+	for _, pm := range conf.RuntimeConfig.PortMaps {
+		if pm.ContainerPort <= 0 {
+			problems.Addf("Invalid container port number: %d", pm.ContainerPort)
+		}
+		if pm.HostPort < 0 || pm.HostPort > 65535 {
+			problems.Addf("Invalid host port number: %d", pm.HostPort)
+		}
+		if pm.HostPort == 0 && conf.RuntimeConfig.LoadBalanceGroup != "" {
+			problems.Addf("Host port allocation is not supported for load balancing group %s", conf.RuntimeConfig.LoadBalanceGroup)
+		}
+		if pm.Protocol != "tcp" && pm.Protocol != "udp" {
+			problems.Addf("Invalid protocol for host port %d: %q", pm.HostPort, pm.Protocol)
+		}
+		if pm.HostIP != "" && net.ParseIP(pm.HostIP) == nil {
+			problems.Addf("Invalid host IP for host port %d: %s", pm.HostPort, pm.HostIP)
+		}
+		if pm.HostInterface != "" {
+			if err := utils.ValidateInterfaceNameMatch(pm.HostInterface); err != nil {
+				problems.Addf("Invalid host interface for host port %d: %v", pm.HostPort, err)
+			}
+		}
+		if err := utils.ValidateMappingLimit(pm.Limit); err != nil {
+			problems.Addf("Invalid limit for host port %d: %v", pm.HostPort, err)
+		}
+		problems.CheckNetworks("", pm.AllowedSources)
+	}
+
+	if conf.HostPortRange != "" {
+		_, _, err := parseHostPortRange(conf.HostPortRange)
+		problems.Add("", err)
+	}
+
+	if conf.DryRun != nil {
+		problems.Add("invalid dry-run config: %v", utils.ValidateDryRunConfig(conf.DryRun))
+	}
+
+	problems.CheckNetworks("", conf.AllowedSources)
+
+	for _, name := range [][2]string{
+		{"nat_table_name", conf.NatTableName},
+		{"postrouting_nat_chain_name", conf.PostRoutingNatChainName},
+		{"prerouting_nat_chain_name", conf.PreRoutingNatChainName},
+		{"output_nat_chain_name", conf.OutputNatChainName},
+		{"input_nat_chain_name", conf.InputNatChainName},
+		{"raw_table_name", conf.RawTableName},
+		{"prerouting_raw_chain_name", conf.PreRoutingRawChainName},
+		{"filter_table_name", conf.FilterTableName},
+		{"forward_filter_chain_name", conf.ForwardFilterChainName},
+	} {
+		problems.CheckNftName(name[0], name[1])
+	}
+	if conf.ExternalSetMarkChain != nil {
+		problems.CheckNftName("externalSetMarkChain", *conf.ExternalSetMarkChain)
+	}
+	problems.CheckChains(getConfigChains(conf))
+
+	// The checks above cover the ones of parseConfigFromBytes. Its error,
+	// if not among the problems yet, is a check missing above, e.g. the
+	// parsing of the previous result.
+	if _, _, err := parseConfigFromBytes(data, ""); err != nil {
+		problems.Add("", err)
+	}
+	return problems
+}
+
+// getConfigChains returns the chains the plugin creates in its tables
+// with the provided configuration.
+func getConfigChains(conf *Config) []*utils.ConfigChain {
+	chains := []*utils.ConfigChain{}
+	addChain := func(tableOption, table, chainOption, chain string) {
+		chains = append(chains, &utils.ConfigChain{
+			Family:      "ip",
+			TableOption: tableOption,
+			Table:       table,
+			ChainOption: chainOption,
+			Chain:       chain,
+		})
+	}
+	addChain("nat_table_name", conf.NatTableName, "postrouting_nat_chain_name", conf.PostRoutingNatChainName)
+	addChain("nat_table_name", conf.NatTableName, "prerouting_nat_chain_name", conf.PreRoutingNatChainName)
+	addChain("nat_table_name", conf.NatTableName, "output_nat_chain_name", conf.OutputNatChainName)
+	addChain("nat_table_name", conf.NatTableName, "input_nat_chain_name", conf.InputNatChainName)
+	addChain("raw_table_name", conf.RawTableName, "prerouting_raw_chain_name", conf.PreRoutingRawChainName)
+	addChain("filter_table_name", conf.FilterTableName, "forward_filter_chain_name", conf.ForwardFilterChainName)
+	return chains
+}
@@ -0,0 +1,75 @@
+package portmap
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/greenpau/cni-plugins/pkg/utils"
+)
+
+func TestValidateConfig(t *testing.T) {
+	var tests = []struct {
+		name string
+		path string
+		data string
+		want []string
+	}{
+		{
+			name: "valid plugin config",
+			path: "testdata/portmap/stdindata/stdindata1.json",
+		},
+		{
+			name: "all problems listed",
+			data: `{"cniVersion": "0.4.0", "name": "test", "plugins": [
+				{
+					"type": "cni-nftables-portmap",
+					"markMasqBit": 32,
+					"allowedSources": ["10.0.0.1", "10.0.0.256"],
+					"runtimeConfig": {"portMappings": [
+						{"hostPort": 8080, "containerPort": 80, "protocol": "sctp"},
+						{"hostPort": 8443, "containerPort": 443, "protocol": "tcp", "allowedSources": ["2001:db8::/129"]}
+					]}
+				}
+			]}`,
+			want: []string{
+				"MasqMarkBit must be between 0 and 31",
+				`Invalid protocol for host port 8080: "sctp"`,
+				"invalid source network: 2001:db8::/129",
+				"invalid source address: 10.0.0.256",
+			},
+		},
+		{
+			name: "conflicting names",
+			data: `{"cniVersion": "0.4.0", "name": "test", "type": "cni-nftables-portmap", "raw_table_name": "nat"}`,
+			want: []string{
+				"prerouting_nat_chain_name and prerouting_raw_chain_name refer to the same chain prerouting of ip table nat",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := []byte(test.data)
+			if test.path != "" {
+				var err error
+				if data, err = utils.LoadDataFromFilePath(test.path); err != nil {
+					t.Fatalf("failed loading %s: %s", test.path, err)
+				}
+			}
+			entries, err := utils.ParsePluginConfigs(data, "cni-nftables-portmap")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			got := []string{}
+			for _, entry := range entries {
+				got = append(got, validateConfig(entry.Data)...)
+			}
+			if test.want == nil {
+				test.want = []string{}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("unexpected problems\ngot:  %q\nwant: %q", got, test.want)
+			}
+		})
+	}
+}
@@ -2,7 +2,9 @@ package portmap
 
 import (
 	"fmt"
+
 	current "github.com/containernetworking/cni/pkg/types/040"
+	"github.com/greenpau/cni-plugins/pkg/utils"
 )
 
 func (p *Plugin) validateInput(conf *Config, result *current.Result) error {
@@ -31,6 +33,8 @@ func (p *Plugin) validateInput(conf *Config, result *current.Result) error {
 		intfMap[i] = intf.Name
 	}
 
+	p.attachment, p.attachmentIntfName = utils.GetAttachment(result)
+
 	if len(result.IPs) == 0 {
 		return fmt.Errorf("the data passed to port mapping plugin has no IP addresses")
 	}
@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// getAntiSpoofingRuleComment returns the comment tagging the anti-spoofing
+// rules of a particular host interface.
+func getAntiSpoofingRuleComment(intfName string) string {
+	return "cni-spoof " + intfName
+}
+
+// getVersionAddrs returns the addresses of the provided IP version.
+func getVersionAddrs(v string, addrs []net.IP) [][]byte {
+	matched := [][]byte{}
+	for _, ip := range addrs {
+		if (ip.To4() != nil) != (v == "4") {
+			continue
+		}
+		matched = append(matched, encodeSetAddr(v, ip))
+	}
+	return matched
+}
+
+// addrNotInMatch returns the exprs matching the packets with the address at
+// the provided offset of the provided base being none of the provided
+// addresses.
+func addrNotInMatch(base expr.PayloadBase, offset, addrLen uint32, addrs [][]byte) []expr.Any {
+	exprs := []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: base, Offset: offset, Len: addrLen},
+	}
+	for _, addr := range addrs {
+		exprs = append(exprs, &expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: addr})
+	}
+	return exprs
+}
+
+// linkLocalNotMatch returns the exprs matching the packets with the IPv6
+// address at the provided offset of the provided base being neither
+// a link-local nor the unspecified address.
+func linkLocalNotMatch(base expr.PayloadBase, offset uint32) []expr.Any {
+	return []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: base, Offset: offset, Len: 16},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: net.IPv6unspecified.To16()},
+		&expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            16,
+			Mask:           net.CIDRMask(10, 128),
+			Xor:            make([]byte, 16),
+		},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: net.ParseIP("fe80::").To16()},
+	}
+}
+
+// SetFilterForwardAntiSpoofingRules replaces the rules dropping the packets
+// received on a host interface with a source address other than the
+// provided addresses in a chain of filter table. The rules are inserted at
+// the top of the chain. The rule looks like:
+//
+//	iifname "<intfName>" ip saddr != 192.168.100.5 counter drop
+func SetFilterForwardAntiSpoofingRules(v, tableName, chainName, intfName string, addrs []net.IP) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	comment := getAntiSpoofingRuleComment(intfName)
+	existingRules, err := GetRulesByComment(v, tableName, chainName, comment)
+	if err != nil {
+		return err
+	}
+	if len(existingRules) == 0 && len(addrs) == 0 {
+		return nil
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+
+	for _, r := range existingRules {
+		if err := conn.DelRule(r); err != nil {
+			return err
+		}
+	}
+
+	if len(addrs) > 0 {
+		tb := &nftables.Table{
+			Name: tableName,
+		}
+		var saddrOffset, addrLen uint32
+		if v == "4" {
+			tb.Family = nftables.TableFamilyIPv4
+			saddrOffset, addrLen = 12, 4
+		} else {
+			tb.Family = nftables.TableFamilyIPv6
+			saddrOffset, addrLen = 8, 16
+		}
+
+		exprs := []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(intfName)},
+		}
+		exprs = append(exprs, addrNotInMatch(expr.PayloadBaseNetworkHeader, saddrOffset, addrLen, getVersionAddrs(v, addrs))...)
+		exprs = append(exprs, &expr.Counter{}, &expr.Verdict{Kind: expr.VerdictDrop})
+
+		conn.InsertRule(&nftables.Rule{
+			Table:    tb,
+			Chain:    &nftables.Chain{Name: chainName, Table: tb},
+			UserData: EncodeRuleComment(comment),
+			Exprs:    exprs,
+		})
+	}
+
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed setting anti-spoofing rules in chain %s of ipv%s %s table: %s",
+			chainName, v, tableName, err,
+		)
+	}
+	return nil
+}
+
+// RemoveFilterForwardAntiSpoofingRules removes the anti-spoofing rules of
+// a host interface in a chain of filter table.
+func RemoveFilterForwardAntiSpoofingRules(v, tableName, chainName, intfName string) error {
+	return SetFilterForwardAntiSpoofingRules(v, tableName, chainName, intfName, nil)
+}
+
+// SetBridgeAntiSpoofingRules replaces the rules pinning the MAC address and
+// the IP addresses of the frames received on a bridge port in a chain of
+// a bridge family table. The frames of an IP version without addresses
+// are dropped. The IPv6 link-local and unspecified source addresses are
+// allowed for neighbor discovery. The rules look like:
+//
+//	iifname "<intfName>" ether saddr != <mac> counter drop
+//	iifname "<intfName>" meta protocol arp arp saddr ether != <mac> counter drop
+//	iifname "<intfName>" meta protocol arp arp saddr ip != 192.168.100.5 counter drop
+//	iifname "<intfName>" meta protocol ip ip saddr != 192.168.100.5 counter drop
+//	iifname "<intfName>" meta protocol ip6 ip6 saddr != { 2001:db8::5, ::, fe80::/10 } counter drop
+//	iifname "<intfName>" meta protocol ip6 icmpv6 type nd-neighbor-advert icmpv6 target != { 2001:db8::5, fe80::/10 } counter drop
+func SetBridgeAntiSpoofingRules(tableName, chainName, intfName string, mac net.HardwareAddr, addrs []net.IP) error {
+	exists, err := IsBridgeChainExists(tableName, chainName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := CreateBridgeChain(tableName, chainName, "prerouting"); err != nil {
+			return err
+		}
+	}
+
+	iifMatch := []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(intfName)},
+	}
+	protoMatch := func(etherType uint16) []expr.Any {
+		return append(append([]expr.Any{}, iifMatch...),
+			&expr.Meta{Key: expr.MetaKeyPROTOCOL, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.BigEndian.PutUint16(etherType)},
+		)
+	}
+	drop := []expr.Any{&expr.Counter{}, &expr.Verdict{Kind: expr.VerdictDrop}}
+
+	rules := [][]expr.Any{}
+
+	// [ payload load 6b @ link header + 6 => reg 1 ]
+	r := append([]expr.Any{}, iifMatch...)
+	r = append(r, addrNotInMatch(expr.PayloadBaseLLHeader, 6, 6, [][]byte{mac})...)
+	rules = append(rules, append(r, drop...))
+
+	// The sender hardware and protocol addresses of ARP packets are at
+	// offsets 8 and 14 of the network header.
+	r = protoMatch(unix.ETH_P_ARP)
+	r = append(r, addrNotInMatch(expr.PayloadBaseNetworkHeader, 8, 6, [][]byte{mac})...)
+	rules = append(rules, append(r, drop...))
+	r = protoMatch(unix.ETH_P_ARP)
+	r = append(r, addrNotInMatch(expr.PayloadBaseNetworkHeader, 14, 4, getVersionAddrs("4", addrs))...)
+	rules = append(rules, append(r, drop...))
+
+	r = protoMatch(unix.ETH_P_IP)
+	r = append(r, addrNotInMatch(expr.PayloadBaseNetworkHeader, 12, 4, getVersionAddrs("4", addrs))...)
+	rules = append(rules, append(r, drop...))
+
+	r = protoMatch(unix.ETH_P_IPV6)
+	r = append(r, addrNotInMatch(expr.PayloadBaseNetworkHeader, 8, 16, getVersionAddrs("6", addrs))...)
+	r = append(r, linkLocalNotMatch(expr.PayloadBaseNetworkHeader, 8)...)
+	rules = append(rules, append(r, drop...))
+
+	// The target address of neighbor advertisements is at offset 8 of
+	// the transport header.
+	r = protoMatch(unix.ETH_P_IPV6)
+	r = append(r,
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_ICMPV6}},
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 0, Len: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{136}},
+	)
+	r = append(r, addrNotInMatch(expr.PayloadBaseTransportHeader, 8, 16, getVersionAddrs("6", addrs))...)
+	r = append(r, linkLocalNotMatch(expr.PayloadBaseTransportHeader, 8)...)
+	rules = append(rules, append(r, drop...))
+
+	return setBridgeRulesByComment(tableName, chainName, getAntiSpoofingRuleComment(intfName), rules)
+}
+
+// RemoveBridgeAntiSpoofingRules removes the anti-spoofing rules of a bridge
+// port in a chain of a bridge family table.
+func RemoveBridgeAntiSpoofingRules(tableName, chainName, intfName string) error {
+	exists, err := IsBridgeChainExists(tableName, chainName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	return setBridgeRulesByComment(tableName, chainName, getAntiSpoofingRuleComment(intfName), nil)
+}
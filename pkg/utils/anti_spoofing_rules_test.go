@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestSetFilterForwardAntiSpoofingRules(t *testing.T) {
+	addrs := []net.IP{net.ParseIP("10.88.0.5"), net.ParseIP("10.88.0.6"), net.ParseIP("fd00::5")}
+	got := getDryRunCommands(t, func() error {
+		for _, v := range []string{"4", "6"} {
+			if err := CreateTable(v, "filter"); err != nil {
+				return err
+			}
+			if err := CreateChain(v, "filter", "cni-ffw-test", "none", "none", "none"); err != nil {
+				return err
+			}
+			if err := SetFilterForwardAntiSpoofingRules(v, "filter", "cni-ffw-test", "veth0", addrs); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	want := []string{
+		"add table ip filter",
+		"add chain ip filter cni-ffw-test",
+		`insert rule ip filter cni-ffw-test iifname "veth0" ip saddr != 10.88.0.5 ip saddr != 10.88.0.6 counter packets 0 bytes 0 drop comment "cni-spoof veth0"`,
+		"add table ip6 filter",
+		"add chain ip6 filter cni-ffw-test",
+		`insert rule ip6 filter cni-ffw-test iifname "veth0" ip6 saddr != fd00::5 counter packets 0 bytes 0 drop comment "cni-spoof veth0"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected rules\ngot:\n%#v\nwant:\n%#v", got, want)
+	}
+}
+
+func TestSetBridgeAntiSpoofingRules(t *testing.T) {
+	mac, _ := net.ParseMAC("d2:75:52:3d:30:f4")
+	addrs := []net.IP{net.ParseIP("10.88.0.5"), net.ParseIP("fd00::5")}
+	got := getDryRunCommands(t, func() error {
+		return SetBridgeAntiSpoofingRules("cni_plugins", "cni-prerouting", "veth0", mac, addrs)
+	})
+	want := []string{
+		"add table bridge cni_plugins",
+		"add chain bridge cni_plugins cni-prerouting { type filter hook prerouting priority 0; policy accept; }",
+		`add rule bridge cni_plugins cni-prerouting iifname "veth0" ether saddr != d2:75:52:3d:30:f4 counter packets 0 bytes 0 drop comment "cni-spoof veth0"`,
+		`add rule bridge cni_plugins cni-prerouting iifname "veth0" meta protocol arp @nh,64,48 != 0xd275523d30f4 counter packets 0 bytes 0 drop comment "cni-spoof veth0"`,
+		`add rule bridge cni_plugins cni-prerouting iifname "veth0" meta protocol arp @nh,112,32 != 0x0a580005 counter packets 0 bytes 0 drop comment "cni-spoof veth0"`,
+		`add rule bridge cni_plugins cni-prerouting iifname "veth0" meta protocol ip ip saddr != 10.88.0.5 counter packets 0 bytes 0 drop comment "cni-spoof veth0"`,
+		`add rule bridge cni_plugins cni-prerouting iifname "veth0" meta protocol ip6 ip6 saddr != fd00::5 ip6 saddr != :: ip6 saddr != fe80::/10 counter packets 0 bytes 0 drop comment "cni-spoof veth0"`,
+		`add rule bridge cni_plugins cni-prerouting iifname "veth0" meta protocol ip6 meta l4proto icmpv6 @th,0,8 0x88 @th,64,128 != 0xfd000000000000000000000000000005 @th,64,128 != 0x00000000000000000000000000000000 @th,64,128 & 0xffc00000000000000000000000000000 != 0xfe800000000000000000000000000000 counter packets 0 bytes 0 drop comment "cni-spoof veth0"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected rules\ngot:\n%#v\nwant:\n%#v", got, want)
+	}
+}
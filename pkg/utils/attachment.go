@@ -0,0 +1,48 @@
+package utils
+
+import (
+	current "github.com/containernetworking/cni/pkg/types/040"
+)
+
+// The types of the attachment of a container to the host.
+const (
+	// AttachmentBridge is a veth pair with the host side in a bridge,
+	// e.g. the bridge plugin. The rules match the bridge.
+	AttachmentBridge = "bridge"
+	// AttachmentPtp is a routed veth pair, e.g. the ptp plugin. The rules
+	// match the host side of the veth pair.
+	AttachmentPtp = "ptp"
+	// AttachmentMacvlan is a macvlan or ipvlan interface of a parent
+	// device. The traffic of the container does not traverse the IP
+	// stack of the host. The rules are in the ingress hook of the parent.
+	AttachmentMacvlan = "macvlan"
+	// AttachmentHostDevice is a device moved into the container, e.g. the
+	// host-device plugin. The traffic of the container does not traverse
+	// the host.
+	AttachmentHostDevice = "host-device"
+)
+
+// GetAttachment returns the type of the attachment of a container and the
+// host interface carrying the traffic of the container, based on the host
+// side interfaces of the provided result, i.e. the interfaces having an
+// empty sandbox. The result of the bridge plugin has the bridge and the
+// host side of the veth pair, and the result of the ptp plugin has only the
+// host side of the veth pair. Without host side interfaces, the function
+// returns AttachmentHostDevice, because the result of the macvlan and
+// ipvlan plugins does not have the parent device.
+func GetAttachment(result *current.Result) (string, string) {
+	hostIntfNames := []string{}
+	for _, intf := range result.Interfaces {
+		if intf.Sandbox != "" {
+			continue
+		}
+		hostIntfNames = append(hostIntfNames, intf.Name)
+	}
+	switch len(hostIntfNames) {
+	case 0:
+		return AttachmentHostDevice, ""
+	case 1:
+		return AttachmentPtp, hostIntfNames[0]
+	}
+	return AttachmentBridge, hostIntfNames[0]
+}
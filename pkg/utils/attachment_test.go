@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"testing"
+
+	current "github.com/containernetworking/cni/pkg/types/040"
+)
+
+func TestGetAttachment(t *testing.T) {
+	testcases := []struct {
+		name           string
+		interfaces     []*current.Interface
+		wantAttachment string
+		wantIntfName   string
+	}{
+		{
+			name: "bridge",
+			interfaces: []*current.Interface{
+				{Name: "cni-podman0"},
+				{Name: "veth0"},
+				{Name: "eth0", Sandbox: "/run/netns/test"},
+			},
+			wantAttachment: AttachmentBridge,
+			wantIntfName:   "cni-podman0",
+		},
+		{
+			name: "ptp",
+			interfaces: []*current.Interface{
+				{Name: "veth0"},
+				{Name: "eth0", Sandbox: "/run/netns/test"},
+			},
+			wantAttachment: AttachmentPtp,
+			wantIntfName:   "veth0",
+		},
+		{
+			name: "macvlan",
+			interfaces: []*current.Interface{
+				{Name: "eth0", Sandbox: "/run/netns/test"},
+			},
+			wantAttachment: AttachmentHostDevice,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			attachment, intfName := GetAttachment(&current.Result{Interfaces: tc.interfaces})
+			if attachment != tc.wantAttachment || intfName != tc.wantIntfName {
+				t.Fatalf(
+					"unexpected attachment: got %s %q, want %s %q",
+					attachment, intfName, tc.wantAttachment, tc.wantIntfName,
+				)
+			}
+		})
+	}
+}
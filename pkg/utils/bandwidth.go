@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"net"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+// BandwidthEntry holds the bandwidth limits of a container, i.e. the
+// bandwidth capability of CNI. The rates are in bits per second and the
+// bursts are in bits.
+type BandwidthEntry struct {
+	// IngressRate and IngressBurst limit the traffic to the container.
+	IngressRate  uint64 `json:"ingressRate,omitempty"`
+	IngressBurst uint64 `json:"ingressBurst,omitempty"`
+	// EgressRate and EgressBurst limit the traffic from the container.
+	EgressRate  uint64 `json:"egressRate,omitempty"`
+	EgressBurst uint64 `json:"egressBurst,omitempty"`
+}
+
+// ValidateBandwidth checks the bandwidth limits of a container.
+func ValidateBandwidth(b *BandwidthEntry) error {
+	if b == nil {
+		return nil
+	}
+	for _, l := range []struct {
+		direction   string
+		rate, burst uint64
+	}{
+		{"ingress", b.IngressRate, b.IngressBurst},
+		{"egress", b.EgressRate, b.EgressBurst},
+	} {
+		if l.rate == 0 && l.burst > 0 {
+			return fmt.Errorf("%s burst %d requires rate", l.direction, l.burst)
+		}
+		if l.rate > 0 && l.rate < 8 {
+			return fmt.Errorf("%s rate %d is less than one byte per second", l.direction, l.rate)
+		}
+		if l.burst/8 > math.MaxUint32 {
+			return fmt.Errorf("%s burst %d exceeds %d bytes", l.direction, l.burst, uint32(math.MaxUint32))
+		}
+	}
+	return nil
+}
+
+// bandwidthRuleComment tags the rules limiting the bandwidth and the
+// volume of the traffic of a container.
+const bandwidthRuleComment = "cni-bw"
+
+// SetFilterForwardBandwidthRules replaces the rules dropping the traffic of
+// a container over its bandwidth limits and its quota in the chain of the
+// container in filter table. The rates of the limits are converted from
+// bits to bytes. The rules are inserted at the top of the chain, because
+// the chain accepts the traffic of the container. When the quota name is
+// empty, the traffic has no quota. The rules count the dropped traffic in
+// the named counter, if any. The rules look like:
+//
+//	iifname "<intfName>" ip saddr <addr> limit rate over 125000 bytes/second burst 12500 bytes counter drop
+//	oifname "<intfName>" ip daddr <addr> limit rate over 250000 bytes/second counter drop
+//	iifname "<intfName>" ip saddr <addr> quota name "<quotaName>" counter drop
+//	oifname "<intfName>" ip daddr <addr> quota name "<quotaName>" counter drop
+func SetFilterForwardBandwidthRules(v, tableName, chainName, intfName string, addrs []net.IP, b *BandwidthEntry, quotaName string, counters *ContainerCounters) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	existingRules, err := GetRulesByComment(v, tableName, chainName, bandwidthRuleComment)
+	if err != nil {
+		return err
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+
+	for _, r := range existingRules {
+		if err := conn.DelRule(r); err != nil {
+			return err
+		}
+	}
+
+	tb := getFlowtableTable(v, tableName)
+	ch := &nftables.Chain{
+		Name:  chainName,
+		Table: tb,
+	}
+	var daddrOffset, saddrOffset, addrLen uint32
+	if v == "4" {
+		daddrOffset, saddrOffset, addrLen = 16, 12, 4
+	} else {
+		daddrOffset, saddrOffset, addrLen = 24, 8, 16
+	}
+
+	type direction struct {
+		key         expr.MetaKey
+		offset      uint32
+		rate, burst uint64
+	}
+	directions := []direction{
+		{expr.MetaKeyIIFNAME, saddrOffset, 0, 0},
+		{expr.MetaKeyOIFNAME, daddrOffset, 0, 0},
+	}
+	if b != nil {
+		directions[0].rate, directions[0].burst = b.EgressRate, b.EgressBurst
+		directions[1].rate, directions[1].burst = b.IngressRate, b.IngressBurst
+	}
+
+	rules := [][]expr.Any{}
+	for _, addr := range getVersionAddrs(v, addrs) {
+		for _, d := range directions {
+			match := []expr.Any{
+				&expr.Meta{Key: d.key, Register: 1},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(intfName)},
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: d.offset, Len: addrLen},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: addr},
+			}
+			if d.rate > 0 {
+				rules = append(rules, append(append([]expr.Any{}, match...),
+					&expr.Limit{
+						Type:  expr.LimitTypePktBytes,
+						Rate:  d.rate / 8,
+						Over:  true,
+						Unit:  expr.LimitTimeSecond,
+						Burst: uint32(d.burst / 8),
+					},
+					verdictCounterExpr(counters, expr.VerdictDrop),
+					&expr.Verdict{Kind: expr.VerdictDrop},
+				))
+			}
+			if quotaName != "" {
+				rules = append(rules, append(append([]expr.Any{}, match...),
+					&expr.Objref{Type: nftObjectQuota, Name: quotaName},
+					verdictCounterExpr(counters, expr.VerdictDrop),
+					&expr.Verdict{Kind: expr.VerdictDrop},
+				))
+			}
+		}
+	}
+
+	// The limits precede the quota, i.e. the traffic over the limits does
+	// not consume the quota.
+	for i := len(rules) - 1; i >= 0; i-- {
+		conn.InsertRule(&nftables.Rule{
+			Table:    tb,
+			Chain:    ch,
+			UserData: EncodeRuleComment(bandwidthRuleComment),
+			Exprs:    rules[i],
+		})
+	}
+
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed setting bandwidth rules in chain %s of ipv%s %s table: %s",
+			chainName, v, tableName, err,
+		)
+	}
+	return nil
+}
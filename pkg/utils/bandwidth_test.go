@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestValidateBandwidth(t *testing.T) {
+	testcases := []struct {
+		name      string
+		bandwidth *BandwidthEntry
+		shouldErr bool
+	}{
+		{name: "no limits"},
+		{name: "rates and bursts", bandwidth: &BandwidthEntry{IngressRate: 1000000, IngressBurst: 100000, EgressRate: 8}},
+		{name: "burst without rate", bandwidth: &BandwidthEntry{EgressBurst: 100000}, shouldErr: true},
+		{name: "rate under one byte", bandwidth: &BandwidthEntry{IngressRate: 7}, shouldErr: true},
+		{name: "burst over limit", bandwidth: &BandwidthEntry{EgressRate: 8, EgressBurst: 1 << 40}, shouldErr: true},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateBandwidth(tc.bandwidth)
+			if tc.shouldErr && err == nil {
+				t.Fatal("expected error")
+			}
+			if !tc.shouldErr && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestSetFilterForwardBandwidthRules(t *testing.T) {
+	addrs := []net.IP{net.ParseIP("10.88.0.5"), net.ParseIP("fd00::5")}
+	b := &BandwidthEntry{EgressRate: 1000000, EgressBurst: 100000, IngressRate: 2000000}
+	counters := GetContainerCounters("test")
+	// The rules are inserted at the top of the chain in the reverse order,
+	// i.e. the limits of a direction precede its quota in the chain.
+	got := getDryRunCommands(t, func() error {
+		if err := CreateTable("4", "filter"); err != nil {
+			return err
+		}
+		if err := CreateChain("4", "filter", "cni-ffw-test", "none", "none", "none"); err != nil {
+			return err
+		}
+		if err := SetQuota("4", "filter", "cni-quota-test", 1000000000); err != nil {
+			return err
+		}
+		return SetFilterForwardBandwidthRules("4", "filter", "cni-ffw-test", "veth0", addrs, b, "cni-quota-test", counters)
+	})
+	want := []string{
+		"add table ip filter",
+		"add chain ip filter cni-ffw-test",
+		"add quota ip filter cni-quota-test { over 1000000000 bytes }",
+		`insert rule ip filter cni-ffw-test oifname "veth0" ip daddr 10.88.0.5 quota name "cni-quota-test" counter name "cni-drp-test" drop comment "cni-bw"`,
+		`insert rule ip filter cni-ffw-test oifname "veth0" ip daddr 10.88.0.5 limit rate over 250000 bytes/second counter name "cni-drp-test" drop comment "cni-bw"`,
+		`insert rule ip filter cni-ffw-test iifname "veth0" ip saddr 10.88.0.5 quota name "cni-quota-test" counter name "cni-drp-test" drop comment "cni-bw"`,
+		`insert rule ip filter cni-ffw-test iifname "veth0" ip saddr 10.88.0.5 limit rate over 125000 bytes/second burst 12500 bytes counter name "cni-drp-test" drop comment "cni-bw"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected rules\ngot:\n%#v\nwant:\n%#v", got, want)
+	}
+}
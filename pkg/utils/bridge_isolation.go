@@ -0,0 +1,228 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+// BridgeIsolationSetName is the name of the set holding the names of the
+// isolated bridges.
+const BridgeIsolationSetName = "cni-bridges"
+
+func getIfNameSet(v, tableName, setName string) *nftables.Set {
+	s := getAddrSet(v, tableName, setName)
+	s.KeyType = nftables.TypeIFName
+	return s
+}
+
+// getBridgeIsolationRuleComment returns the comment tagging the
+// isolation rule of a particular bridge.
+func getBridgeIsolationRuleComment(intfName string) string {
+	return "cni-iso " + intfName
+}
+
+// GetBridgeIsolationPeersSetName returns the name of the set holding the
+// names of the bridges a particular bridge is allowed to reach.
+func GetBridgeIsolationPeersSetName(intfName string) string {
+	return GetChainName("peers", intfName)
+}
+
+// CreateFilterForwardIsolationChain creates the chain isolating bridges in
+// filter table. The chain hooks into forwarding before the forwarding chain
+// of filter table, i.e. the rules of the chain apply regardless of the order
+// of the rules in the forwarding chain, and accepts by default.
+func CreateFilterForwardIsolationChain(v, tableName, chainName string) error {
+	return CreateChain(v, tableName, chainName, "filter", "forward", "mangle")
+}
+
+// AddBridgeIsolation adds a bridge to the set of isolated bridges, replaces
+// the set of its peer bridges, and adds, when missing, the isolation rule of
+// the bridge. The rule looks like:
+//
+//	iifname "<intfName>" oifname @cni-bridges oifname != "<intfName>" oifname != @cni-peers-<intfName> ct state != established,related counter drop
+func AddBridgeIsolation(v, tableName, chainName, intfName string, peers []string) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	peersSetName := GetBridgeIsolationPeersSetName(intfName)
+	for _, setName := range []string{BridgeIsolationSetName, peersSetName} {
+		exists, err := IsSetExists(v, tableName, setName)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		conn, err := initNftConn()
+		if err != nil {
+			return err
+		}
+		if err := conn.AddSet(getIfNameSet(v, tableName, setName), []nftables.SetElement{}); err != nil {
+			return err
+		}
+		if err := conn.Flush(); err != nil {
+			return fmt.Errorf(
+				"failed creating set %s in ipv%s %s table: %s",
+				setName, v, tableName, err,
+			)
+		}
+	}
+
+	rules, err := GetRulesByComment(v, tableName, chainName, getBridgeIsolationRuleComment(intfName))
+	if err != nil {
+		return err
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+
+	if err := conn.SetAddElements(getIfNameSet(v, tableName, BridgeIsolationSetName), []nftables.SetElement{
+		{Key: EncodeInterfaceName(intfName)},
+	}); err != nil {
+		return err
+	}
+
+	peersSet := getIfNameSet(v, tableName, peersSetName)
+	conn.FlushSet(peersSet)
+	elements := []nftables.SetElement{}
+	for _, peer := range peers {
+		elements = append(elements, nftables.SetElement{Key: EncodeInterfaceName(peer)})
+	}
+	if len(elements) > 0 {
+		if err := conn.SetAddElements(peersSet, elements); err != nil {
+			return err
+		}
+	}
+
+	if len(rules) == 0 {
+		tb := peersSet.Table
+		conn.AddRule(&nftables.Rule{
+			Table:    tb,
+			Chain:    &nftables.Chain{Name: chainName, Table: tb},
+			UserData: EncodeRuleComment(getBridgeIsolationRuleComment(intfName)),
+			Exprs: []expr.Any{
+				&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(intfName)},
+				&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+				&expr.Lookup{SourceRegister: 1, SetName: BridgeIsolationSetName},
+				&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: EncodeInterfaceName(intfName)},
+				&expr.Lookup{SourceRegister: 1, SetName: peersSetName, Invert: true},
+				// ct state != established,related
+				&expr.Ct{Register: 1, Key: expr.CtKeySTATE},
+				&expr.Bitwise{
+					SourceRegister: 1,
+					DestRegister:   1,
+					Len:            4,
+					Mask:           []byte("\x06\x00\x00\x00"),
+					Xor:            []byte{0x0, 0x0, 0x0, 0x0},
+				},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x0, 0x0, 0x0, 0x0}},
+				&expr.Counter{},
+				&expr.Verdict{Kind: expr.VerdictDrop},
+			},
+		})
+	}
+
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed adding isolation of bridge %s in chain %s of ipv%s %s table: %s",
+			intfName, chainName, v, tableName, err,
+		)
+	}
+	return nil
+}
+
+// CheckBridgeIsolation checks whether the isolation rule of a bridge exists.
+func CheckBridgeIsolation(v, tableName, chainName, intfName string) error {
+	rules, err := GetRulesByComment(v, tableName, chainName, getBridgeIsolationRuleComment(intfName))
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return fmt.Errorf(
+			"ipv%s chain %s in %s table has no isolation rule for bridge %s",
+			v, chainName, tableName, intfName,
+		)
+	}
+	return nil
+}
+
+// RemoveBridgeIsolation removes a bridge from the set of isolated bridges,
+// and removes the set of its peer bridges and its isolation rule, if any.
+func RemoveBridgeIsolation(v, tableName, chainName, intfName string) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	exists, err := IsChainExists(v, tableName, chainName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	rules, err := GetRulesByComment(v, tableName, chainName, getBridgeIsolationRuleComment(intfName))
+	if err != nil {
+		return err
+	}
+	if len(rules) > 0 {
+		conn, err := initNftConn()
+		if err != nil {
+			return err
+		}
+		for _, r := range rules {
+			if err := conn.DelRule(r); err != nil {
+				return err
+			}
+		}
+		if err := conn.Flush(); err != nil {
+			return fmt.Errorf(
+				"error deleting isolation rule of bridge %s in chain %s of %s table: %s",
+				intfName, chainName, tableName, err,
+			)
+		}
+	}
+
+	exists, err = IsSetExists(v, tableName, BridgeIsolationSetName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		conn, err := initNftConn()
+		if err != nil {
+			return err
+		}
+		// The removal of a missing element fails. Hence, the element is
+		// added first.
+		s := getIfNameSet(v, tableName, BridgeIsolationSetName)
+		elements := []nftables.SetElement{{Key: EncodeInterfaceName(intfName)}}
+		if err := conn.SetAddElements(s, elements); err != nil {
+			return err
+		}
+		if err := conn.SetDeleteElements(s, elements); err != nil {
+			return err
+		}
+		if err := conn.Flush(); err != nil {
+			return fmt.Errorf(
+				"failed removing %s from set %s in ipv%s %s table: %s",
+				intfName, BridgeIsolationSetName, v, tableName, err,
+			)
+		}
+	}
+
+	peersSetName := GetBridgeIsolationPeersSetName(intfName)
+	exists, err = IsSetExists(v, tableName, peersSetName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return DeleteSet(v, tableName, peersSetName)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddBridgeIsolation(t *testing.T) {
+	got := getDryRunCommands(t, func() error {
+		if err := CreateTable("4", "filter"); err != nil {
+			return err
+		}
+		if err := CreateFilterForwardIsolationChain("4", "filter", "cni-isolation"); err != nil {
+			return err
+		}
+		for _, bridge := range []string{"cni-podman0", "cni-podman1"} {
+			if err := AddBridgeIsolation("4", "filter", "cni-isolation", bridge, []string{"cni-shared"}); err != nil {
+				return err
+			}
+		}
+		return AddBridgeIsolation("4", "filter", "cni-isolation", "cni-podman0", nil)
+	})
+	// The second addition of a bridge replaces its peers and keeps its
+	// rule.
+	want := []string{
+		"add table ip filter",
+		"add chain ip filter cni-isolation { type filter hook forward priority -150; policy accept; }",
+		"add set ip filter cni-bridges { type ifname; }",
+		"add set ip filter cni-peers-cnipodman0 { type ifname; }",
+		`add element ip filter cni-bridges { "cni-podman0" }`,
+		"flush set ip filter cni-peers-cnipodman0",
+		`add element ip filter cni-peers-cnipodman0 { "cni-shared" }`,
+		`add rule ip filter cni-isolation iifname "cni-podman0" oifname @cni-bridges oifname != "cni-podman0" oifname != @cni-peers-cnipodman0 ct state != established,related counter packets 0 bytes 0 drop comment "cni-iso cni-podman0"`,
+		"add set ip filter cni-peers-cnipodman1 { type ifname; }",
+		`add element ip filter cni-bridges { "cni-podman1" }`,
+		"flush set ip filter cni-peers-cnipodman1",
+		`add element ip filter cni-peers-cnipodman1 { "cni-shared" }`,
+		`add rule ip filter cni-isolation iifname "cni-podman1" oifname @cni-bridges oifname != "cni-podman1" oifname != @cni-peers-cnipodman1 ct state != established,related counter packets 0 bytes 0 drop comment "cni-iso cni-podman1"`,
+		`add element ip filter cni-bridges { "cni-podman0" }`,
+		"flush set ip filter cni-peers-cnipodman0",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected rules\ngot:\n%#v\nwant:\n%#v", got, want)
+	}
+}
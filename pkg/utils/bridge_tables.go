@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+// The rules of bridge family tables apply to the frames forwarded between
+// the ports of a bridge, regardless of br_netfilter. There is a single
+// bridge family table for both IPv4 and IPv6.
+
+func getBridgeTable(tableName string) *nftables.Table {
+	return &nftables.Table{
+		Name:   tableName,
+		Family: nftables.TableFamilyBridge,
+	}
+}
+
+// IsBridgeChainExists checks whether a chain exists in a bridge family table.
+func IsBridgeChainExists(tableName, chainName string) (bool, error) {
+	conn, err := initNftConn()
+	if err != nil {
+		return false, err
+	}
+
+	chains, err := conn.ListChains()
+	if err != nil {
+		return false, err
+	}
+
+	for _, chain := range chains {
+		if chain == nil {
+			continue
+		}
+		if chain.Name != chainName {
+			continue
+		}
+		if chain.Table.Name != tableName {
+			continue
+		}
+		if chain.Table.Family != nftables.TableFamilyBridge {
+			continue
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// CreateBridgeChain creates a bridge family table, when missing, and a
+// filter chain in the table hooked into the provided hook, i.e. prerouting
+// or forward. The chain accepts by default.
+func CreateBridgeChain(tableName, chainName, chainHookType string) error {
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+
+	tb := getBridgeTable(tableName)
+	ch := &nftables.Chain{
+		Name:     chainName,
+		Table:    tb,
+		Type:     nftables.ChainTypeFilter,
+		Priority: nftables.ChainPriorityFilter,
+	}
+
+	switch chainHookType {
+	case "prerouting":
+		ch.Hooknum = nftables.ChainHookPrerouting
+	case "forward":
+		ch.Hooknum = nftables.ChainHookForward
+	default:
+		return fmt.Errorf("unsupported bridge chain type: %s", chainHookType)
+	}
+
+	conn.AddTable(tb)
+	conn.AddChain(ch)
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed creating %s chain in bridge %s table: %s",
+			chainName, tableName, err,
+		)
+	}
+	return nil
+}
+
+// getTableRulesByComment returns the rules of a particular chain of
+// a bridge or netdev family table having the provided comment.
+func getTableRulesByComment(conn *nftables.Conn, tb *nftables.Table, chainName, comment string) ([]*nftables.Rule, error) {
+	rules, err := conn.GetRule(tb, &nftables.Chain{Name: chainName, Table: tb})
+	if err != nil {
+		return nil, err
+	}
+	matched := []*nftables.Rule{}
+	for _, r := range rules {
+		if DecodeRuleComment(r.UserData) != comment {
+			continue
+		}
+		matched = append(matched, r)
+	}
+	return matched, nil
+}
+
+// setTableRulesByComment replaces the rules of a particular chain of
+// a bridge or netdev family table having the provided comment. When the
+// provided rules are empty, the function removes the rules having the
+// comment.
+func setTableRulesByComment(tb *nftables.Table, chainName, comment string, rules [][]expr.Any) error {
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+
+	existingRules, err := getTableRulesByComment(conn, tb, chainName, comment)
+	if err != nil {
+		return err
+	}
+	if len(existingRules) == 0 && len(rules) == 0 {
+		return nil
+	}
+	for _, r := range existingRules {
+		if err := conn.DelRule(r); err != nil {
+			return err
+		}
+	}
+
+	ch := &nftables.Chain{
+		Name:  chainName,
+		Table: tb,
+	}
+	for _, exprs := range rules {
+		conn.AddRule(&nftables.Rule{
+			Table:    tb,
+			Chain:    ch,
+			UserData: EncodeRuleComment(comment),
+			Exprs:    exprs,
+		})
+	}
+
+	if err := conn.Flush(); err != nil {
+		family := "bridge"
+		if tb.Family == nftables.TableFamilyNetdev {
+			family = "netdev"
+		}
+		return fmt.Errorf(
+			"failed setting rules in chain %s of %s %s table: %s",
+			chainName, family, tb.Name, err,
+		)
+	}
+	return nil
+}
+
+// setBridgeRulesByComment replaces the rules of a particular chain of
+// a bridge family table having the provided comment.
+func setBridgeRulesByComment(tableName, chainName, comment string, rules [][]expr.Any) error {
+	return setTableRulesByComment(getBridgeTable(tableName), chainName, comment, rules)
+}
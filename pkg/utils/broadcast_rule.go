@@ -88,8 +88,8 @@ func addPostRoutingBroadcastRule(opts map[string]interface{}) error {
 	conn.AddRule(r)
 	if err := conn.Flush(); err != nil {
 		return fmt.Errorf(
-			"failed adding broadcast rule in chain %s of ipv%s %s table for %v: %s",
-			chainName, v, tableName, addr, err,
+			"failed adding broadcast rule in chain %s of ipv%s %s table for %v: %s, rule: %s",
+			chainName, v, tableName, addr, err, FormatRuleExprs(v, r.Exprs),
 		)
 	}
 	return nil
@@ -5,16 +5,18 @@ import (
 	"net"
 
 	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
 	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
 )
 
-// DeleteJumpRule deletes the chain jumping rule.
+// DeleteJumpRule deletes the chain jumping rules.
 func DeleteJumpRule(v, tableName, srcChainName, dstChainName string) error {
-	r, err := GetJumpRule(v, tableName, srcChainName, dstChainName)
+	rules, err := GetJumpRules(v, tableName, srcChainName, dstChainName)
 	if err != nil {
 		return err
 	}
-	if r == nil {
+	if len(rules) == 0 {
 		return nil
 	}
 
@@ -37,17 +39,20 @@ func DeleteJumpRule(v, tableName, srcChainName, dstChainName string) error {
 		Table: tb,
 	}
 
-	conn.DelRule(&nftables.Rule{
-		Table: tb,
-		Chain: ch,
-		//&nftables.Chain{Name: r.Chain.Name, Type: r.Chain.Type},
-		Handle: r.Handle,
-	})
+	for _, r := range rules {
+		if err := conn.DelRule(&nftables.Rule{
+			Table:  tb,
+			Chain:  ch,
+			Handle: r.Handle,
+		}); err != nil {
+			return err
+		}
+	}
 
 	if err := conn.Flush(); err != nil {
 		return fmt.Errorf(
 			"error deleting jump rule to %s chain found in chain %s in %s table: %s",
-			dstChainName, r.Chain.Name, r.Table.Name, err,
+			dstChainName, srcChainName, tableName, err,
 		)
 	}
 
@@ -56,6 +61,19 @@ func DeleteJumpRule(v, tableName, srcChainName, dstChainName string) error {
 
 // GetJumpRule return information about a specific jump rule.
 func GetJumpRule(v, tableName, srcChainName, dstChainName string) (*nftables.Rule, error) {
+	rules, err := GetJumpRules(v, tableName, srcChainName, dstChainName)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	return rules[0], nil
+}
+
+// GetJumpRules returns all the rules jumping from one chain to another,
+// e.g. the `ip daddr` jump rules created for each of the host addresses.
+func GetJumpRules(v, tableName, srcChainName, dstChainName string) ([]*nftables.Rule, error) {
 	if err := isSupportedIPVersion(v); err != nil {
 		return nil, err
 	}
@@ -63,11 +81,11 @@ func GetJumpRule(v, tableName, srcChainName, dstChainName string) (*nftables.Rul
 	if err != nil {
 		return nil, err
 	}
+	rules := []*nftables.Rule{}
 	for _, r := range chainProps.Rules {
 		for _, expression := range r.Exprs {
-			rr, err := expression.(*expr.Verdict)
-
-			if !err {
+			rr, ok := expression.(*expr.Verdict)
+			if !ok {
 				continue
 			}
 			if rr.Kind != expr.VerdictJump {
@@ -76,11 +94,12 @@ func GetJumpRule(v, tableName, srcChainName, dstChainName string) (*nftables.Rul
 			if rr.Chain != dstChainName {
 				continue
 			}
-			return r, nil
+			rules = append(rules, r)
+			break
 		}
 	}
 
-	return nil, nil
+	return rules, nil
 }
 
 // IPDaddrMatch returns the nftables exprs required for matching the provided
@@ -124,8 +143,9 @@ func IPDaddrMatch(v string, ipAddress net.IP) []expr.Any {
 // another that will trigger when the destination IP address is one
 // handled by the local system. The resulting rule will be placed in
 // <srcChainName> and look like
-// "ip daddr <ipAddress> jump <dstChainName>"
-func CreateJumpRuleWithIPDaddrMatch(v, tableName, srcChainName, dstChainName string, ipAddress net.IP) error {
+// "ip daddr <ipAddress> jump <dstChainName>". The rule carries the
+// provided comment, if any.
+func CreateJumpRuleWithIPDaddrMatch(v, tableName, srcChainName, dstChainName string, ipAddress net.IP, comment string) error {
 
 	conditions := IPDaddrMatch(v, ipAddress)
 	conditions = append(conditions, &expr.Verdict{
@@ -133,20 +153,53 @@ func CreateJumpRuleWithIPDaddrMatch(v, tableName, srcChainName, dstChainName str
 		Chain: dstChainName,
 	})
 
-	return createJumpRule(v, tableName, srcChainName, dstChainName, conditions)
+	return createJumpRule(v, tableName, srcChainName, dstChainName, conditions, comment)
+}
+
+// CreateJumpRuleWithLocalDaddrMatch creates a jump rule from one chain to
+// another that will trigger when the destination IP address is any of the
+// addresses handled by the local system, whatever they are at the time.
+// The resulting rule will be placed in <srcChainName> and look like
+// "fib daddr type local jump <dstChainName>". The rule carries the provided
+// comment, if any.
+func CreateJumpRuleWithLocalDaddrMatch(v, tableName, srcChainName, dstChainName, comment string) error {
+	return createJumpRule(v, tableName, srcChainName, dstChainName, []expr.Any{
+		// [ fib daddr type => reg 1 ]
+		&expr.Fib{
+			Register:       1,
+			FlagDADDR:      true,
+			ResultADDRTYPE: true,
+		},
+		// [ cmp eq reg 1 0x00000002 ]
+		&expr.Cmp{
+			Op:       expr.CmpOpEq,
+			Register: 1,
+			Data:     binaryutil.NativeEndian.PutUint32(unix.RTN_LOCAL),
+		},
+		&expr.Verdict{
+			Kind:  expr.VerdictJump,
+			Chain: dstChainName,
+		},
+	}, comment)
 }
 
 // CreateJumpRule create a jump rule from one chain to another.
 func CreateJumpRule(v, tableName, srcChainName, dstChainName string) error {
+	return CreateJumpRuleWithComment(v, tableName, srcChainName, dstChainName, "")
+}
+
+// CreateJumpRuleWithComment creates a jump rule from one chain to another
+// carrying the provided comment.
+func CreateJumpRuleWithComment(v, tableName, srcChainName, dstChainName, comment string) error {
 	return createJumpRule(v, tableName, srcChainName, dstChainName, []expr.Any{
 		&expr.Verdict{
 			Kind:  expr.VerdictJump,
 			Chain: dstChainName,
 		},
-	})
+	}, comment)
 }
 
-func createJumpRule(v, tableName, srcChainName, dstChainName string, expressions []expr.Any) error {
+func createJumpRule(v, tableName, srcChainName, dstChainName string, expressions []expr.Any, comment string) error {
 	if err := isSupportedIPVersion(v); err != nil {
 		return err
 	}
@@ -183,19 +236,17 @@ func createJumpRule(v, tableName, srcChainName, dstChainName string, expressions
 		Chain: ch,
 		Exprs: expressions,
 	}
-
-	if chainProps.RuleCount > 0 {
-		r.Position = chainProps.Positions[0]
-	}
-	if chainProps.RuleCount == 0 {
-		conn.AddRule(r)
-	} else {
-		conn.InsertRule(r)
+	if comment != "" {
+		r.UserData = EncodeRuleComment(comment)
 	}
+
+	// The jump rules precede the other rules, including the rules
+	// terminating the chain.
+	placeRule(conn, r, chainProps.Rules, PlaceAtHead)
 	if err := conn.Flush(); err != nil {
 		return fmt.Errorf(
-			"failed adding jump rule from chain %s in ipv%s table %s to chain %s: %s",
-			srcChainName, v, tableName, dstChainName, err,
+			"failed adding jump rule from chain %s in ipv%s table %s to chain %s: %s, rule: %s",
+			srcChainName, v, tableName, dstChainName, err, FormatRuleExprs(v, r.Exprs),
 		)
 	}
 
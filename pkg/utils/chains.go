@@ -85,6 +85,13 @@ func CreateRawPreRoutingChain(v, tableName, chainName string) error {
 	return CreateChain(v, tableName, chainName, "filter", "prerouting", "raw")
 }
 
+// CreateManglePreRoutingChain creates a prerouting chain of filter type
+// with mangle priority, i.e. the chain sees the packets before the
+// routing decision and the nat and filter chains.
+func CreateManglePreRoutingChain(v, tableName, chainName string) error {
+	return CreateChain(v, tableName, chainName, "filter", "prerouting", "mangle")
+}
+
 // CreateChain creates NAT chain of a specific type.
 func CreateChain(v, tableName, chainName, chainType, chainHookType, chainPriority string) error {
 	if err := isSupportedIPVersion(v); err != nil {
@@ -147,6 +154,8 @@ func CreateChain(v, tableName, chainName, chainType, chainHookType, chainPriorit
 		ch.Priority = nftables.ChainPriorityNATSource
 	case "raw":
 		ch.Priority = nftables.ChainPriorityRaw
+	case "mangle":
+		ch.Priority = nftables.ChainPriorityMangle
 	case "filter":
 		ch.Priority = nftables.ChainPriorityFilter
 	case "none":
@@ -164,8 +173,10 @@ func CreateChain(v, tableName, chainName, chainType, chainHookType, chainPriorit
 	return nil
 }
 
-// CreateFilterForwardChain creates forward chain in filter table.
-func CreateFilterForwardChain(v, tableName, chainName string) error {
+// CreateFilterForwardChain creates forward chain in filter table. The
+// chain ends with the rules logging and denying the packets, see
+// SetFilterForwardDenyRules.
+func CreateFilterForwardChain(v, tableName, chainName string, deny *DenyRuleConfig) error {
 	if err := isSupportedIPVersion(v); err != nil {
 		return err
 	}
@@ -196,7 +207,7 @@ func CreateFilterForwardChain(v, tableName, chainName string) error {
 		return err
 	}
 
-	if err := addLogDenyRule(v, tableName, chainName); err != nil {
+	if err := SetFilterForwardDenyRules(v, tableName, chainName, deny); err != nil {
 		return err
 	}
 
@@ -2,7 +2,9 @@ package utils
 
 import (
 	"github.com/google/nftables"
+	"github.com/mdlayher/netlink"
 	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
 )
 
 func initNftConn() (*nftables.Conn, error) {
@@ -13,5 +15,86 @@ func initNftConn() (*nftables.Conn, error) {
 	conn := &nftables.Conn{
 		NetNS: int(ns),
 	}
+	if p := activeDryRun; p != nil {
+		conn.TestDial = p.dial
+	}
 	return conn, nil
 }
+
+// execNftMessage sends the provided nf_tables request, which nftables
+// package is unable to build, outside of a batch, and returns the replies.
+func execNftMessage(message netlink.Message) ([]netlink.Message, error) {
+	if p := activeDryRun; p != nil {
+		return p.exec(message)
+	}
+	return execLiveNftMessage(message)
+}
+
+// execLiveNftMessage sends the provided nf_tables request to the kernel,
+// regardless of the dry-run mode.
+func execLiveNftMessage(message netlink.Message) ([]netlink.Message, error) {
+	ns, err := netns.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer ns.Close()
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, &netlink.Config{NetNS: int(ns)})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.Execute(message)
+}
+
+// flushNftMessages sends the provided nf_tables messages, which nftables
+// package is unable to build, in a batch, and waits for the
+// acknowledgement of the messages requesting one.
+func flushNftMessages(messages []netlink.Message) error {
+	if p := activeDryRun; p != nil {
+		p.record(messages)
+		return nil
+	}
+
+	ns, err := netns.Get()
+	if err != nil {
+		return err
+	}
+	defer ns.Close()
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, &netlink.Config{NetNS: int(ns)})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	batchHeader := []byte{unix.AF_UNSPEC, unix.NFNETLINK_V0, 0, unix.NFNL_SUBSYS_NFTABLES}
+	batch := []netlink.Message{
+		{
+			Header: netlink.Header{
+				Type:  netlink.HeaderType(unix.NFNL_MSG_BATCH_BEGIN),
+				Flags: netlink.Request,
+			},
+			Data: batchHeader,
+		},
+	}
+	batch = append(batch, messages...)
+	batch = append(batch, netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType(unix.NFNL_MSG_BATCH_END),
+			Flags: netlink.Request,
+		},
+		Data: batchHeader,
+	})
+	if _, err := conn.SendMessages(batch); err != nil {
+		return err
+	}
+
+	for _, msg := range messages {
+		if msg.Header.Flags&netlink.Acknowledge == 0 {
+			continue
+		}
+		if _, err := conn.Receive(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"strings"
+)
+
+// containerInfoCommentPrefix is the prefix of the comments tagging the jump
+// rules to the chains of a container. The comment is followed by the ID
+// and the network of the container.
+const containerInfoCommentPrefix = "cni-ctr "
+
+// ContainerInfo holds the ID and the network of a container, which the
+// names of the chains of the container do not carry in full.
+type ContainerInfo struct {
+	ID      string
+	Network string
+}
+
+// GetContainerInfoComment returns the comment tagging the jump rules to
+// the chains of a container.
+func GetContainerInfoComment(containerID, network string) string {
+	return containerInfoCommentPrefix + containerID + " " + network
+}
+
+// parseContainerInfoComment returns the container found in the comment of
+// a jump rule, or nil when the comment is not one of a container.
+func parseContainerInfoComment(comment string) *ContainerInfo {
+	if !strings.HasPrefix(comment, containerInfoCommentPrefix) {
+		return nil
+	}
+	arr := strings.SplitN(strings.TrimPrefix(comment, containerInfoCommentPrefix), " ", 2)
+	info := &ContainerInfo{ID: arr[0]}
+	if len(arr) == 2 {
+		info.Network = arr[1]
+	}
+	return info
+}
@@ -0,0 +1,416 @@
+package utils
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// nftObjectCounter is the type of the counter stateful objects.
+const nftObjectCounter = 1
+
+// The kinds of the named counters of the containers. The name of a counter
+// is cni-<kind>-<key>, where the key is the last characters of the ID of
+// the container, as in the names of the chains of the container. The name of
+// a DNAT counter holds the protocol and the host port of the port mapping,
+// e.g. cni-dnt-tcp8080-<key>.
+const (
+	// CounterAccepted counts the traffic of a container accepted by the
+	// chain of the container.
+	CounterAccepted = "acc"
+	// CounterDropped counts the traffic of a container dropped by the
+	// chain of the container.
+	CounterDropped = "drp"
+	// CounterDNAT counts the traffic translated to a port mapping of
+	// a container.
+	CounterDNAT = "dnt"
+)
+
+var counterNameRegex = regexp.MustCompile(`^cni-(acc|drp|dnt)-(?:(tcp|udp)(\d+)-)?([a-zA-Z0-9]+)$`)
+
+// GetContainerKey returns the key of a container in the names of its
+// counters, i.e. the part of the ID of the container in the names of the
+// chains of the container, e.g. cni-ffw-<key>.
+func GetContainerKey(containerID string) string {
+	return strings.TrimPrefix(GetChainName(CounterAccepted, containerID), "cni-"+CounterAccepted+"-")
+}
+
+// ContainerCounters holds the names of the counters of the traffic of
+// a container accepted and dropped by the chain of the container.
+type ContainerCounters struct {
+	Accepted string
+	Dropped  string
+}
+
+// GetContainerCounters returns the names of the counters of a container.
+func GetContainerCounters(containerID string) *ContainerCounters {
+	key := GetContainerKey(containerID)
+	return &ContainerCounters{
+		Accepted: "cni-" + CounterAccepted + "-" + key,
+		Dropped:  "cni-" + CounterDropped + "-" + key,
+	}
+}
+
+// Names returns the names of the counters.
+func (c *ContainerCounters) Names() []string {
+	return []string{c.Accepted, c.Dropped}
+}
+
+// GetMappingCounterName returns the name of the DNAT counter of a port
+// mapping of a container.
+func GetMappingCounterName(pm MappingEntry, containerID string) string {
+	return fmt.Sprintf("cni-%s-%s%d-%s", CounterDNAT, pm.Protocol, pm.HostPort, GetContainerKey(containerID))
+}
+
+// counterExpr returns the expr counting the packets in the named counter,
+// or in an anonymous counter when the name is empty.
+func counterExpr(name string) expr.Any {
+	if name == "" {
+		return &expr.Counter{}
+	}
+	return &expr.Objref{Type: nftObjectCounter, Name: name}
+}
+
+// verdictCounterExpr returns the expr counting the packets of the provided
+// verdict in the named counter of a container, if any.
+func verdictCounterExpr(c *ContainerCounters, kind expr.VerdictKind) expr.Any {
+	if c == nil {
+		return &expr.Counter{}
+	}
+	if kind == expr.VerdictAccept {
+		return counterExpr(c.Accepted)
+	}
+	return counterExpr(c.Dropped)
+}
+
+// NamedCounter holds the name and the values of a named counter.
+type NamedCounter struct {
+	Name    string
+	Packets uint64
+	Bytes   uint64
+}
+
+// ListCounters returns the named counters of a table. The counter objects
+// are listed by type, because nftables package fails to list the other
+// stateful objects, e.g. quotas.
+func ListCounters(v, tableName string) ([]*NamedCounter, error) {
+	if err := isSupportedIPVersion(v); err != nil {
+		return nil, err
+	}
+
+	data, err := netlink.MarshalAttributes([]netlink.Attribute{
+		{Type: unix.NFTA_OBJ_TABLE, Data: []byte(tableName + "\x00")},
+		{Type: unix.NFTA_OBJ_TYPE, Data: binaryutil.BigEndian.PutUint32(nftObjectCounter)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	replies, err := execNftMessage(netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType((unix.NFNL_SUBSYS_NFTABLES << 8) | unix.NFT_MSG_GETOBJ),
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: append([]byte{byte(getFlowtableTable(v, tableName).Family), unix.NFNETLINK_V0, 0, 0}, data...),
+	})
+	if err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed listing counters of ipv%s %s table: %s", v, tableName, err)
+	}
+
+	counters := []*NamedCounter{}
+	for _, reply := range replies {
+		if len(reply.Data) < 4 {
+			continue
+		}
+		ad, err := netlink.NewAttributeDecoder(reply.Data[4:])
+		if err != nil {
+			return nil, err
+		}
+		ad.ByteOrder = binary.BigEndian
+		var tb string
+		var objType uint32
+		c := &NamedCounter{}
+		for ad.Next() {
+			switch ad.Type() {
+			case unix.NFTA_OBJ_TABLE:
+				tb = ad.String()
+			case unix.NFTA_OBJ_NAME:
+				c.Name = ad.String()
+			case unix.NFTA_OBJ_TYPE:
+				objType = ad.Uint32()
+			case unix.NFTA_OBJ_DATA:
+				ad.Nested(func(nad *netlink.AttributeDecoder) error {
+					nad.ByteOrder = binary.BigEndian
+					for nad.Next() {
+						switch nad.Type() {
+						case unix.NFTA_COUNTER_PACKETS:
+							c.Packets = nad.Uint64()
+						case unix.NFTA_COUNTER_BYTES:
+							c.Bytes = nad.Uint64()
+						}
+					}
+					return nil
+				})
+			}
+		}
+		if err := ad.Err(); err != nil {
+			return nil, err
+		}
+		if tb != tableName || objType != nftObjectCounter {
+			continue
+		}
+		counters = append(counters, c)
+	}
+	return counters, nil
+}
+
+// AddCounters creates the missing named counters of a table.
+func AddCounters(v, tableName string, names []string) error {
+	existingCounters, err := ListCounters(v, tableName)
+	if err != nil {
+		return err
+	}
+	exists := map[string]bool{}
+	for _, c := range existingCounters {
+		exists[c.Name] = true
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+	missing := []string{}
+	for _, name := range names {
+		if exists[name] {
+			continue
+		}
+		missing = append(missing, name)
+		conn.AddObj(&nftables.CounterObj{
+			Table: getFlowtableTable(v, tableName),
+			Name:  name,
+		})
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed adding counters %v to ipv%s %s table: %s",
+			missing, v, tableName, err,
+		)
+	}
+	return nil
+}
+
+// DeleteCounters deletes the named counters of a table having the
+// provided names or, when the key is not empty, the provided container
+// key. The rules referring to the counters must be deleted beforehand.
+func DeleteCounters(v, tableName string, names []string, key string) error {
+	existingCounters, err := ListCounters(v, tableName)
+	if err != nil {
+		return err
+	}
+	deleted := map[string]bool{}
+	for _, name := range names {
+		deleted[name] = true
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+	found := []string{}
+	for _, c := range existingCounters {
+		if !deleted[c.Name] {
+			stats := parseCounterName(c.Name)
+			if key == "" || stats == nil || stats.Container != key {
+				continue
+			}
+		}
+		found = append(found, c.Name)
+		conn.DeleteObject(&nftables.CounterObj{
+			Table: getFlowtableTable(v, tableName),
+			Name:  c.Name,
+		})
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed deleting counters %v of ipv%s %s table: %s",
+			found, v, tableName, err,
+		)
+	}
+	return nil
+}
+
+// CounterStats holds the values of a named counter of a container. The
+// quota of a container is a counter of the consumed bytes, with the bytes
+// the quota allows and whether the quota is depleted.
+type CounterStats struct {
+	Container string `json:"container"`
+	Family    string `json:"family"`
+	Counter   string `json:"counter"`
+	Mapping   string `json:"mapping,omitempty"`
+	Packets   uint64 `json:"packets"`
+	Bytes     uint64 `json:"bytes"`
+	Quota     uint64 `json:"quota,omitempty"`
+	Depleted  bool   `json:"depleted,omitempty"`
+}
+
+// parseCounterName returns the stats of a named counter of a container
+// without the values, or nil when the name is not one of a container.
+func parseCounterName(name string) *CounterStats {
+	m := counterNameRegex.FindStringSubmatch(name)
+	if m == nil {
+		return nil
+	}
+	stats := &CounterStats{Container: m[4]}
+	switch m[1] {
+	case CounterAccepted:
+		stats.Counter = "accepted"
+	case CounterDropped:
+		stats.Counter = "dropped"
+	case CounterDNAT:
+		if m[2] == "" {
+			return nil
+		}
+		stats.Counter = "dnat"
+		stats.Mapping = m[3] + "/" + m[2]
+	}
+	return stats
+}
+
+// GetCounterStats returns the values of the named counters of the
+// containers in the IPv4 and IPv6 tables of the provided name. When the
+// container ID is not empty, the function returns the counters of the
+// container only.
+func GetCounterStats(tableName, containerID string) ([]*CounterStats, error) {
+	key := ""
+	if containerID != "" {
+		key = GetContainerKey(containerID)
+	}
+	entries := []*CounterStats{}
+	for _, v := range []string{"4", "6"} {
+		exists, err := IsTableExist(v, tableName)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		counters, err := ListCounters(v, tableName)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range counters {
+			stats := parseCounterName(c.Name)
+			if stats == nil {
+				continue
+			}
+			if key != "" && stats.Container != key {
+				continue
+			}
+			stats.Family = "ipv" + v
+			stats.Packets = c.Packets
+			stats.Bytes = c.Bytes
+			entries = append(entries, stats)
+		}
+		quotas, err := ListQuotas(v, tableName)
+		if err != nil {
+			return nil, err
+		}
+		for _, q := range quotas {
+			container := strings.TrimPrefix(q.Name, quotaNamePrefix)
+			if container == q.Name || (key != "" && container != key) {
+				continue
+			}
+			entries = append(entries, &CounterStats{
+				Container: container,
+				Family:    "ipv" + v,
+				Counter:   "quota",
+				Bytes:     q.Consumed,
+				Quota:     q.Bytes,
+				Depleted:  q.Depleted || q.Consumed > q.Bytes,
+			})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Container != b.Container {
+			return a.Container < b.Container
+		}
+		if a.Family != b.Family {
+			return a.Family < b.Family
+		}
+		if a.Counter != b.Counter {
+			return a.Counter < b.Counter
+		}
+		return a.Mapping < b.Mapping
+	})
+	return entries, nil
+}
+
+// WriteCounterStats writes the values of the named counters of the
+// containers as a table or as JSON.
+func WriteCounterStats(w io.Writer, entries []*CounterStats, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case "table":
+	default:
+		return fmt.Errorf("unsupported stats format: %s", format)
+	}
+
+	// The quota column is printed when a container has a quota, i.e. by
+	// the firewall plugin only.
+	hasQuota := false
+	for _, e := range entries {
+		if e.Counter == "quota" {
+			hasQuota = true
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := "CONTAINER\tFAMILY\tCOUNTER\tMAPPING\tPACKETS\tBYTES"
+	if hasQuota {
+		header += "\tQUOTA"
+	}
+	fmt.Fprintln(tw, header)
+	for _, e := range entries {
+		mapping := e.Mapping
+		if mapping == "" {
+			mapping = "-"
+		}
+		line := fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%d", e.Container, e.Family, e.Counter, mapping, e.Packets, e.Bytes)
+		if hasQuota {
+			switch {
+			case e.Counter != "quota":
+				line += "\t-"
+			case e.Depleted:
+				line += fmt.Sprintf("\t%d (depleted)", e.Quota)
+			default:
+				line += fmt.Sprintf("\t%d", e.Quota)
+			}
+		}
+		fmt.Fprintln(tw, line)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetContainerCounters(t *testing.T) {
+	// The IDs share their first 12 characters, i.e. their short IDs.
+	ids := []string{
+		"3f0e8b6c2a1d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c1d2e3f4a5b6c7d8e9f",
+		"3f0e8b6c2a1dffffffffffffffffffffffffffffffffffffffffffffffffffff",
+	}
+	seen := map[string]string{}
+	for _, id := range ids {
+		counters := GetContainerCounters(id)
+		key := GetContainerKey(id)
+		if want := "cni-" + CounterAccepted + "-" + key; counters.Accepted != want {
+			t.Fatalf("unexpected counter name %s, want %s", counters.Accepted, want)
+		}
+		if want := GetChainName("ffw", id)[len("cni-ffw-"):]; key != want {
+			t.Fatalf("key %s of %s differs from the key %s of its chains", key, id, want)
+		}
+		if stats := parseCounterName(GetMappingCounterName(MappingEntry{Protocol: "tcp", HostPort: 8080}, id)); stats == nil || stats.Container != key {
+			t.Fatalf("unexpected stats %+v of the dnat counter of %s", stats, id)
+		}
+		if other, exists := seen[key]; exists {
+			t.Fatalf("containers %s and %s share the key %s", other, id, key)
+		}
+		seen[key] = id
+	}
+}
+
+func TestWriteCounterStats(t *testing.T) {
+	entries := []*CounterStats{
+		{Container: "3e03fd5faebf8b7d8ca83d8", Family: "ipv4", Counter: "accepted", Packets: 10, Bytes: 1000},
+		{Container: "3e03fd5faebf8b7d8ca83d8", Family: "ipv4", Counter: "quota", Bytes: 2000, Quota: 1000, Depleted: true},
+	}
+	var b strings.Builder
+	if err := WriteCounterStats(&b, entries, "table"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := strings.Join([]string{
+		"CONTAINER                FAMILY  COUNTER   MAPPING  PACKETS  BYTES  QUOTA",
+		"3e03fd5faebf8b7d8ca83d8  ipv4    accepted  -        10       1000   -",
+		"3e03fd5faebf8b7d8ca83d8  ipv4    quota     -        0        2000   1000 (depleted)",
+		"",
+	}, "\n")
+	if got := b.String(); got != want {
+		t.Fatalf("unexpected stats\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Without quotas, e.g. for the port mapping plugin, the quota column
+	// is not printed.
+	b.Reset()
+	if err := WriteCounterStats(&b, entries[:1], "table"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := strings.SplitN(b.String(), "\n", 2)[0]; strings.Contains(got, "QUOTA") {
+		t.Fatalf("unexpected quota column in %q", got)
+	}
+}
@@ -10,7 +10,8 @@ import (
 	"golang.org/x/sys/unix"
 )
 
-// AddDestinationNatRules creates destination NAT rules
+// AddDestinationNatRules creates destination NAT rules. The translated
+// traffic is counted in the named counter in opts["counter"], if any.
 func AddDestinationNatRules(opts map[string]interface{}) error {
 	v := opts["version"].(string)
 	tableName := opts["table"].(string)
@@ -18,6 +19,7 @@ func AddDestinationNatRules(opts map[string]interface{}) error {
 	bridgeIntfName := opts["bridge_interface"].(string)
 	addr := opts["ip_address"].(net.IPNet)
 	pm := opts["port_mapping"].(MappingEntry)
+	counterName, _ := opts["counter"].(string)
 
 	/*
 		rule := fmt.Sprintf(
@@ -45,103 +47,187 @@ func AddDestinationNatRules(opts map[string]interface{}) error {
 		Table: tb,
 	}
 
-	r := &nftables.Rule{
-		Table: tb,
-		Chain: ch,
-		Exprs: []expr.Any{},
+	rules, err := getDestinationNatRules(tb, ch, v, bridgeIntfName, addr, pm, counterName)
+	if err != nil {
+		return err
+	}
+	for _, r := range rules {
+		conn.AddRule(r)
+	}
+
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed adding destination NAT rules in chain %s of ipv%s %s table for %v: %s, rules: %s",
+			chainName, v, tableName, pm, err, formatRulesExprs(v, rules),
+		)
+	}
+	return nil
+}
+
+// getDestinationNatRules returns the rules translating the destination
+// of the traffic to a published port, i.e. the rules dropping the traffic
+// over the limits of the port, if any, and a rule per allowed source.
+func getDestinationNatRules(tb *nftables.Table, ch *nftables.Chain, v, bridgeIntfName string, addr net.IPNet, pm MappingEntry, counterName string) ([]*nftables.Rule, error) {
+	srcMatches, err := MappingSourceMatches(v, pm)
+	if err != nil {
+		return nil, err
 	}
 
+	portMatch := mappingHostMatch(v, pm)
+	l4Match, err := mappingPortMatch(pm.Protocol, pm.HostPort)
+	if err != nil {
+		return nil, err
+	}
+	portMatch = append(portMatch, l4Match...)
+
 	// match non-container interface
-	r.Exprs = append(r.Exprs, &expr.Meta{
-		Key:      expr.MetaKeyIIFNAME,
-		Register: 1,
-	})
-	r.Exprs = append(r.Exprs, &expr.Cmp{
-		Op:       expr.CmpOpNeq,
-		Register: 1,
-		Data:     EncodeInterfaceName(bridgeIntfName),
-	})
+	iifMatch := []expr.Any{
+		&expr.Meta{
+			Key:      expr.MetaKeyIIFNAME,
+			Register: 1,
+		},
+		&expr.Cmp{
+			Op:       expr.CmpOpNeq,
+			Register: 1,
+			Data:     EncodeInterfaceName(bridgeIntfName),
+		},
+	}
+
+	// drop traffic over the limits of the port, if any, whatever the
+	// source of the traffic is
+	rules := []*nftables.Rule{}
+	for _, limitExprs := range MappingLimitDropRules(pm.Limit, "nat") {
+		rules = append(rules, &nftables.Rule{
+			Table: tb,
+			Chain: ch,
+			Exprs: concatExprs(iifMatch, portMatch, limitExprs),
+		})
+	}
+
+	for _, srcMatch := range srcMatches {
+		// match source address, if restricted
+		r := &nftables.Rule{
+			Table: tb,
+			Chain: ch,
+			Exprs: concatExprs(iifMatch, srcMatch, portMatch),
+		}
+
+		if counterName != "" {
+			r.Exprs = append(r.Exprs, counterExpr(counterName))
+		}
+
+		if v == "4" {
+			r.Exprs = append(r.Exprs, &expr.Immediate{
+				Register: 1,
+				Data:     addr.IP.To4(),
+			})
+		} else {
+			r.Exprs = append(r.Exprs, &expr.Immediate{
+				Register: 1,
+				Data:     addr.IP.To16(),
+			})
+		}
+
+		r.Exprs = append(r.Exprs, &expr.Immediate{
+			Register: 2,
+			Data:     binaryutil.BigEndian.PutUint16(uint16(pm.ContainerPort)),
+		})
+
+		if v == "4" {
+			r.Exprs = append(r.Exprs, &expr.NAT{
+				Type:        expr.NATTypeDestNAT,
+				Family:      unix.NFPROTO_IPV4,
+				RegAddrMin:  1,
+				RegProtoMin: 2,
+			})
+		} else {
+			r.Exprs = append(r.Exprs, &expr.NAT{
+				Type:        expr.NATTypeDestNAT,
+				Family:      unix.NFPROTO_IPV6,
+				RegAddrMin:  1,
+				RegProtoMin: 2,
+			})
+		}
+
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// mappingHostMatch returns the nftables exprs matching the host interface
+// and the host IP of a published port, if any.
+func mappingHostMatch(v string, pm MappingEntry) []expr.Any {
+	exprs := []expr.Any{}
+
+	// match host interface, if specified
+	if pm.HostInterface != "" {
+		exprs = append(exprs, &expr.Meta{
+			Key:      expr.MetaKeyIIFNAME,
+			Register: 1,
+		})
+		exprs = append(exprs, &expr.Cmp{
+			Op:       expr.CmpOpEq,
+			Register: 1,
+			Data:     EncodeInterfaceNameMatch(pm.HostInterface),
+		})
+	}
 
 	// match host IP, if specified
 	if hostIP := net.ParseIP(pm.HostIP); hostIP != nil {
-		r.Exprs = append(r.Exprs, IPDaddrMatch(v, hostIP)...)
+		exprs = append(exprs, IPDaddrMatch(v, hostIP)...)
 	}
+	return exprs
+}
 
-	// match port
-
-	r.Exprs = append(r.Exprs, &expr.Meta{
-		Key:      expr.MetaKeyL4PROTO,
-		Register: 1,
-	})
+// mappingPortMatch returns the nftables exprs matching the protocol and
+// the destination port of a published port, e.g. `tcp dport 8080`.
+func mappingPortMatch(protocol string, port int) ([]expr.Any, error) {
+	exprs := []expr.Any{
+		&expr.Meta{
+			Key:      expr.MetaKeyL4PROTO,
+			Register: 1,
+		},
+	}
 
-	switch pm.Protocol {
+	switch protocol {
 	case "tcp":
-		r.Exprs = append(r.Exprs, &expr.Cmp{
+		exprs = append(exprs, &expr.Cmp{
 			Op:       expr.CmpOpEq,
 			Register: 1,
 			Data:     []byte{unix.IPPROTO_TCP},
 		})
 	case "udp":
-		r.Exprs = append(r.Exprs, &expr.Cmp{
+		exprs = append(exprs, &expr.Cmp{
 			Op:       expr.CmpOpEq,
 			Register: 1,
 			Data:     []byte{unix.IPPROTO_UDP},
 		})
 	default:
-		return fmt.Errorf("unsupported protocol: %s", pm.Protocol)
+		return nil, fmt.Errorf("unsupported protocol: %s", protocol)
 	}
 
 	// [ payload load 2b @ transport header + 2 => reg 1 ]
-	r.Exprs = append(r.Exprs, &expr.Payload{
+	exprs = append(exprs, &expr.Payload{
 		DestRegister: 1,
 		Base:         expr.PayloadBaseTransportHeader,
-		Offset:       2, // TODO
-		Len:          2, // TODO
+		Offset:       2,
+		Len:          2,
 	})
 
 	// [ cmp eq reg 1 0x0000e60f ]
-	r.Exprs = append(r.Exprs, &expr.Cmp{
+	exprs = append(exprs, &expr.Cmp{
 		Op:       expr.CmpOpEq,
 		Register: 1,
-		Data:     binaryutil.BigEndian.PutUint16(uint16(pm.HostPort)),
-	})
-
-	if v == "4" {
-		r.Exprs = append(r.Exprs, &expr.Immediate{
-			Register: 1,
-			Data:     addr.IP.To4(),
-		})
-	} else {
-		r.Exprs = append(r.Exprs, &expr.Immediate{
-			Register: 1,
-			Data:     addr.IP.To16(),
-		})
-	}
-
-	r.Exprs = append(r.Exprs, &expr.Immediate{
-		Register: 2,
-		Data:     binaryutil.BigEndian.PutUint16(uint16(pm.ContainerPort)),
+		Data:     binaryutil.BigEndian.PutUint16(uint16(port)),
 	})
+	return exprs, nil
+}
 
-	if v == "4" {
-		r.Exprs = append(r.Exprs, &expr.NAT{
-			Type:        expr.NATTypeDestNAT,
-			Family:      unix.NFPROTO_IPV4,
-			RegAddrMin:  1,
-			RegProtoMin: 2,
-		})
-	} else {
-		r.Exprs = append(r.Exprs, &expr.NAT{
-			Type:        expr.NATTypeDestNAT,
-			Family:      unix.NFPROTO_IPV6,
-			RegAddrMin:  1,
-			RegProtoMin: 2,
-		})
-	}
-
-	conn.AddRule(r)
-	if err := conn.Flush(); err != nil {
-		return err
+// concatExprs returns a new list of the provided lists of exprs.
+func concatExprs(lists ...[]expr.Any) []expr.Any {
+	exprs := []expr.Any{}
+	for _, l := range lists {
+		exprs = append(exprs, l...)
 	}
-	return nil
+	return exprs
 }
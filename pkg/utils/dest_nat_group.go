@@ -0,0 +1,270 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func getDestinationNatGroupMap(v, tableName, mapName string) *nftables.Set {
+	tb := &nftables.Table{
+		Name: tableName,
+	}
+	m := &nftables.Set{
+		Table:   tb,
+		Name:    mapName,
+		IsMap:   true,
+		KeyType: nftables.TypeInteger,
+	}
+	if v == "4" {
+		tb.Family = nftables.TableFamilyIPv4
+		m.DataType = nftables.TypeIPAddr
+	} else {
+		tb.Family = nftables.TableFamilyIPv6
+		m.DataType = nftables.TypeIP6Addr
+	}
+	return m
+}
+
+// CreateDestinationNatGroupMap creates a named map holding the backends
+// of a load-balanced port mapping group. The keys of the map are the
+// indexes of the backends and the values are their IP addresses.
+func CreateDestinationNatGroupMap(v, tableName, mapName string) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+
+	if err := conn.AddSet(getDestinationNatGroupMap(v, tableName, mapName), []nftables.SetElement{}); err != nil {
+		return err
+	}
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed creating map %s in ipv%s %s table: %s",
+			mapName, v, tableName, err,
+		)
+	}
+	return nil
+}
+
+// GetDestinationNatGroupMembers returns the IP addresses of the backends
+// of a load-balanced port mapping group, ordered by their index.
+func GetDestinationNatGroupMembers(v, tableName, mapName string) ([]net.IP, error) {
+	if err := isSupportedIPVersion(v); err != nil {
+		return nil, err
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return nil, err
+	}
+
+	elements, err := conn.GetSetElements(getDestinationNatGroupMap(v, tableName, mapName))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(elements, func(i, j int) bool {
+		return binaryutil.NativeEndian.Uint32(elements[i].Key) < binaryutil.NativeEndian.Uint32(elements[j].Key)
+	})
+
+	members := []net.IP{}
+	for _, element := range elements {
+		members = append(members, net.IP(element.Val))
+	}
+	return members, nil
+}
+
+// SetDestinationNatGroupMembers replaces the backends of a load-balanced
+// port mapping group and the destination NAT rules of the group. The
+// backends are indexed from 0 to N-1, and the rules pick a backend
+// using either an incremental number generator or a hash of the source
+// address of a packet. The resulting rules look like:
+//
+//	iifname != "<bridgeIntfName>" tcp dport <hostPort> dnat to numgen inc mod <N> map @<mapName>:<containerPort>
+//	iifname != "<bridgeIntfName>" tcp dport <hostPort> dnat to jhash ip saddr mod <N> map @<mapName>:<containerPort>
+//
+// The map and the chain are updated in a single transaction.
+func SetDestinationNatGroupMembers(opts map[string]interface{}) error {
+	v := opts["version"].(string)
+	tableName := opts["table"].(string)
+	chainName := opts["chain"].(string)
+	mapName := opts["map"].(string)
+	bridgeIntfName := opts["bridge_interface"].(string)
+	members := opts["members"].([]net.IP)
+	pms := opts["port_mappings"].([]MappingEntry)
+	mode := opts["mode"].(string)
+
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+
+	m := getDestinationNatGroupMap(v, tableName, mapName)
+
+	ch := &nftables.Chain{
+		Name:  chainName,
+		Table: m.Table,
+	}
+
+	conn.FlushSet(m)
+	conn.FlushChain(ch)
+
+	if len(members) == 0 {
+		if err := conn.Flush(); err != nil {
+			return fmt.Errorf(
+				"failed flushing load-balanced destination NAT group %s in ipv%s %s table: %s",
+				mapName, v, tableName, err,
+			)
+		}
+		return nil
+	}
+
+	elements := []nftables.SetElement{}
+	for i, member := range members {
+		elements = append(elements, nftables.SetElement{
+			Key: binaryutil.NativeEndian.PutUint32(uint32(i)),
+			Val: encodeSetAddr(v, member),
+		})
+	}
+	if err := conn.SetAddElements(m, elements); err != nil {
+		return err
+	}
+
+	for _, pm := range pms {
+		srcMatches, err := MappingSourceMatches(v, pm)
+		if err != nil {
+			return err
+		}
+
+		portMatch := mappingHostMatch(v, pm)
+		l4Match, err := mappingPortMatch(pm.Protocol, pm.HostPort)
+		if err != nil {
+			return err
+		}
+		portMatch = append(portMatch, l4Match...)
+
+		// match non-container interface
+		iifMatch := []expr.Any{
+			&expr.Meta{
+				Key:      expr.MetaKeyIIFNAME,
+				Register: 1,
+			},
+			&expr.Cmp{
+				Op:       expr.CmpOpNeq,
+				Register: 1,
+				Data:     EncodeInterfaceName(bridgeIntfName),
+			},
+		}
+
+		// drop traffic over the limits of the port, if any, whatever the
+		// source of the traffic is
+		for _, limitExprs := range MappingLimitDropRules(pm.Limit, "nat") {
+			conn.AddRule(&nftables.Rule{
+				Table: m.Table,
+				Chain: ch,
+				Exprs: concatExprs(iifMatch, portMatch, limitExprs),
+			})
+		}
+
+		for _, srcMatch := range srcMatches {
+			// match source address, if restricted
+			r := &nftables.Rule{
+				Table: m.Table,
+				Chain: ch,
+				Exprs: concatExprs(iifMatch, srcMatch, portMatch),
+			}
+
+			switch mode {
+			case "jhash":
+				// [ payload load 4b @ network header + 12 => reg 1 ]
+				// [ hash reg 1 = jhash(reg 1, 4, 0x0) % mod N ]
+				if v == "4" {
+					r.Exprs = append(r.Exprs, &expr.Payload{
+						DestRegister: 1,
+						Base:         expr.PayloadBaseNetworkHeader,
+						Offset:       12,
+						Len:          4,
+					})
+				} else {
+					r.Exprs = append(r.Exprs, &expr.Payload{
+						DestRegister: 1,
+						Base:         expr.PayloadBaseNetworkHeader,
+						Offset:       8,
+						Len:          16,
+					})
+				}
+				r.Exprs = append(r.Exprs, &expr.Hash{
+					SourceRegister: 1,
+					DestRegister:   1,
+					Length:         uint32(len(encodeSetAddr(v, members[0]))),
+					Modulus:        uint32(len(members)),
+					Type:           expr.HashTypeJenkins,
+				})
+			case "numgen":
+				// [ numgen reg 1 = inc mod N ]
+				r.Exprs = append(r.Exprs, &expr.Numgen{
+					Register: 1,
+					Modulus:  uint32(len(members)),
+					Type:     unix.NFT_NG_INCREMENTAL,
+				})
+			default:
+				return fmt.Errorf("unsupported load balancing mode: %s", mode)
+			}
+
+			// [ lookup reg 1 set <mapName> dreg 1 ]
+			r.Exprs = append(r.Exprs, &expr.Lookup{
+				SourceRegister: 1,
+				DestRegister:   1,
+				IsDestRegSet:   true,
+				SetName:        mapName,
+			})
+
+			r.Exprs = append(r.Exprs, &expr.Immediate{
+				Register: 2,
+				Data:     binaryutil.BigEndian.PutUint16(uint16(pm.ContainerPort)),
+			})
+
+			r.Exprs = append(r.Exprs, &expr.Counter{})
+
+			if v == "4" {
+				r.Exprs = append(r.Exprs, &expr.NAT{
+					Type:        expr.NATTypeDestNAT,
+					Family:      unix.NFPROTO_IPV4,
+					RegAddrMin:  1,
+					RegProtoMin: 2,
+				})
+			} else {
+				r.Exprs = append(r.Exprs, &expr.NAT{
+					Type:        expr.NATTypeDestNAT,
+					Family:      unix.NFPROTO_IPV6,
+					RegAddrMin:  1,
+					RegProtoMin: 2,
+				})
+			}
+
+			conn.AddRule(r)
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed updating load-balanced destination NAT group %s in ipv%s %s table: %s",
+			mapName, v, tableName, err,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"net"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// getDestinationNatRulePort returns the port mapping of a destination NAT
+// rule, i.e. the protocol, the host port, the container port, and the
+// host IP and the host interface the port is published on, if any.
+func getDestinationNatRulePort(r *nftables.Rule) (MappingEntry, bool) {
+	pm := MappingEntry{}
+	isDestNat := false
+	for i, e := range r.Exprs {
+		switch x := e.(type) {
+		case *expr.Meta:
+			if i+1 >= len(r.Exprs) {
+				continue
+			}
+			c, ok := r.Exprs[i+1].(*expr.Cmp)
+			if !ok || c.Op != expr.CmpOpEq {
+				continue
+			}
+			switch {
+			case x.Key == expr.MetaKeyL4PROTO && len(c.Data) == 1:
+				switch c.Data[0] {
+				case unix.IPPROTO_TCP:
+					pm.Protocol = "tcp"
+				case unix.IPPROTO_UDP:
+					pm.Protocol = "udp"
+				}
+			case x.Key == expr.MetaKeyIIFNAME:
+				pm.HostInterface = DecodeInterfaceNameMatch(c.Data)
+			}
+		case *expr.Payload:
+			if i+1 >= len(r.Exprs) {
+				continue
+			}
+			c, ok := r.Exprs[i+1].(*expr.Cmp)
+			if !ok || c.Op != expr.CmpOpEq || int(x.Len) != len(c.Data) {
+				continue
+			}
+			switch {
+			case x.Base == expr.PayloadBaseTransportHeader && x.Offset == 2 && x.Len == 2:
+				pm.HostPort = int(binaryutil.BigEndian.Uint16(c.Data))
+			case x.Base == expr.PayloadBaseNetworkHeader && x.Offset == 16 && x.Len == net.IPv4len,
+				x.Base == expr.PayloadBaseNetworkHeader && x.Offset == 24 && x.Len == net.IPv6len:
+				pm.HostIP = net.IP(c.Data).String()
+			}
+		case *expr.Immediate:
+			if x.Register != 2 || len(x.Data) != 2 {
+				continue
+			}
+			pm.ContainerPort = int(binaryutil.BigEndian.Uint16(x.Data))
+		case *expr.NAT:
+			if x.Type == expr.NATTypeDestNAT {
+				isDestNat = true
+			}
+		}
+	}
+	if !isDestNat || pm.Protocol == "" || pm.HostPort == 0 {
+		return pm, false
+	}
+	return pm, true
+}
+
+// GetDestinationNatPorts returns the port mappings of the destination
+// NAT rules found in a particular chain.
+func GetDestinationNatPorts(v, tableName, chainName string) ([]MappingEntry, error) {
+	chainProps, err := GetChainProps(v, tableName, chainName)
+	if err != nil {
+		return nil, err
+	}
+	pms := []MappingEntry{}
+	for _, r := range chainProps.Rules {
+		if pm, ok := getDestinationNatRulePort(r); ok {
+			pms = append(pms, pm)
+		}
+	}
+	return pms, nil
+}
+
+// GetDestinationNatHostPorts returns the host ports, by protocol, used by
+// the destination NAT rules found in any of the chains of a table.
+func GetDestinationNatHostPorts(v, tableName string) (map[string]map[int]bool, error) {
+	if err := isSupportedIPVersion(v); err != nil {
+		return nil, err
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return nil, err
+	}
+
+	tb := &nftables.Table{
+		Name: tableName,
+	}
+	if v == "4" {
+		tb.Family = nftables.TableFamilyIPv4
+	} else {
+		tb.Family = nftables.TableFamilyIPv6
+	}
+
+	chains, err := conn.ListChains()
+	if err != nil {
+		return nil, err
+	}
+
+	ports := map[string]map[int]bool{
+		"tcp": {},
+		"udp": {},
+	}
+	for _, chain := range chains {
+		if chain == nil || chain.Table.Name != tableName || chain.Table.Family != tb.Family {
+			continue
+		}
+		rules, err := conn.GetRule(tb, &nftables.Chain{Name: chain.Name, Table: tb})
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rules {
+			if pm, ok := getDestinationNatRulePort(r); ok {
+				ports[pm.Protocol][pm.HostPort] = true
+			}
+		}
+	}
+	return ports, nil
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestGetDestinationNatRulePort(t *testing.T) {
+	var tests = []struct {
+		name string
+		v    string
+		pm   MappingEntry
+	}{
+		{
+			name: "any host address",
+			v:    "4",
+			pm:   MappingEntry{HostPort: 32768, ContainerPort: 80, Protocol: "tcp"},
+		},
+		{
+			name: "ipv4 host address and interface",
+			v:    "4",
+			pm:   MappingEntry{HostPort: 32769, ContainerPort: 80, Protocol: "tcp", HostIP: "192.0.2.1", HostInterface: "eth0"},
+		},
+		{
+			name: "ipv6 host address and interface prefix",
+			v:    "6",
+			pm:   MappingEntry{HostPort: 32770, ContainerPort: 53, Protocol: "udp", HostIP: "2001:db8::1", HostInterface: "eth*"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			portMatch, err := mappingPortMatch(test.pm.Protocol, test.pm.HostPort)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			family := byte(unix.NFPROTO_IPV4)
+			containerIP := net.ParseIP("10.88.0.5").To4()
+			if test.v == "6" {
+				family = unix.NFPROTO_IPV6
+				containerIP = net.ParseIP("fd00::5")
+			}
+			r := &nftables.Rule{
+				Exprs: concatExprs(
+					[]expr.Any{
+						&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+						&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: EncodeInterfaceName("cni-podman0")},
+					},
+					mappingHostMatch(test.v, test.pm),
+					portMatch,
+					[]expr.Any{
+						&expr.Immediate{Register: 1, Data: containerIP},
+						&expr.Immediate{Register: 2, Data: binaryutil.BigEndian.PutUint16(uint16(test.pm.ContainerPort))},
+						&expr.NAT{Type: expr.NATTypeDestNAT, Family: uint32(family), RegAddrMin: 1, RegProtoMin: 2},
+					},
+				),
+			}
+			got, ok := getDestinationNatRulePort(r)
+			if !ok {
+				t.Fatalf("rule not recognized as destination NAT rule")
+			}
+			if got.Protocol != test.pm.Protocol || got.HostPort != test.pm.HostPort ||
+				got.ContainerPort != test.pm.ContainerPort || got.HostIP != test.pm.HostIP ||
+				got.HostInterface != test.pm.HostInterface {
+				t.Fatalf("unexpected port mapping\ngot:  %+v\nwant: %+v", got, test.pm)
+			}
+		})
+	}
+}
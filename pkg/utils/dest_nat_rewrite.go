@@ -182,7 +182,10 @@ func AddDestinationNatRewriteRules(opts map[string]interface{}) error {
 
 	conn.AddRule(r)
 	if err := conn.Flush(); err != nil {
-		return err
+		return fmt.Errorf(
+			"failed adding destination NAT rewrite rule in chain %s of ipv%s %s table for %v: %s, rule: %s",
+			chainName, v, tableName, pm, err, FormatRuleExprs(v, r.Exprs),
+		)
 	}
 	return nil
 }
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAddDestinationNatRules(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "plan.nft")
+	c := &DryRunConfig{Output: output, Format: "nft", Baseline: "empty"}
+	pm := MappingEntry{
+		HostPort:       8080,
+		ContainerPort:  80,
+		Protocol:       "tcp",
+		AllowedSources: []string{"192.0.2.0/24", "198.51.100.7"},
+		Limit:          &MappingLimit{Rate: 10, Unit: "second", Per: "connection", MaxConnections: 100},
+	}
+	err := DryRun(c, "test", func() error {
+		return AddDestinationNatRules(map[string]interface{}{
+			"version":          "4",
+			"table":            "nat",
+			"chain":            "cni-npr-test",
+			"bridge_interface": "cni-podman0",
+			"ip_address":       net.IPNet{IP: net.ParseIP("10.88.0.5"), Mask: net.CIDRMask(16, 32)},
+			"port_mapping":     pm,
+		})
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	b, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("failed reading dry-run output: %s", err)
+	}
+
+	// The limits apply to the port, whatever the source of the traffic
+	// is, and the allowed sources get a destination NAT rule each.
+	want := []string{
+		"# test",
+		`add rule ip nat cni-npr-test iifname != "cni-podman0" meta l4proto tcp tcp dport 8080 ct state new limit rate over 10/second counter packets 0 bytes 0 drop`,
+		`add rule ip nat cni-npr-test iifname != "cni-podman0" meta l4proto tcp tcp dport 8080 ct state new ct count over 100 counter packets 0 bytes 0 drop`,
+		`add rule ip nat cni-npr-test iifname != "cni-podman0" ip saddr 192.0.2.0/24 meta l4proto tcp tcp dport 8080 dnat to 10.88.0.5:80`,
+		`add rule ip nat cni-npr-test iifname != "cni-podman0" ip saddr 198.51.100.7 meta l4proto tcp tcp dport 8080 dnat to 10.88.0.5:80`,
+	}
+	got := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected rules\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestMappingMatches(t *testing.T) {
+	var tests = []struct {
+		name string
+		v    string
+		pm   MappingEntry
+		want string
+	}{
+		{
+			name: "any host address",
+			v:    "4",
+			pm:   MappingEntry{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"},
+			want: `meta l4proto tcp tcp dport 8080`,
+		},
+		{
+			name: "host interface",
+			v:    "4",
+			pm:   MappingEntry{HostPort: 8080, ContainerPort: 80, Protocol: "tcp", HostInterface: "eth0"},
+			want: `iifname "eth0" meta l4proto tcp tcp dport 8080`,
+		},
+		{
+			name: "host interface prefix and ipv6 host address",
+			v:    "6",
+			pm:   MappingEntry{HostPort: 5353, ContainerPort: 53, Protocol: "udp", HostInterface: "wl*", HostIP: "2001:db8::1"},
+			want: `iifname "wl*" ip6 daddr 2001:db8::1 meta l4proto udp udp dport 5353`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			portMatch, err := mappingPortMatch(test.pm.Protocol, test.pm.HostPort)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			got := FormatRuleExprs(test.v, concatExprs(mappingHostMatch(test.v, test.pm), portMatch))
+			if got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestInterfaceNameMatch(t *testing.T) {
+	for _, name := range []string{"eth0", "eth*", "enp0s31f6", "veth*"} {
+		if got := DecodeInterfaceNameMatch(EncodeInterfaceNameMatch(name)); got != name {
+			t.Errorf("got %s, want %s", got, name)
+		}
+	}
+}
+
+func TestGetDestinationNatRules(t *testing.T) {
+	pm := MappingEntry{
+		HostPort:       8080,
+		ContainerPort:  80,
+		Protocol:       "tcp",
+		AllowedSources: []string{"192.0.2.0/24", "2001:db8::/32"},
+		Limit:          &MappingLimit{MaxConnections: 100},
+	}
+	var tests = []struct {
+		name string
+		v    string
+		addr net.IPNet
+		want string
+	}{
+		{
+			name: "ipv4",
+			v:    "4",
+			addr: net.IPNet{IP: net.ParseIP("10.88.0.5"), Mask: net.CIDRMask(16, 32)},
+			want: `iifname != "cni-podman0" meta l4proto tcp tcp dport 8080 ct state new ct count over 100 counter packets 0 bytes 0 drop; ` +
+				`iifname != "cni-podman0" ip saddr 192.0.2.0/24 meta l4proto tcp tcp dport 8080 counter name "cni-dnt-test" dnat to 10.88.0.5:80`,
+		},
+		{
+			name: "ipv6",
+			v:    "6",
+			addr: net.IPNet{IP: net.ParseIP("fd00::5"), Mask: net.CIDRMask(64, 128)},
+			want: `iifname != "cni-podman0" meta l4proto tcp tcp dport 8080 ct state new ct count over 100 counter packets 0 bytes 0 drop; ` +
+				`iifname != "cni-podman0" ip6 saddr 2001:db8::/32 meta l4proto tcp tcp dport 8080 counter name "cni-dnt-test" dnat to [fd00::5]:80`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules, err := getDestinationNatRules(nil, nil, test.v, "cni-podman0", test.addr, pm, "cni-dnt-test")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			// The rules are reported as such when the flush fails.
+			if got := formatRulesExprs(test.v, rules); got != test.want {
+				t.Fatalf("unexpected rules\ngot:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
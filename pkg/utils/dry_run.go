@@ -0,0 +1,434 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nltest"
+	"golang.org/x/sys/unix"
+)
+
+// The environment variables enabling the dry-run mode and overriding the
+// dry-run config.
+const (
+	DryRunEnv         = "CNI_NFTABLES_DRY_RUN"
+	DryRunOutputEnv   = "CNI_NFTABLES_DRY_RUN_OUTPUT"
+	DryRunFormatEnv   = "CNI_NFTABLES_DRY_RUN_FORMAT"
+	DryRunBaselineEnv = "CNI_NFTABLES_DRY_RUN_BASELINE"
+)
+
+// DryRunConfig is the dry-run mode of the plugins, in which the nf_tables
+// changes of ADD and DEL are written as a script instead of being applied.
+type DryRunConfig struct {
+	// Output is the file the script is appended to. The script is written
+	// to stderr by default, because stdout holds the result.
+	Output string `json:"output,omitempty"`
+	// Format is either nft, i.e. a script for `nft -f`, or json, i.e. a
+	// libnftables JSON document.
+	Format string `json:"format,omitempty"`
+	// Baseline is the ruleset the changes are computed against, i.e. live,
+	// the ruleset of the host, or empty, an empty ruleset read without
+	// access to the kernel, e.g. for golden tests.
+	Baseline string `json:"baseline,omitempty"`
+}
+
+// GetDryRunConfig returns the dry-run config with the overrides of the
+// environment variables, or nil when the dry-run mode is disabled. The
+// CNI_NFTABLES_DRY_RUN variable enables or disables the mode regardless
+// of the config.
+func GetDryRunConfig(c *DryRunConfig) (*DryRunConfig, error) {
+	if s, exists := os.LookupEnv(DryRunEnv); exists && s != "" {
+		enabled, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q: %v", DryRunEnv, s, err)
+		}
+		if !enabled {
+			return nil, nil
+		}
+		if c == nil {
+			c = &DryRunConfig{}
+		}
+	}
+	if c == nil {
+		return nil, nil
+	}
+
+	dc := *c
+	if s := os.Getenv(DryRunOutputEnv); s != "" {
+		dc.Output = s
+	}
+	if s := os.Getenv(DryRunFormatEnv); s != "" {
+		dc.Format = s
+	}
+	if s := os.Getenv(DryRunBaselineEnv); s != "" {
+		dc.Baseline = s
+	}
+	if dc.Format == "" {
+		dc.Format = "nft"
+	}
+	if dc.Baseline == "" {
+		dc.Baseline = "live"
+	}
+	if err := ValidateDryRunConfig(&dc); err != nil {
+		return nil, err
+	}
+	return &dc, nil
+}
+
+// ValidateDryRunConfig validates the dry-run config.
+func ValidateDryRunConfig(c *DryRunConfig) error {
+	if c == nil {
+		return nil
+	}
+	switch c.Format {
+	case "", "nft", "json":
+	default:
+		return fmt.Errorf("unsupported dry-run format %s", c.Format)
+	}
+	switch c.Baseline {
+	case "", "live", "empty":
+	default:
+		return fmt.Errorf("unsupported dry-run baseline %s", c.Baseline)
+	}
+	return nil
+}
+
+// dryRunRuleHandleBase is the base of the handles of the planned rules,
+// above the handles of the rules of the baseline.
+const dryRunRuleHandleBase = 1 << 48
+
+// dryRunPlan holds the nf_tables messages of the changes planned in
+// dry-run mode, in the order of the batches sending them.
+type dryRunPlan struct {
+	mu       sync.Mutex
+	config   *DryRunConfig
+	messages []netlink.Message
+	// ruleHandles is the number of the planned rules having a handle.
+	ruleHandles uint64
+}
+
+// activeDryRun is the plan recording the changes while a function runs in
+// dry-run mode.
+var activeDryRun *dryRunPlan
+
+// DryRun runs the provided function in dry-run mode, i.e. the nf_tables
+// changes of the function are written to the output of the dry-run config,
+// preceded by the provided header, instead of being applied. The reads of
+// the function see the ruleset of the baseline with the changes planned
+// so far. Without a config, the function runs as usual.
+func DryRun(c *DryRunConfig, header string, fn func() error) error {
+	if c == nil {
+		return fn()
+	}
+	p := &dryRunPlan{config: c}
+	activeDryRun = p
+	err := fn()
+	activeDryRun = nil
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stderr
+	if c.Output != "" {
+		f, err := os.OpenFile(c.Output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("failed opening dry-run output %s: %v", c.Output, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := p.write(w, header); err != nil {
+		return fmt.Errorf("failed writing dry-run output: %v", err)
+	}
+	return nil
+}
+
+// record adds the messages of a batch to the plan.
+func (p *dryRunPlan) record(messages []netlink.Message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, msg := range messages {
+		switch msg.Header.Type {
+		case netlink.HeaderType(unix.NFNL_MSG_BATCH_BEGIN), netlink.HeaderType(unix.NFNL_MSG_BATCH_END):
+			continue
+		}
+		if nftMessageType(msg) == unix.NFT_MSG_NEWRULE && msg.Header.Flags&netlink.Replace == 0 {
+			msg = p.addRuleHandle(msg)
+		}
+		p.messages = append(p.messages, msg)
+	}
+}
+
+// addRuleHandle returns a planned rule with a handle, as the kernel
+// assigns one, so that the rules placed relative to the rule and the
+// deletion of the rule apply to the replies to the queries.
+func (p *dryRunPlan) addRuleHandle(msg netlink.Message) netlink.Message {
+	p.ruleHandles++
+	attr, err := netlink.MarshalAttributes([]netlink.Attribute{
+		{Type: unix.NFTA_RULE_HANDLE, Data: binaryutil.BigEndian.PutUint64(dryRunRuleHandleBase + p.ruleHandles)},
+	})
+	if err != nil {
+		return msg
+	}
+	data := make([]byte, 0, len(msg.Data)+len(attr))
+	msg.Data = append(append(data, msg.Data...), attr...)
+	return msg
+}
+
+// isPlannedRuleHandle checks whether a rule handle is the handle of a
+// planned rule.
+func isPlannedRuleHandle(handle uint64) bool {
+	return handle > dryRunRuleHandleBase
+}
+
+// nftRuleHandle returns the handle of a rule message, if any.
+func nftRuleHandle(msg netlink.Message) (uint64, bool) {
+	handle, exists := nftMessageAttrs(msg)[unix.NFTA_RULE_HANDLE]
+	if !exists || len(handle) != 8 {
+		return 0, false
+	}
+	return binaryutil.BigEndian.Uint64(handle), true
+}
+
+// dial is the netlink socket of the connections of nftables package in
+// dry-run mode. The batches are recorded in the plan and the queries are
+// answered from the baseline with the planned changes.
+func (p *dryRunPlan) dial(req []netlink.Message) ([]netlink.Message, error) {
+	if len(req) == 0 {
+		return nil, nil
+	}
+	if req[0].Header.Type == netlink.HeaderType(unix.NFNL_MSG_BATCH_BEGIN) {
+		p.record(req)
+		return nil, nil
+	}
+	replies, err := p.query(req[0])
+	if err != nil {
+		return nil, err
+	}
+	for i := range replies {
+		replies[i].Header.Flags = 0
+		replies[i].Header.Sequence = req[0].Header.Sequence
+		replies[i].Header.PID = req[0].Header.PID
+	}
+	return replies, nil
+}
+
+// exec answers a query sent outside of nftables package.
+func (p *dryRunPlan) exec(message netlink.Message) ([]netlink.Message, error) {
+	conn := nltest.Dial(p.dial)
+	defer conn.Close()
+	return conn.Execute(message)
+}
+
+// query returns the replies of the baseline to a query, with the objects
+// created by the planned changes added and the deleted objects removed.
+// The objects missing from the baseline are the ones of an empty list.
+func (p *dryRunPlan) query(req netlink.Message) ([]netlink.Message, error) {
+	replies, err := p.baseline(req)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	replies = overlayNftMessages(req, replies, p.messages)
+	p.mu.Unlock()
+
+	if len(replies) == 0 && req.Header.Flags&netlink.Dump != netlink.Dump {
+		return nil, unix.ENOENT
+	}
+	return replies, nil
+}
+
+// baseline returns the replies of the baseline to a query.
+func (p *dryRunPlan) baseline(req netlink.Message) ([]netlink.Message, error) {
+	if p.config.Baseline == "empty" {
+		return []netlink.Message{}, nil
+	}
+	replies, err := execLiveNftMessage(req)
+	switch {
+	case err == nil:
+		return replies, nil
+	case errors.Is(err, unix.ENOENT):
+		return []netlink.Message{}, nil
+	}
+	return nil, err
+}
+
+// nftMessageType returns the type of an nf_tables message, e.g.
+// NFT_MSG_NEWRULE, or -1 for the other messages.
+func nftMessageType(msg netlink.Message) int {
+	if msg.Header.Type>>8 != unix.NFNL_SUBSYS_NFTABLES || len(msg.Data) < 4 {
+		return -1
+	}
+	return int(msg.Header.Type & 0xff)
+}
+
+// nftMessageAttrs returns the attributes of an nf_tables message by type.
+func nftMessageAttrs(msg netlink.Message) map[uint16][]byte {
+	attrs := map[uint16][]byte{}
+	if len(msg.Data) < 4 {
+		return attrs
+	}
+	ad, err := netlink.NewAttributeDecoder(msg.Data[4:])
+	if err != nil {
+		return attrs
+	}
+	for ad.Next() {
+		attrs[ad.Type()] = ad.Bytes()
+	}
+	return attrs
+}
+
+// isNftAttrsMatch checks whether the attributes of a message hold every
+// attribute of a selector, e.g. the table and the chain of a query.
+func isNftAttrsMatch(selector, attrs map[uint16][]byte) bool {
+	for attrType, data := range selector {
+		value, exists := attrs[attrType]
+		if !exists || !bytes.Equal(bytes.TrimRight(value, "\x00"), bytes.TrimRight(data, "\x00")) {
+			return false
+		}
+	}
+	return true
+}
+
+// nftIdentityAttrs are the attributes identifying the named objects, by
+// the type of the messages creating them.
+var nftIdentityAttrs = map[int][]uint16{
+	unix.NFT_MSG_NEWTABLE:     {unix.NFTA_TABLE_NAME},
+	unix.NFT_MSG_NEWCHAIN:     {unix.NFTA_CHAIN_TABLE, unix.NFTA_CHAIN_NAME},
+	unix.NFT_MSG_NEWSET:       {unix.NFTA_SET_TABLE, unix.NFTA_SET_NAME},
+	unix.NFT_MSG_NEWOBJ:       {unix.NFTA_OBJ_TABLE, unix.NFTA_OBJ_NAME, unix.NFTA_OBJ_TYPE},
+	unix.NFT_MSG_NEWFLOWTABLE: {nftables.NFTA_FLOWTABLE_TABLE, nftables.NFTA_FLOWTABLE_NAME},
+}
+
+// overlayNftMessages applies the planned messages to the replies to a
+// query. The replies to NFT_MSG_GET<X> are NFT_MSG_NEW<X> messages, which
+// the planned NFT_MSG_NEW<X> messages matching the attributes of the query
+// are added to, and the planned NFT_MSG_DEL<X> messages are removed from.
+// The rules are placed at the position of the planned rules.
+func overlayNftMessages(req netlink.Message, replies, planned []netlink.Message) []netlink.Message {
+	getType := nftMessageType(req)
+	if getType < 0 {
+		return replies
+	}
+	newType, delType := getType-1, getType+1
+	family := req.Data[0]
+	selector := nftMessageAttrs(req)
+
+	for _, msg := range planned {
+		msgType := nftMessageType(msg)
+		if family != unix.AF_UNSPEC && msg.Data[0] != family {
+			continue
+		}
+		attrs := nftMessageAttrs(msg)
+		switch {
+		case msgType == newType:
+			if !isNftAttrsMatch(selector, attrs) {
+				continue
+			}
+			replies = addNftReply(replies, msg, attrs)
+		case msgType == delType:
+			switch {
+			case msgType == unix.NFT_MSG_DELSETELEM && attrs[unix.NFTA_SET_ELEM_LIST_ELEMENTS] != nil:
+				// The elements of the replies are not removed one by one.
+				continue
+			case msgType == nftables.NFT_MSG_DELFLOWTABLE && attrs[nftables.NFTA_FLOWTABLE_HOOK] != nil:
+				// The devices of the flowtables are not tracked.
+				continue
+			}
+			deleted := attrs
+			if identity, exists := nftIdentityAttrs[newType]; exists {
+				deleted = map[uint16][]byte{}
+				for _, attrType := range identity {
+					deleted[attrType] = attrs[attrType]
+				}
+			}
+			replies = removeNftReplies(replies, func(reply netlink.Message) bool {
+				return reply.Data[0] == msg.Data[0] && isNftAttrsMatch(deleted, nftMessageAttrs(reply))
+			})
+		case msgType == unix.NFT_MSG_DELTABLE:
+			// The deleted tables are deleted with their objects.
+			replies = removeNftReplies(replies, func(reply netlink.Message) bool {
+				return reply.Data[0] == msg.Data[0] && isNftAttrsMatch(
+					map[uint16][]byte{1: attrs[unix.NFTA_TABLE_NAME]}, nftMessageAttrs(reply),
+				)
+			})
+		case msgType == unix.NFT_MSG_DELCHAIN && getType == unix.NFT_MSG_GETRULE:
+			replies = removeNftReplies(replies, func(reply netlink.Message) bool {
+				return reply.Data[0] == msg.Data[0] && isNftAttrsMatch(map[uint16][]byte{
+					unix.NFTA_RULE_TABLE: attrs[unix.NFTA_CHAIN_TABLE],
+					unix.NFTA_RULE_CHAIN: attrs[unix.NFTA_CHAIN_NAME],
+				}, nftMessageAttrs(reply))
+			})
+		}
+	}
+	return replies
+}
+
+// addNftReply adds a planned message to the replies. The existing named
+// objects are kept, and the rules are inserted or appended at the
+// position of the planned rules.
+func addNftReply(replies []netlink.Message, msg netlink.Message, attrs map[uint16][]byte) []netlink.Message {
+	msgType := nftMessageType(msg)
+	if identity, exists := nftIdentityAttrs[msgType]; exists {
+		selector := map[uint16][]byte{}
+		for _, attrType := range identity {
+			selector[attrType] = attrs[attrType]
+		}
+		for _, reply := range replies {
+			if reply.Data[0] == msg.Data[0] && isNftAttrsMatch(selector, nftMessageAttrs(reply)) {
+				return replies
+			}
+		}
+		return append(replies, msg)
+	}
+	if msgType != unix.NFT_MSG_NEWRULE {
+		return append(replies, msg)
+	}
+
+	isAppend := msg.Header.Flags&unix.NLM_F_APPEND != 0
+	position, hasPosition := attrs[unix.NFTA_RULE_POSITION]
+	i := len(replies)
+	switch {
+	case hasPosition:
+		for j, reply := range replies {
+			if handle := nftMessageAttrs(reply)[unix.NFTA_RULE_HANDLE]; handle != nil && bytes.Equal(handle, position) {
+				i = j
+				if isAppend {
+					i++
+				}
+				break
+			}
+		}
+	case !isAppend:
+		i = 0
+	}
+	replies = append(replies, netlink.Message{})
+	copy(replies[i+1:], replies[i:])
+	replies[i] = msg
+	return replies
+}
+
+func removeNftReplies(replies []netlink.Message, match func(netlink.Message) bool) []netlink.Message {
+	kept := []netlink.Message{}
+	for _, reply := range replies {
+		if !match(reply) {
+			kept = append(kept, reply)
+		}
+	}
+	return kept
+}
+
+// trimNftString returns a string attribute without the null terminator.
+func trimNftString(b []byte) string {
+	return strings.TrimRight(string(b), "\x00")
+}
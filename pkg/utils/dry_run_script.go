@@ -0,0 +1,757 @@
+package utils
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+var nftFamilyNames = map[byte]string{
+	unix.NFPROTO_INET:   "inet",
+	unix.NFPROTO_IPV4:   "ip",
+	unix.NFPROTO_ARP:    "arp",
+	unix.NFPROTO_NETDEV: "netdev",
+	unix.NFPROTO_BRIDGE: "bridge",
+	unix.NFPROTO_IPV6:   "ip6",
+}
+
+var nftHookNames = map[uint32]string{
+	unix.NF_INET_PRE_ROUTING:  "prerouting",
+	unix.NF_INET_LOCAL_IN:     "input",
+	unix.NF_INET_FORWARD:      "forward",
+	unix.NF_INET_LOCAL_OUT:    "output",
+	unix.NF_INET_POST_ROUTING: "postrouting",
+}
+
+var nftNetdevHookNames = map[uint32]string{
+	unix.NF_NETDEV_INGRESS: "ingress",
+	1:                      "egress",
+}
+
+// dryRunCommand is a planned change as an nft command and as a
+// libnftables JSON command.
+type dryRunCommand struct {
+	text string
+	json jsonObject
+}
+
+// write writes the planned changes as an nft script preceded by a comment
+// holding the provided header, or as a libnftables JSON document.
+func (p *dryRunPlan) write(w io.Writer, header string) error {
+	commands, err := p.commands()
+	if err != nil {
+		return err
+	}
+
+	if p.config.Format == "json" {
+		cmds := []interface{}{jsonObject{"metainfo": jsonObject{"json_schema_version": 1}}}
+		for _, c := range commands {
+			cmds = append(cmds, c.json)
+		}
+		b, err := json.Marshal(jsonObject{"nftables": cmds})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n", header)
+	for _, c := range commands {
+		b.WriteString(c.text + "\n")
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// commands returns the planned changes as commands. The planned rules
+// deleted by the plan are left out, along with their deletion, because a
+// script is unable to refer to the rules it adds by handle.
+func (p *dryRunPlan) commands() ([]*dryRunCommand, error) {
+	deleted := map[uint64]bool{}
+	for _, msg := range p.messages {
+		if handle, exists := nftRuleHandle(msg); exists && isPlannedRuleHandle(handle) && nftMessageType(msg) == unix.NFT_MSG_DELRULE {
+			deleted[handle] = true
+		}
+	}
+
+	sets := map[string]*nftables.Set{}
+	commands := []*dryRunCommand{}
+	for i, msg := range p.messages {
+		if handle, exists := nftRuleHandle(msg); exists && deleted[handle] {
+			continue
+		}
+		c, err := p.command(msg, p.messages[:i], deleted, sets)
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			commands = append(commands, c)
+		}
+	}
+	return commands, nil
+}
+
+// command returns a planned nf_tables message, following the provided
+// planned messages, as a command. The sets created by the plan are added
+// to the provided sets, keyed by family, table and name, for the
+// formatting of their elements.
+func (p *dryRunPlan) command(msg netlink.Message, planned []netlink.Message, deleted map[uint64]bool, sets map[string]*nftables.Set) (*dryRunCommand, error) {
+	msgType := nftMessageType(msg)
+	if msgType < 0 {
+		return nil, fmt.Errorf("unsupported dry-run message type %d", msg.Header.Type)
+	}
+	family := nftFamilyNames[msg.Data[0]]
+	tb := &nftables.Table{Family: nftables.TableFamily(msg.Data[0])}
+	attrs := nftMessageAttrs(msg)
+
+	switch msgType {
+	case unix.NFT_MSG_NEWTABLE, unix.NFT_MSG_DELTABLE:
+		verb := nftCommandVerb(msgType == unix.NFT_MSG_NEWTABLE)
+		name := trimNftString(attrs[unix.NFTA_TABLE_NAME])
+		return &dryRunCommand{
+			text: fmt.Sprintf("%s table %s %s", verb, family, name),
+			json: jsonObject{verb: jsonObject{"table": jsonObject{"family": family, "name": name}}},
+		}, nil
+	case unix.NFT_MSG_NEWCHAIN:
+		return formatChainCommand(family, attrs)
+	case unix.NFT_MSG_DELCHAIN:
+		tb.Name = trimNftString(attrs[unix.NFTA_CHAIN_TABLE])
+		return formatObjectCommand("delete", "chain", tb, trimNftString(attrs[unix.NFTA_CHAIN_NAME])), nil
+	case unix.NFT_MSG_NEWRULE:
+		return p.formatRuleCommand(msg, planned, deleted, tb, attrs)
+	case unix.NFT_MSG_DELRULE:
+		tb.Name = trimNftString(attrs[unix.NFTA_RULE_TABLE])
+		chainName := trimNftString(attrs[unix.NFTA_RULE_CHAIN])
+		handle, exists := attrs[unix.NFTA_RULE_HANDLE]
+		if !exists {
+			return formatObjectCommand("flush", "chain", tb, chainName), nil
+		}
+		h := binaryutil.BigEndian.Uint64(handle)
+		return &dryRunCommand{
+			text: fmt.Sprintf("delete rule %s %s %s handle %d", family, tb.Name, chainName, h),
+			json: jsonObject{"delete": jsonObject{"rule": jsonObject{
+				"family": family, "table": tb.Name, "chain": chainName, "handle": h,
+			}}},
+		}, nil
+	case unix.NFT_MSG_NEWSET:
+		tb.Name = trimNftString(attrs[unix.NFTA_SET_TABLE])
+		planned, err := replayNftMessage(msg).GetSets(tb)
+		if err != nil {
+			return nil, err
+		}
+		if len(planned) == 0 {
+			return nil, fmt.Errorf("failed decoding planned set")
+		}
+		s := planned[0]
+		sets[nftSetKey(tb, s.Name)] = s
+		return formatSetCommand(s), nil
+	case unix.NFT_MSG_DELSET:
+		tb.Name = trimNftString(attrs[unix.NFTA_SET_TABLE])
+		return formatObjectCommand("delete", "set", tb, trimNftString(attrs[unix.NFTA_SET_NAME])), nil
+	case unix.NFT_MSG_NEWSETELEM, unix.NFT_MSG_DELSETELEM:
+		tb.Name = trimNftString(attrs[unix.NFTA_SET_ELEM_LIST_TABLE])
+		s := p.lookupSet(sets, tb, trimNftString(attrs[unix.NFTA_SET_ELEM_LIST_SET]))
+		if msgType == unix.NFT_MSG_DELSETELEM && attrs[unix.NFTA_SET_ELEM_LIST_ELEMENTS] == nil {
+			return formatObjectCommand("flush", "set", tb, s.Name), nil
+		}
+		// The parser of nftables package decodes the added elements only.
+		msg.Header.Type = netlink.HeaderType((unix.NFNL_SUBSYS_NFTABLES << 8) | unix.NFT_MSG_NEWSETELEM)
+		elements, err := replayNftMessage(renumberSetElemList(msg)).GetSetElements(s)
+		if err != nil {
+			return nil, err
+		}
+		if len(elements) == 0 {
+			return nil, nil
+		}
+		texts, values := formatSetElements(s, elements)
+		verb := nftCommandVerb(msgType == unix.NFT_MSG_NEWSETELEM)
+		return &dryRunCommand{
+			text: fmt.Sprintf("%s element %s %s %s { %s }", verb, family, tb.Name, s.Name, strings.Join(texts, ", ")),
+			json: jsonObject{verb: jsonObject{"element": jsonObject{
+				"family": family, "table": tb.Name, "name": s.Name, "elem": values,
+			}}},
+		}, nil
+	case unix.NFT_MSG_NEWOBJ, unix.NFT_MSG_DELOBJ:
+		return formatObjCommand(msgType == unix.NFT_MSG_NEWOBJ, tb, attrs)
+	case nftables.NFT_MSG_NEWFLOWTABLE, nftables.NFT_MSG_DELFLOWTABLE:
+		tb.Name = trimNftString(attrs[nftables.NFTA_FLOWTABLE_TABLE])
+		// The parser of nftables package decodes the added flowtables only.
+		isAdd := msgType == nftables.NFT_MSG_NEWFLOWTABLE
+		msg.Header.Type = netlink.HeaderType((unix.NFNL_SUBSYS_NFTABLES << 8) | nftables.NFT_MSG_NEWFLOWTABLE)
+		flowtables, err := replayNftMessage(msg).ListFlowtables(tb)
+		if err != nil {
+			return nil, err
+		}
+		if len(flowtables) == 0 {
+			return nil, fmt.Errorf("failed decoding planned flowtable")
+		}
+		return formatFlowtableCommand(isAdd, flowtables[0]), nil
+	}
+	return nil, fmt.Errorf("unsupported dry-run message type %d", msgType)
+}
+
+func nftCommandVerb(isAdd bool) string {
+	if isAdd {
+		return "add"
+	}
+	return "delete"
+}
+
+func nftSetKey(tb *nftables.Table, name string) string {
+	return fmt.Sprintf("%d/%s/%s", tb.Family, tb.Name, name)
+}
+
+// replayNftMessage returns a connection of nftables package receiving the
+// provided message as the reply to any query, to decode a planned message
+// with the parsers of the package.
+func replayNftMessage(msg netlink.Message) *nftables.Conn {
+	return &nftables.Conn{
+		TestDial: func(req []netlink.Message) ([]netlink.Message, error) {
+			if len(req) == 0 {
+				return nil, nil
+			}
+			reply := msg
+			reply.Header.Flags = 0
+			reply.Header.Sequence = req[0].Header.Sequence
+			reply.Header.PID = req[0].Header.PID
+			return []netlink.Message{reply}, nil
+		},
+	}
+}
+
+// renumberSetElemList returns the provided set element message with the
+// type of every element of its list set to NFTA_LIST_ELEM. The nftables
+// package numbers the elements it sends, which the kernel ignores, but
+// its parser decodes the elements of type NFTA_LIST_ELEM only.
+func renumberSetElemList(msg netlink.Message) netlink.Message {
+	if len(msg.Data) < 4 {
+		return msg
+	}
+	attrs, err := netlink.UnmarshalAttributes(msg.Data[4:])
+	if err != nil {
+		return msg
+	}
+	for i, attr := range attrs {
+		if attr.Type&^unix.NLA_F_NESTED != unix.NFTA_SET_ELEM_LIST_ELEMENTS {
+			continue
+		}
+		elements, err := netlink.UnmarshalAttributes(attr.Data)
+		if err != nil {
+			return msg
+		}
+		for j := range elements {
+			elements[j].Type = unix.NFTA_LIST_ELEM | unix.NLA_F_NESTED
+		}
+		if attrs[i].Data, err = netlink.MarshalAttributes(elements); err != nil {
+			return msg
+		}
+	}
+	b, err := netlink.MarshalAttributes(attrs)
+	if err != nil {
+		return msg
+	}
+	msg.Data = append(append([]byte{}, msg.Data[:4]...), b...)
+	return msg
+}
+
+// lookupSet returns a set created by the plan, or a set of the baseline,
+// for the formatting of its elements.
+func (p *dryRunPlan) lookupSet(sets map[string]*nftables.Set, tb *nftables.Table, name string) *nftables.Set {
+	key := nftSetKey(tb, name)
+	if s, exists := sets[key]; exists {
+		return s
+	}
+	s := &nftables.Set{Table: tb, Name: name}
+	if p.config.Baseline != "empty" {
+		if conn, err := initNftConn(); err == nil {
+			if existing, err := conn.GetSetByName(tb, name); err == nil {
+				s = existing
+				s.Table = tb
+			}
+		}
+	}
+	sets[key] = s
+	return s
+}
+
+// formatObjectCommand returns a command on a named object of a table,
+// e.g. `delete chain ip filter cni-ffw-abc`.
+func formatObjectCommand(verb, kind string, tb *nftables.Table, name string) *dryRunCommand {
+	family := nftFamilyNames[byte(tb.Family)]
+	return &dryRunCommand{
+		text: fmt.Sprintf("%s %s %s %s %s", verb, kind, family, tb.Name, name),
+		json: jsonObject{verb: jsonObject{kind: jsonObject{"family": family, "table": tb.Name, "name": name}}},
+	}
+}
+
+func formatChainCommand(family string, attrs map[uint16][]byte) (*dryRunCommand, error) {
+	tableName := trimNftString(attrs[unix.NFTA_CHAIN_TABLE])
+	name := trimNftString(attrs[unix.NFTA_CHAIN_NAME])
+	chain := jsonObject{"family": family, "table": tableName, "name": name}
+	text := fmt.Sprintf("add chain %s %s %s", family, tableName, name)
+
+	hook, exists := attrs[unix.NFTA_CHAIN_HOOK]
+	if !exists {
+		return &dryRunCommand{text: text, json: jsonObject{"add": jsonObject{"chain": chain}}}, nil
+	}
+	ad, err := netlink.NewAttributeDecoder(hook)
+	if err != nil {
+		return nil, err
+	}
+	ad.ByteOrder = binary.BigEndian
+	var hookNum uint32
+	var priority int32
+	var device string
+	for ad.Next() {
+		switch ad.Type() {
+		case unix.NFTA_HOOK_HOOKNUM:
+			hookNum = ad.Uint32()
+		case unix.NFTA_HOOK_PRIORITY:
+			priority = int32(ad.Uint32())
+		case unix.NFTA_HOOK_DEV:
+			device = ad.String()
+		}
+	}
+	if err := ad.Err(); err != nil {
+		return nil, err
+	}
+
+	chainType := trimNftString(attrs[unix.NFTA_CHAIN_TYPE])
+	if chainType == "" {
+		chainType = string(nftables.ChainTypeFilter)
+	}
+	hookName := nftHookNames[hookNum]
+	if family == "netdev" {
+		hookName = nftNetdevHookNames[hookNum]
+	}
+	policy := "accept"
+	if b, exists := attrs[unix.NFTA_CHAIN_POLICY]; exists && binaryutil.BigEndian.Uint32(b) == uint32(nftables.ChainPolicyDrop) {
+		policy = "drop"
+	}
+
+	chain["type"], chain["hook"], chain["prio"], chain["policy"] = chainType, hookName, priority, policy
+	spec := fmt.Sprintf("type %s hook %s", chainType, hookName)
+	if device != "" {
+		chain["dev"] = device
+		spec += fmt.Sprintf(" device %q", device)
+	}
+	text += fmt.Sprintf(" { %s priority %d; policy %s; }", spec, priority, policy)
+	return &dryRunCommand{text: text, json: jsonObject{"add": jsonObject{"chain": chain}}}, nil
+}
+
+// formatRuleCommand returns a planned rule as a command. The position of
+// a rule placed relative to a planned rule is the index of the planned
+// rule in the chain, because a script is unable to refer to the rules it
+// adds by handle.
+func (p *dryRunPlan) formatRuleCommand(msg netlink.Message, planned []netlink.Message, deleted map[uint64]bool, tb *nftables.Table, attrs map[uint16][]byte) (*dryRunCommand, error) {
+	tb.Name = trimNftString(attrs[unix.NFTA_RULE_TABLE])
+	ch := &nftables.Chain{Name: trimNftString(attrs[unix.NFTA_RULE_CHAIN])}
+	rules, err := replayNftMessage(msg).GetRules(tb, ch)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("failed decoding planned rule of %s chain", ch.Name)
+	}
+	r := rules[0]
+	r.Exprs = addSkippedNftExprs(attrs[unix.NFTA_RULE_EXPRESSIONS], r.Exprs)
+
+	v := ""
+	switch tb.Family {
+	case nftables.TableFamilyIPv4:
+		v = "4"
+	case nftables.TableFamilyIPv6:
+		v = "6"
+	}
+	family := nftFamilyNames[byte(tb.Family)]
+	rule := jsonObject{"family": family, "table": tb.Name, "chain": ch.Name, "expr": formatRuleJSON(v, r.Exprs)}
+	if comment := DecodeRuleComment(r.UserData); comment != "" {
+		rule["comment"] = comment
+	}
+
+	verb := "insert"
+	position := ""
+	switch {
+	case msg.Header.Flags&netlink.Replace != 0:
+		verb = "replace"
+		rule["handle"] = r.Handle
+		position = fmt.Sprintf(" handle %d", r.Handle)
+	case msg.Header.Flags&unix.NLM_F_APPEND != 0:
+		verb = "add"
+	}
+	switch {
+	case verb == "replace" || r.Position == 0:
+	case isPlannedRuleHandle(r.Position):
+		index, err := p.ruleIndex(msg, planned, deleted, r.Position)
+		if err != nil {
+			return nil, err
+		}
+		rule["index"] = index
+		position = fmt.Sprintf(" index %d", index)
+	default:
+		rule["handle"] = r.Position
+		position = fmt.Sprintf(" handle %d", r.Position)
+	}
+	return &dryRunCommand{
+		text: fmt.Sprintf("%s rule %s %s %s%s %s", verb, family, tb.Name, ch.Name, position, FormatRule(v, r)),
+		json: jsonObject{verb: jsonObject{"rule": rule}},
+	}, nil
+}
+
+// ruleIndex returns the index of the rule having the provided handle in
+// the chain of a planned rule, as planned before the rule. The planned
+// rules left out of the script are not counted.
+func (p *dryRunPlan) ruleIndex(msg netlink.Message, planned []netlink.Message, deleted map[uint64]bool, handle uint64) (int, error) {
+	attrs := nftMessageAttrs(msg)
+	selector, err := netlink.MarshalAttributes([]netlink.Attribute{
+		{Type: unix.NFTA_RULE_TABLE, Data: attrs[unix.NFTA_RULE_TABLE]},
+		{Type: unix.NFTA_RULE_CHAIN, Data: attrs[unix.NFTA_RULE_CHAIN]},
+	})
+	if err != nil {
+		return 0, err
+	}
+	req := netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType((unix.NFNL_SUBSYS_NFTABLES << 8) | unix.NFT_MSG_GETRULE),
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: append([]byte{msg.Data[0], unix.NFNETLINK_V0, 0, 0}, selector...),
+	}
+	replies, err := p.baseline(req)
+	if err != nil {
+		return 0, err
+	}
+	index := 0
+	for _, reply := range overlayNftMessages(req, replies, planned) {
+		h, _ := nftRuleHandle(reply)
+		if h == handle {
+			return index, nil
+		}
+		if !deleted[h] {
+			index++
+		}
+	}
+	return 0, fmt.Errorf(
+		"failed finding planned rule handle %d in %s chain",
+		handle, trimNftString(attrs[unix.NFTA_RULE_CHAIN]),
+	)
+}
+
+// addSkippedNftExprs adds the expressions the parser of nftables package
+// skips, i.e. the fib, hash, numgen, and masquerade ones, to the parsed
+// expressions of a rule, in their places. It also replaces the ct
+// expressions setting a key, because the parser loses their register.
+func addSkippedNftExprs(b []byte, parsed []expr.Any) []expr.Any {
+	ad, err := netlink.NewAttributeDecoder(b)
+	if err != nil {
+		return parsed
+	}
+	exprs := []expr.Any{}
+	for ad.Next() {
+		var name string
+		var data []byte
+		ad.Nested(func(nad *netlink.AttributeDecoder) error {
+			for nad.Next() {
+				switch nad.Type() {
+				case unix.NFTA_EXPR_NAME:
+					name = nad.String()
+				case unix.NFTA_EXPR_DATA:
+					data = nad.Bytes()
+				}
+			}
+			return nil
+		})
+		if e := decodeSkippedNftExpr(name, data); e != nil {
+			exprs = append(exprs, e)
+			if name == "ct" && len(parsed) > 0 {
+				parsed = parsed[1:]
+			}
+			continue
+		}
+		if len(data) > 0 || name == "notrack" {
+			if len(parsed) == 0 {
+				return exprs
+			}
+			exprs = append(exprs, parsed[0])
+			parsed = parsed[1:]
+		}
+	}
+	return append(exprs, parsed...)
+}
+
+// decodeSkippedNftExpr returns the expression of the provided name and
+// attributes, when the parser of nftables package skips or misreads it,
+// or nil.
+func decodeSkippedNftExpr(name string, data []byte) expr.Any {
+	attrs := map[uint16]uint32{}
+	if ad, err := netlink.NewAttributeDecoder(data); err == nil {
+		ad.ByteOrder = binary.BigEndian
+		for ad.Next() {
+			attrs[ad.Type()] = ad.Uint32()
+		}
+	}
+	switch name {
+	case "ct":
+		sreg, exists := attrs[unix.NFTA_CT_SREG]
+		if !exists {
+			return nil
+		}
+		return &expr.Ct{
+			Key:            expr.CtKey(attrs[unix.NFTA_CT_KEY]),
+			Register:       sreg,
+			SourceRegister: true,
+		}
+	case "fib":
+		return &expr.Fib{
+			Register:       attrs[unix.NFTA_FIB_DREG],
+			ResultOIF:      attrs[unix.NFTA_FIB_RESULT] == unix.NFT_FIB_RESULT_OIF,
+			ResultOIFNAME:  attrs[unix.NFTA_FIB_RESULT] == unix.NFT_FIB_RESULT_OIFNAME,
+			ResultADDRTYPE: attrs[unix.NFTA_FIB_RESULT] == unix.NFT_FIB_RESULT_ADDRTYPE,
+			FlagSADDR:      attrs[unix.NFTA_FIB_FLAGS]&unix.NFTA_FIB_F_SADDR != 0,
+			FlagDADDR:      attrs[unix.NFTA_FIB_FLAGS]&unix.NFTA_FIB_F_DADDR != 0,
+			FlagMARK:       attrs[unix.NFTA_FIB_FLAGS]&unix.NFTA_FIB_F_MARK != 0,
+			FlagIIF:        attrs[unix.NFTA_FIB_FLAGS]&unix.NFTA_FIB_F_IIF != 0,
+			FlagOIF:        attrs[unix.NFTA_FIB_FLAGS]&unix.NFTA_FIB_F_OIF != 0,
+			FlagPRESENT:    attrs[unix.NFTA_FIB_FLAGS]&unix.NFTA_FIB_F_PRESENT != 0,
+		}
+	case "hash":
+		return &expr.Hash{
+			SourceRegister: attrs[unix.NFTA_HASH_SREG],
+			DestRegister:   attrs[unix.NFTA_HASH_DREG],
+			Length:         attrs[unix.NFTA_HASH_LEN],
+			Modulus:        attrs[unix.NFTA_HASH_MODULUS],
+			Seed:           attrs[unix.NFTA_HASH_SEED],
+			Offset:         attrs[unix.NFTA_HASH_OFFSET],
+			Type:           expr.HashType(attrs[unix.NFTA_HASH_TYPE]),
+		}
+	case "numgen":
+		return &expr.Numgen{
+			Register: attrs[unix.NFTA_NG_DREG],
+			Modulus:  attrs[unix.NFTA_NG_MODULUS],
+			Type:     attrs[unix.NFTA_NG_TYPE],
+			Offset:   attrs[unix.NFTA_NG_OFFSET],
+		}
+	case "masq":
+		_, toPorts := attrs[unix.NFTA_MASQ_REG_PROTO_MIN]
+		return &expr.Masq{
+			Random:      attrs[unix.NFTA_MASQ_FLAGS]&expr.NF_NAT_RANGE_PROTO_RANDOM != 0,
+			FullyRandom: attrs[unix.NFTA_MASQ_FLAGS]&expr.NF_NAT_RANGE_PROTO_RANDOM_FULLY != 0,
+			Persistent:  attrs[unix.NFTA_MASQ_FLAGS]&expr.NF_NAT_RANGE_PERSISTENT != 0,
+			ToPorts:     toPorts,
+			RegProtoMin: attrs[unix.NFTA_MASQ_REG_PROTO_MIN],
+			RegProtoMax: attrs[unix.NFTA_MASQ_REG_PROTO_MAX],
+		}
+	}
+	return nil
+}
+
+func formatSetCommand(s *nftables.Set) *dryRunCommand {
+	family := nftFamilyNames[byte(s.Table.Family)]
+	kind := "set"
+	set := jsonObject{"family": family, "table": s.Table.Name, "name": s.Name, "type": s.KeyType.Name}
+	spec := "type " + s.KeyType.Name
+	if s.IsMap {
+		kind = "map"
+		set["map"] = s.DataType.Name
+		spec += " : " + s.DataType.Name
+	}
+	flags := []string{}
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{
+		{s.Constant, "constant"},
+		{s.Interval, "interval"},
+		{s.HasTimeout, "timeout"},
+		{s.Dynamic, "dynamic"},
+	} {
+		if flag.set {
+			flags = append(flags, flag.name)
+		}
+	}
+	if len(flags) > 0 {
+		set["flags"] = flags
+		spec += "; flags " + strings.Join(flags, ",")
+	}
+	return &dryRunCommand{
+		text: fmt.Sprintf("add %s %s %s %s { %s; }", kind, family, s.Table.Name, s.Name, spec),
+		json: jsonObject{"add": jsonObject{kind: set}},
+	}
+}
+
+// formatSetElements returns the elements of a set as nft values and as
+// libnftables JSON values. The intervals are printed as prefixes, when
+// possible, or as ranges.
+func formatSetElements(s *nftables.Set, elements []nftables.SetElement) ([]string, []interface{}) {
+	texts := []string{}
+	values := []interface{}{}
+	for i, e := range elements {
+		if e.IntervalEnd {
+			continue
+		}
+		text, value := formatSetElementValue(s.KeyType.Name, e.Key)
+		if s.Interval {
+			var end []byte
+			if i+1 < len(elements) && elements[i+1].IntervalEnd {
+				end = elements[i+1].Key
+			}
+			text, value = formatSetElementInterval(s.KeyType.Name, e.Key, end)
+		}
+		if s.IsMap {
+			dataText, dataValue := formatSetElementValue(s.DataType.Name, e.Val)
+			text += " : " + dataText
+			value = []interface{}{value, dataValue}
+		}
+		texts = append(texts, text)
+		values = append(values, value)
+	}
+	return texts, values
+}
+
+func formatSetElementValue(typeName string, data []byte) (string, interface{}) {
+	switch typeName {
+	case nftables.TypeIPAddr.Name, nftables.TypeIP6Addr.Name:
+		if len(data) == net.IPv4len || len(data) == net.IPv6len {
+			s := net.IP(data).String()
+			return s, s
+		}
+	case nftables.TypeIFName.Name:
+		s := formatValue(valueKindInterface, data)
+		return s, jsonValue(valueKindInterface, data)
+	case nftables.TypeInteger.Name, nftables.TypeMark.Name:
+		if len(data) == 4 {
+			n := binaryutil.NativeEndian.Uint32(data)
+			return fmt.Sprintf("%d", n), n
+		}
+	case nftables.TypeInetService.Name:
+		if len(data) == 2 {
+			n := binaryutil.BigEndian.Uint16(data)
+			return fmt.Sprintf("%d", n), n
+		}
+	case nftables.TypeInetProto.Name:
+		s := formatValue(valueKindProtocol, data)
+		return s, jsonValue(valueKindProtocol, data)
+	}
+	s := "0x" + hex.EncodeToString(data)
+	return s, s
+}
+
+// formatSetElementInterval returns an interval of a set, i.e. the start
+// and the exclusive end of the interval, the end being nil for the
+// intervals ending at the highest value.
+func formatSetElementInterval(typeName string, start, end []byte) (string, interface{}) {
+	first := new(big.Int).SetBytes(start)
+	next := new(big.Int).Lsh(big.NewInt(1), uint(len(start)*8))
+	if end != nil {
+		next.SetBytes(end)
+	}
+	size := new(big.Int).Sub(next, first)
+	last := new(big.Int).Sub(next, big.NewInt(1)).FillBytes(make([]byte, len(start)))
+
+	isAddr := typeName == nftables.TypeIPAddr.Name || typeName == nftables.TypeIP6Addr.Name
+	bits := size.BitLen() - 1
+	if isAddr && size.Sign() > 0 && new(big.Int).Lsh(big.NewInt(1), uint(bits)).Cmp(size) == 0 &&
+		new(big.Int).Mod(first, size).Sign() == 0 {
+		addr := net.IP(start).String()
+		ones := len(start)*8 - bits
+		if ones == len(start)*8 {
+			return addr, addr
+		}
+		return fmt.Sprintf("%s/%d", addr, ones), jsonObject{"prefix": jsonObject{"addr": addr, "len": ones}}
+	}
+	startText, startValue := formatSetElementValue(typeName, start)
+	lastText, lastValue := formatSetElementValue(typeName, last)
+	return startText + "-" + lastText, jsonObject{"range": []interface{}{startValue, lastValue}}
+}
+
+func formatObjCommand(isAdd bool, tb *nftables.Table, attrs map[uint16][]byte) (*dryRunCommand, error) {
+	tb.Name = trimNftString(attrs[unix.NFTA_OBJ_TABLE])
+	name := trimNftString(attrs[unix.NFTA_OBJ_NAME])
+	var objType uint32
+	if b, exists := attrs[unix.NFTA_OBJ_TYPE]; exists {
+		objType = binaryutil.BigEndian.Uint32(b)
+	}
+	kind := ""
+	switch objType {
+	case nftObjectCounter:
+		kind = "counter"
+	case nftObjectQuota:
+		kind = "quota"
+	default:
+		return nil, fmt.Errorf("unsupported dry-run object type %d", objType)
+	}
+	if !isAdd {
+		return formatObjectCommand("delete", kind, tb, name), nil
+	}
+	c := formatObjectCommand("add", kind, tb, name)
+	if kind != "quota" {
+		return c, nil
+	}
+
+	ad, err := netlink.NewAttributeDecoder(attrs[unix.NFTA_OBJ_DATA])
+	if err != nil {
+		return nil, err
+	}
+	ad.ByteOrder = binary.BigEndian
+	var quotaBytes uint64
+	over := false
+	for ad.Next() {
+		switch ad.Type() {
+		case unix.NFTA_QUOTA_BYTES:
+			quotaBytes = ad.Uint64()
+		case unix.NFTA_QUOTA_FLAGS:
+			over = ad.Uint32()&unix.NFT_QUOTA_F_INV != 0
+		}
+	}
+	if err := ad.Err(); err != nil {
+		return nil, err
+	}
+	quota := c.json["add"].(jsonObject)["quota"].(jsonObject)
+	quota["bytes"], quota["inv"] = quotaBytes, over
+	spec := fmt.Sprintf("%d bytes", quotaBytes)
+	if over {
+		spec = "over " + spec
+	}
+	c.text += fmt.Sprintf(" { %s }", spec)
+	return c, nil
+}
+
+func formatFlowtableCommand(isAdd bool, ft *nftables.Flowtable) *dryRunCommand {
+	verb := nftCommandVerb(isAdd)
+	c := formatObjectCommand(verb, "flowtable", ft.Table, ft.Name)
+	flowtable := c.json[verb].(jsonObject)["flowtable"].(jsonObject)
+	devices := []string{}
+	for _, device := range ft.Devices {
+		devices = append(devices, fmt.Sprintf("%q", device))
+	}
+	if isAdd {
+		hook, priority := "ingress", int32(0)
+		if ft.Hooknum != nil {
+			hook = nftNetdevHookNames[uint32(*ft.Hooknum)]
+		}
+		if ft.Priority != nil {
+			priority = int32(*ft.Priority)
+		}
+		flowtable["hook"], flowtable["prio"], flowtable["dev"] = hook, priority, ft.Devices
+		c.text += fmt.Sprintf(" { hook %s priority %d; devices = { %s }; }", hook, priority, strings.Join(devices, ", "))
+		return c
+	}
+	if len(ft.Devices) > 0 {
+		flowtable["dev"] = ft.Devices
+		c.text += fmt.Sprintf(" { devices = { %s }; }", strings.Join(devices, ", "))
+	}
+	return c
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+// getDryRunCommands returns the nft commands planned by the provided
+// function, as if the host had no ruleset.
+func getDryRunCommands(t *testing.T, fn func() error) []string {
+	t.Helper()
+	output := filepath.Join(t.TempDir(), "plan.nft")
+	c := &DryRunConfig{Output: output, Format: "nft", Baseline: "empty"}
+	if err := DryRun(c, "test", fn); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	b, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("failed reading dry-run output: %s", err)
+	}
+	commands := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			commands = append(commands, line)
+		}
+	}
+	return commands
+}
+
+func TestDryRunPlannedRuleHandles(t *testing.T) {
+	var comments []string
+	got := getDryRunCommands(t, func() error {
+		if err := CreateTable("4", "filter"); err != nil {
+			return err
+		}
+		if err := CreateFilterForwardChain("4", "filter", "forward", nil); err != nil {
+			return err
+		}
+		chainProps, err := GetChainProps("4", "filter", "forward")
+		if err != nil {
+			return err
+		}
+		conn, err := initNftConn()
+		if err != nil {
+			return err
+		}
+		tb := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+		ch := &nftables.Chain{Name: "forward", Table: tb}
+		for _, comment := range []string{"first", "deleted", "second"} {
+			placeRule(conn, &nftables.Rule{
+				Table:    tb,
+				Chain:    ch,
+				UserData: EncodeRuleComment(comment),
+				Exprs:    []expr.Any{&expr.Counter{}, &expr.Verdict{Kind: expr.VerdictAccept}},
+			}, chainProps.Rules, PlaceBeforeDeny)
+		}
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+
+		rules, err := GetRulesByComment("4", "filter", "forward", "deleted")
+		if err != nil {
+			return err
+		}
+		if len(rules) != 1 {
+			return fmt.Errorf("found %d planned rules, want 1", len(rules))
+		}
+		if err := conn.DelRule(rules[0]); err != nil {
+			return err
+		}
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+
+		chainProps, err = GetChainProps("4", "filter", "forward")
+		if err != nil {
+			return err
+		}
+		for _, r := range chainProps.Rules {
+			comments = append(comments, DecodeRuleComment(r.UserData))
+		}
+		return nil
+	})
+
+	wantComments := []string{"first", "second", denyRuleComment, denyRuleComment}
+	if !reflect.DeepEqual(comments, wantComments) {
+		t.Fatalf("unexpected planned chain\ngot:\n%#v\nwant:\n%#v", comments, wantComments)
+	}
+	want := []string{
+		"add table ip filter",
+		"add chain ip filter forward { type filter hook forward priority 0; policy drop; }",
+		"add rule ip filter forward log prefix \"ip4 forward drop: \" comment \"cni-deny\"",
+		"add rule ip filter forward counter packets 0 bytes 0 drop comment \"cni-deny\"",
+		"insert rule ip filter forward index 0 counter packets 0 bytes 0 accept comment \"first\"",
+		"insert rule ip filter forward index 1 counter packets 0 bytes 0 accept comment \"second\"",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected commands\ngot:\n%#v\nwant:\n%#v", got, want)
+	}
+}
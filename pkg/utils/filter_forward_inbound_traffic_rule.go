@@ -7,7 +7,7 @@ import (
 	"github.com/google/nftables/expr"
 )
 
-func addFilterForwardInboundTrafficRule(v, tableName, chainName string, addr *current.IPConfig, intfName string) error {
+func addFilterForwardInboundTrafficRule(v, tableName, chainName string, addr *current.IPConfig, intfName string, counters *ContainerCounters) error {
 	conn, err := initNftConn()
 	if err != nil {
 		return err
@@ -97,7 +97,7 @@ func addFilterForwardInboundTrafficRule(v, tableName, chainName string, addr *cu
 	})
 
 	// counter pkts 0 bytes 0
-	r.Exprs = append(r.Exprs, &expr.Counter{})
+	r.Exprs = append(r.Exprs, verdictCounterExpr(counters, expr.VerdictAccept))
 	// immediate reg 0 accept
 	r.Exprs = append(r.Exprs, &expr.Verdict{
 		Kind: expr.VerdictAccept,
@@ -106,8 +106,8 @@ func addFilterForwardInboundTrafficRule(v, tableName, chainName string, addr *cu
 	conn.AddRule(r)
 	if err := conn.Flush(); err != nil {
 		return fmt.Errorf(
-			"failed adding inbound traffic filtering rule in chain %s of ipv%s %s table for %v: %s",
-			chainName, v, tableName, addr, err,
+			"failed adding inbound traffic filtering rule in chain %s of ipv%s %s table for %v: %s, rule: %s",
+			chainName, v, tableName, addr, err, FormatRuleExprs(v, r.Exprs),
 		)
 	}
 	return nil
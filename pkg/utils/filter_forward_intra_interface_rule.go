@@ -2,12 +2,15 @@ package utils
 
 import (
 	"fmt"
+	"net"
+
 	current "github.com/containernetworking/cni/pkg/types/040"
 	"github.com/google/nftables"
 	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
 )
 
-func addFilterForwardIntraInterfaceRule(v, tableName, chainName string, addr *current.IPConfig, intfName string) error {
+func addFilterForwardIntraInterfaceRule(v, tableName, chainName string, addr *current.IPConfig, intfName string, counters *ContainerCounters) error {
 	conn, err := initNftConn()
 	if err != nil {
 		return err
@@ -27,49 +30,65 @@ func addFilterForwardIntraInterfaceRule(v, tableName, chainName string, addr *cu
 		Table: tb,
 	}
 
-	// nft add rule iifname "dummy0" oifname "dummy0" counter packets 0 bytes 0 accept
-	r := &nftables.Rule{
-		Table: tb,
-		Chain: ch,
-		Exprs: []expr.Any{},
+	// nft add rule iifname "dummy0" oifname "dummy0" ip daddr 192.168.100.5 counter packets 0 bytes 0 accept
+	// nft add rule iifname "dummy0" oifname "dummy0" meta pkttype != host counter packets 0 bytes 0 accept
+	// nft add rule iifname "dummy0" oifname "dummy0" ip6 daddr fe80::/10 counter packets 0 bytes 0 accept
+	//
+	// The unicast traffic between the containers is accepted only by the
+	// chain of the destination container, after its ingress policy, if
+	// any, because the chains of the other containers return it.
+	match := []expr.Any{
+		// meta load iifname => reg 1
+		// cmp eq reg 1 0x6d6d7564 0x00003079 0x00000000 0x00000000
+		&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(intfName)},
+		// meta load oifname => reg 1
+		// cmp eq reg 1 0x6d6d7564 0x00003079 0x00000000 0x00000000
+		&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(intfName)},
 	}
 
-	// meta load iifname => reg 1
-	// cmp eq reg 1 0x6d6d7564 0x00003079 0x00000000 0x00000000
-	r.Exprs = append(r.Exprs, &expr.Meta{
-		Key:      expr.MetaKeyIIFNAME,
-		Register: 1,
-	})
-	r.Exprs = append(r.Exprs, &expr.Cmp{
-		Op:       expr.CmpOpEq,
-		Register: 1,
-		Data:     EncodeInterfaceName(intfName),
-	})
-
-	// meta load oifname => reg 2
-	// cmp eq reg 2 0x6d6d7564 0x00003079 0x00000000 0x00000000
-	r.Exprs = append(r.Exprs, &expr.Meta{
-		Key:      expr.MetaKeyOIFNAME,
-		Register: 1,
-	})
-	r.Exprs = append(r.Exprs, &expr.Cmp{
-		Op:       expr.CmpOpEq,
-		Register: 1,
-		Data:     EncodeInterfaceName(intfName),
-	})
+	host := &net.IPNet{IP: addr.Address.IP.To4(), Mask: net.CIDRMask(32, 32)}
+	if v == "6" {
+		host = &net.IPNet{IP: addr.Address.IP.To16(), Mask: net.CIDRMask(128, 128)}
+	}
+	matches := [][]expr.Any{
+		IPDaddrPrefixMatch(v, host),
+		{
+			// meta load pkttype => reg 1
+			// cmp neq reg 1 0x00000000
+			&expr.Meta{Key: expr.MetaKeyPKTTYPE, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: []byte{unix.PACKET_HOST}},
+		},
+	}
+	if v == "6" {
+		matches = append(matches, IPDaddrPrefixMatch(v, &net.IPNet{
+			IP:   net.ParseIP("fe80::"),
+			Mask: net.CIDRMask(10, 128),
+		}))
+	}
 
-	// counter pkts 0 bytes 0
-	r.Exprs = append(r.Exprs, &expr.Counter{})
-	// immediate reg 0 accept
-	r.Exprs = append(r.Exprs, &expr.Verdict{
-		Kind: expr.VerdictAccept,
-	})
+	rules := []*nftables.Rule{}
+	for _, m := range matches {
+		r := &nftables.Rule{
+			Table: tb,
+			Chain: ch,
+			Exprs: append(append([]expr.Any{}, match...), m...),
+		}
+		// counter pkts 0 bytes 0
+		r.Exprs = append(r.Exprs, verdictCounterExpr(counters, expr.VerdictAccept))
+		// immediate reg 0 accept
+		r.Exprs = append(r.Exprs, &expr.Verdict{
+			Kind: expr.VerdictAccept,
+		})
+		conn.AddRule(r)
+		rules = append(rules, r)
+	}
 
-	conn.AddRule(r)
 	if err := conn.Flush(); err != nil {
 		return fmt.Errorf(
-			"failed adding intra interface filtering rule in chain %s of ipv%s %s table for %v: %s",
-			chainName, v, tableName, addr, err,
+			"failed adding intra interface filtering rules in chain %s of ipv%s %s table for %v: %s, rules: %s",
+			chainName, v, tableName, addr, err, formatRulesExprs(v, rules),
 		)
 	}
 	return nil
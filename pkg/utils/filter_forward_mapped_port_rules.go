@@ -4,9 +4,6 @@ import (
 	"fmt"
 	"github.com/google/nftables"
 	"github.com/google/nftables/expr"
-
-	"github.com/google/nftables/binaryutil"
-	"golang.org/x/sys/unix"
 	"net"
 	"reflect"
 )
@@ -48,99 +45,62 @@ func AddFilterForwardMappedPortRules(opts map[string]interface{}) error {
 		return err
 	}
 
-	r := &nftables.Rule{
-		Table: tb,
-		Chain: ch,
-		Exprs: []expr.Any{},
-	}
-
-	if chain.RuleCount > 0 {
-		r.Position = chain.Positions[0]
+	srcMatches, err := MappingSourceMatches(v, pm)
+	if err != nil {
+		return err
 	}
 
-	r.Exprs = append(r.Exprs, &expr.Meta{
-		Key:      expr.MetaKeyOIFNAME,
-		Register: 1,
-	})
-	r.Exprs = append(r.Exprs, &expr.Cmp{
-		Op:       expr.CmpOpEq,
-		Register: 1,
-		Data:     EncodeInterfaceName(bridgeIntfName),
-	})
-
-	if v == "4" {
-		r.Exprs = append(r.Exprs, &expr.Payload{
-			DestRegister: 1,
-			Base:         expr.PayloadBaseNetworkHeader,
-			Offset:       16,
-			Len:          4,
-		})
-		r.Exprs = append(r.Exprs, &expr.Cmp{
-			Op:       expr.CmpOpEq,
+	// match container interface and address
+	dstMatch := []expr.Any{
+		&expr.Meta{
+			Key:      expr.MetaKeyOIFNAME,
 			Register: 1,
-			Data:     addr.IP.To4(),
-		})
-	} else {
-		r.Exprs = append(r.Exprs, &expr.Payload{
-			DestRegister: 1,
-			Base:         expr.PayloadBaseNetworkHeader,
-			Offset:       24,
-			Len:          16,
-		})
-		r.Exprs = append(r.Exprs, &expr.Cmp{
+		},
+		&expr.Cmp{
 			Op:       expr.CmpOpEq,
 			Register: 1,
-			Data:     addr.IP.To16(),
-		})
+			Data:     EncodeInterfaceName(bridgeIntfName),
+		},
 	}
+	dstMatch = append(dstMatch, IPDaddrMatch(v, addr.IP)...)
 
-	r.Exprs = append(r.Exprs, &expr.Meta{
-		Key:      expr.MetaKeyL4PROTO,
-		Register: 1,
-	})
+	portMatch, err := mappingPortMatch(pm.Protocol, pm.ContainerPort)
+	if err != nil {
+		return err
+	}
 
-	switch pm.Protocol {
-	case "tcp":
-		r.Exprs = append(r.Exprs, &expr.Cmp{
-			Op:       expr.CmpOpEq,
-			Register: 1,
-			Data:     []byte{unix.IPPROTO_TCP},
+	// drop traffic over the limits of the port, if any, whatever the
+	// source of the traffic is
+	rules := []*nftables.Rule{}
+	for _, limitExprs := range MappingLimitDropRules(pm.Limit, "filter") {
+		rules = append(rules, &nftables.Rule{
+			Table: tb,
+			Chain: ch,
+			Exprs: concatExprs(dstMatch, portMatch, limitExprs),
 		})
-	case "udp":
-		r.Exprs = append(r.Exprs, &expr.Cmp{
-			Op:       expr.CmpOpEq,
-			Register: 1,
-			Data:     []byte{unix.IPPROTO_UDP},
+	}
+
+	for _, srcMatch := range srcMatches {
+		// match source address, if restricted
+		r := &nftables.Rule{
+			Table: tb,
+			Chain: ch,
+			Exprs: concatExprs(dstMatch, srcMatch, portMatch),
+		}
+
+		r.Exprs = append(r.Exprs, &expr.Counter{})
+
+		r.Exprs = append(r.Exprs, &expr.Verdict{
+			Kind: expr.VerdictAccept,
 		})
-	default:
-		return fmt.Errorf("unsupported protocol: %s", pm.Protocol)
+
+		rules = append(rules, r)
 	}
 
-	// [ payload load 2b @ transport header + 2 => reg 1 ]
-	r.Exprs = append(r.Exprs, &expr.Payload{
-		DestRegister: 1,
-		Base:         expr.PayloadBaseTransportHeader,
-		Offset:       2, // TODO
-		Len:          2, // TODO
-	})
-
-	// [ cmp eq reg 1 0x0000e60f ]
-	r.Exprs = append(r.Exprs, &expr.Cmp{
-		Op:       expr.CmpOpEq,
-		Register: 1,
-		Data:     binaryutil.BigEndian.PutUint16(uint16(pm.ContainerPort)),
-	})
-
-	r.Exprs = append(r.Exprs, &expr.Counter{})
-
-	r.Exprs = append(r.Exprs, &expr.Verdict{
-		Kind: expr.VerdictAccept,
-	})
-
-	if chain.RuleCount == 0 {
-		conn.AddRule(r)
-	} else {
-		conn.InsertRule(r)
+	// The rules are placed at the top of the chain, i.e. before the jump
+	// rules to the chains of the containers. Hence, the reverse order.
+	for i := len(rules) - 1; i >= 0; i-- {
+		placeRule(conn, rules[i], chain.Rules, PlaceAtHead)
 	}
 
 	if err := conn.Flush(); err != nil {
@@ -7,7 +7,7 @@ import (
 	"github.com/google/nftables/expr"
 )
 
-func addFilterForwardOutboundTrafficRule(v, tableName, chainName string, addr *current.IPConfig, intfName string) error {
+func addFilterForwardOutboundTrafficRule(v, tableName, chainName string, addr *current.IPConfig, intfName string, counters *ContainerCounters) error {
 	conn, err := initNftConn()
 	if err != nil {
 		return err
@@ -72,16 +72,33 @@ func addFilterForwardOutboundTrafficRule(v, tableName, chainName string, addr *c
 		})
 	}
 
-	r.Exprs = append(r.Exprs, &expr.Counter{})
+	// The traffic leaving via the bridge, i.e. destined to another
+	// container, returns from the chain, so that the chain of the other
+	// container accepts it, or applies its ingress policy.
+	// nft add rule iifname "dummy0" ip saddr 192.168.100.5 oifname "dummy0" counter return
+	rr := &nftables.Rule{
+		Table: tb,
+		Chain: ch,
+		Exprs: append([]expr.Any{}, r.Exprs...),
+	}
+	rr.Exprs = append(rr.Exprs,
+		&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(intfName)},
+		&expr.Counter{},
+		&expr.Verdict{Kind: expr.VerdictReturn},
+	)
+
+	r.Exprs = append(r.Exprs, verdictCounterExpr(counters, expr.VerdictAccept))
 	r.Exprs = append(r.Exprs, &expr.Verdict{
 		Kind: expr.VerdictAccept,
 	})
 
+	conn.AddRule(rr)
 	conn.AddRule(r)
 	if err := conn.Flush(); err != nil {
 		return fmt.Errorf(
-			"failed adding outbound traffic filtering rule in chain %s of ipv%s %s table for %v: %s",
-			chainName, v, tableName, addr, err,
+			"failed adding outbound traffic filtering rules in chain %s of ipv%s %s table for %v: %s, rules: %s",
+			chainName, v, tableName, addr, err, formatRulesExprs(v, []*nftables.Rule{rr, r}),
 		)
 	}
 	return nil
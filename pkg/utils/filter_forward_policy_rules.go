@@ -0,0 +1,265 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+
+	current "github.com/containernetworking/cni/pkg/types/040"
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// getPolicyVerdict returns the exprs of the action of a policy rule.
+func getPolicyVerdict(v, action string, counters *ContainerCounters) []expr.Any {
+	switch action {
+	case "accept":
+		return []expr.Any{verdictCounterExpr(counters, expr.VerdictAccept), &expr.Verdict{Kind: expr.VerdictAccept}}
+	case "reject":
+		// reject with icmp type port-unreachable
+		code := uint8(3)
+		if v == "6" {
+			code = 4
+		}
+		return []expr.Any{verdictCounterExpr(counters, expr.VerdictDrop), &expr.Reject{Type: unix.NFT_REJECT_ICMP_UNREACH, Code: code}}
+	}
+	return []expr.Any{verdictCounterExpr(counters, expr.VerdictDrop), &expr.Verdict{Kind: expr.VerdictDrop}}
+}
+
+// getPolicyRuleMatches returns the matches of a policy rule, following the
+// matches of a direction. It returns no matches when the rule does not
+// apply to the IP version.
+func getPolicyRuleMatches(v, direction string, r *FirewallPolicyRule) [][]expr.Any {
+	var l4Matches [][]expr.Any
+
+	switch r.Protocol {
+	case "", "any":
+		l4Matches = [][]expr.Any{{}}
+	case "icmp", "icmpv6":
+		if (r.Protocol == "icmp") != (v == "4") {
+			return nil
+		}
+		proto := byte(unix.IPPROTO_ICMP)
+		if v == "6" {
+			proto = unix.IPPROTO_ICMPV6
+		}
+		m := []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{proto}},
+		}
+		if r.ICMPType != nil {
+			// [ payload load 1b @ transport header + 0 => reg 1 ]
+			m = append(m,
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 0, Len: 1},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{byte(*r.ICMPType)}},
+			)
+		}
+		l4Matches = [][]expr.Any{m}
+	default:
+		proto := byte(unix.IPPROTO_TCP)
+		if r.Protocol == "udp" {
+			proto = unix.IPPROTO_UDP
+		}
+		protoMatch := []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{proto}},
+		}
+		if len(r.Ports) == 0 {
+			l4Matches = [][]expr.Any{protoMatch}
+			break
+		}
+		for _, port := range r.Ports {
+			start, end, err := parsePolicyPort(port)
+			if err != nil {
+				continue
+			}
+			m := append([]expr.Any{}, protoMatch...)
+			// [ payload load 2b @ transport header + 2 => reg 1 ]
+			m = append(m, &expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2})
+			if start == end {
+				m = append(m, &expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.BigEndian.PutUint16(start)})
+			} else {
+				m = append(m,
+					&expr.Cmp{Op: expr.CmpOpGte, Register: 1, Data: binaryutil.BigEndian.PutUint16(start)},
+					&expr.Cmp{Op: expr.CmpOpLte, Register: 1, Data: binaryutil.BigEndian.PutUint16(end)},
+				)
+			}
+			l4Matches = append(l4Matches, m)
+		}
+	}
+
+	matches := [][]expr.Any{}
+	for _, prefix := range getPolicyRuleCIDRs(v, r) {
+		var remoteMatch []expr.Any
+		if prefix != nil {
+			if direction == "ingress" {
+				remoteMatch = IPSaddrPrefixMatch(v, prefix)
+			} else {
+				remoteMatch = IPDaddrPrefixMatch(v, prefix)
+			}
+		}
+		for _, l4Match := range l4Matches {
+			m := append([]expr.Any{}, remoteMatch...)
+			m = append(m, l4Match...)
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
+// getPolicyDirectionMatch returns the matches of the traffic to a container,
+// i.e. ingress, or from a container, i.e. egress.
+func getPolicyDirectionMatch(v, direction string, ip net.IP, intfName string) []expr.Any {
+	host := &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+	if v == "4" {
+		host = &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}
+	}
+	if direction == "ingress" {
+		m := []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(intfName)},
+		}
+		return append(m, IPDaddrPrefixMatch(v, host)...)
+	}
+	m := []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(intfName)},
+	}
+	return append(m, IPSaddrPrefixMatch(v, host)...)
+}
+
+// getPolicyActionRules returns the rules performing the action of a policy
+// rule. An accepted egress packet leaving via the bridge, i.e. destined to
+// another container, returns from the chain of the container, so that the
+// ingress policy of the other container applies to it.
+func getPolicyActionRules(v, direction, action, intfName string, match []expr.Any, counters *ContainerCounters) [][]expr.Any {
+	rules := [][]expr.Any{}
+	if direction == "egress" && action == "accept" {
+		m := append([]expr.Any{}, match...)
+		m = append(m,
+			&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(intfName)},
+			&expr.Counter{},
+			&expr.Verdict{Kind: expr.VerdictReturn},
+		)
+		rules = append(rules, m)
+	}
+	m := append([]expr.Any{}, match...)
+	m = append(m, getPolicyVerdict(v, action, counters)...)
+	return append(rules, m)
+}
+
+// getFilterForwardPolicyRules returns the exprs of the rules of the
+// directions of a firewall policy, i.e. the rules of the policy and the
+// rule of the default action of each direction present in the policy.
+func getFilterForwardPolicyRules(v string, ip net.IP, intfName string, policy *FirewallPolicy, counters *ContainerCounters) [][]expr.Any {
+	rules := [][]expr.Any{}
+	for _, direction := range []string{"ingress", "egress"} {
+		d := policy.Ingress
+		if direction == "egress" {
+			d = policy.Egress
+		}
+		if d == nil {
+			continue
+		}
+
+		match := getPolicyDirectionMatch(v, direction, ip, intfName)
+
+		if direction == "egress" {
+			// ct state established,related
+			m := append([]expr.Any{}, match...)
+			m = append(m, &expr.Ct{Register: 1, Key: expr.CtKeySTATE},
+				&expr.Bitwise{
+					SourceRegister: 1,
+					DestRegister:   1,
+					Len:            4,
+					Mask:           []byte("\x06\x00\x00\x00"),
+					Xor:            []byte{0x0, 0x0, 0x0, 0x0},
+				},
+				&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: []byte{0x0, 0x0, 0x0, 0x0}},
+				verdictCounterExpr(counters, expr.VerdictAccept),
+				&expr.Verdict{Kind: expr.VerdictAccept},
+			)
+			rules = append(rules, m)
+		}
+
+		for _, r := range d.Rules {
+			for _, ruleMatch := range getPolicyRuleMatches(v, direction, r) {
+				m := append(append([]expr.Any{}, match...), ruleMatch...)
+				rules = append(rules, getPolicyActionRules(v, direction, r.Action, intfName, m, counters)...)
+			}
+		}
+
+		rules = append(rules, getPolicyActionRules(v, direction, d.getDefaultAction(), intfName, match, counters)...)
+	}
+	return rules
+}
+
+// AddFilterForwardPolicyRules adds the rules of a firewall policy in
+// the forwarding chain of a container. The rules of a policy with
+// ingress and egress directions look like:
+//
+//	oifname "<intfName>" ip daddr <addr> ct state established,related counter accept
+//	oifname "<intfName>" ip daddr <addr> ip saddr 10.0.0.0/8 tcp dport 80 counter accept
+//	oifname "<intfName>" ip daddr <addr> counter drop
+//	iifname "<intfName>" ip saddr <addr> ct state established,related counter accept
+//	iifname "<intfName>" ip saddr <addr> udp dport 53 oifname "<intfName>" counter return
+//	iifname "<intfName>" ip saddr <addr> udp dport 53 counter accept
+//	iifname "<intfName>" ip saddr <addr> counter drop
+//	iifname "<intfName>" oifname "<intfName>" ip daddr <addr> counter accept
+//	iifname "<intfName>" oifname "<intfName>" meta pkttype != host counter accept
+//
+// When a direction is not in the policy, the traffic in the direction
+// is handled by the rules of AddFilterForwardRules. The rules count the
+// accepted and the dropped traffic in the named counters, if any.
+func AddFilterForwardPolicyRules(v, tableName, chainName string, addr *current.IPConfig, intfName string, policy *FirewallPolicy, counters *ContainerCounters) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	if err := addFilterForwardInboundTrafficRule(v, tableName, chainName, addr, intfName, counters); err != nil {
+		return err
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+
+	tb := &nftables.Table{
+		Name: tableName,
+	}
+	if v == "4" {
+		tb.Family = nftables.TableFamilyIPv4
+	} else {
+		tb.Family = nftables.TableFamilyIPv6
+	}
+
+	ch := &nftables.Chain{
+		Name:  chainName,
+		Table: tb,
+	}
+
+	for _, exprs := range getFilterForwardPolicyRules(v, addr.Address.IP, intfName, policy, counters) {
+		conn.AddRule(&nftables.Rule{Table: tb, Chain: ch, Exprs: exprs})
+	}
+
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed adding policy filtering rules in chain %s of ipv%s %s table for %v: %s",
+			chainName, v, tableName, addr, err,
+		)
+	}
+
+	if policy.Egress == nil {
+		if err := addFilterForwardOutboundTrafficRule(v, tableName, chainName, addr, intfName, counters); err != nil {
+			return err
+		}
+	}
+
+	if err := addFilterForwardIntraInterfaceRule(v, tableName, chainName, addr, intfName, counters); err != nil {
+		return err
+	}
+	return nil
+}
@@ -5,17 +5,20 @@ import (
 )
 
 // AddFilterForwardRules adds a set of rules in forwarding chain of filter table.
-func AddFilterForwardRules(v, tableName, chainName string, addr *current.IPConfig, intfName string) error {
+// The traffic to the other containers of the bridge returns from the chain,
+// so that the chain of the destination container, and its ingress policy,
+// decides. The rules count the accepted traffic in the named counters, if any.
+func AddFilterForwardRules(v, tableName, chainName string, addr *current.IPConfig, intfName string, counters *ContainerCounters) error {
 	if err := isSupportedIPVersion(v); err != nil {
 		return err
 	}
-	if err := addFilterForwardInboundTrafficRule(v, tableName, chainName, addr, intfName); err != nil {
+	if err := addFilterForwardInboundTrafficRule(v, tableName, chainName, addr, intfName, counters); err != nil {
 		return err
 	}
-	if err := addFilterForwardOutboundTrafficRule(v, tableName, chainName, addr, intfName); err != nil {
+	if err := addFilterForwardOutboundTrafficRule(v, tableName, chainName, addr, intfName, counters); err != nil {
 		return err
 	}
-	if err := addFilterForwardIntraInterfaceRule(v, tableName, chainName, addr, intfName); err != nil {
+	if err := addFilterForwardIntraInterfaceRule(v, tableName, chainName, addr, intfName, counters); err != nil {
 		return err
 	}
 	return nil
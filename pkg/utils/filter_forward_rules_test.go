@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddFilterForwardRulesIntraBridge(t *testing.T) {
+	// The sender has no policy, and the receiver has an ingress policy.
+	// The chain of the sender returns the traffic to the receiver, so
+	// that the policy of the receiver applies regardless of the order
+	// of the chains.
+	sender, receiver := getTestIPConfig("10.88.0.5/16"), getTestIPConfig("10.88.0.6/16")
+	policy := &FirewallPolicy{
+		Ingress: &FirewallPolicyDirection{
+			DefaultAction: "drop",
+			Rules: []*FirewallPolicyRule{
+				{Protocol: "tcp", Ports: []string{"80"}, Action: "accept"},
+			},
+		},
+	}
+	got := getDryRunCommands(t, func() error {
+		if err := CreateTable("4", "filter"); err != nil {
+			return err
+		}
+		for _, chainName := range []string{"cni-ffw-sender", "cni-ffw-receiver"} {
+			if err := CreateChain("4", "filter", chainName, "none", "none", "none"); err != nil {
+				return err
+			}
+		}
+		if err := AddFilterForwardRules("4", "filter", "cni-ffw-sender", sender, "cni-podman0", nil); err != nil {
+			return err
+		}
+		return AddFilterForwardPolicyRules("4", "filter", "cni-ffw-receiver", receiver, "cni-podman0", policy, nil)
+	})
+	want := []string{
+		"add table ip filter",
+		"add chain ip filter cni-ffw-sender",
+		"add chain ip filter cni-ffw-receiver",
+		"add rule ip filter cni-ffw-sender oifname \"cni-podman0\" ip daddr 10.88.0.5 ct state established,related counter packets 0 bytes 0 accept",
+		"add rule ip filter cni-ffw-sender iifname \"cni-podman0\" ip saddr 10.88.0.5 oifname \"cni-podman0\" counter packets 0 bytes 0 return",
+		"add rule ip filter cni-ffw-sender iifname \"cni-podman0\" ip saddr 10.88.0.5 counter packets 0 bytes 0 accept",
+		"add rule ip filter cni-ffw-sender iifname \"cni-podman0\" oifname \"cni-podman0\" ip daddr 10.88.0.5 counter packets 0 bytes 0 accept",
+		"add rule ip filter cni-ffw-sender iifname \"cni-podman0\" oifname \"cni-podman0\" meta pkttype != host counter packets 0 bytes 0 accept",
+		"add rule ip filter cni-ffw-receiver oifname \"cni-podman0\" ip daddr 10.88.0.6 ct state established,related counter packets 0 bytes 0 accept",
+		"add rule ip filter cni-ffw-receiver oifname \"cni-podman0\" ip daddr 10.88.0.6 meta l4proto tcp tcp dport 80 counter packets 0 bytes 0 accept",
+		"add rule ip filter cni-ffw-receiver oifname \"cni-podman0\" ip daddr 10.88.0.6 counter packets 0 bytes 0 drop",
+		"add rule ip filter cni-ffw-receiver iifname \"cni-podman0\" ip saddr 10.88.0.6 oifname \"cni-podman0\" counter packets 0 bytes 0 return",
+		"add rule ip filter cni-ffw-receiver iifname \"cni-podman0\" ip saddr 10.88.0.6 counter packets 0 bytes 0 accept",
+		"add rule ip filter cni-ffw-receiver iifname \"cni-podman0\" oifname \"cni-podman0\" ip daddr 10.88.0.6 counter packets 0 bytes 0 accept",
+		"add rule ip filter cni-ffw-receiver iifname \"cni-podman0\" oifname \"cni-podman0\" meta pkttype != host counter packets 0 bytes 0 accept",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected rules\ngot:\n%#v\nwant:\n%#v", got, want)
+	}
+}
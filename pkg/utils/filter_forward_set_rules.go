@@ -0,0 +1,253 @@
+package utils
+
+import (
+	"fmt"
+
+	current "github.com/containernetworking/cni/pkg/types/040"
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+// getFilterForwardSetRulesComment returns the comment tagging the
+// network-wide rules referencing a particular set.
+func getFilterForwardSetRulesComment(setName string) string {
+	return "cni-ffs " + setName
+}
+
+// AddFilterForwardSetRules adds the address of a container to the
+// set of a network and, when missing, the network-wide rules in
+// forwarding chain of filter table referencing the set. The rules
+// look like:
+//
+//	oifname "<intfName>" ip daddr @<setName> ct state established,related counter accept
+//	iifname "<intfName>" ip saddr @<setName> counter accept
+//	iifname "<intfName>" oifname "<intfName>" counter accept
+func AddFilterForwardSetRules(v, tableName, chainName, setName string, addr *current.IPConfig, intfName string) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	exists, err := IsSetExists(v, tableName, setName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := CreateAddrSet(v, tableName, setName); err != nil {
+			return err
+		}
+	}
+
+	if err := AddSetAddress(v, tableName, setName, addr.Address.IP); err != nil {
+		return err
+	}
+
+	comment := getFilterForwardSetRulesComment(setName)
+	rules, err := GetRulesByComment(v, tableName, chainName, comment)
+	if err != nil {
+		return err
+	}
+	if len(rules) > 0 {
+		return nil
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+
+	tb := &nftables.Table{
+		Name: tableName,
+	}
+	var daddrOffset, saddrOffset, addrLen uint32
+	if v == "4" {
+		tb.Family = nftables.TableFamilyIPv4
+		daddrOffset, saddrOffset, addrLen = 16, 12, 4
+	} else {
+		tb.Family = nftables.TableFamilyIPv6
+		daddrOffset, saddrOffset, addrLen = 24, 8, 16
+	}
+
+	ch := &nftables.Chain{
+		Name:  chainName,
+		Table: tb,
+	}
+
+	inboundRule := &nftables.Rule{
+		Table:    tb,
+		Chain:    ch,
+		UserData: EncodeRuleComment(comment),
+		Exprs: []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(intfName)},
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: daddrOffset, Len: addrLen},
+			&expr.Lookup{SourceRegister: 1, SetName: setName},
+			// ct state established,related
+			&expr.Ct{Register: 1, Key: expr.CtKeySTATE},
+			&expr.Bitwise{
+				SourceRegister: 1,
+				DestRegister:   1,
+				Len:            4,
+				Mask:           []byte("\x06\x00\x00\x00"),
+				Xor:            []byte{0x0, 0x0, 0x0, 0x0},
+			},
+			&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: []byte{0x0, 0x0, 0x0, 0x0}},
+			&expr.Counter{},
+			&expr.Verdict{Kind: expr.VerdictAccept},
+		},
+	}
+
+	outboundRule := &nftables.Rule{
+		Table:    tb,
+		Chain:    ch,
+		UserData: EncodeRuleComment(comment),
+		Exprs: []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(intfName)},
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: saddrOffset, Len: addrLen},
+			&expr.Lookup{SourceRegister: 1, SetName: setName},
+			&expr.Counter{},
+			&expr.Verdict{Kind: expr.VerdictAccept},
+		},
+	}
+
+	intraInterfaceRule := &nftables.Rule{
+		Table:    tb,
+		Chain:    ch,
+		UserData: EncodeRuleComment(comment),
+		Exprs: []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(intfName)},
+			&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(intfName)},
+			&expr.Counter{},
+			&expr.Verdict{Kind: expr.VerdictAccept},
+		},
+	}
+
+	// The rules are placed right before the default deny rules.
+	chainProps, err := GetChainProps(v, tableName, chainName)
+	if err != nil {
+		return err
+	}
+	for _, r := range []*nftables.Rule{inboundRule, outboundRule, intraInterfaceRule} {
+		placeRule(conn, r, chainProps.Rules, PlaceBeforeDeny)
+	}
+
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed adding set-based filtering rules in chain %s of ipv%s %s table for %v: %s",
+			chainName, v, tableName, addr, err,
+		)
+	}
+	return nil
+}
+
+// CheckFilterForwardSetRules checks whether the address of a container is
+// in the set of a network and the rules referencing the set exist.
+func CheckFilterForwardSetRules(v, tableName, chainName, setName string, addr *current.IPConfig) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	exists, err := IsSetExists(v, tableName, setName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("ipv%s set %s does not exist in %s table", v, setName, tableName)
+	}
+
+	addrs, err := GetSetAddresses(v, tableName, setName)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, ip := range addrs {
+		if ip.Equal(addr.Address.IP) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("ipv%s set %s in %s table has no %s address", v, setName, tableName, addr.Address.IP)
+	}
+
+	rules, err := GetRulesByComment(v, tableName, chainName, getFilterForwardSetRulesComment(setName))
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return fmt.Errorf(
+			"ipv%s chain %s in %s table has no rules referencing set %s",
+			v, chainName, tableName, setName,
+		)
+	}
+	return nil
+}
+
+// RemoveFilterForwardSetRules removes the address of a container from the
+// set of a network. When the set becomes empty, the function removes the
+// set and the network-wide rules referencing it.
+func RemoveFilterForwardSetRules(v, tableName, chainName, setName string, addr *current.IPConfig) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	exists, err := IsSetExists(v, tableName, setName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	addrs, err := GetSetAddresses(v, tableName, setName)
+	if err != nil {
+		return err
+	}
+
+	remaining := 0
+	for _, ip := range addrs {
+		if ip.Equal(addr.Address.IP) {
+			if err := DeleteSetAddress(v, tableName, setName, ip); err != nil {
+				return err
+			}
+			continue
+		}
+		remaining++
+	}
+
+	if remaining > 0 {
+		return nil
+	}
+
+	exists, err = IsChainExists(v, tableName, chainName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		rules, err := GetRulesByComment(v, tableName, chainName, getFilterForwardSetRulesComment(setName))
+		if err != nil {
+			return err
+		}
+		if len(rules) > 0 {
+			conn, err := initNftConn()
+			if err != nil {
+				return err
+			}
+			for _, r := range rules {
+				if err := conn.DelRule(r); err != nil {
+					return err
+				}
+			}
+			if err := conn.Flush(); err != nil {
+				return fmt.Errorf(
+					"error deleting rules referencing set %s in chain %s of %s table: %s",
+					setName, chainName, tableName, err,
+				)
+			}
+		}
+	}
+
+	return DeleteSet(v, tableName, setName)
+}
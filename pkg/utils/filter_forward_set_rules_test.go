@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddFilterForwardSetRules(t *testing.T) {
+	got := getDryRunCommands(t, func() error {
+		if err := CreateTable("4", "filter"); err != nil {
+			return err
+		}
+		if err := CreateChain("4", "filter", "forward", "filter", "forward", "filter"); err != nil {
+			return err
+		}
+		for _, addr := range []string{"10.88.0.5/16", "10.88.0.6/16"} {
+			if err := AddFilterForwardSetRules("4", "filter", "forward", "cni-ffs-podman", getTestIPConfig(addr), "cni-podman0"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	// The rules of the network are added with its first container.
+	want := []string{
+		"add table ip filter",
+		"add chain ip filter forward { type filter hook forward priority 0; policy accept; }",
+		"add set ip filter cni-ffs-podman { type ipv4_addr; }",
+		"add element ip filter cni-ffs-podman { 10.88.0.5 }",
+		`add rule ip filter forward oifname "cni-podman0" ip daddr @cni-ffs-podman ct state established,related counter packets 0 bytes 0 accept comment "cni-ffs cni-ffs-podman"`,
+		`add rule ip filter forward iifname "cni-podman0" ip saddr @cni-ffs-podman counter packets 0 bytes 0 accept comment "cni-ffs cni-ffs-podman"`,
+		`add rule ip filter forward iifname "cni-podman0" oifname "cni-podman0" counter packets 0 bytes 0 accept comment "cni-ffs cni-ffs-podman"`,
+		"add element ip filter cni-ffs-podman { 10.88.0.6 }",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected rules\ngot:\n%#v\nwant:\n%#v", got, want)
+	}
+}
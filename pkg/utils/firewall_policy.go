@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// FirewallPolicy holds the ingress and egress rules of a container.
+type FirewallPolicy struct {
+	Ingress *FirewallPolicyDirection `json:"ingress,omitempty"`
+	Egress  *FirewallPolicyDirection `json:"egress,omitempty"`
+}
+
+// FirewallPolicyDirection holds the rules of a direction of traffic, i.e.
+// ingress or egress, and the action for the traffic matching none of them.
+type FirewallPolicyDirection struct {
+	DefaultAction string                `json:"default_action,omitempty"`
+	Rules         []*FirewallPolicyRule `json:"rules,omitempty"`
+}
+
+// FirewallPolicyRule holds a rule of a firewall policy. The empty fields
+// match any traffic. The CIDRs are the remote addresses, i.e. the source
+// addresses of ingress traffic and the destination addresses of egress
+// traffic. The ports are the destination ports, e.g. 80 or 8000-8080.
+type FirewallPolicyRule struct {
+	CIDRs    []string `json:"cidrs,omitempty"`
+	Protocol string   `json:"protocol,omitempty"`
+	Ports    []string `json:"ports,omitempty"`
+	ICMPType *int     `json:"icmp_type,omitempty"`
+	Action   string   `json:"action"`
+}
+
+func isSupportedPolicyAction(s string) bool {
+	switch s {
+	case "accept", "drop", "reject":
+		return true
+	}
+	return false
+}
+
+// parsePolicyPort parses a port or a port range, e.g. 80 or 8000-8080.
+func parsePolicyPort(s string) (uint16, uint16, error) {
+	arr := strings.SplitN(s, "-", 2)
+	start, err := strconv.ParseUint(strings.TrimSpace(arr[0]), 10, 16)
+	if err != nil || start == 0 {
+		return 0, 0, fmt.Errorf("invalid port: %s", s)
+	}
+	end := start
+	if len(arr) == 2 {
+		end, err = strconv.ParseUint(strings.TrimSpace(arr[1]), 10, 16)
+		if err != nil || end < start {
+			return 0, 0, fmt.Errorf("invalid port range: %s", s)
+		}
+	}
+	return uint16(start), uint16(end), nil
+}
+
+// getDefaultAction returns the action for the traffic matching none of
+// the rules of a direction. The default action of a direction is drop,
+// i.e. a direction present in a policy allows only the traffic its rules
+// accept.
+func (d *FirewallPolicyDirection) getDefaultAction() string {
+	if d.DefaultAction == "" {
+		return "drop"
+	}
+	return d.DefaultAction
+}
+
+// ValidateFirewallPolicy checks a firewall policy, the ingress direction
+// first, then the egress one.
+func ValidateFirewallPolicy(p *FirewallPolicy) error {
+	if p == nil {
+		return nil
+	}
+	for _, direction := range []struct {
+		name string
+		d    *FirewallPolicyDirection
+	}{
+		{"ingress", p.Ingress},
+		{"egress", p.Egress},
+	} {
+		name, d := direction.name, direction.d
+		if d == nil {
+			continue
+		}
+		if !isSupportedPolicyAction(d.getDefaultAction()) {
+			return fmt.Errorf("unsupported %s default action: %s", name, d.DefaultAction)
+		}
+		for i, r := range d.Rules {
+			if r == nil {
+				return fmt.Errorf("%s rule %d is empty", name, i)
+			}
+			if !isSupportedPolicyAction(r.Action) {
+				return fmt.Errorf("unsupported %s rule %d action: %s", name, i, r.Action)
+			}
+			for _, cidr := range r.CIDRs {
+				if _, err := ParseMappingSource(cidr); err != nil {
+					return fmt.Errorf("%s rule %d: %s", name, i, err)
+				}
+			}
+			switch r.Protocol {
+			case "", "any", "tcp", "udp", "icmp", "icmpv6":
+			default:
+				return fmt.Errorf("unsupported %s rule %d protocol: %s", name, i, r.Protocol)
+			}
+			if len(r.Ports) > 0 && r.Protocol != "tcp" && r.Protocol != "udp" {
+				return fmt.Errorf("%s rule %d has ports, but its protocol is not tcp or udp", name, i)
+			}
+			for _, port := range r.Ports {
+				if _, _, err := parsePolicyPort(port); err != nil {
+					return fmt.Errorf("%s rule %d: %s", name, i, err)
+				}
+			}
+			if r.ICMPType != nil {
+				if r.Protocol != "icmp" && r.Protocol != "icmpv6" {
+					return fmt.Errorf("%s rule %d has icmp type, but its protocol is not icmp or icmpv6", name, i)
+				}
+				if *r.ICMPType < 0 || *r.ICMPType > 255 {
+					return fmt.Errorf("%s rule %d has invalid icmp type: %d", name, i, *r.ICMPType)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// getPolicyRuleCIDRs returns the remote networks of a policy rule for the
+// provided IP version. A rule without CIDRs returns a single nil network,
+// i.e. any address. A rule with the CIDRs of the other IP version only
+// returns no networks, i.e. the rule does not apply to the IP version.
+func getPolicyRuleCIDRs(v string, r *FirewallPolicyRule) []*net.IPNet {
+	if len(r.CIDRs) == 0 {
+		return []*net.IPNet{nil}
+	}
+	prefixes := []*net.IPNet{}
+	for _, cidr := range r.CIDRs {
+		prefix, err := ParseMappingSource(cidr)
+		if err != nil {
+			continue
+		}
+		if (prefix.IP.To4() != nil) != (v == "4") {
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
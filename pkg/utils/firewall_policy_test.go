@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestValidateFirewallPolicy(t *testing.T) {
+	var tests = []struct {
+		name   string
+		policy *FirewallPolicy
+		want   string
+	}{
+		{
+			name: "valid policy",
+			policy: &FirewallPolicy{
+				Ingress: &FirewallPolicyDirection{Rules: []*FirewallPolicyRule{
+					{CIDRs: []string{"10.0.0.0/8"}, Protocol: "tcp", Ports: []string{"80", "8000-8080"}, Action: "accept"},
+				}},
+				Egress: &FirewallPolicyDirection{DefaultAction: "accept"},
+			},
+		},
+		{
+			name: "ingress checked before egress",
+			policy: &FirewallPolicy{
+				Ingress: &FirewallPolicyDirection{DefaultAction: "allow"},
+				Egress:  &FirewallPolicyDirection{DefaultAction: "deny"},
+			},
+			want: "unsupported ingress default action: allow",
+		},
+		{
+			name: "egress",
+			policy: &FirewallPolicy{
+				Ingress: &FirewallPolicyDirection{},
+				Egress: &FirewallPolicyDirection{Rules: []*FirewallPolicyRule{
+					{Protocol: "udp", Action: "accept"},
+					{Protocol: "icmp", Ports: []string{"53"}, Action: "accept"},
+				}},
+			},
+			want: "egress rule 1 has ports, but its protocol is not tcp or udp",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The validation reports the same problem every time and
+			// leaves the policy as is.
+			for i := 0; i < 10; i++ {
+				before := *test.policy
+				err := ValidateFirewallPolicy(test.policy)
+				got := ""
+				if err != nil {
+					got = err.Error()
+				}
+				if got != test.want {
+					t.Fatalf("got error %q, want %q", got, test.want)
+				}
+				if !reflect.DeepEqual(*test.policy, before) {
+					t.Fatalf("policy changed by validation")
+				}
+			}
+		})
+	}
+}
+
+func TestGetFilterForwardPolicyRules(t *testing.T) {
+	icmpType := 8
+	var tests = []struct {
+		name   string
+		v      string
+		ip     string
+		policy *FirewallPolicy
+		want   []string
+	}{
+		{
+			name: "ingress with default drop",
+			v:    "4",
+			ip:   "10.88.0.5",
+			policy: &FirewallPolicy{
+				Ingress: &FirewallPolicyDirection{Rules: []*FirewallPolicyRule{
+					{CIDRs: []string{"10.0.0.0/8", "2001:db8::/32"}, Protocol: "tcp", Ports: []string{"80", "8000-8080"}, Action: "accept"},
+					{Protocol: "icmp", ICMPType: &icmpType, Action: "accept"},
+				}},
+			},
+			want: []string{
+				`oifname "cni-podman0" ip daddr 10.88.0.5 ip saddr 10.0.0.0/8 meta l4proto tcp tcp dport 80 counter name "cni-acc-3f0e8b6c2a1d" accept`,
+				`oifname "cni-podman0" ip daddr 10.88.0.5 ip saddr 10.0.0.0/8 meta l4proto tcp tcp dport >= 8000 tcp dport <= 8080 counter name "cni-acc-3f0e8b6c2a1d" accept`,
+				`oifname "cni-podman0" ip daddr 10.88.0.5 meta l4proto icmp @th,0,8 0x08 counter name "cni-acc-3f0e8b6c2a1d" accept`,
+				`oifname "cni-podman0" ip daddr 10.88.0.5 counter name "cni-drp-3f0e8b6c2a1d" drop`,
+			},
+		},
+		{
+			name: "egress with a rule of the other version",
+			v:    "6",
+			ip:   "fd00::5",
+			policy: &FirewallPolicy{
+				Egress: &FirewallPolicyDirection{DefaultAction: "reject", Rules: []*FirewallPolicyRule{
+					{CIDRs: []string{"192.0.2.0/24"}, Action: "accept"},
+					{Protocol: "udp", Ports: []string{"53"}, Action: "accept"},
+				}},
+			},
+			want: []string{
+				`iifname "cni-podman0" ip6 saddr fd00::5 ct state established,related counter name "cni-acc-3f0e8b6c2a1d" accept`,
+				`iifname "cni-podman0" ip6 saddr fd00::5 meta l4proto udp udp dport 53 oifname "cni-podman0" counter packets 0 bytes 0 return`,
+				`iifname "cni-podman0" ip6 saddr fd00::5 meta l4proto udp udp dport 53 counter name "cni-acc-3f0e8b6c2a1d" accept`,
+				`iifname "cni-podman0" ip6 saddr fd00::5 counter name "cni-drp-3f0e8b6c2a1d" reject`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := []string{}
+			rules := getFilterForwardPolicyRules(test.v, net.ParseIP(test.ip), "cni-podman0", test.policy, GetContainerCounters("3f0e8b6c2a1d"))
+			for _, exprs := range rules {
+				got = append(got, FormatRuleExprs(test.v, exprs))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("unexpected rules\ngot:\n%#v\nwant:\n%#v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// flowOffloadRuleCommentPrefix is the prefix of the comments tagging the
+// flow offload rules. The comment of the rules of a container is followed
+// by the host interface and the ID of the container.
+const flowOffloadRuleCommentPrefix = "cni-flow "
+
+// getFlowOffloadRuleComment returns the comment tagging the flow offload
+// rules of a container attached to a particular host interface.
+func getFlowOffloadRuleComment(intfName, containerID string) string {
+	return flowOffloadRuleCommentPrefix + intfName + " " + containerID
+}
+
+func getFlowtableTable(v, tableName string) *nftables.Table {
+	tb := &nftables.Table{
+		Name: tableName,
+	}
+	if v == "4" {
+		tb.Family = nftables.TableFamilyIPv4
+	} else {
+		tb.Family = nftables.TableFamilyIPv6
+	}
+	return tb
+}
+
+// GetFlowtable returns a flowtable of filter table, or nil when the
+// flowtable does not exist.
+func GetFlowtable(v, tableName, flowtableName string) (*nftables.Flowtable, error) {
+	if err := isSupportedIPVersion(v); err != nil {
+		return nil, err
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return nil, err
+	}
+
+	flowtables, err := conn.ListFlowtables(getFlowtableTable(v, tableName))
+	if err != nil {
+		return nil, err
+	}
+	for _, ft := range flowtables {
+		if ft.Name == flowtableName {
+			return ft, nil
+		}
+	}
+	return nil, nil
+}
+
+// AddFlowtableDevices creates a flowtable in filter table, when missing,
+// and adds the provided devices to the flowtable.
+func AddFlowtableDevices(v, tableName, flowtableName string, devices []string) error {
+	ft, err := GetFlowtable(v, tableName, flowtableName)
+	if err != nil {
+		return err
+	}
+
+	missing := []string{}
+	for _, device := range devices {
+		found := false
+		if ft != nil {
+			for _, existingDevice := range ft.Devices {
+				if existingDevice == device {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			missing = append(missing, device)
+		}
+	}
+	if ft != nil && len(missing) == 0 {
+		return nil
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+	conn.AddFlowtable(&nftables.Flowtable{
+		Table:    getFlowtableTable(v, tableName),
+		Name:     flowtableName,
+		Hooknum:  nftables.FlowtableHookIngress,
+		Priority: nftables.FlowtablePriorityFilter,
+		Devices:  missing,
+	})
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed adding devices %v to flowtable %s of ipv%s %s table: %s",
+			missing, flowtableName, v, tableName, err,
+		)
+	}
+	return nil
+}
+
+// RemoveFlowtableDevices removes the provided devices from a flowtable of
+// filter table.
+func RemoveFlowtableDevices(v, tableName, flowtableName string, devices []string) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	// The flowtables of nftables package are deleted with all their
+	// devices.
+	devs := []netlink.Attribute{}
+	for _, device := range devices {
+		devs = append(devs, netlink.Attribute{Type: nftables.NFTA_DEVICE_NAME, Data: []byte(device + "\x00")})
+	}
+	devsAttr, err := netlink.MarshalAttributes(devs)
+	if err != nil {
+		return err
+	}
+	hook, err := netlink.MarshalAttributes([]netlink.Attribute{
+		{Type: nftables.NFTA_FLOWTABLE_HOOK_NUM, Data: binaryutil.BigEndian.PutUint32(uint32(*nftables.FlowtableHookIngress))},
+		{Type: nftables.NFTA_FLOWTABLE_PRIORITY, Data: binaryutil.BigEndian.PutUint32(uint32(*nftables.FlowtablePriorityFilter))},
+		{Type: unix.NLA_F_NESTED | nftables.NFTA_FLOWTABLE_DEVS, Data: devsAttr},
+	})
+	if err != nil {
+		return err
+	}
+	data, err := netlink.MarshalAttributes([]netlink.Attribute{
+		{Type: nftables.NFTA_FLOWTABLE_TABLE, Data: []byte(tableName + "\x00")},
+		{Type: nftables.NFTA_FLOWTABLE_NAME, Data: []byte(flowtableName + "\x00")},
+		{Type: unix.NLA_F_NESTED | nftables.NFTA_FLOWTABLE_HOOK, Data: hook},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := flushNftMessages([]netlink.Message{
+		{
+			Header: netlink.Header{
+				Type:  netlink.HeaderType((unix.NFNL_SUBSYS_NFTABLES << 8) | nftables.NFT_MSG_DELFLOWTABLE),
+				Flags: netlink.Request | netlink.Acknowledge,
+			},
+			Data: append([]byte{byte(getFlowtableTable(v, tableName).Family), unix.NFNETLINK_V0, 0, 0}, data...),
+		},
+	}); err != nil {
+		return fmt.Errorf(
+			"failed removing devices %v from flowtable %s of ipv%s %s table: %s",
+			devices, flowtableName, v, tableName, err,
+		)
+	}
+	return nil
+}
+
+// DeleteFlowtable deletes a flowtable of filter table.
+func DeleteFlowtable(v, tableName, flowtableName string) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+	conn.DelFlowtable(&nftables.Flowtable{
+		Table: getFlowtableTable(v, tableName),
+		Name:  flowtableName,
+	})
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed deleting flowtable %s of ipv%s %s table: %s",
+			flowtableName, v, tableName, err,
+		)
+	}
+	return nil
+}
+
+// SetFilterForwardFlowOffloadRules replaces the rules offloading the
+// established connections of a container to a flowtable in forwarding
+// chain of filter table. The rules are inserted at the top of the chain,
+// because the chain of the container accepts the established connections.
+// When the provided addresses are empty, the function removes the rules of
+// the container. The rules look like:
+//
+//	iifname "<intfName>" ip saddr <addr> ct state established flow add @<flowtableName>
+//	oifname "<intfName>" ip daddr <addr> ct state established flow add @<flowtableName>
+func SetFilterForwardFlowOffloadRules(v, tableName, chainName, flowtableName, intfName, containerID string, addrs []net.IP) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	comment := getFlowOffloadRuleComment(intfName, containerID)
+	existingRules, err := GetRulesByComment(v, tableName, chainName, comment)
+	if err != nil {
+		return err
+	}
+	versionAddrs := getVersionAddrs(v, addrs)
+	if len(existingRules) == 0 && len(versionAddrs) == 0 {
+		return nil
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+
+	for _, r := range existingRules {
+		if err := conn.DelRule(r); err != nil {
+			return err
+		}
+	}
+
+	tb := getFlowtableTable(v, tableName)
+	ch := &nftables.Chain{
+		Name:  chainName,
+		Table: tb,
+	}
+	var daddrOffset, saddrOffset, addrLen uint32
+	if v == "4" {
+		daddrOffset, saddrOffset, addrLen = 16, 12, 4
+	} else {
+		daddrOffset, saddrOffset, addrLen = 24, 8, 16
+	}
+
+	offload := []expr.Any{
+		// ct state established
+		&expr.Ct{Register: 1, Key: expr.CtKeySTATE},
+		&expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            4,
+			Mask:           binaryutil.NativeEndian.PutUint32(expr.CtStateBitESTABLISHED),
+			Xor:            []byte{0x0, 0x0, 0x0, 0x0},
+		},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: []byte{0x0, 0x0, 0x0, 0x0}},
+		&expr.FlowOffload{Name: flowtableName},
+	}
+
+	for _, addr := range versionAddrs {
+		for _, m := range []struct {
+			key    expr.MetaKey
+			offset uint32
+		}{
+			{expr.MetaKeyIIFNAME, saddrOffset},
+			{expr.MetaKeyOIFNAME, daddrOffset},
+		} {
+			exprs := []expr.Any{
+				&expr.Meta{Key: m.key, Register: 1},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(intfName)},
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: m.offset, Len: addrLen},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: addr},
+			}
+			conn.InsertRule(&nftables.Rule{
+				Table:    tb,
+				Chain:    ch,
+				UserData: EncodeRuleComment(comment),
+				Exprs:    append(exprs, offload...),
+			})
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed setting flow offload rules in chain %s of ipv%s %s table: %s",
+			chainName, v, tableName, err,
+		)
+	}
+	return nil
+}
+
+// GetFlowOffloadInterfaces returns the number of containers having flow
+// offload rules in forwarding chain of filter table per host interface.
+func GetFlowOffloadInterfaces(v, tableName, chainName string) (map[string]int, error) {
+	chainProps, err := GetChainProps(v, tableName, chainName)
+	if err != nil {
+		return nil, err
+	}
+	containers := map[string]bool{}
+	interfaces := map[string]int{}
+	for _, r := range chainProps.Rules {
+		comment := DecodeRuleComment(r.UserData)
+		if !strings.HasPrefix(comment, flowOffloadRuleCommentPrefix) {
+			continue
+		}
+		if containers[comment] {
+			continue
+		}
+		containers[comment] = true
+		arr := strings.SplitN(strings.TrimPrefix(comment, flowOffloadRuleCommentPrefix), " ", 2)
+		interfaces[arr[0]]++
+	}
+	return interfaces, nil
+}
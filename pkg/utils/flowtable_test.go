@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestSetFilterForwardFlowOffloadRules(t *testing.T) {
+	got := getDryRunCommands(t, func() error {
+		if err := CreateTable("4", "filter"); err != nil {
+			return err
+		}
+		if err := CreateChain("4", "filter", "FORWARD", "filter", "forward", "filter"); err != nil {
+			return err
+		}
+		if err := AddFlowtableDevices("4", "filter", "cni-ft", []string{"cni-podman0"}); err != nil {
+			return err
+		}
+		addrs := []net.IP{net.ParseIP("10.88.0.5"), net.ParseIP("fd00::5")}
+		return SetFilterForwardFlowOffloadRules("4", "filter", "FORWARD", "cni-ft", "cni-podman0", "test", addrs)
+	})
+	want := []string{
+		"add table ip filter",
+		"add chain ip filter FORWARD { type filter hook forward priority 0; policy accept; }",
+		"add flowtable ip filter cni-ft { hook ingress priority 0; devices = { \"cni-podman0\" }; }",
+		"insert rule ip filter FORWARD iifname \"cni-podman0\" ip saddr 10.88.0.5 ct state established flow add @cni-ft comment \"cni-flow cni-podman0 test\"",
+		"insert rule ip filter FORWARD oifname \"cni-podman0\" ip daddr 10.88.0.5 ct state established flow add @cni-ft comment \"cni-flow cni-podman0 test\"",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected rules\ngot:\n%#v\nwant:\n%#v", got, want)
+	}
+}
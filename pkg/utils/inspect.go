@@ -0,0 +1,270 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/nftables/expr"
+)
+
+// containerChainKinds are the kinds of the chains of a single container,
+// i.e. the chains named cni-<kind>-<id>.
+var containerChainKinds = []string{"ffw", "npo", "npr", "mgl"}
+
+// InspectFilter selects the containers the inspect subcommand prints.
+type InspectFilter struct {
+	// ContainerID is a prefix of the ID of the containers.
+	ContainerID string
+	// Network is the network of the containers.
+	Network string
+	// Family is either ipv4 or ipv6.
+	Family string
+}
+
+// InspectJumpRule is a rule jumping to a chain of a container.
+type InspectJumpRule struct {
+	Chain  string `json:"chain"`
+	Handle uint64 `json:"handle"`
+	Rule   string `json:"rule"`
+}
+
+// InspectChain is a chain of a container with its rules in nft syntax.
+type InspectChain struct {
+	Family    string             `json:"family"`
+	Table     string             `json:"table"`
+	Chain     string             `json:"chain"`
+	JumpRules []*InspectJumpRule `json:"jump_rules"`
+	Rules     []string           `json:"rules"`
+}
+
+// InspectContainer holds the chains of a container. The ID is the suffix
+// of the names of the chains when no jump rule to the chains records the
+// ID and the network of the container.
+type InspectContainer struct {
+	ID      string          `json:"id"`
+	Network string          `json:"network,omitempty"`
+	Chains  []*InspectChain `json:"chains"`
+}
+
+// InspectContainers returns the chains of the containers in the IPv4 and
+// IPv6 tables having the provided names, grouped by container, with the
+// rules of the chains and the jump rules to the chains.
+func InspectContainers(tableNames []string, filter *InspectFilter) ([]*InspectContainer, error) {
+	containers := map[string]*InspectContainer{}
+	for _, v := range []string{"4", "6"} {
+		if filter.Family != "" && filter.Family != "ipv"+v {
+			continue
+		}
+		for _, tableName := range tableNames {
+			exists, err := IsTableExist(v, tableName)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				continue
+			}
+			if err := inspectTable(v, tableName, containers); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// The chains of a container are grouped by the suffix of their names,
+	// and the ID and the network of the container are the ones found in
+	// any table.
+	entries := []*InspectContainer{}
+	for _, c := range containers {
+		if filter.ContainerID != "" && !isInspectContainerMatch(c, filter.ContainerID) {
+			continue
+		}
+		if filter.Network != "" && c.Network != filter.Network {
+			continue
+		}
+		sort.SliceStable(c.Chains, func(i, j int) bool {
+			a, b := c.Chains[i], c.Chains[j]
+			if a.Family != b.Family {
+				return a.Family < b.Family
+			}
+			if a.Table != b.Table {
+				return a.Table < b.Table
+			}
+			return a.Chain < b.Chain
+		})
+		entries = append(entries, c)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, nil
+}
+
+// isInspectContainerMatch checks whether the ID of a container starts with
+// the provided prefix. The ID of a container without the comment of the
+// jump rules is the suffix of the names of its chains, which a full ID
+// matches.
+func isInspectContainerMatch(c *InspectContainer, prefix string) bool {
+	if strings.HasPrefix(c.ID, prefix) {
+		return true
+	}
+	return c.ID == strings.TrimPrefix(GetChainName(containerChainKinds[0], prefix), "cni-"+containerChainKinds[0]+"-")
+}
+
+// ExplainEnv is the environment variable enabling the explanation of the
+// failed checks, as the -explain flag does, for the runtimes running the
+// plugins without arguments.
+const ExplainEnv = "CNI_NFTABLES_EXPLAIN"
+
+// IsExplainEnabled checks whether the ExplainEnv variable enables the
+// explanation of the failed checks.
+func IsExplainEnabled() (bool, error) {
+	s := os.Getenv(ExplainEnv)
+	if s == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value %q: %v", ExplainEnv, s, err)
+	}
+	return enabled, nil
+}
+
+// ExplainError adds the chains of a container in the IPv4 and IPv6 tables
+// having the provided names, with the rules of the chains and the jump
+// rules to the chains in nft syntax, to an error, e.g. a failed check.
+func ExplainError(err error, tableNames []string, containerID string) error {
+	entries, inspectErr := InspectContainers(tableNames, &InspectFilter{ContainerID: containerID})
+	if inspectErr != nil {
+		return fmt.Errorf("%s; failed obtaining chains of container %s: %s", err, containerID, inspectErr)
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("%s; container %s has no chains in %v tables", err, containerID, tableNames)
+	}
+	var b strings.Builder
+	WriteInspectContainers(&b, entries, "text")
+	return fmt.Errorf("%s; the chains of container %s are:\n%s", err, containerID, strings.TrimRight(b.String(), "\n"))
+}
+
+// inspectTable adds the chains of the containers in a table to the
+// provided containers, keyed by the suffix of the names of the chains.
+func inspectTable(v, tableName string, containers map[string]*InspectContainer) error {
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+	chains, err := conn.ListChains()
+	if err != nil {
+		return err
+	}
+	family := getFlowtableTable(v, tableName).Family
+
+	chainNames := []string{}
+	for _, ch := range chains {
+		if ch == nil || ch.Table.Name != tableName || ch.Table.Family != family {
+			continue
+		}
+		chainNames = append(chainNames, ch.Name)
+	}
+
+	jumpRules := map[string][]*InspectJumpRule{}
+	infos := map[string]*ContainerInfo{}
+	rules := map[string][]string{}
+	for _, chainName := range chainNames {
+		chainProps, err := GetChainProps(v, tableName, chainName)
+		if err != nil {
+			return err
+		}
+		for _, r := range chainProps.Rules {
+			rules[chainName] = append(rules[chainName], FormatRule(v, r))
+			for _, e := range r.Exprs {
+				x, ok := e.(*expr.Verdict)
+				if !ok || (x.Kind != expr.VerdictJump && x.Kind != expr.VerdictGoto) {
+					continue
+				}
+				jumpRules[x.Chain] = append(jumpRules[x.Chain], &InspectJumpRule{
+					Chain:  chainName,
+					Handle: r.Handle,
+					Rule:   FormatRule(v, r),
+				})
+				if info := parseContainerInfoComment(DecodeRuleComment(r.UserData)); info != nil {
+					infos[x.Chain] = info
+				}
+			}
+		}
+	}
+
+	for _, chainName := range chainNames {
+		suffix := ""
+		for _, kind := range containerChainKinds {
+			if s := strings.TrimPrefix(chainName, "cni-"+kind+"-"); s != chainName {
+				suffix = s
+				break
+			}
+		}
+		if suffix == "" {
+			continue
+		}
+		c, exists := containers[suffix]
+		if !exists {
+			c = &InspectContainer{ID: suffix}
+			containers[suffix] = c
+		}
+		if info, found := infos[chainName]; found {
+			c.ID, c.Network = info.ID, info.Network
+		}
+		ch := &InspectChain{
+			Family:    "ipv" + v,
+			Table:     tableName,
+			Chain:     chainName,
+			JumpRules: jumpRules[chainName],
+			Rules:     rules[chainName],
+		}
+		if ch.JumpRules == nil {
+			ch.JumpRules = []*InspectJumpRule{}
+		}
+		if ch.Rules == nil {
+			ch.Rules = []string{}
+		}
+		c.Chains = append(c.Chains, ch)
+	}
+	return nil
+}
+
+// WriteInspectContainers writes the chains of the containers as text or as
+// JSON.
+func WriteInspectContainers(w io.Writer, entries []*InspectContainer, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case "text":
+	default:
+		return fmt.Errorf("unsupported inspect format: %s", format)
+	}
+
+	for i, c := range entries {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		network := c.Network
+		if network == "" {
+			network = "-"
+		}
+		fmt.Fprintf(w, "container %s network %s\n", c.ID, network)
+		for _, ch := range c.Chains {
+			fmt.Fprintf(w, "  %s %s chain %s\n", ch.Family, ch.Table, ch.Chain)
+			for _, j := range ch.JumpRules {
+				fmt.Fprintf(w, "    jump from %s (handle %d): %s\n", j.Chain, j.Handle, j.Rule)
+			}
+			for _, r := range ch.Rules {
+				fmt.Fprintf(w, "    %s\n", r)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestIsExplainEnabled(t *testing.T) {
+	for _, tc := range []struct {
+		value     string
+		want      bool
+		shouldErr bool
+	}{
+		{value: ""},
+		{value: "true", want: true},
+		{value: "1", want: true},
+		{value: "false"},
+		{value: "yes", shouldErr: true},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			t.Setenv(ExplainEnv, tc.value)
+			got, err := IsExplainEnabled()
+			if tc.shouldErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tc.want {
+				t.Fatalf("unexpected value: got %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
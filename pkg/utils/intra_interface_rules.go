@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// ValidateIntraInterfaceMode checks the handling of the traffic between
+// the containers of a bridge. The mode is allow, deny, or peers, i.e. the
+// traffic is allowed only from and to the provided peer addresses or
+// networks.
+func ValidateIntraInterfaceMode(mode string, peers []string) error {
+	switch mode {
+	case "allow", "deny":
+		if len(peers) > 0 {
+			return fmt.Errorf("intra interface peers require peers mode")
+		}
+	case "peers":
+		if len(peers) == 0 {
+			return fmt.Errorf("intra interface peers mode requires peers")
+		}
+		for _, peer := range peers {
+			if _, err := ParseMappingSource(peer); err != nil {
+				return fmt.Errorf("invalid intra interface peer: %s", err)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported intra interface mode: %s", mode)
+	}
+	return nil
+}
+
+// getIntraInterfaceRuleComment returns the comment tagging the rules
+// restricting the traffic between the containers of a particular bridge.
+func getIntraInterfaceRuleComment(intfName string) string {
+	return "cni-intra " + intfName
+}
+
+// getIntraInterfacePeers returns the peer networks of the provided IP version.
+func getIntraInterfacePeers(v string, peers []string) []*net.IPNet {
+	prefixes := []*net.IPNet{}
+	for _, peer := range peers {
+		prefix, err := ParseMappingSource(peer)
+		if err != nil {
+			continue
+		}
+		if (prefix.IP.To4() != nil) != (v == "4") {
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+// neighborDiscoveryMatch returns the exprs matching the ICMPv6 router and
+// neighbor solicitations and advertisements, i.e. types 133 to 136.
+func neighborDiscoveryMatch() []expr.Any {
+	return []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_ICMPV6}},
+		// [ payload load 1b @ transport header + 0 => reg 1 ]
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 0, Len: 1},
+		&expr.Cmp{Op: expr.CmpOpGte, Register: 1, Data: []byte{133}},
+		&expr.Cmp{Op: expr.CmpOpLte, Register: 1, Data: []byte{136}},
+	}
+}
+
+// getIntraInterfaceRules returns the rules restricting the traffic of the
+// provided IP version matching the provided exprs.
+func getIntraInterfaceRules(v, mode string, peers []string, match []expr.Any) [][]expr.Any {
+	rules := [][]expr.Any{}
+	if mode == "peers" {
+		for _, prefix := range getIntraInterfacePeers(v, peers) {
+			for _, m := range [][]expr.Any{IPSaddrPrefixMatch(v, prefix), IPDaddrPrefixMatch(v, prefix)} {
+				r := append(append([]expr.Any{}, match...), m...)
+				r = append(r, &expr.Counter{}, &expr.Verdict{Kind: expr.VerdictAccept})
+				rules = append(rules, r)
+			}
+		}
+		if v == "6" {
+			r := append(append([]expr.Any{}, match...), neighborDiscoveryMatch()...)
+			r = append(r, &expr.Counter{}, &expr.Verdict{Kind: expr.VerdictAccept})
+			rules = append(rules, r)
+		}
+	}
+	r := append([]expr.Any{}, match...)
+	r = append(r, &expr.Counter{}, &expr.Verdict{Kind: expr.VerdictDrop})
+	return append(rules, r)
+}
+
+// SetFilterForwardIntraInterfaceRules replaces the rules restricting the
+// traffic between the containers of a bridge in a chain of filter table.
+// The rules are inserted at the top of the chain, i.e. before the rules
+// accepting the traffic of the containers. In allow mode, the function
+// removes the rules. In peers mode, the rules look like:
+//
+//	iifname "<intfName>" oifname "<intfName>" ip saddr 192.168.100.5 counter accept
+//	iifname "<intfName>" oifname "<intfName>" ip daddr 192.168.100.5 counter accept
+//	iifname "<intfName>" oifname "<intfName>" counter drop
+func SetFilterForwardIntraInterfaceRules(v, tableName, chainName, intfName, mode string, peers []string) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	comment := getIntraInterfaceRuleComment(intfName)
+	existingRules, err := GetRulesByComment(v, tableName, chainName, comment)
+	if err != nil {
+		return err
+	}
+	if len(existingRules) == 0 && mode == "allow" {
+		return nil
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+
+	for _, r := range existingRules {
+		if err := conn.DelRule(r); err != nil {
+			return err
+		}
+	}
+
+	if mode != "allow" {
+		tb := &nftables.Table{
+			Name: tableName,
+		}
+		if v == "4" {
+			tb.Family = nftables.TableFamilyIPv4
+		} else {
+			tb.Family = nftables.TableFamilyIPv6
+		}
+
+		ch := &nftables.Chain{
+			Name:  chainName,
+			Table: tb,
+		}
+
+		match := []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(intfName)},
+			&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(intfName)},
+		}
+
+		// The rules are inserted at the top of the chain. Hence, the
+		// reverse order.
+		rules := getIntraInterfaceRules(v, mode, peers, match)
+		for i := len(rules) - 1; i >= 0; i-- {
+			conn.InsertRule(&nftables.Rule{
+				Table:    tb,
+				Chain:    ch,
+				UserData: EncodeRuleComment(comment),
+				Exprs:    rules[i],
+			})
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed setting intra interface filtering rules in chain %s of ipv%s %s table: %s",
+			chainName, v, tableName, err,
+		)
+	}
+	return nil
+}
+
+// RemoveFilterForwardIntraInterfaceRules removes the rules restricting the
+// traffic between the containers of a bridge in a chain of filter table.
+func RemoveFilterForwardIntraInterfaceRules(v, tableName, chainName, intfName string) error {
+	return SetFilterForwardIntraInterfaceRules(v, tableName, chainName, intfName, "allow", nil)
+}
+
+// SetBridgeIntraInterfaceRules replaces the rules restricting the traffic
+// between the ports of a bridge in a chain of a bridge family table. The
+// rules apply to the frames bypassing br_netfilter. The non-IP frames,
+// e.g. ARP, are accepted. In allow mode, the function removes the rules.
+// In deny mode, the rules look like:
+//
+//	meta ibrname "<intfName>" meta obrname "<intfName>" meta protocol ip counter drop
+//	meta ibrname "<intfName>" meta obrname "<intfName>" meta protocol ip6 counter drop
+func SetBridgeIntraInterfaceRules(tableName, chainName, intfName, mode string, peers []string) error {
+	exists, err := IsBridgeChainExists(tableName, chainName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if mode == "allow" {
+			return nil
+		}
+		if err := CreateBridgeChain(tableName, chainName, "forward"); err != nil {
+			return err
+		}
+	}
+
+	rules := [][]expr.Any{}
+	if mode != "allow" {
+		for _, v := range []string{"4", "6"} {
+			etherType := uint16(unix.ETH_P_IP)
+			if v == "6" {
+				etherType = unix.ETH_P_IPV6
+			}
+			match := []expr.Any{
+				&expr.Meta{Key: expr.MetaKeyBRIIIFNAME, Register: 1},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(intfName)},
+				&expr.Meta{Key: expr.MetaKeyBRIOIFNAME, Register: 1},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(intfName)},
+				&expr.Meta{Key: expr.MetaKeyPROTOCOL, Register: 1},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.BigEndian.PutUint16(etherType)},
+			}
+			rules = append(rules, getIntraInterfaceRules(v, mode, peers, match)...)
+		}
+	}
+	return setBridgeRulesByComment(tableName, chainName, getIntraInterfaceRuleComment(intfName), rules)
+}
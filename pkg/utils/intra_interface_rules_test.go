@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateIntraInterfaceMode(t *testing.T) {
+	testcases := []struct {
+		name      string
+		mode      string
+		peers     []string
+		shouldErr bool
+	}{
+		{name: "allow", mode: "allow"},
+		{name: "deny", mode: "deny"},
+		{name: "peers", mode: "peers", peers: []string{"10.88.0.1", "fd00::/64"}},
+		{name: "peers without peers mode", mode: "deny", peers: []string{"10.88.0.1"}, shouldErr: true},
+		{name: "peers mode without peers", mode: "peers", shouldErr: true},
+		{name: "invalid peer", mode: "peers", peers: []string{"10.88.0.300"}, shouldErr: true},
+		{name: "unsupported mode", mode: "isolate", shouldErr: true},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateIntraInterfaceMode(tc.mode, tc.peers)
+			if tc.shouldErr && err == nil {
+				t.Fatal("expected error")
+			}
+			if !tc.shouldErr && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestSetFilterForwardIntraInterfaceRules(t *testing.T) {
+	peers := []string{"10.88.0.1", "fd00::1"}
+	got := getDryRunCommands(t, func() error {
+		for _, v := range []string{"4", "6"} {
+			if err := CreateTable(v, "filter"); err != nil {
+				return err
+			}
+			if err := CreateChain(v, "filter", "forward", "none", "none", "none"); err != nil {
+				return err
+			}
+			if err := SetFilterForwardIntraInterfaceRules(v, "filter", "forward", "cni-podman0", "peers", peers); err != nil {
+				return err
+			}
+		}
+		return SetBridgeIntraInterfaceRules("cni_plugins", "cni-forward", "cni-podman0", "deny", nil)
+	})
+	// The rules are inserted at the top of the chain in the reverse order.
+	want := []string{
+		"add table ip filter",
+		"add chain ip filter forward",
+		`insert rule ip filter forward iifname "cni-podman0" oifname "cni-podman0" counter packets 0 bytes 0 drop comment "cni-intra cni-podman0"`,
+		`insert rule ip filter forward iifname "cni-podman0" oifname "cni-podman0" ip daddr 10.88.0.1 counter packets 0 bytes 0 accept comment "cni-intra cni-podman0"`,
+		`insert rule ip filter forward iifname "cni-podman0" oifname "cni-podman0" ip saddr 10.88.0.1 counter packets 0 bytes 0 accept comment "cni-intra cni-podman0"`,
+		"add table ip6 filter",
+		"add chain ip6 filter forward",
+		`insert rule ip6 filter forward iifname "cni-podman0" oifname "cni-podman0" counter packets 0 bytes 0 drop comment "cni-intra cni-podman0"`,
+		`insert rule ip6 filter forward iifname "cni-podman0" oifname "cni-podman0" meta l4proto icmpv6 @th,0,8 >= 0x85 @th,0,8 <= 0x88 counter packets 0 bytes 0 accept comment "cni-intra cni-podman0"`,
+		`insert rule ip6 filter forward iifname "cni-podman0" oifname "cni-podman0" ip6 daddr fd00::1 counter packets 0 bytes 0 accept comment "cni-intra cni-podman0"`,
+		`insert rule ip6 filter forward iifname "cni-podman0" oifname "cni-podman0" ip6 saddr fd00::1 counter packets 0 bytes 0 accept comment "cni-intra cni-podman0"`,
+		"add table bridge cni_plugins",
+		"add chain bridge cni_plugins cni-forward { type filter hook forward priority 0; policy accept; }",
+		`add rule bridge cni_plugins cni-forward meta ibrname "cni-podman0" meta obrname "cni-podman0" meta protocol ip counter packets 0 bytes 0 drop comment "cni-intra cni-podman0"`,
+		`add rule bridge cni_plugins cni-forward meta ibrname "cni-podman0" meta obrname "cni-podman0" meta protocol ip6 counter packets 0 bytes 0 drop comment "cni-intra cni-podman0"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected rules\ngot:\n%#v\nwant:\n%#v", got, want)
+	}
+}
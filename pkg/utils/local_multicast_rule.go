@@ -81,21 +81,29 @@ func addPostRoutingLocalMulticastRule(opts map[string]interface{}) error {
 		})
 	}
 
-	// destination XXXX for IPv6
 	if addr.Version == "6" {
-		// payload load 4b @ network header + 16 => reg 1
-		// cmp eq reg 1 0xc8c8a8c0
+		// match ip6 destination ff02::/16 for IPv6
+		//
+		// payload load 16b @ network header + 24 => reg 1
+		// bitwise reg 1 = (reg=1 & 0x0000ffff ... ) ^ 0x00000000 ...
+		// cmp eq reg 1 0x000002ff ...
 		r.Exprs = append(r.Exprs, &expr.Payload{
 			DestRegister: 1,
 			Base:         expr.PayloadBaseNetworkHeader,
-			// Offset:       8,
-			Offset: 24,
-			Len:    16,
+			Offset:       24,
+			Len:          16,
+		})
+		r.Exprs = append(r.Exprs, &expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            16,
+			Mask:           net.CIDRMask(16, 128),
+			Xor:            make([]byte, 16),
 		})
 		r.Exprs = append(r.Exprs, &expr.Cmp{
 			Op:       expr.CmpOpEq,
 			Register: 1,
-			Data:     addr.Address.IP.To16(),
+			Data:     net.ParseIP("ff02::").To16(),
 		})
 	} else {
 		// match ip destination 224.0.0.0/24 for IPv4
@@ -131,8 +139,8 @@ func addPostRoutingLocalMulticastRule(opts map[string]interface{}) error {
 	conn.AddRule(r)
 	if err := conn.Flush(); err != nil {
 		return fmt.Errorf(
-			"failed adding multicast rule in chain %s of ipv%s %s table for %v: %s",
-			chainName, v, tableName, addr, err,
+			"failed adding multicast rule in chain %s of ipv%s %s table for %v: %s, rule: %s",
+			chainName, v, tableName, addr, err, FormatRuleExprs(v, r.Exprs),
 		)
 	}
 	return nil
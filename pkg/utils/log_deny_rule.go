@@ -2,17 +2,214 @@ package utils
 
 import (
 	"fmt"
+	"strings"
+
 	"github.com/google/nftables"
 	"github.com/google/nftables/expr"
 	"golang.org/x/sys/unix"
 )
 
-func addLogDenyRule(v, tableName, chainName string) error {
+// DefaultDenyLogPrefix is the default log prefix template of the packets
+// denied by the forwarding chain of filter table.
+const DefaultDenyLogPrefix = "ip{version} {chain} drop: "
+
+// denyRuleComment is the comment tagging the rules terminating the
+// forwarding chain of filter table.
+const denyRuleComment = "cni-deny"
+
+// DenyRuleConfig holds the configuration of the rules terminating the
+// forwarding chain of filter table, i.e. the logging of the packets
+// reaching the end of the chain and the verdict for the packets.
+type DenyRuleConfig struct {
+	// Log enables the logging of the denied packets. It is enabled
+	// by default.
+	Log *bool `json:"log,omitempty"`
+	// LogPrefix is the log prefix template. The {version} and {chain}
+	// placeholders are replaced with the IP version and the chain name.
+	LogPrefix string `json:"log_prefix,omitempty"`
+	// LogLevel is the syslog level of the log messages, i.e. emerg,
+	// alert, crit, err, warn, notice, info, debug, or audit.
+	LogLevel string `json:"log_level,omitempty"`
+	// LogGroup is the NFLOG group the packets are sent to instead of
+	// the kernel log. The LogSnaplen is the number of bytes of a packet
+	// copied to the group.
+	LogGroup   *uint16 `json:"log_group,omitempty"`
+	LogSnaplen uint32  `json:"log_snaplen,omitempty"`
+	// LogRate is the number of log messages per LogRateUnit, i.e.
+	// second (default), minute, hour, or day. LogBurst is the number
+	// of log messages allowed to exceed the rate.
+	LogRate     uint64 `json:"log_rate,omitempty"`
+	LogRateUnit string `json:"log_rate_unit,omitempty"`
+	LogBurst    uint32 `json:"log_burst,omitempty"`
+	// Action is the verdict for the denied packets, i.e. drop (default),
+	// reject, or none. The none action leaves the packets to the policy
+	// of the chain.
+	Action string `json:"action,omitempty"`
+}
+
+var logLevels = map[string]expr.LogLevel{
+	"emerg":   expr.LogLevelEmerg,
+	"alert":   expr.LogLevelAlert,
+	"crit":    expr.LogLevelCrit,
+	"err":     expr.LogLevelErr,
+	"warn":    expr.LogLevelWarning,
+	"notice":  expr.LogLevelNotice,
+	"info":    expr.LogLevelInfo,
+	"debug":   expr.LogLevelDebug,
+	"audit":   expr.LogLevelAudit,
+	"warning": expr.LogLevelWarning,
+}
+
+// NewDenyRuleConfig returns the default configuration of the rules
+// terminating the forwarding chain of filter table.
+func NewDenyRuleConfig() *DenyRuleConfig {
+	enabled := true
+	return &DenyRuleConfig{
+		Log:         &enabled,
+		LogPrefix:   DefaultDenyLogPrefix,
+		LogRateUnit: "second",
+		Action:      "drop",
+	}
+}
+
+// ValidateDenyRuleConfig checks the configuration of the rules terminating
+// the forwarding chain of filter table and sets the default values.
+func ValidateDenyRuleConfig(c *DenyRuleConfig) error {
+	if c == nil {
+		return nil
+	}
+	if c.Log == nil {
+		enabled := true
+		c.Log = &enabled
+	}
+	if c.LogPrefix == "" {
+		c.LogPrefix = DefaultDenyLogPrefix
+	}
+	if len(c.LogPrefix) > 127 {
+		return fmt.Errorf("log prefix is longer than 127 characters: %s", c.LogPrefix)
+	}
+	if c.LogLevel != "" {
+		if _, exists := logLevels[c.LogLevel]; !exists {
+			return fmt.Errorf("unsupported log level: %s", c.LogLevel)
+		}
+		if c.LogGroup != nil {
+			return fmt.Errorf("log level and log group are mutually exclusive")
+		}
+	}
+	if c.LogSnaplen > 0 && c.LogGroup == nil {
+		return fmt.Errorf("log snaplen requires log group")
+	}
+	switch c.LogRateUnit {
+	case "":
+		c.LogRateUnit = "second"
+	case "second", "minute", "hour", "day":
+	default:
+		return fmt.Errorf("unsupported log rate unit: %s", c.LogRateUnit)
+	}
+	if c.LogRate == 0 && c.LogBurst > 0 {
+		return fmt.Errorf("log burst %d requires log rate", c.LogBurst)
+	}
+	switch c.Action {
+	case "":
+		c.Action = "drop"
+	case "drop", "reject", "none":
+	default:
+		return fmt.Errorf("unsupported deny action: %s", c.Action)
+	}
+	return nil
+}
+
+// getDenyRules returns the rules terminating the forwarding chain of
+// filter table.
+func getDenyRules(v, chainName string, c *DenyRuleConfig) [][]expr.Any {
+	rules := [][]expr.Any{}
+
+	if *c.Log {
+		r := []expr.Any{}
+		if c.LogRate > 0 {
+			r = append(r, &expr.Limit{
+				Type:  expr.LimitTypePkts,
+				Rate:  c.LogRate,
+				Unit:  getLimitTime(c.LogRateUnit),
+				Burst: c.LogBurst,
+			})
+		}
+		// log prefix "ip4 forward drop: "
+		prefix := strings.NewReplacer("{version}", v, "{chain}", chainName).Replace(c.LogPrefix)
+		e := &expr.Log{
+			Key:  1 << unix.NFTA_LOG_PREFIX,
+			Data: []byte(prefix),
+		}
+		if c.LogLevel != "" {
+			e.Key |= 1 << unix.NFTA_LOG_LEVEL
+			e.Level = logLevels[c.LogLevel]
+		}
+		if c.LogGroup != nil {
+			e.Key |= 1 << unix.NFTA_LOG_GROUP
+			e.Group = *c.LogGroup
+			if c.LogSnaplen > 0 {
+				e.Key |= 1 << unix.NFTA_LOG_SNAPLEN
+				e.Snaplen = c.LogSnaplen
+			}
+		}
+		rules = append(rules, append(r, e))
+	}
+
+	switch c.Action {
+	case "drop":
+		rules = append(rules, []expr.Any{
+			&expr.Counter{},
+			&expr.Verdict{Kind: expr.VerdictDrop},
+		})
+	case "reject":
+		// reject with icmp type admin-prohibited
+		code := uint8(13)
+		if v == "6" {
+			code = 1
+		}
+		rules = append(rules, []expr.Any{
+			&expr.Counter{},
+			&expr.Reject{Type: unix.NFT_REJECT_ICMP_UNREACH, Code: code},
+		})
+	}
+	return rules
+}
+
+// SetFilterForwardDenyRules replaces the rules terminating the forwarding
+// chain of filter table, i.e. the logging and the verdict of the packets
+// reaching the end of the chain. The rules are appended to the chain. When
+// the configuration is nil, the rules follow the default configuration.
+// The rules look like:
+//
+//	limit rate 10/minute log prefix "ip4 forward drop: " level warn
+//	counter drop
+func SetFilterForwardDenyRules(v, tableName, chainName string, c *DenyRuleConfig) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+	if c == nil {
+		c = NewDenyRuleConfig()
+	}
+
+	chainProps, err := GetChainProps(v, tableName, chainName)
+	if err != nil {
+		return err
+	}
+
 	conn, err := initNftConn()
 	if err != nil {
 		return err
 	}
 
+	for _, r := range chainProps.Rules {
+		if !isDenyRule(r) {
+			continue
+		}
+		if err := conn.DelRule(r); err != nil {
+			return err
+		}
+	}
+
 	tb := &nftables.Table{
 		Name: tableName,
 	}
@@ -27,42 +224,18 @@ func addLogDenyRule(v, tableName, chainName string) error {
 		Table: tb,
 	}
 
-	// log prefix "ipv6 input drop: " flags all
-	prefix := fmt.Sprintf("ip%s forward drop: ", v)
-
-	r := &nftables.Rule{
-		Table: tb,
-		Chain: ch,
-		Exprs: []expr.Any{},
-	}
-
-	r.Exprs = append(r.Exprs, &expr.Log{
-		Key:  unix.NFTA_LOG_PREFIX,
-		Data: []byte(prefix),
-	})
-
-	conn.AddRule(r)
-	if err := conn.Flush(); err != nil {
-		return fmt.Errorf(
-			"failed adding default logging rule in chain %s of ipv%s %s table: %s",
-			chainName, v, tableName, err,
-		)
-	}
-
-	r = &nftables.Rule{
-		Table: tb,
-		Chain: ch,
-		Exprs: []expr.Any{},
+	for _, exprs := range getDenyRules(v, chainName, c) {
+		conn.AddRule(&nftables.Rule{
+			Table:    tb,
+			Chain:    ch,
+			UserData: EncodeRuleComment(denyRuleComment),
+			Exprs:    exprs,
+		})
 	}
-	r.Exprs = append(r.Exprs, &expr.Counter{})
-	r.Exprs = append(r.Exprs, &expr.Verdict{
-		Kind: expr.VerdictDrop,
-	})
 
-	conn.AddRule(r)
 	if err := conn.Flush(); err != nil {
 		return fmt.Errorf(
-			"failed adding default deny with counter rule in chain %s of ipv%s %s table: %s",
+			"failed setting default deny rules in chain %s of ipv%s %s table: %s",
 			chainName, v, tableName, err,
 		)
 	}
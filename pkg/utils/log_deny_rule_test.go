@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetDenyRules(t *testing.T) {
+	disabled := false
+	group := uint16(5)
+	testcases := []struct {
+		name      string
+		version   string
+		config    *DenyRuleConfig
+		want      []string
+		shouldErr bool
+	}{
+		{
+			name:    "default",
+			version: "4",
+			config:  &DenyRuleConfig{},
+			want: []string{
+				`log prefix "ip4 forward drop: "`,
+				"counter packets 0 bytes 0 drop",
+			},
+		},
+		{
+			name:    "rate limited log with level and reject",
+			version: "6",
+			config:  &DenyRuleConfig{LogRate: 10, LogRateUnit: "minute", LogBurst: 5, LogLevel: "warn", Action: "reject"},
+			want: []string{
+				`limit rate 10/minute burst 5 packets log prefix "ip6 forward drop: " level warn`,
+				"counter packets 0 bytes 0 reject with icmpv6 type admin-prohibited",
+			},
+		},
+		{
+			name:    "log group with snaplen and no verdict",
+			version: "4",
+			config:  &DenyRuleConfig{LogPrefix: "{chain} v{version}: ", LogGroup: &group, LogSnaplen: 64, Action: "none"},
+			want: []string{
+				`log prefix "forward v4: " group 5 snaplen 64`,
+			},
+		},
+		{
+			name:    "no log",
+			version: "4",
+			config:  &DenyRuleConfig{Log: &disabled},
+			want: []string{
+				"counter packets 0 bytes 0 drop",
+			},
+		},
+		{
+			name:      "log level and log group",
+			config:    &DenyRuleConfig{LogLevel: "info", LogGroup: &group},
+			shouldErr: true,
+		},
+		{
+			name:      "snaplen without log group",
+			config:    &DenyRuleConfig{LogSnaplen: 64},
+			shouldErr: true,
+		},
+		{
+			name:      "burst without rate",
+			config:    &DenyRuleConfig{LogBurst: 5},
+			shouldErr: true,
+		},
+		{
+			name:      "unsupported action",
+			config:    &DenyRuleConfig{Action: "return"},
+			shouldErr: true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateDenyRuleConfig(tc.config)
+			if tc.shouldErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			got := []string{}
+			for _, exprs := range getDenyRules(tc.version, "forward", tc.config) {
+				got = append(got, FormatRuleExprs(tc.version, exprs))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("unexpected rules\ngot:\n%#v\nwant:\n%#v", got, tc.want)
+			}
+		})
+	}
+}
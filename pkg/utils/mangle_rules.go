@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+)
+
+// ctDirectionReply is the direction of the packets of a connection going
+// to the originator of the connection.
+const ctDirectionReply = 1
+
+// ValidateDSCP checks a DSCP value, i.e. 0 to 63.
+func ValidateDSCP(dscp *uint8) error {
+	if dscp != nil && *dscp > 63 {
+		return fmt.Errorf("%d is out of range 0-63", *dscp)
+	}
+	return nil
+}
+
+// getSetDSCPExprs returns the exprs setting the DSCP of the packets. The
+// DSCP is the upper six bits of the second byte of IPv4 header and the
+// bits 4 to 9 of the first two bytes of IPv6 header.
+func getSetDSCPExprs(v string, dscp uint8) []expr.Any {
+	if v == "4" {
+		// payload load 1b @ network header + 1 => reg 1
+		// bitwise reg 1 = (reg=1 & 0x03 ) ^ <dscp << 2>
+		// payload write reg 1 => 1b @ network header + 1 csum_type 1 csum_off 10
+		return []expr.Any{
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 1, Len: 1},
+			&expr.Bitwise{
+				SourceRegister: 1,
+				DestRegister:   1,
+				Len:            1,
+				Mask:           []byte{0x03},
+				Xor:            []byte{dscp << 2},
+			},
+			&expr.Payload{
+				OperationType:  expr.PayloadWrite,
+				SourceRegister: 1,
+				Base:           expr.PayloadBaseNetworkHeader,
+				Offset:         1,
+				Len:            1,
+				CsumType:       expr.CsumTypeInet,
+				CsumOffset:     10,
+			},
+		}
+	}
+	// payload load 2b @ network header + 0 => reg 1
+	// bitwise reg 1 = (reg=1 & 0xf03f ) ^ <dscp << 6>
+	// payload write reg 1 => 2b @ network header + 0
+	return []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 0, Len: 2},
+		&expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            2,
+			Mask:           []byte{0xf0, 0x3f},
+			Xor:            binaryutil.BigEndian.PutUint16(uint16(dscp) << 6),
+		},
+		&expr.Payload{
+			OperationType:  expr.PayloadWrite,
+			SourceRegister: 1,
+			Base:           expr.PayloadBaseNetworkHeader,
+			Offset:         0,
+			Len:            2,
+		},
+	}
+}
+
+// SetManglePreRoutingRules replaces the rules of the chain of a container
+// marking the traffic from the container and setting its DSCP. The mark
+// is saved in the connection and restored on the replies to the container.
+// The rules look like:
+//
+//	iifname "<intfName>" ip saddr <addr> meta mark set 0x10 ct mark set 0x10
+//	ip daddr <addr> ct direction reply meta mark set ct mark
+//	iifname "<intfName>" ip saddr <addr> ip dscp set 0x2e
+func SetManglePreRoutingRules(v, tableName, chainName, intfName string, addrs []net.IP, mark *uint32, dscp *uint8) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+
+	tb := getFlowtableTable(v, tableName)
+	ch := &nftables.Chain{
+		Name:  chainName,
+		Table: tb,
+	}
+	conn.FlushChain(ch)
+
+	addRule := func(exprs ...[]expr.Any) {
+		r := &nftables.Rule{
+			Table: tb,
+			Chain: ch,
+			Exprs: []expr.Any{},
+		}
+		for _, e := range exprs {
+			r.Exprs = append(r.Exprs, e...)
+		}
+		conn.AddRule(r)
+	}
+
+	for _, ip := range addrs {
+		if (ip.To4() != nil) != (v == "4") {
+			continue
+		}
+		host := &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}
+		if v == "6" {
+			host = &net.IPNet{IP: ip.To16(), Mask: net.CIDRMask(128, 128)}
+		}
+		fromContainer := []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(intfName)},
+		}
+		fromContainer = append(fromContainer, IPSaddrPrefixMatch(v, host)...)
+
+		if mark != nil {
+			addRule(fromContainer, []expr.Any{
+				&expr.Immediate{Register: 1, Data: binaryutil.NativeEndian.PutUint32(*mark)},
+				&expr.Meta{Key: expr.MetaKeyMARK, SourceRegister: true, Register: 1},
+				&expr.Ct{Key: expr.CtKeyMARK, SourceRegister: true, Register: 1},
+			})
+			addRule(IPDaddrPrefixMatch(v, host), []expr.Any{
+				// ct direction reply
+				&expr.Ct{Key: expr.CtKeyDIRECTION, Register: 1},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{ctDirectionReply}},
+				&expr.Ct{Key: expr.CtKeyMARK, Register: 1},
+				&expr.Meta{Key: expr.MetaKeyMARK, SourceRegister: true, Register: 1},
+			})
+		}
+		if dscp != nil {
+			addRule(fromContainer, getSetDSCPExprs(v, *dscp))
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed setting mangle rules in chain %s of ipv%s %s table: %s",
+			chainName, v, tableName, err,
+		)
+	}
+	return nil
+}
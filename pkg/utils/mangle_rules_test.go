@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestValidateDSCP(t *testing.T) {
+	valid, invalid := uint8(63), uint8(64)
+	testcases := []struct {
+		name      string
+		dscp      *uint8
+		shouldErr bool
+	}{
+		{name: "unset"},
+		{name: "valid", dscp: &valid},
+		{name: "invalid", dscp: &invalid, shouldErr: true},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateDSCP(tc.dscp)
+			if (err != nil) != tc.shouldErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestSetManglePreRoutingRules(t *testing.T) {
+	mark, dscp := uint32(0x10), uint8(46)
+	addrs := []net.IP{net.ParseIP("10.88.0.5"), net.ParseIP("fd00::5")}
+	testcases := []struct {
+		name string
+		v    string
+		want []string
+	}{
+		{
+			name: "ipv4",
+			v:    "4",
+			want: []string{
+				"add table ip mangle",
+				"add chain ip mangle cni-mgl-test",
+				"flush chain ip mangle cni-mgl-test",
+				"add rule ip mangle cni-mgl-test iifname \"veth0\" ip saddr 10.88.0.5 meta mark set 0x00000010 ct mark set 0x00000010",
+				"add rule ip mangle cni-mgl-test ip daddr 10.88.0.5 ct direction reply meta mark set ct mark",
+				"add rule ip mangle cni-mgl-test iifname \"veth0\" ip saddr 10.88.0.5 ip dscp set 46",
+			},
+		},
+		{
+			name: "ipv6",
+			v:    "6",
+			want: []string{
+				"add table ip6 mangle",
+				"add chain ip6 mangle cni-mgl-test",
+				"flush chain ip6 mangle cni-mgl-test",
+				"add rule ip6 mangle cni-mgl-test iifname \"veth0\" ip6 saddr fd00::5 meta mark set 0x00000010 ct mark set 0x00000010",
+				"add rule ip6 mangle cni-mgl-test ip6 daddr fd00::5 ct direction reply meta mark set ct mark",
+				"add rule ip6 mangle cni-mgl-test iifname \"veth0\" ip6 saddr fd00::5 ip6 dscp set 46",
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := getDryRunCommands(t, func() error {
+				if err := CreateTable(tc.v, "mangle"); err != nil {
+					return err
+				}
+				if err := CreateChain(tc.v, "mangle", "cni-mgl-test", "none", "none", "none"); err != nil {
+					return err
+				}
+				return SetManglePreRoutingRules(tc.v, "mangle", "cni-mgl-test", "veth0", addrs, &mark, &dscp)
+			})
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("unexpected rules\ngot:\n%#v\nwant:\n%#v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/google/nftables/expr"
+)
+
+// MappingLimit holds the rate and connection limits of a published port.
+type MappingLimit struct {
+	// Rate is the number of packets or new connections per unit of time
+	// allowed to reach the port.
+	Rate uint64 `json:"rate,omitempty"`
+	// Unit is the unit of time of the rate, i.e. second (default),
+	// minute, hour, or day.
+	Unit string `json:"unit,omitempty"`
+	// Burst is the number of packets or new connections allowed to
+	// exceed the rate.
+	Burst uint32 `json:"burst,omitempty"`
+	// Per is what the rate applies to, i.e. connection (default)
+	// or packet.
+	Per string `json:"per,omitempty"`
+	// MaxConnections is the maximum number of concurrent connections
+	// to the port.
+	MaxConnections uint32 `json:"maxConnections,omitempty"`
+}
+
+// ValidateMappingLimit checks the limits of a published port and sets
+// the default values.
+func ValidateMappingLimit(l *MappingLimit) error {
+	if l == nil {
+		return nil
+	}
+	switch l.Unit {
+	case "":
+		l.Unit = "second"
+	case "second", "minute", "hour", "day":
+	default:
+		return fmt.Errorf("unsupported limit unit: %s", l.Unit)
+	}
+	switch l.Per {
+	case "":
+		l.Per = "connection"
+	case "connection", "packet":
+	default:
+		return fmt.Errorf("unsupported limit type: %s", l.Per)
+	}
+	if l.Rate == 0 && l.Burst > 0 {
+		return fmt.Errorf("limit burst %d requires rate", l.Burst)
+	}
+	return nil
+}
+
+func getLimitTime(unit string) expr.LimitTime {
+	switch unit {
+	case "minute":
+		return expr.LimitTimeMinute
+	case "hour":
+		return expr.LimitTimeHour
+	case "day":
+		return expr.LimitTimeDay
+	}
+	return expr.LimitTimeSecond
+}
+
+// ctStateNewMatch returns the nftables exprs for `ct state new`.
+func ctStateNewMatch() []expr.Any {
+	return []expr.Any{
+		&expr.Ct{Register: 1, Key: expr.CtKeySTATE},
+		&expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            4,
+			Mask:           []byte("\x08\x00\x00\x00"),
+			Xor:            []byte{0x0, 0x0, 0x0, 0x0},
+		},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: []byte{0x0, 0x0, 0x0, 0x0}},
+	}
+}
+
+// MappingLimitDropRules returns the trailing exprs of the rules dropping
+// the traffic to a published port over its limits. The exprs follow the
+// matches of the port. The rules look like:
+//
+//	ct state new limit rate over 10/second burst 20 packets counter drop
+//	ct state new ct count over 100 counter drop
+//	limit rate over 1000/second burst 100 packets counter drop
+//
+// A NAT chain sees the first packet of a connection only. Therefore, the
+// connection limits go to the destination NAT rules of the port, and the
+// packet rate limit goes to the forward rules of the port.
+func MappingLimitDropRules(l *MappingLimit, chainType string) [][]expr.Any {
+	rules := [][]expr.Any{}
+	if l == nil {
+		return rules
+	}
+
+	if l.Rate > 0 && ((l.Per == "connection" && chainType == "nat") || (l.Per == "packet" && chainType == "filter")) {
+		exprs := []expr.Any{}
+		if l.Per == "connection" {
+			exprs = append(exprs, ctStateNewMatch()...)
+		}
+		exprs = append(exprs, &expr.Limit{
+			Type:  expr.LimitTypePkts,
+			Rate:  l.Rate,
+			Over:  true,
+			Unit:  getLimitTime(l.Unit),
+			Burst: l.Burst,
+		})
+		exprs = append(exprs, &expr.Counter{})
+		exprs = append(exprs, &expr.Verdict{Kind: expr.VerdictDrop})
+		rules = append(rules, exprs)
+	}
+
+	if l.MaxConnections > 0 && chainType == "nat" {
+		exprs := ctStateNewMatch()
+		exprs = append(exprs, &expr.Connlimit{
+			Count: l.MaxConnections,
+			Flags: expr.NFT_CONNLIMIT_F_INV,
+		})
+		exprs = append(exprs, &expr.Counter{})
+		exprs = append(exprs, &expr.Verdict{Kind: expr.VerdictDrop})
+		rules = append(rules, exprs)
+	}
+	return rules
+}
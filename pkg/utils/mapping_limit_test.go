@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateMappingLimit(t *testing.T) {
+	var tests = []struct {
+		name      string
+		limit     *MappingLimit
+		want      *MappingLimit
+		shouldErr bool
+	}{
+		{
+			name: "no limit",
+		},
+		{
+			name:  "defaults",
+			limit: &MappingLimit{Rate: 10},
+			want:  &MappingLimit{Rate: 10, Unit: "second", Per: "connection"},
+		},
+		{
+			name:  "packet rate per minute",
+			limit: &MappingLimit{Rate: 600, Unit: "minute", Burst: 20, Per: "packet"},
+			want:  &MappingLimit{Rate: 600, Unit: "minute", Burst: 20, Per: "packet"},
+		},
+		{
+			name:  "connections only",
+			limit: &MappingLimit{MaxConnections: 100},
+			want:  &MappingLimit{Unit: "second", Per: "connection", MaxConnections: 100},
+		},
+		{
+			name:      "unsupported unit",
+			limit:     &MappingLimit{Rate: 10, Unit: "week"},
+			shouldErr: true,
+		},
+		{
+			name:      "unsupported type",
+			limit:     &MappingLimit{Rate: 10, Per: "byte"},
+			shouldErr: true,
+		},
+		{
+			name:      "burst without rate",
+			limit:     &MappingLimit{Burst: 5},
+			shouldErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateMappingLimit(test.limit)
+			if test.shouldErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", test.limit)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(test.limit, test.want) {
+				t.Fatalf("got %+v, want %+v", test.limit, test.want)
+			}
+		})
+	}
+}
+
+func TestMappingLimitDropRules(t *testing.T) {
+	var tests = []struct {
+		name      string
+		limit     *MappingLimit
+		chainType string
+		want      []string
+	}{
+		{
+			name:      "no limit",
+			chainType: "nat",
+			want:      []string{},
+		},
+		{
+			name:      "connection rate and count in nat chain",
+			limit:     &MappingLimit{Rate: 10, Unit: "second", Burst: 5, Per: "connection", MaxConnections: 100},
+			chainType: "nat",
+			want: []string{
+				"ct state new limit rate over 10/second burst 5 packets counter packets 0 bytes 0 drop",
+				"ct state new ct count over 100 counter packets 0 bytes 0 drop",
+			},
+		},
+		{
+			name:      "connection limits not in filter chain",
+			limit:     &MappingLimit{Rate: 10, Unit: "second", Per: "connection", MaxConnections: 100},
+			chainType: "filter",
+			want:      []string{},
+		},
+		{
+			name:      "packet rate in filter chain",
+			limit:     &MappingLimit{Rate: 1000, Unit: "minute", Per: "packet", MaxConnections: 100},
+			chainType: "filter",
+			want: []string{
+				"limit rate over 1000/minute counter packets 0 bytes 0 drop",
+			},
+		},
+		{
+			name:      "packet rate not in nat chain",
+			limit:     &MappingLimit{Rate: 1000, Unit: "minute", Per: "packet"},
+			chainType: "nat",
+			want:      []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := []string{}
+			for _, exprs := range MappingLimitDropRules(test.limit, test.chainType) {
+				got = append(got, FormatRuleExprs("4", exprs))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
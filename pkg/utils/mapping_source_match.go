@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/google/nftables/expr"
+)
+
+// ParseMappingSource parses a source address or network allowed to reach
+// a published port, e.g. 192.168.100.0/24, 10.0.0.1, or 2001:db8::/32.
+func ParseMappingSource(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source address: %s", s)
+		}
+		if ip.To4() != nil {
+			return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip.To16(), Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, prefix, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source network: %s", s)
+	}
+	return prefix, nil
+}
+
+// IPSaddrPrefixMatch returns the nftables exprs required for matching the
+// provided IPv4 or IPv6 network as source address.
+func IPSaddrPrefixMatch(v string, prefix *net.IPNet) []expr.Any {
+	if v == "6" {
+		return ipPrefixMatch(v, prefix, 8)
+	}
+	return ipPrefixMatch(v, prefix, 12)
+}
+
+// IPDaddrPrefixMatch returns the nftables exprs required for matching the
+// provided IPv4 or IPv6 network as destination address.
+func IPDaddrPrefixMatch(v string, prefix *net.IPNet) []expr.Any {
+	if v == "6" {
+		return ipPrefixMatch(v, prefix, 24)
+	}
+	return ipPrefixMatch(v, prefix, 16)
+}
+
+func ipPrefixMatch(v string, prefix *net.IPNet, offset uint32) []expr.Any {
+	var addrLen uint32
+	var ip net.IP
+	if v == "6" {
+		addrLen = 16
+		ip = prefix.IP.To16()
+	} else {
+		addrLen = 4
+		ip = prefix.IP.To4()
+	}
+
+	mask := []byte(prefix.Mask)
+	if len(mask) != int(addrLen) {
+		ones, _ := prefix.Mask.Size()
+		mask = net.CIDRMask(ones, int(addrLen)*8)
+	}
+
+	// payload load 4b @ network header + 12 => reg 1
+	exprs := []expr.Any{
+		&expr.Payload{
+			DestRegister: 1,
+			Base:         expr.PayloadBaseNetworkHeader,
+			Offset:       offset,
+			Len:          addrLen,
+		},
+	}
+
+	// bitwise reg 1 = (reg=1 & 0x00ffffff ) ^ 0x00000000
+	if ones, bits := net.IPMask(mask).Size(); ones != bits {
+		exprs = append(exprs, &expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            addrLen,
+			Mask:           mask,
+			Xor:            make([]byte, addrLen),
+		})
+	}
+
+	// cmp eq reg 1 0x0064a8c0
+	exprs = append(exprs, &expr.Cmp{
+		Op:       expr.CmpOpEq,
+		Register: 1,
+		Data:     ip.Mask(mask),
+	})
+	return exprs
+}
+
+// MappingSourceMatches returns the source address matches of the rules
+// publishing a port. A port without allowed sources is reachable from any
+// source, i.e. the function returns a single empty match. Otherwise, the
+// function returns a match per allowed source of the provided IP version.
+// When none of the allowed sources is of the provided IP version, the port
+// is not published for the version, i.e. the function returns no matches.
+func MappingSourceMatches(v string, pm MappingEntry) ([][]expr.Any, error) {
+	if len(pm.AllowedSources) == 0 {
+		return [][]expr.Any{{}}, nil
+	}
+	matches := [][]expr.Any{}
+	for _, s := range pm.AllowedSources {
+		prefix, err := ParseMappingSource(s)
+		if err != nil {
+			return nil, err
+		}
+		if (prefix.IP.To4() != nil) != (v == "4") {
+			continue
+		}
+		matches = append(matches, IPSaddrPrefixMatch(v, prefix))
+	}
+	return matches, nil
+}
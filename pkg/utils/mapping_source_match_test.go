@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMappingSource(t *testing.T) {
+	var tests = []struct {
+		input     string
+		want      string
+		shouldErr bool
+	}{
+		{input: "192.168.100.0/24", want: "192.168.100.0/24"},
+		{input: "192.168.100.7/24", want: "192.168.100.0/24"},
+		{input: "10.0.0.1", want: "10.0.0.1/32"},
+		{input: "2001:db8::/32", want: "2001:db8::/32"},
+		{input: "2001:db8::1", want: "2001:db8::1/128"},
+		{input: "10.0.0.256", shouldErr: true},
+		{input: "2001:db8::/129", shouldErr: true},
+		{input: "example.com", shouldErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			prefix, err := ParseMappingSource(test.input)
+			if test.shouldErr {
+				if err == nil {
+					t.Fatalf("expected error, got %s", prefix)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if prefix.String() != test.want {
+				t.Fatalf("got %s, want %s", prefix, test.want)
+			}
+		})
+	}
+}
+
+func TestMappingSourceMatches(t *testing.T) {
+	var tests = []struct {
+		name    string
+		v       string
+		sources []string
+		want    []string
+	}{
+		{
+			name: "any source",
+			v:    "4",
+			want: []string{""},
+		},
+		{
+			name:    "ipv4 sources",
+			v:       "4",
+			sources: []string{"192.168.100.0/24", "10.0.0.1", "2001:db8::/32"},
+			want:    []string{"ip saddr 192.168.100.0/24", "ip saddr 10.0.0.1"},
+		},
+		{
+			name:    "ipv6 sources",
+			v:       "6",
+			sources: []string{"192.168.100.0/24", "2001:db8::/32"},
+			want:    []string{"ip6 saddr 2001:db8::/32"},
+		},
+		{
+			name:    "no source of the version",
+			v:       "6",
+			sources: []string{"192.168.100.0/24"},
+			want:    []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matches, err := MappingSourceMatches(test.v, MappingEntry{AllowedSources: test.sources})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			got := []string{}
+			for _, match := range matches {
+				got = append(got, FormatRuleExprs(test.v, match))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
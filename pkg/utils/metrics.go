@@ -0,0 +1,476 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// TrafficSample holds the packets and the bytes counted by the rules of the
+// chain of a container having the same labels.
+type TrafficSample struct {
+	ContainerID string
+	Network     string
+	Family      string
+	Direction   string
+	Action      string
+	Protocol    string
+	HostPort    int
+	Packets     uint64
+	Bytes       uint64
+}
+
+func (s *TrafficSample) key() string {
+	return strings.Join([]string{
+		s.ContainerID, s.Network, s.Family, s.Direction, s.Action, s.Protocol, fmt.Sprint(s.HostPort),
+	}, "\x00")
+}
+
+// QuotaSample holds the state of the quota of a container.
+type QuotaSample struct {
+	ContainerID string
+	Network     string
+	Family      string
+	Bytes       uint64
+	Consumed    uint64
+	Depleted    bool
+}
+
+// ContainerMetrics holds the traffic and the quotas of the containers.
+type ContainerMetrics struct {
+	Traffic []*TrafficSample
+	Quotas  []*QuotaSample
+}
+
+var l4ProtocolNames = map[byte]string{
+	unix.IPPROTO_ICMP:   "icmp",
+	unix.IPPROTO_TCP:    "tcp",
+	unix.IPPROTO_UDP:    "udp",
+	unix.IPPROTO_ICMPV6: "icmpv6",
+	unix.IPPROTO_SCTP:   "sctp",
+}
+
+// getRuleTrafficSample returns the labels and the values of the counter of
+// a rule of the chain of a container, or nil when the rule counts no
+// accepted, dropped, or translated traffic.
+func getRuleTrafficSample(r *nftables.Rule, counters map[string]*NamedCounter) *TrafficSample {
+	s := &TrafficSample{Protocol: "any"}
+	counted := false
+	var iif, oif bool
+	for i, e := range r.Exprs {
+		switch x := e.(type) {
+		case *expr.Meta:
+			if i+1 >= len(r.Exprs) {
+				continue
+			}
+			c, ok := r.Exprs[i+1].(*expr.Cmp)
+			if !ok || c.Op != expr.CmpOpEq {
+				continue
+			}
+			switch x.Key {
+			case expr.MetaKeyIIFNAME:
+				iif = true
+			case expr.MetaKeyOIFNAME:
+				oif = true
+			case expr.MetaKeyL4PROTO:
+				if len(c.Data) != 1 {
+					continue
+				}
+				if name, exists := l4ProtocolNames[c.Data[0]]; exists {
+					s.Protocol = name
+				}
+			}
+		case *expr.Counter:
+			s.Packets, s.Bytes = x.Packets, x.Bytes
+			counted = true
+		case *expr.Objref:
+			if c, exists := counters[x.Name]; exists && x.Type == nftObjectCounter {
+				s.Packets, s.Bytes = c.Packets, c.Bytes
+				counted = true
+			}
+		case *expr.Verdict:
+			switch x.Kind {
+			case expr.VerdictAccept:
+				s.Action = "accept"
+			case expr.VerdictDrop:
+				s.Action = "drop"
+			}
+		case *expr.Reject:
+			s.Action = "reject"
+		case *expr.NAT:
+			if x.Type == expr.NATTypeDestNAT {
+				s.Action = "dnat"
+			}
+		}
+	}
+	if !counted || s.Action == "" {
+		return nil
+	}
+
+	switch {
+	case s.Action == "dnat":
+		// The destination NAT rules match the traffic to the host ports
+		// not coming from the container.
+		s.Direction = "ingress"
+		if pm, ok := getDestinationNatRulePort(r); ok {
+			s.HostPort = pm.HostPort
+		}
+	case iif && oif:
+		s.Direction = "intra"
+	case iif:
+		s.Direction = "egress"
+	case oif:
+		s.Direction = "ingress"
+	default:
+		s.Direction = "any"
+	}
+	return s
+}
+
+// GetContainerMetrics returns the traffic counted by the rules of the
+// chains of the containers in the IPv4 and IPv6 tables having the provided
+// name, i.e. the chains named cni-<prefix>-<id>, and the quotas of the
+// containers in the tables.
+func GetContainerMetrics(tableName string, prefixes []string) (*ContainerMetrics, error) {
+	m := &ContainerMetrics{
+		Traffic: []*TrafficSample{},
+		Quotas:  []*QuotaSample{},
+	}
+	for _, v := range []string{"4", "6"} {
+		exists, err := IsTableExist(v, tableName)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		samples, quotas, err := getContainerTraffic(v, tableName, prefixes)
+		if err != nil {
+			return nil, err
+		}
+		m.Traffic = append(m.Traffic, samples...)
+		m.Quotas = append(m.Quotas, quotas...)
+	}
+	return m, nil
+}
+
+// getContainerTraffic returns the traffic counted by the rules of the
+// chains of the containers in a table, and the quotas of the containers
+// having such chains. The ID and the network of a container are found in
+// the comment of the jump rules to the chain of the container. Without
+// such a rule, the ID is the one in the name of the chain.
+func getContainerTraffic(v, tableName string, prefixes []string) ([]*TrafficSample, []*QuotaSample, error) {
+	if err := isSupportedIPVersion(v); err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return nil, nil, err
+	}
+	chains, err := conn.ListChains()
+	if err != nil {
+		return nil, nil, err
+	}
+	family := getFlowtableTable(v, tableName).Family
+
+	namedCounters, err := ListCounters(v, tableName)
+	if err != nil {
+		return nil, nil, err
+	}
+	counters := map[string]*NamedCounter{}
+	for _, c := range namedCounters {
+		counters[c.Name] = c
+	}
+
+	namedQuotas, err := ListQuotas(v, tableName)
+	if err != nil {
+		return nil, nil, err
+	}
+	quotas := map[string]*Quota{}
+	for _, q := range namedQuotas {
+		quotas[q.Name] = q
+	}
+
+	containers := map[string]*ContainerInfo{}
+	containerChains := map[string]*ContainerInfo{}
+	for _, ch := range chains {
+		if ch == nil || ch.Table.Name != tableName || ch.Table.Family != family {
+			continue
+		}
+		for _, prefix := range prefixes {
+			if id := strings.TrimPrefix(ch.Name, "cni-"+prefix+"-"); id != ch.Name {
+				containerChains[ch.Name] = &ContainerInfo{ID: id}
+			}
+		}
+		chainProps, err := GetChainProps(v, tableName, ch.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, r := range chainProps.Rules {
+			info := parseContainerInfoComment(DecodeRuleComment(r.UserData))
+			if info == nil {
+				continue
+			}
+			for _, e := range r.Exprs {
+				if x, ok := e.(*expr.Verdict); ok && x.Kind == expr.VerdictJump {
+					containers[x.Chain] = info
+				}
+			}
+		}
+	}
+
+	samples := map[string]*TrafficSample{}
+	quotaSamples := []*QuotaSample{}
+	for chainName, info := range containerChains {
+		// The key of the quota of a container is the one in the name of
+		// its chains.
+		quotaName := quotaNamePrefix + info.ID
+		if found, exists := containers[chainName]; exists {
+			info = found
+		}
+		if q, exists := quotas[quotaName]; exists {
+			quotaSamples = append(quotaSamples, &QuotaSample{
+				ContainerID: info.ID,
+				Network:     info.Network,
+				Family:      "ipv" + v,
+				Bytes:       q.Bytes,
+				Consumed:    q.Consumed,
+				Depleted:    q.Depleted || q.Consumed > q.Bytes,
+			})
+		}
+		chainProps, err := GetChainProps(v, tableName, chainName)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, r := range chainProps.Rules {
+			s := getRuleTrafficSample(r, counters)
+			if s == nil {
+				continue
+			}
+			s.ContainerID = info.ID
+			s.Network = info.Network
+			s.Family = "ipv" + v
+			if existing, exists := samples[s.key()]; exists {
+				existing.Packets += s.Packets
+				existing.Bytes += s.Bytes
+				continue
+			}
+			samples[s.key()] = s
+		}
+	}
+
+	entries := []*TrafficSample{}
+	for _, s := range samples {
+		entries = append(entries, s)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key() < entries[j].key()
+	})
+	sort.Slice(quotaSamples, func(i, j int) bool {
+		return quotaSamples[i].ContainerID < quotaSamples[j].ContainerID
+	})
+	return entries, quotaSamples, nil
+}
+
+// escapeMetricLabel escapes a label value of the Prometheus text format.
+func escapeMetricLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+// WriteContainerMetrics writes the traffic and the quotas of the
+// containers in the Prometheus text format. The quota metrics are written
+// when a container has a quota, i.e. by the firewall plugin only.
+func WriteContainerMetrics(w io.Writer, metrics *ContainerMetrics) error {
+	if err := writeTrafficMetrics(w, metrics.Traffic); err != nil {
+		return err
+	}
+	if len(metrics.Quotas) == 0 {
+		return nil
+	}
+	return writeQuotaMetrics(w, metrics.Quotas)
+}
+
+// writeTrafficMetrics writes the traffic of the containers.
+func writeTrafficMetrics(w io.Writer, samples []*TrafficSample) error {
+	for _, m := range []struct {
+		name, help string
+		value      func(*TrafficSample) uint64
+	}{
+		{
+			"cni_nftables_container_packets_total",
+			"Packets counted by the nftables rules of the containers.",
+			func(s *TrafficSample) uint64 { return s.Packets },
+		},
+		{
+			"cni_nftables_container_bytes_total",
+			"Bytes counted by the nftables rules of the containers.",
+			func(s *TrafficSample) uint64 { return s.Bytes },
+		},
+	} {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", m.name, m.help, m.name); err != nil {
+			return err
+		}
+		for _, s := range samples {
+			hostPort := ""
+			if s.HostPort > 0 {
+				hostPort = fmt.Sprint(s.HostPort)
+			}
+			if _, err := fmt.Fprintf(
+				w,
+				"%s{container_id=\"%s\",network=\"%s\",family=\"%s\",direction=\"%s\",action=\"%s\",protocol=\"%s\",host_port=\"%s\"} %d\n",
+				m.name,
+				escapeMetricLabel(s.ContainerID),
+				escapeMetricLabel(s.Network),
+				s.Family, s.Direction, s.Action, s.Protocol, hostPort,
+				m.value(s),
+			); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeQuotaMetrics writes the quotas of the containers, i.e. the bytes a
+// quota allows, the bytes consumed, and whether the quota is depleted, in
+// which case the traffic of the container is dropped.
+func writeQuotaMetrics(w io.Writer, samples []*QuotaSample) error {
+	for _, m := range []struct {
+		name, help string
+		value      func(*QuotaSample) uint64
+	}{
+		{
+			"cni_nftables_container_quota_bytes",
+			"Bytes allowed by the quotas of the containers.",
+			func(s *QuotaSample) uint64 { return s.Bytes },
+		},
+		{
+			"cni_nftables_container_quota_consumed_bytes",
+			"Bytes consumed from the quotas of the containers.",
+			func(s *QuotaSample) uint64 { return s.Consumed },
+		},
+		{
+			"cni_nftables_container_quota_depleted",
+			"Whether the quotas of the containers are depleted.",
+			func(s *QuotaSample) uint64 {
+				if s.Depleted {
+					return 1
+				}
+				return 0
+			},
+		},
+	} {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name); err != nil {
+			return err
+		}
+		for _, s := range samples {
+			if _, err := fmt.Fprintf(
+				w,
+				"%s{container_id=\"%s\",network=\"%s\",family=\"%s\"} %d\n",
+				m.name,
+				escapeMetricLabel(s.ContainerID),
+				escapeMetricLabel(s.Network),
+				s.Family,
+				m.value(s),
+			); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeMetricsTextfile writes the metrics to a file of a node_exporter
+// textfile collector directory. The file is renamed into place, so that
+// the collector never reads a partial file.
+func writeMetricsTextfile(dir, name string, collect func() (*ContainerMetrics, error)) error {
+	metrics, err := collect()
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := WriteContainerMetrics(f, metrics); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(dir, name+".prom"))
+}
+
+// ServeMetrics serves the traffic of the containers in the Prometheus text
+// format over HTTP at the provided address, if any, and writes it to the
+// provided node_exporter textfile collector directory at the provided
+// interval, if any. The arguments are checked before serving. The function
+// runs until serving fails.
+func ServeMetrics(name, listen, textfileDir string, interval time.Duration, collect func() (*ContainerMetrics, error)) error {
+	if listen == "" && textfileDir == "" {
+		return fmt.Errorf("serving metrics requires a listen address or a textfile directory")
+	}
+	if textfileDir != "" {
+		if interval <= 0 {
+			return fmt.Errorf("invalid metrics interval: %s", interval)
+		}
+		fi, err := os.Stat(textfileDir)
+		if err != nil {
+			return fmt.Errorf("invalid metrics textfile directory: %s", err)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("invalid metrics textfile directory: %s is not a directory", textfileDir)
+		}
+	}
+
+	errs := make(chan error, 2)
+	if listen != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			metrics, err := collect()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+			if err := WriteContainerMetrics(w, metrics); err != nil {
+				fmt.Fprintf(os.Stderr, "failed writing metrics to %s: %s\n", r.RemoteAddr, err)
+			}
+		})
+		go func() {
+			errs <- http.ListenAndServe(listen, mux)
+		}()
+	}
+
+	if textfileDir != "" {
+		go func() {
+			for {
+				if err := writeMetricsTextfile(textfileDir, name, collect); err != nil {
+					fmt.Fprintf(os.Stderr, "failed writing metrics to %s: %s\n", textfileDir, err)
+				}
+				time.Sleep(interval)
+			}
+		}()
+	}
+
+	if listen == "" {
+		select {}
+	}
+	return <-errs
+}
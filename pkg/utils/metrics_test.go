@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteContainerMetrics(t *testing.T) {
+	metrics := &ContainerMetrics{
+		Traffic: []*TrafficSample{
+			{ContainerID: "3e03fd5f", Network: "podman", Family: "ipv4", Direction: "egress", Action: "accept", Protocol: "any", Packets: 10, Bytes: 1000},
+		},
+		Quotas: []*QuotaSample{
+			{ContainerID: "3e03fd5f", Network: "podman", Family: "ipv4", Bytes: 1000, Consumed: 2000, Depleted: true},
+		},
+	}
+	var b strings.Builder
+	if err := WriteContainerMetrics(&b, metrics); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, want := range []string{
+		`cni_nftables_container_bytes_total{container_id="3e03fd5f",network="podman",family="ipv4",direction="egress",action="accept",protocol="any",host_port=""} 1000`,
+		"# TYPE cni_nftables_container_quota_bytes gauge",
+		`cni_nftables_container_quota_bytes{container_id="3e03fd5f",network="podman",family="ipv4"} 1000`,
+		`cni_nftables_container_quota_consumed_bytes{container_id="3e03fd5f",network="podman",family="ipv4"} 2000`,
+		`cni_nftables_container_quota_depleted{container_id="3e03fd5f",network="podman",family="ipv4"} 1`,
+	} {
+		if !strings.Contains(b.String(), want+"\n") {
+			t.Fatalf("metrics have no line %q:\n%s", want, b.String())
+		}
+	}
+
+	// Without quotas, e.g. for the port mapping plugin, the quota metrics
+	// are not written.
+	b.Reset()
+	metrics.Quotas = nil
+	if err := WriteContainerMetrics(&b, metrics); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Contains(b.String(), "quota") {
+		t.Fatalf("unexpected quota metrics:\n%s", b.String())
+	}
+}
+
+func TestServeMetricsArgs(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	collect := func() (*ContainerMetrics, error) {
+		t.Fatal("unexpected collection of metrics")
+		return nil, nil
+	}
+	// The listen address is checked by serving, i.e. an invalid argument
+	// fails before the address is used.
+	for _, tc := range []struct {
+		name        string
+		listen      string
+		textfileDir string
+		interval    time.Duration
+	}{
+		{name: "no output", interval: time.Second},
+		{name: "zero interval", listen: "invalid", textfileDir: dir},
+		{name: "negative interval", listen: "invalid", textfileDir: dir, interval: -time.Second},
+		{name: "missing directory", listen: "invalid", textfileDir: filepath.Join(dir, "missing"), interval: time.Second},
+		{name: "file directory", listen: "invalid", textfileDir: file, interval: time.Second},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ServeMetrics("test", tc.listen, tc.textfileDir, tc.interval, collect)
+			if err == nil || strings.HasPrefix(err.Error(), "listen tcp") {
+				t.Fatalf("expected argument error, got %v", err)
+			}
+		})
+	}
+}
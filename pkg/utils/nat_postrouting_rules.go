@@ -12,6 +12,9 @@ func AddPostRoutingRules(opts map[string]interface{}) error {
 	if err := addPostRoutingBroadcastRule(opts); err != nil {
 		return err
 	}
+	if err := addPostRoutingNoMasqueradeRule(opts); err != nil {
+		return err
+	}
 	if err := addPostRoutingSourceNatRule(opts); err != nil {
 		return err
 	}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"net"
+
+	current "github.com/containernetworking/cni/pkg/types/040"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// getNetdevIngressRuleComment returns the comment tagging the ingress
+// rules of a particular container.
+func getNetdevIngressRuleComment(containerID string) string {
+	return "cni-ing " + containerID
+}
+
+// SetNetdevIngressRules replaces the rules of a container in the ingress
+// chain of the parent device of the container in a netdev family table.
+// The rules apply the rules of the ingress direction of the provided
+// policy, if any, and count the traffic to the container. The ingress hook
+// has no connection tracking, therefore the default action of the policy
+// does not apply and the reject action drops the traffic. The rules look
+// like:
+//
+//	meta protocol ip ip daddr <addr> ip saddr 10.0.0.0/8 tcp dport 80 counter accept
+//	meta protocol ip ip daddr <addr> counter
+func SetNetdevIngressRules(tableName, chainName, containerID string, addrs []*current.IPConfig, policy *FirewallPolicy) error {
+	rules := [][]expr.Any{}
+	for _, addr := range addrs {
+		v := addr.Version
+		if err := isSupportedIPVersion(v); err != nil {
+			return err
+		}
+		etherType := uint16(unix.ETH_P_IP)
+		host := &net.IPNet{IP: addr.Address.IP.To4(), Mask: net.CIDRMask(32, 32)}
+		if v == "6" {
+			etherType = unix.ETH_P_IPV6
+			host = &net.IPNet{IP: addr.Address.IP.To16(), Mask: net.CIDRMask(128, 128)}
+		}
+		match := []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyPROTOCOL, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.BigEndian.PutUint16(etherType)},
+		}
+		match = append(match, IPDaddrPrefixMatch(v, host)...)
+
+		if policy != nil && policy.Ingress != nil {
+			for _, r := range policy.Ingress.Rules {
+				verdict := expr.VerdictDrop
+				if r.Action == "accept" {
+					verdict = expr.VerdictAccept
+				}
+				for _, m := range getPolicyRuleMatches(v, "ingress", r) {
+					exprs := append([]expr.Any{}, match...)
+					exprs = append(exprs, m...)
+					exprs = append(exprs, &expr.Counter{}, &expr.Verdict{Kind: verdict})
+					rules = append(rules, exprs)
+				}
+			}
+		}
+		rules = append(rules, append(append([]expr.Any{}, match...), &expr.Counter{}))
+	}
+	return setTableRulesByComment(getNetdevTable(tableName), chainName, getNetdevIngressRuleComment(containerID), rules)
+}
+
+// RemoveNetdevIngressRules removes the rules of a container in the ingress
+// chain of the parent device of the container in a netdev family table.
+func RemoveNetdevIngressRules(tableName, chainName, containerID string) error {
+	exists, err := IsNetdevChainExists(tableName, chainName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	return setTableRulesByComment(getNetdevTable(tableName), chainName, getNetdevIngressRuleComment(containerID), nil)
+}
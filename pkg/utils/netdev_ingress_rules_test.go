@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	current "github.com/containernetworking/cni/pkg/types/040"
+)
+
+func TestSetNetdevIngressRules(t *testing.T) {
+	addrs := []*current.IPConfig{getTestIPConfig("10.88.0.5/16"), getTestIPConfig("fd00::5/64")}
+	addrs[0].Version, addrs[1].Version = "4", "6"
+	policy := &FirewallPolicy{
+		Ingress: &FirewallPolicyDirection{Rules: []*FirewallPolicyRule{
+			{CIDRs: []string{"10.0.0.0/8"}, Protocol: "tcp", Ports: []string{"80"}, Action: "accept"},
+			{Protocol: "udp", Action: "reject"},
+		}},
+	}
+	got := getDryRunCommands(t, func() error {
+		if err := CreateNetdevIngressChain("cni_plugins", "cni-ingress-eth0", "eth0"); err != nil {
+			return err
+		}
+		return SetNetdevIngressRules("cni_plugins", "cni-ingress-eth0", "test", addrs, policy)
+	})
+	want := []string{
+		"add table netdev cni_plugins",
+		"add chain netdev cni_plugins cni-ingress-eth0 { type filter hook ingress device \"eth0\" priority 0; policy accept; }",
+		"add rule netdev cni_plugins cni-ingress-eth0 meta protocol ip ip daddr 10.88.0.5 ip saddr 10.0.0.0/8 meta l4proto tcp tcp dport 80 counter packets 0 bytes 0 accept comment \"cni-ing test\"",
+		"add rule netdev cni_plugins cni-ingress-eth0 meta protocol ip ip daddr 10.88.0.5 meta l4proto udp counter packets 0 bytes 0 drop comment \"cni-ing test\"",
+		"add rule netdev cni_plugins cni-ingress-eth0 meta protocol ip ip daddr 10.88.0.5 counter packets 0 bytes 0 comment \"cni-ing test\"",
+		"add rule netdev cni_plugins cni-ingress-eth0 meta protocol ip6 ip6 daddr fd00::5 meta l4proto udp counter packets 0 bytes 0 drop comment \"cni-ing test\"",
+		"add rule netdev cni_plugins cni-ingress-eth0 meta protocol ip6 ip6 daddr fd00::5 counter packets 0 bytes 0 comment \"cni-ing test\"",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected rules\ngot:\n%#v\nwant:\n%#v", got, want)
+	}
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// The rules of netdev family tables apply to the frames received on
+// a particular device, e.g. the parent of macvlan and ipvlan interfaces,
+// before the frames reach the interfaces. There is a single netdev family
+// table for both IPv4 and IPv6.
+
+func getNetdevTable(tableName string) *nftables.Table {
+	return &nftables.Table{
+		Name:   tableName,
+		Family: nftables.TableFamilyNetdev,
+	}
+}
+
+// IsNetdevChainExists checks whether a chain exists in a netdev family table.
+func IsNetdevChainExists(tableName, chainName string) (bool, error) {
+	conn, err := initNftConn()
+	if err != nil {
+		return false, err
+	}
+
+	chains, err := conn.ListChains()
+	if err != nil {
+		return false, err
+	}
+
+	for _, chain := range chains {
+		if chain == nil {
+			continue
+		}
+		if chain.Name != chainName {
+			continue
+		}
+		if chain.Table.Name != tableName {
+			continue
+		}
+		if chain.Table.Family != nftables.TableFamilyNetdev {
+			continue
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// CreateNetdevIngressChain creates a netdev family table, when missing, and
+// a filter chain in the table hooked into the ingress hook of the provided
+// device. The chain accepts by default.
+func CreateNetdevIngressChain(tableName, chainName, deviceName string) error {
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+	conn.AddTable(getNetdevTable(tableName))
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("failed creating netdev %s table: %s", tableName, err)
+	}
+
+	// The chains of nftables package have no device, which the hook of
+	// a netdev family chain requires.
+	hook, err := netlink.MarshalAttributes([]netlink.Attribute{
+		{Type: unix.NFTA_HOOK_HOOKNUM, Data: binaryutil.BigEndian.PutUint32(unix.NF_NETDEV_INGRESS)},
+		{Type: unix.NFTA_HOOK_PRIORITY, Data: binaryutil.BigEndian.PutUint32(uint32(*nftables.ChainPriorityFilter))},
+		{Type: unix.NFTA_HOOK_DEV, Data: []byte(deviceName + "\x00")},
+	})
+	if err != nil {
+		return err
+	}
+	data, err := netlink.MarshalAttributes([]netlink.Attribute{
+		{Type: unix.NFTA_CHAIN_TABLE, Data: []byte(tableName + "\x00")},
+		{Type: unix.NFTA_CHAIN_NAME, Data: []byte(chainName + "\x00")},
+		{Type: unix.NLA_F_NESTED | unix.NFTA_CHAIN_HOOK, Data: hook},
+		{Type: unix.NFTA_CHAIN_TYPE, Data: []byte(string(nftables.ChainTypeFilter) + "\x00")},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := flushNftMessages([]netlink.Message{
+		{
+			Header: netlink.Header{
+				Type:  netlink.HeaderType((unix.NFNL_SUBSYS_NFTABLES << 8) | unix.NFT_MSG_NEWCHAIN),
+				Flags: netlink.Request | netlink.Acknowledge | netlink.Create,
+			},
+			Data: append([]byte{unix.NFPROTO_NETDEV, unix.NFNETLINK_V0, 0, 0}, data...),
+		},
+	}); err != nil {
+		return fmt.Errorf(
+			"failed creating %s chain of device %s in netdev %s table: %s",
+			chainName, deviceName, tableName, err,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+
+	current "github.com/containernetworking/cni/pkg/types/040"
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+// getIntervalSet returns a named set holding IPv4 or IPv6 networks.
+func getIntervalSet(v, tableName, setName string) *nftables.Set {
+	s := getAddrSet(v, tableName, setName)
+	s.Interval = true
+	return s
+}
+
+// nextAddr returns the address following the provided address, or nil
+// when the address is the last one.
+func nextAddr(ip []byte) []byte {
+	next := append([]byte{}, ip...)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			return next
+		}
+	}
+	return nil
+}
+
+// getIntervalSetElements returns the elements of an interval set holding
+// the provided networks of the provided IP version. The overlapping
+// networks are merged, because the intervals of a set must not overlap.
+func getIntervalSetElements(v string, prefixes []*net.IPNet) []nftables.SetElement {
+	type interval struct {
+		start, end []byte
+	}
+	intervals := []interval{}
+	for _, prefix := range prefixes {
+		if (prefix.IP.To4() != nil) != (v == "4") {
+			continue
+		}
+		start := append([]byte{}, encodeSetAddr(v, prefix.IP)...)
+		mask := []byte(prefix.Mask)
+		if len(mask) != len(start) {
+			ones, _ := prefix.Mask.Size()
+			mask = net.CIDRMask(ones, len(start)*8)
+		}
+		end := make([]byte, len(start))
+		for i := range start {
+			start[i] &= mask[i]
+			end[i] = start[i] | ^mask[i]
+		}
+		intervals = append(intervals, interval{start: start, end: end})
+	}
+
+	sort.Slice(intervals, func(i, j int) bool {
+		return bytes.Compare(intervals[i].start, intervals[j].start) < 0
+	})
+
+	merged := []interval{}
+	for _, i := range intervals {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			if next := nextAddr(last.end); next == nil || bytes.Compare(i.start, next) <= 0 {
+				if bytes.Compare(i.end, last.end) > 0 {
+					last.end = i.end
+				}
+				continue
+			}
+		}
+		merged = append(merged, i)
+	}
+
+	elements := []nftables.SetElement{}
+	for _, i := range merged {
+		elements = append(elements, nftables.SetElement{Key: i.start})
+		if next := nextAddr(i.end); next != nil {
+			elements = append(elements, nftables.SetElement{Key: next, IntervalEnd: true})
+		}
+	}
+	return elements
+}
+
+// SetNoMasqueradeDestinations creates, when missing, a named interval set
+// and replaces its networks with the provided destinations of the
+// provided IP version.
+func SetNoMasqueradeDestinations(v, tableName, setName string, destinations []string) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	prefixes := []*net.IPNet{}
+	for _, destination := range destinations {
+		prefix, err := ParseMappingSource(destination)
+		if err != nil {
+			return err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	exists, err := IsSetExists(v, tableName, setName)
+	if err != nil {
+		return err
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+
+	s := getIntervalSet(v, tableName, setName)
+	if !exists {
+		if err := conn.AddSet(s, []nftables.SetElement{}); err != nil {
+			return err
+		}
+	} else {
+		conn.FlushSet(s)
+	}
+	if elements := getIntervalSetElements(v, prefixes); len(elements) > 0 {
+		if err := conn.SetAddElements(s, elements); err != nil {
+			return err
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed setting no masquerade destinations in set %s of ipv%s %s table: %s",
+			setName, v, tableName, err,
+		)
+	}
+	return nil
+}
+
+// Add rule for the traffic coming out of the container to the destinations
+// that must not be masqueraded. The resulting rule looks like
+// iifname "<bridgeIntfName>" ip saddr <addr> ip daddr @<setName> counter return
+func addPostRoutingNoMasqueradeRule(opts map[string]interface{}) error {
+	v := opts["version"].(string)
+	tableName := opts["table"].(string)
+	chainName := opts["chain"].(string)
+	bridgeIntfName := opts["bridge_interface"].(string)
+	addr := opts["ip_address"].(*current.IPConfig)
+	setName, exists := opts["no_masquerade_set"].(string)
+	if !exists || setName == "" {
+		return nil
+	}
+	if mode, _ := opts["nat_mode"].(string); mode == NatModeNone {
+		return nil
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+
+	tb := &nftables.Table{
+		Name: tableName,
+	}
+	var daddrOffset, addrLen uint32
+	if v == "4" {
+		tb.Family = nftables.TableFamilyIPv4
+		daddrOffset, addrLen = 16, 4
+	} else {
+		tb.Family = nftables.TableFamilyIPv6
+		daddrOffset, addrLen = 24, 16
+	}
+
+	host := &net.IPNet{IP: addr.Address.IP, Mask: net.CIDRMask(int(addrLen)*8, int(addrLen)*8)}
+
+	r := &nftables.Rule{
+		Table: tb,
+		Chain: &nftables.Chain{Name: chainName, Table: tb},
+		Exprs: []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(bridgeIntfName)},
+		},
+	}
+	r.Exprs = append(r.Exprs, IPSaddrPrefixMatch(v, host)...)
+	r.Exprs = append(r.Exprs,
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: daddrOffset, Len: addrLen},
+		&expr.Lookup{SourceRegister: 1, SetName: setName},
+		&expr.Counter{},
+		&expr.Verdict{Kind: expr.VerdictReturn},
+	)
+
+	conn.AddRule(r)
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed adding no masquerade rule in chain %s of ipv%s %s table for %v: %s, rule: %s",
+			chainName, v, tableName, addr, err, FormatRuleExprs(v, r.Exprs),
+		)
+	}
+	return nil
+}
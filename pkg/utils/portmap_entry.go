@@ -6,4 +6,13 @@ type MappingEntry struct {
 	ContainerPort int    `json:"containerPort"`
 	Protocol      string `json:"protocol"`
 	HostIP        string `json:"hostIP,omitempty"`
+	// HostInterface is the name of the host interface, e.g. eth0 or eth*,
+	// the port is published on, whatever its IP addresses are.
+	HostInterface string `json:"hostInterface,omitempty"`
+	// AllowedSources is the list of source addresses or networks,
+	// e.g. 192.168.100.0/24, allowed to reach the port. When empty,
+	// the port is reachable from any source.
+	AllowedSources []string `json:"allowedSources,omitempty"`
+	// Limit holds the rate and connection limits of the port, if any.
+	Limit *MappingLimit `json:"limit,omitempty"`
 }
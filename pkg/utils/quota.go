@@ -0,0 +1,234 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/google/nftables/binaryutil"
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// nftObjectQuota is the type of the quota stateful objects.
+const nftObjectQuota = 2
+
+// Quota holds the state of a named quota object.
+type Quota struct {
+	// Table and Name are the table and the name of the object.
+	Table string
+	Name  string
+	// Bytes is the number of bytes the quota allows.
+	Bytes uint64
+	// Consumed is the number of bytes matched by the quota.
+	Consumed uint64
+	// Depleted is whether the consumed bytes exceed the quota.
+	Depleted bool
+}
+
+// quotaNamePrefix is the prefix of the names of the quotas of the
+// containers, i.e. cni-qta-<key>, the key being the one of the counters.
+const quotaNamePrefix = "cni-qta-"
+
+// GetContainerQuotaName returns the name of the quota of a container.
+func GetContainerQuotaName(containerID string) string {
+	return quotaNamePrefix + GetContainerKey(containerID)
+}
+
+// getQuotaObjMessage returns the nf_tables message of the provided type
+// for a quota object of filter table.
+func getQuotaObjMessage(v, tableName, quotaName string, msgType uint16, flags netlink.HeaderFlags, attrs []netlink.Attribute) (netlink.Message, error) {
+	data, err := netlink.MarshalAttributes(append([]netlink.Attribute{
+		{Type: unix.NFTA_OBJ_TABLE, Data: []byte(tableName + "\x00")},
+		{Type: unix.NFTA_OBJ_NAME, Data: []byte(quotaName + "\x00")},
+		{Type: unix.NFTA_OBJ_TYPE, Data: binaryutil.BigEndian.PutUint32(nftObjectQuota)},
+	}, attrs...))
+	if err != nil {
+		return netlink.Message{}, err
+	}
+	return netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType((unix.NFNL_SUBSYS_NFTABLES << 8) | msgType),
+			Flags: flags,
+		},
+		Data: append([]byte{byte(getFlowtableTable(v, tableName).Family), unix.NFNETLINK_V0, 0, 0}, data...),
+	}, nil
+}
+
+// SetQuota creates a named quota object in filter table, or updates the
+// number of bytes of an existing object. The consumed bytes of an existing
+// object are kept. The object matches the packets over the quota.
+func SetQuota(v, tableName, quotaName string, bytes uint64) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	// The stateful objects of nftables package are counters only.
+	quota, err := netlink.MarshalAttributes([]netlink.Attribute{
+		{Type: unix.NFTA_QUOTA_BYTES, Data: binaryutil.BigEndian.PutUint64(bytes)},
+		{Type: unix.NFTA_QUOTA_FLAGS, Data: binaryutil.BigEndian.PutUint32(unix.NFT_QUOTA_F_INV)},
+	})
+	if err != nil {
+		return err
+	}
+	msg, err := getQuotaObjMessage(
+		v, tableName, quotaName, unix.NFT_MSG_NEWOBJ,
+		netlink.Request|netlink.Acknowledge|netlink.Create,
+		[]netlink.Attribute{{Type: unix.NLA_F_NESTED | unix.NFTA_OBJ_DATA, Data: quota}},
+	)
+	if err != nil {
+		return err
+	}
+	if err := flushNftMessages([]netlink.Message{msg}); err != nil {
+		return fmt.Errorf(
+			"failed setting quota %s of ipv%s %s table: %s",
+			quotaName, v, tableName, err,
+		)
+	}
+	return nil
+}
+
+// GetQuota returns the state of a named quota object of filter table, or
+// nil when the object does not exist.
+func GetQuota(v, tableName, quotaName string) (*Quota, error) {
+	if err := isSupportedIPVersion(v); err != nil {
+		return nil, err
+	}
+
+	msg, err := getQuotaObjMessage(v, tableName, quotaName, unix.NFT_MSG_GETOBJ, netlink.Request, nil)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := execNftMessage(msg)
+	if err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf(
+			"failed obtaining quota %s of ipv%s %s table: %s",
+			quotaName, v, tableName, err,
+		)
+	}
+
+	for _, reply := range replies {
+		q, err := decodeQuotaObj(reply)
+		if err != nil {
+			return nil, err
+		}
+		if q != nil {
+			return q, nil
+		}
+	}
+	return nil, nil
+}
+
+// decodeQuotaObj returns the state of the quota object of a reply, or nil
+// when the reply holds no quota object.
+func decodeQuotaObj(reply netlink.Message) (*Quota, error) {
+	if len(reply.Data) < 4 {
+		return nil, nil
+	}
+	ad, err := netlink.NewAttributeDecoder(reply.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+	ad.ByteOrder = binary.BigEndian
+	var objType uint32
+	q := &Quota{}
+	for ad.Next() {
+		switch ad.Type() {
+		case unix.NFTA_OBJ_TABLE:
+			q.Table = ad.String()
+		case unix.NFTA_OBJ_NAME:
+			q.Name = ad.String()
+		case unix.NFTA_OBJ_TYPE:
+			objType = ad.Uint32()
+		case unix.NFTA_OBJ_DATA:
+			ad.Nested(func(nad *netlink.AttributeDecoder) error {
+				nad.ByteOrder = binary.BigEndian
+				for nad.Next() {
+					switch nad.Type() {
+					case unix.NFTA_QUOTA_BYTES:
+						q.Bytes = nad.Uint64()
+					case unix.NFTA_QUOTA_CONSUMED:
+						q.Consumed = nad.Uint64()
+					case unix.NFTA_QUOTA_FLAGS:
+						q.Depleted = nad.Uint32()&unix.NFT_QUOTA_F_DEPLETED != 0
+					}
+				}
+				return nil
+			})
+		}
+	}
+	if err := ad.Err(); err != nil {
+		return nil, err
+	}
+	if objType != 0 && objType != nftObjectQuota {
+		return nil, nil
+	}
+	return q, nil
+}
+
+// ListQuotas returns the named quota objects of a table.
+func ListQuotas(v, tableName string) ([]*Quota, error) {
+	if err := isSupportedIPVersion(v); err != nil {
+		return nil, err
+	}
+
+	data, err := netlink.MarshalAttributes([]netlink.Attribute{
+		{Type: unix.NFTA_OBJ_TABLE, Data: []byte(tableName + "\x00")},
+		{Type: unix.NFTA_OBJ_TYPE, Data: binaryutil.BigEndian.PutUint32(nftObjectQuota)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	replies, err := execNftMessage(netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType((unix.NFNL_SUBSYS_NFTABLES << 8) | unix.NFT_MSG_GETOBJ),
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: append([]byte{byte(getFlowtableTable(v, tableName).Family), unix.NFNETLINK_V0, 0, 0}, data...),
+	})
+	if err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed listing quotas of ipv%s %s table: %s", v, tableName, err)
+	}
+
+	quotas := []*Quota{}
+	for _, reply := range replies {
+		q, err := decodeQuotaObj(reply)
+		if err != nil {
+			return nil, err
+		}
+		if q == nil || q.Table != tableName {
+			continue
+		}
+		quotas = append(quotas, q)
+	}
+	return quotas, nil
+}
+
+// DeleteQuota deletes a named quota object of filter table. The rules
+// referring to the object must be deleted beforehand.
+func DeleteQuota(v, tableName, quotaName string) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	msg, err := getQuotaObjMessage(
+		v, tableName, quotaName, unix.NFT_MSG_DELOBJ,
+		netlink.Request|netlink.Acknowledge, nil,
+	)
+	if err != nil {
+		return err
+	}
+	if err := flushNftMessages([]netlink.Message{msg}); err != nil {
+		return fmt.Errorf(
+			"failed deleting quota %s of ipv%s %s table: %s",
+			quotaName, v, tableName, err,
+		)
+	}
+	return nil
+}
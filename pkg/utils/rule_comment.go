@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/google/nftables"
+)
+
+// The nft tool stores rule comments in the user data of a rule as
+// a type-length-value attribute. The type of a comment is 0 and
+// the value is a null-terminated string.
+const ruleCommentType = 0
+
+// EncodeRuleComment returns the user data carrying the provided
+// comment, as understood by `nft list ruleset`.
+func EncodeRuleComment(s string) []byte {
+	if len(s) > 126 {
+		s = s[:126]
+	}
+	b := []byte{ruleCommentType, byte(len(s) + 1)}
+	b = append(b, []byte(s)...)
+	b = append(b, 0x0)
+	return b
+}
+
+// DecodeRuleComment returns the comment found in the user data
+// of a rule, if any.
+func DecodeRuleComment(b []byte) string {
+	for len(b) > 2 {
+		attrType, attrLen := b[0], int(b[1])
+		if len(b) < 2+attrLen {
+			return ""
+		}
+		if attrType == ruleCommentType {
+			return strings.TrimRight(string(b[2:2+attrLen]), "\x00")
+		}
+		b = b[2+attrLen:]
+	}
+	return ""
+}
+
+// GetRulesByComment returns the rules of a particular chain
+// having the provided comment.
+func GetRulesByComment(v, tableName, chainName, comment string) ([]*nftables.Rule, error) {
+	chainProps, err := GetChainProps(v, tableName, chainName)
+	if err != nil {
+		return nil, err
+	}
+	rules := []*nftables.Rule{}
+	for _, r := range chainProps.Rules {
+		if DecodeRuleComment(r.UserData) != comment {
+			continue
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
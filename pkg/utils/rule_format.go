@@ -0,0 +1,796 @@
+package utils
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// The kinds of the values held by the registers, i.e. how the data
+// compared to or assigned from a register is printed.
+const (
+	valueKindRaw = iota
+	valueKindInterface
+	valueKindAddr
+	valueKindDecimal
+	valueKindProtocol
+	valueKindEtherType
+	valueKindNfProto
+	valueKindMark
+	valueKindCtState
+	valueKindCtDirection
+	valueKindAddrType
+	valueKindLinkAddr
+	valueKindPktType
+)
+
+// registerValue is the value loaded into a register by the expressions
+// of a rule, e.g. `ip saddr`, or the data of an immediate expression.
+type registerValue struct {
+	field string
+	kind  int
+	data  []byte
+	mask  []byte
+	xor   []byte
+	// expr is the field as a libnftables JSON expression.
+	expr interface{}
+}
+
+// payloadField is a header field loaded by a payload expression.
+type payloadField struct {
+	name string
+	kind int
+}
+
+var networkHeaderFields = map[string]map[[2]uint32]payloadField{
+	"4": {
+		{1, 1}:  {"ip dscp", valueKindRaw},
+		{8, 1}:  {"ip ttl", valueKindDecimal},
+		{9, 1}:  {"ip protocol", valueKindProtocol},
+		{12, 4}: {"ip saddr", valueKindAddr},
+		{16, 4}: {"ip daddr", valueKindAddr},
+	},
+	"6": {
+		{0, 2}:   {"ip6 dscp", valueKindRaw},
+		{6, 1}:   {"ip6 nexthdr", valueKindProtocol},
+		{7, 1}:   {"ip6 hoplimit", valueKindDecimal},
+		{8, 16}:  {"ip6 saddr", valueKindAddr},
+		{24, 16}: {"ip6 daddr", valueKindAddr},
+	},
+}
+
+var metaKeyNames = map[expr.MetaKey]payloadField{
+	expr.MetaKeyLEN:        {"meta length", valueKindDecimal},
+	expr.MetaKeyPROTOCOL:   {"meta protocol", valueKindEtherType},
+	expr.MetaKeyMARK:       {"meta mark", valueKindMark},
+	expr.MetaKeyIIF:        {"iif", valueKindDecimal},
+	expr.MetaKeyOIF:        {"oif", valueKindDecimal},
+	expr.MetaKeyIIFNAME:    {"iifname", valueKindInterface},
+	expr.MetaKeyOIFNAME:    {"oifname", valueKindInterface},
+	expr.MetaKeyNFPROTO:    {"meta nfproto", valueKindNfProto},
+	expr.MetaKeyL4PROTO:    {"meta l4proto", valueKindProtocol},
+	expr.MetaKeyBRIIIFNAME: {"meta ibrname", valueKindInterface},
+	expr.MetaKeyBRIOIFNAME: {"meta obrname", valueKindInterface},
+	expr.MetaKeyPKTTYPE:    {"meta pkttype", valueKindPktType},
+}
+
+var ctKeyNames = map[expr.CtKey]payloadField{
+	expr.CtKeySTATE:     {"ct state", valueKindCtState},
+	expr.CtKeyDIRECTION: {"ct direction", valueKindCtDirection},
+	expr.CtKeyMARK:      {"ct mark", valueKindMark},
+}
+
+var ctStateNames = []struct {
+	bit  uint32
+	name string
+}{
+	{expr.CtStateBitINVALID, "invalid"},
+	{expr.CtStateBitESTABLISHED, "established"},
+	{expr.CtStateBitRELATED, "related"},
+	{expr.CtStateBitNEW, "new"},
+	{expr.CtStateBitUNTRACKED, "untracked"},
+}
+
+var cmpOpNames = map[expr.CmpOp]string{
+	expr.CmpOpEq:  "",
+	expr.CmpOpNeq: "!= ",
+	expr.CmpOpLt:  "< ",
+	expr.CmpOpLte: "<= ",
+	expr.CmpOpGt:  "> ",
+	expr.CmpOpGte: ">= ",
+}
+
+var addrTypeNames = map[byte]string{
+	unix.RTN_UNICAST:   "unicast",
+	unix.RTN_LOCAL:     "local",
+	unix.RTN_BROADCAST: "broadcast",
+	unix.RTN_ANYCAST:   "anycast",
+	unix.RTN_MULTICAST: "multicast",
+}
+
+var pktTypeNames = map[byte]string{
+	unix.PACKET_HOST:      "host",
+	unix.PACKET_BROADCAST: "broadcast",
+	unix.PACKET_MULTICAST: "multicast",
+	unix.PACKET_OTHERHOST: "other",
+}
+
+var icmpRejectCodes = map[string]map[uint8]string{
+	"4": {0: "net-unreachable", 1: "host-unreachable", 3: "port-unreachable", 13: "admin-prohibited"},
+	"6": {0: "no-route", 1: "admin-prohibited", 3: "addr-unreachable", 4: "port-unreachable"},
+}
+
+var limitUnitNames = map[expr.LimitTime]string{
+	expr.LimitTimeSecond: "second",
+	expr.LimitTimeMinute: "minute",
+	expr.LimitTimeHour:   "hour",
+	expr.LimitTimeDay:    "day",
+	expr.LimitTimeWeek:   "week",
+}
+
+// ruleFormatter holds the state of the decompilation of the expressions of
+// a rule, i.e. the values loaded into the registers, the network protocol
+// of the payload expressions and the transport protocol matched so far.
+type ruleFormatter struct {
+	v          string
+	registers  map[uint32]*registerValue
+	transport  string
+	statements []string
+	// exprs are the statements as libnftables JSON statements.
+	exprs []interface{}
+}
+
+// jsonObject is an object of libnftables JSON.
+type jsonObject = map[string]interface{}
+
+// FormatRuleExprs returns the expressions of a rule of the IPv4 or IPv6
+// table, or of the netdev table when the IP version is empty, as nft
+// statements, e.g. `iifname "cni-podman0" ip saddr 10.88.0.5 accept`.
+// The expressions without an nft equivalent are printed in brackets.
+func FormatRuleExprs(v string, exprs []expr.Any) string {
+	return strings.Join(newRuleFormatter(v, exprs).statements, " ")
+}
+
+// formatRulesExprs returns the expressions of the provided rules as nft
+// statements, separated by semicolons, for the errors of the functions
+// adding several rules at once.
+func formatRulesExprs(v string, rules []*nftables.Rule) string {
+	formatted := []string{}
+	for _, r := range rules {
+		formatted = append(formatted, FormatRuleExprs(v, r.Exprs))
+	}
+	return strings.Join(formatted, "; ")
+}
+
+// formatRuleJSON returns the expressions of a rule as libnftables JSON
+// statements. The expressions without a JSON equivalent are objects named
+// after the type of the expressions.
+func formatRuleJSON(v string, exprs []expr.Any) []interface{} {
+	return newRuleFormatter(v, exprs).exprs
+}
+
+func newRuleFormatter(v string, exprs []expr.Any) *ruleFormatter {
+	f := &ruleFormatter{
+		v:         v,
+		registers: map[uint32]*registerValue{},
+		exprs:     []interface{}{},
+	}
+	for _, e := range exprs {
+		f.add(e)
+	}
+	return f
+}
+
+// FormatRule returns a rule as nft statements, followed by the comment of
+// the rule, if any.
+func FormatRule(v string, r *nftables.Rule) string {
+	s := FormatRuleExprs(v, r.Exprs)
+	if comment := DecodeRuleComment(r.UserData); comment != "" {
+		s += fmt.Sprintf(" comment %q", comment)
+	}
+	return s
+}
+
+func (f *ruleFormatter) emit(stmt interface{}, format string, args ...interface{}) {
+	f.statements = append(f.statements, fmt.Sprintf(format, args...))
+	f.exprs = append(f.exprs, stmt)
+}
+
+func (f *ruleFormatter) load(reg uint32, name string, kind int) {
+	f.loadExpr(reg, name, kind, jsonField(name))
+}
+
+func (f *ruleFormatter) loadExpr(reg uint32, name string, kind int, e interface{}) {
+	f.registers[reg] = &registerValue{field: name, kind: kind, expr: e}
+}
+
+// operand returns the value of a register used by a statement, e.g. the
+// address of a dnat statement. The data of the immediate expressions is
+// printed as a value of the provided kind.
+func (f *ruleFormatter) operand(reg uint32, kind int) string {
+	rv, exists := f.registers[reg]
+	if !exists {
+		return fmt.Sprintf("reg %d", reg)
+	}
+	if rv.field != "" {
+		return rv.field
+	}
+	return formatValue(kind, rv.data)
+}
+
+// operandJSON returns the value of a register used by a statement as a
+// libnftables JSON expression.
+func (f *ruleFormatter) operandJSON(reg uint32, kind int) interface{} {
+	rv, exists := f.registers[reg]
+	if !exists {
+		return fmt.Sprintf("reg %d", reg)
+	}
+	if rv.field != "" {
+		return rv.expr
+	}
+	return jsonValue(kind, rv.data)
+}
+
+// mangle returns the statement assigning a value to a field.
+func (f *ruleFormatter) mangle(field payloadField, key, value interface{}, text string) {
+	f.emit(jsonObject{"mangle": jsonObject{"key": key, "value": value}}, "%s set %s", field.name, text)
+}
+
+func (f *ruleFormatter) add(e expr.Any) {
+	switch x := e.(type) {
+	case *expr.Meta:
+		field, exists := metaKeyNames[x.Key]
+		if !exists {
+			field = payloadField{fmt.Sprintf("meta key %d", x.Key), valueKindRaw}
+		}
+		if x.SourceRegister {
+			f.mangle(field, jsonField(field.name), f.operandJSON(x.Register, field.kind), f.operand(x.Register, field.kind))
+			return
+		}
+		f.load(x.Register, field.name, field.kind)
+	case *expr.Ct:
+		field, exists := ctKeyNames[x.Key]
+		if !exists {
+			field = payloadField{fmt.Sprintf("ct key %d", x.Key), valueKindRaw}
+		}
+		if x.SourceRegister {
+			f.mangle(field, jsonField(field.name), f.operandJSON(x.Register, field.kind), f.operand(x.Register, field.kind))
+			return
+		}
+		f.load(x.Register, field.name, field.kind)
+	case *expr.Payload:
+		field := f.payloadField(x)
+		if x.OperationType == expr.PayloadWrite {
+			value := f.payloadWriteOperand(x, field)
+			var valueJSON interface{} = value
+			if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+				valueJSON = n
+			} else if f.registers[x.SourceRegister] != nil {
+				valueJSON = f.operandJSON(x.SourceRegister, field.kind)
+			}
+			f.mangle(field, jsonField(field.name), valueJSON, value)
+			return
+		}
+		f.load(x.DestRegister, field.name, field.kind)
+	case *expr.Immediate:
+		f.registers[x.Register] = &registerValue{data: x.Data}
+	case *expr.Bitwise:
+		rv := &registerValue{mask: x.Mask, xor: x.Xor}
+		if src, exists := f.registers[x.SourceRegister]; exists {
+			rv.field, rv.kind, rv.data, rv.expr = src.field, src.kind, src.data, src.expr
+		}
+		f.registers[x.DestRegister] = rv
+	case *expr.Cmp:
+		f.cmp(x)
+	case *expr.Range:
+		rv := f.registers[x.Register]
+		if rv == nil {
+			rv = &registerValue{field: fmt.Sprintf("reg %d", x.Register)}
+			rv.expr = rv.field
+		}
+		f.emit(
+			jsonMatch(x.Op, rv.expr, jsonObject{"range": []interface{}{jsonValue(rv.kind, x.FromData), jsonValue(rv.kind, x.ToData)}}),
+			"%s %s%s-%s", rv.field, cmpOpNames[x.Op], formatValue(rv.kind, x.FromData), formatValue(rv.kind, x.ToData),
+		)
+	case *expr.Lookup:
+		operand := f.operand(x.SourceRegister, valueKindRaw)
+		operandJSON := f.operandJSON(x.SourceRegister, valueKindRaw)
+		if x.IsDestRegSet {
+			f.loadExpr(x.DestRegister, operand+" map @"+x.SetName, valueKindRaw, jsonObject{"map": jsonObject{"key": operandJSON, "data": "@" + x.SetName}})
+			return
+		}
+		op, cmpOp := "", expr.CmpOpEq
+		if x.Invert {
+			op, cmpOp = "!= ", expr.CmpOpNeq
+		}
+		f.emit(jsonMatch(cmpOp, operandJSON, "@"+x.SetName), "%s %s@%s", operand, op, x.SetName)
+	case *expr.Fib:
+		f.loadExpr(x.Register, formatFib(x), valueKindAddrType, fibJSON(x))
+	case *expr.Hash:
+		s := fmt.Sprintf("jhash %s mod %d", f.operand(x.SourceRegister, valueKindRaw), x.Modulus)
+		name, hash := "jhash", jsonObject{"mod": x.Modulus, "expr": f.operandJSON(x.SourceRegister, valueKindRaw)}
+		if x.Type == expr.HashTypeSym {
+			s = fmt.Sprintf("symhash mod %d", x.Modulus)
+			name, hash = "symhash", jsonObject{"mod": x.Modulus}
+		}
+		if x.Seed != 0 {
+			s += fmt.Sprintf(" seed 0x%x", x.Seed)
+			hash["seed"] = x.Seed
+		}
+		if x.Offset != 0 {
+			s += fmt.Sprintf(" offset %d", x.Offset)
+			hash["offset"] = x.Offset
+		}
+		f.loadExpr(x.DestRegister, s, valueKindRaw, jsonObject{name: hash})
+	case *expr.Numgen:
+		s := fmt.Sprintf("numgen inc mod %d", x.Modulus)
+		numgen := jsonObject{"mode": "inc", "mod": x.Modulus}
+		if x.Type == unix.NFT_NG_RANDOM {
+			s = fmt.Sprintf("numgen random mod %d", x.Modulus)
+			numgen["mode"] = "random"
+		}
+		if x.Offset != 0 {
+			s += fmt.Sprintf(" offset %d", x.Offset)
+			numgen["offset"] = x.Offset
+		}
+		f.loadExpr(x.Register, s, valueKindRaw, jsonObject{"numgen": numgen})
+	case *expr.Counter:
+		f.emit(jsonObject{"counter": jsonObject{"packets": x.Packets, "bytes": x.Bytes}}, "counter packets %d bytes %d", x.Packets, x.Bytes)
+	case *expr.Objref:
+		switch x.Type {
+		case nftObjectCounter:
+			f.emit(jsonObject{"counter": x.Name}, "counter name %q", x.Name)
+		case nftObjectQuota:
+			f.emit(jsonObject{"quota": x.Name}, "quota name %q", x.Name)
+		default:
+			f.emit(jsonObject{"objref": jsonObject{"type": x.Type, "name": x.Name}}, "[objref type %d name %q]", x.Type, x.Name)
+		}
+	case *expr.Quota:
+		s := "quota "
+		quota := jsonObject{"val": x.Bytes, "val_unit": "bytes"}
+		if x.Over {
+			s += "over "
+			quota["inv"] = true
+		}
+		s += fmt.Sprintf("%d bytes", x.Bytes)
+		if x.Consumed != 0 {
+			s += fmt.Sprintf(" used %d bytes", x.Consumed)
+			quota["used"], quota["used_unit"] = x.Consumed, "bytes"
+		}
+		f.emit(jsonObject{"quota": quota}, "%s", s)
+	case *expr.Limit:
+		f.emit(limitJSON(x), "%s", formatLimit(x))
+	case *expr.Connlimit:
+		if x.Flags&expr.NFT_CONNLIMIT_F_INV != 0 {
+			f.emit(jsonObject{"ct count": jsonObject{"val": x.Count, "inv": true}}, "ct count over %d", x.Count)
+			return
+		}
+		f.emit(jsonObject{"ct count": jsonObject{"val": x.Count}}, "ct count %d", x.Count)
+	case *expr.Log:
+		f.emit(logJSON(x), "%s", formatLog(x))
+	case *expr.Reject:
+		f.emit(f.rejectJSON(x), "%s", f.formatReject(x))
+	case *expr.NAT:
+		f.emit(f.natJSON(x), "%s", f.formatNAT(x))
+	case *expr.Masq:
+		s := "masquerade"
+		masq := jsonObject{}
+		if x.ToPorts {
+			s += " to :" + f.portRange(x.RegProtoMin, x.RegProtoMax)
+			masq["port"] = f.portRangeJSON(x.RegProtoMin, x.RegProtoMax)
+		}
+		if flags := natFlags(x.Random, x.FullyRandom, x.Persistent); len(flags) > 0 {
+			masq["flags"] = flags
+		}
+		if len(masq) == 0 {
+			masq = nil
+		}
+		f.emit(jsonObject{"masquerade": masq}, "%s", s+formatNatFlags(x.Random, x.FullyRandom, x.Persistent))
+	case *expr.FlowOffload:
+		f.emit(jsonObject{"flow": jsonObject{"op": "add", "flowtable": "@" + x.Name}}, "flow add @%s", x.Name)
+	case *expr.Verdict:
+		f.emit(verdictJSON(x), "%s", formatVerdict(x))
+	default:
+		name := strings.ToLower(strings.TrimPrefix(fmt.Sprintf("%T", e), "*expr."))
+		f.emit(jsonObject{name: nil}, "[%s]", name)
+	}
+}
+
+// payloadField returns the header field loaded or written by a payload
+// expression, e.g. `ip saddr`, or the raw header offset and length, e.g.
+// `@nh,96,32`.
+func (f *ruleFormatter) payloadField(x *expr.Payload) payloadField {
+	key := [2]uint32{x.Offset, x.Len}
+	switch x.Base {
+	case expr.PayloadBaseLLHeader:
+		switch key {
+		case [2]uint32{0, 6}:
+			return payloadField{"ether daddr", valueKindLinkAddr}
+		case [2]uint32{6, 6}:
+			return payloadField{"ether saddr", valueKindLinkAddr}
+		case [2]uint32{12, 2}:
+			return payloadField{"ether type", valueKindEtherType}
+		}
+		return payloadField{fmt.Sprintf("@ll,%d,%d", x.Offset*8, x.Len*8), valueKindRaw}
+	case expr.PayloadBaseNetworkHeader:
+		versions := []string{f.v}
+		if f.v == "" {
+			versions = []string{"4", "6"}
+		}
+		for _, v := range versions {
+			if field, exists := networkHeaderFields[v][key]; exists {
+				return field
+			}
+		}
+		return payloadField{fmt.Sprintf("@nh,%d,%d", x.Offset*8, x.Len*8), valueKindRaw}
+	case expr.PayloadBaseTransportHeader:
+		proto := f.transport
+		if proto == "" || proto == "icmp" || proto == "icmpv6" {
+			proto = "th"
+		}
+		switch key {
+		case [2]uint32{0, 2}:
+			return payloadField{proto + " sport", valueKindDecimal}
+		case [2]uint32{2, 2}:
+			return payloadField{proto + " dport", valueKindDecimal}
+		}
+		return payloadField{fmt.Sprintf("@th,%d,%d", x.Offset*8, x.Len*8), valueKindRaw}
+	}
+	return payloadField{fmt.Sprintf("@base%d,%d,%d", x.Base, x.Offset*8, x.Len*8), valueKindRaw}
+}
+
+// payloadWriteOperand returns the value written by a payload expression.
+// The DSCP field is written with the other bits of its bytes, i.e. the
+// value is the xor of the bitwise expression clearing the field.
+func (f *ruleFormatter) payloadWriteOperand(x *expr.Payload, field payloadField) string {
+	rv, exists := f.registers[x.SourceRegister]
+	if !exists {
+		return fmt.Sprintf("reg %d", x.SourceRegister)
+	}
+	if rv.field == field.name && rv.xor != nil {
+		switch field.name {
+		case "ip dscp":
+			return fmt.Sprintf("%d", rv.xor[0]>>2)
+		case "ip6 dscp":
+			return fmt.Sprintf("%d", (binaryutil.BigEndian.Uint16(rv.xor)>>6)&0x3f)
+		}
+	}
+	return f.operand(x.SourceRegister, field.kind)
+}
+
+func (f *ruleFormatter) cmp(x *expr.Cmp) {
+	rv := f.registers[x.Register]
+	if rv == nil {
+		rv = &registerValue{field: fmt.Sprintf("reg %d", x.Register)}
+		rv.expr = rv.field
+	}
+	op := cmpOpNames[x.Op]
+
+	if rv.kind == valueKindProtocol && x.Op == expr.CmpOpEq && len(x.Data) == 1 {
+		f.transport = formatValue(valueKindProtocol, x.Data)
+	}
+	if rv.kind == valueKindEtherType && x.Op == expr.CmpOpEq && len(x.Data) == 2 {
+		switch binaryutil.BigEndian.Uint16(x.Data) {
+		case unix.ETH_P_IP:
+			f.v = "4"
+		case unix.ETH_P_IPV6:
+			f.v = "6"
+		}
+	}
+
+	switch {
+	case rv.mask != nil && rv.kind == valueKindCtState && isZero(x.Data):
+		// ct state established,related
+		states := formatValue(valueKindCtState, rv.mask)
+		if x.Op == expr.CmpOpEq {
+			f.emit(jsonMatch(expr.CmpOpNeq, rv.expr, jsonValue(valueKindCtState, rv.mask)), "%s != %s", rv.field, states)
+			return
+		}
+		f.emit(jsonObject{"match": jsonObject{"op": "in", "left": rv.expr, "right": jsonValue(valueKindCtState, rv.mask)}}, "%s %s", rv.field, states)
+	case rv.mask != nil && rv.kind == valueKindAddr && isZero(rv.xor):
+		// ip saddr 10.88.0.0/16
+		ones, bits := net.IPMask(rv.mask).Size()
+		if bits == 0 {
+			masked := jsonObject{"&": []interface{}{rv.expr, jsonValue(valueKindRaw, rv.mask)}}
+			f.emit(jsonMatch(x.Op, masked, jsonValue(rv.kind, x.Data)), "%s & %s %s%s", rv.field, formatValue(valueKindRaw, rv.mask), op, formatValue(rv.kind, x.Data))
+			return
+		}
+		prefix := jsonObject{"prefix": jsonObject{"addr": jsonValue(rv.kind, x.Data), "len": ones}}
+		f.emit(jsonMatch(x.Op, rv.expr, prefix), "%s %s%s/%d", rv.field, op, formatValue(rv.kind, x.Data), ones)
+	case rv.mask != nil:
+		if op == "" {
+			op = "== "
+		}
+		masked := jsonObject{"&": []interface{}{rv.expr, jsonValue(valueKindRaw, rv.mask)}}
+		f.emit(jsonMatch(x.Op, masked, jsonValue(valueKindRaw, x.Data)), "%s & %s %s%s", rv.field, formatValue(valueKindRaw, rv.mask), op, formatValue(valueKindRaw, x.Data))
+	default:
+		f.emit(jsonMatch(x.Op, rv.expr, jsonValue(rv.kind, x.Data)), "%s %s%s", rv.field, op, formatValue(rv.kind, x.Data))
+	}
+}
+
+// portRange returns the port or the port range held by the provided
+// registers of a nat or a masquerade expression.
+func (f *ruleFormatter) portRange(min, max uint32) string {
+	s := f.operand(min, valueKindDecimal)
+	if max != 0 && max != min {
+		if end := f.operand(max, valueKindDecimal); end != s {
+			s += "-" + end
+		}
+	}
+	return s
+}
+
+func (f *ruleFormatter) formatNAT(x *expr.NAT) string {
+	s := "snat"
+	if x.Type == expr.NATTypeDestNAT {
+		s = "dnat"
+	}
+	addr := ""
+	if x.RegAddrMin != 0 {
+		addr = f.operand(x.RegAddrMin, valueKindAddr)
+		if x.RegAddrMax != 0 && x.RegAddrMax != x.RegAddrMin {
+			addr += "-" + f.operand(x.RegAddrMax, valueKindAddr)
+		}
+	}
+	if x.RegProtoMin != 0 {
+		if x.Family == unix.NFPROTO_IPV6 && addr != "" {
+			addr = "[" + addr + "]"
+		}
+		addr += ":" + f.portRange(x.RegProtoMin, x.RegProtoMax)
+	}
+	return s + " to " + addr + formatNatFlags(x.Random, x.FullyRandom, x.Persistent)
+}
+
+func (f *ruleFormatter) formatReject(x *expr.Reject) string {
+	switch x.Type {
+	case unix.NFT_REJECT_TCP_RST:
+		return "reject with tcp reset"
+	case unix.NFT_REJECT_ICMPX_UNREACH:
+		return fmt.Sprintf("reject with icmpx type %d", x.Code)
+	}
+	v := f.v
+	if v == "" {
+		v = "4"
+	}
+	proto := "icmp"
+	if v == "6" {
+		proto = "icmpv6"
+	}
+	name, exists := icmpRejectCodes[v][x.Code]
+	switch {
+	case name == "port-unreachable":
+		return "reject"
+	case exists:
+		return fmt.Sprintf("reject with %s type %s", proto, name)
+	}
+	return fmt.Sprintf("reject with %s type %d", proto, x.Code)
+}
+
+func formatNatFlags(random, fullyRandom, persistent bool) string {
+	flags := natFlags(random, fullyRandom, persistent)
+	if len(flags) == 0 {
+		return ""
+	}
+	return " " + strings.Join(flags, ",")
+}
+
+func natFlags(random, fullyRandom, persistent bool) []string {
+	flags := []string{}
+	if random {
+		flags = append(flags, "random")
+	}
+	if fullyRandom {
+		flags = append(flags, "fully-random")
+	}
+	if persistent {
+		flags = append(flags, "persistent")
+	}
+	return flags
+}
+
+func formatFib(x *expr.Fib) string {
+	return "fib " + strings.Join(fibFlags(x), " . ") + " " + fibResult(x)
+}
+
+func fibFlags(x *expr.Fib) []string {
+	flags := []string{}
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{
+		{x.FlagSADDR, "saddr"},
+		{x.FlagDADDR, "daddr"},
+		{x.FlagMARK, "mark"},
+		{x.FlagIIF, "iif"},
+		{x.FlagOIF, "oif"},
+	} {
+		if flag.set {
+			flags = append(flags, flag.name)
+		}
+	}
+	return flags
+}
+
+func fibResult(x *expr.Fib) string {
+	switch {
+	case x.ResultOIF:
+		return "oif"
+	case x.ResultOIFNAME:
+		return "oifname"
+	}
+	return "type"
+}
+
+func formatLimit(x *expr.Limit) string {
+	s := "limit rate "
+	if x.Over {
+		s += "over "
+	}
+	unit := limitUnitNames[x.Unit]
+	if x.Type == expr.LimitTypePktBytes {
+		s += fmt.Sprintf("%d bytes/%s", x.Rate, unit)
+		if x.Burst != 0 {
+			s += fmt.Sprintf(" burst %d bytes", x.Burst)
+		}
+		return s
+	}
+	s += fmt.Sprintf("%d/%s", x.Rate, unit)
+	if x.Burst != 0 {
+		s += fmt.Sprintf(" burst %d packets", x.Burst)
+	}
+	return s
+}
+
+func formatLog(x *expr.Log) string {
+	s := "log"
+	if x.Key&(1<<unix.NFTA_LOG_PREFIX) != 0 {
+		s += fmt.Sprintf(" prefix %q", strings.TrimRight(string(x.Data), "\x00"))
+	}
+	if x.Key&(1<<unix.NFTA_LOG_GROUP) != 0 {
+		s += fmt.Sprintf(" group %d", x.Group)
+	}
+	if x.Key&(1<<unix.NFTA_LOG_SNAPLEN) != 0 {
+		s += fmt.Sprintf(" snaplen %d", x.Snaplen)
+	}
+	if x.Key&(1<<unix.NFTA_LOG_LEVEL) != 0 {
+		for name, level := range logLevels {
+			if level == x.Level && name != "warning" {
+				s += " level " + name
+				break
+			}
+		}
+	}
+	return s
+}
+
+func formatVerdict(x *expr.Verdict) string {
+	switch x.Kind {
+	case expr.VerdictAccept:
+		return "accept"
+	case expr.VerdictDrop:
+		return "drop"
+	case expr.VerdictReturn:
+		return "return"
+	case expr.VerdictContinue:
+		return "continue"
+	case expr.VerdictJump:
+		return "jump " + x.Chain
+	case expr.VerdictGoto:
+		return "goto " + x.Chain
+	case expr.VerdictQueue:
+		return "queue"
+	}
+	return fmt.Sprintf("[verdict %d]", x.Kind)
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// formatValue returns the data compared to or assigned from a register as
+// an nft value of the provided kind.
+func formatValue(kind int, data []byte) string {
+	switch kind {
+	case valueKindInterface:
+		if i := strings.IndexByte(string(data), 0); i >= 0 {
+			return fmt.Sprintf("%q", string(data[:i]))
+		}
+		// The names without the null terminator match as a prefix.
+		return fmt.Sprintf("%q", string(data)+"*")
+	case valueKindAddr:
+		if len(data) == net.IPv4len || len(data) == net.IPv6len {
+			return net.IP(data).String()
+		}
+	case valueKindLinkAddr:
+		if len(data) == 6 {
+			return net.HardwareAddr(data).String()
+		}
+	case valueKindDecimal:
+		switch len(data) {
+		case 1:
+			return fmt.Sprintf("%d", data[0])
+		case 2:
+			return fmt.Sprintf("%d", binaryutil.BigEndian.Uint16(data))
+		case 4:
+			return fmt.Sprintf("%d", binaryutil.BigEndian.Uint32(data))
+		}
+	case valueKindProtocol:
+		if len(data) == 1 {
+			if name, exists := l4ProtocolNames[data[0]]; exists {
+				return name
+			}
+			return fmt.Sprintf("%d", data[0])
+		}
+	case valueKindEtherType:
+		if len(data) == 2 {
+			switch binaryutil.BigEndian.Uint16(data) {
+			case unix.ETH_P_IP:
+				return "ip"
+			case unix.ETH_P_IPV6:
+				return "ip6"
+			case unix.ETH_P_ARP:
+				return "arp"
+			}
+		}
+	case valueKindNfProto:
+		if len(data) == 1 {
+			switch data[0] {
+			case unix.NFPROTO_IPV4:
+				return "ipv4"
+			case unix.NFPROTO_IPV6:
+				return "ipv6"
+			}
+		}
+	case valueKindMark:
+		if len(data) == 4 {
+			return fmt.Sprintf("0x%08x", binaryutil.NativeEndian.Uint32(data))
+		}
+	case valueKindCtState:
+		if len(data) == 4 {
+			bits := binaryutil.NativeEndian.Uint32(data)
+			states := []string{}
+			for _, state := range ctStateNames {
+				if bits&state.bit != 0 {
+					states = append(states, state.name)
+				}
+			}
+			if len(states) > 0 {
+				return strings.Join(states, ",")
+			}
+		}
+	case valueKindCtDirection:
+		if len(data) == 1 {
+			switch data[0] {
+			case 0:
+				return "original"
+			case ctDirectionReply:
+				return "reply"
+			}
+		}
+	case valueKindAddrType:
+		if len(data) == 4 {
+			if name, exists := addrTypeNames[byte(binaryutil.NativeEndian.Uint32(data))]; exists {
+				return name
+			}
+		}
+	case valueKindPktType:
+		if len(data) == 1 {
+			if name, exists := pktTypeNames[data[0]]; exists {
+				return name
+			}
+		}
+	}
+	return "0x" + hex.EncodeToString(data)
+}
@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+var cmpOpJSONNames = map[expr.CmpOp]string{
+	expr.CmpOpEq:  "==",
+	expr.CmpOpNeq: "!=",
+	expr.CmpOpLt:  "<",
+	expr.CmpOpLte: "<=",
+	expr.CmpOpGt:  ">",
+	expr.CmpOpGte: ">=",
+}
+
+// jsonField returns a field printed by the rule formatter, e.g. `ip saddr`,
+// `iifname` or `@nh,96,32`, as a libnftables JSON expression.
+func jsonField(name string) interface{} {
+	switch name {
+	case "iif", "oif", "iifname", "oifname":
+		return jsonObject{"meta": jsonObject{"key": name}}
+	}
+	switch {
+	case strings.HasPrefix(name, "meta "):
+		return jsonObject{"meta": jsonObject{"key": strings.TrimPrefix(name, "meta ")}}
+	case strings.HasPrefix(name, "ct "):
+		return jsonObject{"ct": jsonObject{"key": strings.TrimPrefix(name, "ct ")}}
+	case strings.HasPrefix(name, "@"):
+		parts := strings.Split(strings.TrimPrefix(name, "@"), ",")
+		if len(parts) == 3 {
+			offset, _ := strconv.Atoi(parts[1])
+			length, _ := strconv.Atoi(parts[2])
+			return jsonObject{"payload": jsonObject{"base": parts[0], "offset": offset, "len": length}}
+		}
+	}
+	if protocol, field, found := strings.Cut(name, " "); found {
+		return jsonObject{"payload": jsonObject{"protocol": protocol, "field": field}}
+	}
+	return name
+}
+
+// jsonValue returns the data compared to or assigned from a register as a
+// libnftables JSON value of the provided kind.
+func jsonValue(kind int, data []byte) interface{} {
+	s := formatValue(kind, data)
+	if strings.HasPrefix(s, "0x") && kind != valueKindMark {
+		if len(data) > 0 && len(data) <= 8 {
+			var n uint64
+			for _, b := range data {
+				n = n<<8 | uint64(b)
+			}
+			return n
+		}
+		return s
+	}
+	switch kind {
+	case valueKindInterface:
+		name, err := strconv.Unquote(s)
+		if err == nil {
+			return name
+		}
+	case valueKindDecimal:
+		if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return n
+		}
+	case valueKindMark:
+		if n, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64); err == nil {
+			return n
+		}
+	case valueKindCtState:
+		if states := strings.Split(s, ","); len(states) > 1 {
+			return states
+		}
+	}
+	return s
+}
+
+func jsonMatch(op expr.CmpOp, left, right interface{}) jsonObject {
+	return jsonObject{"match": jsonObject{"op": cmpOpJSONNames[op], "left": left, "right": right}}
+}
+
+func fibJSON(x *expr.Fib) jsonObject {
+	return jsonObject{"fib": jsonObject{"flags": fibFlags(x), "result": fibResult(x)}}
+}
+
+// portRangeJSON returns the port or the port range held by the provided
+// registers of a nat or a masquerade expression as a libnftables JSON
+// value.
+func (f *ruleFormatter) portRangeJSON(min, max uint32) interface{} {
+	start := f.operandJSON(min, valueKindDecimal)
+	if max != 0 && max != min && f.operand(max, valueKindDecimal) != f.operand(min, valueKindDecimal) {
+		return jsonObject{"range": []interface{}{start, f.operandJSON(max, valueKindDecimal)}}
+	}
+	return start
+}
+
+func (f *ruleFormatter) natJSON(x *expr.NAT) jsonObject {
+	name := "snat"
+	if x.Type == expr.NATTypeDestNAT {
+		name = "dnat"
+	}
+	nat := jsonObject{}
+	switch x.Family {
+	case unix.NFPROTO_IPV4:
+		nat["family"] = "ip"
+	case unix.NFPROTO_IPV6:
+		nat["family"] = "ip6"
+	}
+	if x.RegAddrMin != 0 {
+		nat["addr"] = f.operandJSON(x.RegAddrMin, valueKindAddr)
+		if x.RegAddrMax != 0 && x.RegAddrMax != x.RegAddrMin {
+			nat["addr"] = jsonObject{"range": []interface{}{nat["addr"], f.operandJSON(x.RegAddrMax, valueKindAddr)}}
+		}
+	}
+	if x.RegProtoMin != 0 {
+		nat["port"] = f.portRangeJSON(x.RegProtoMin, x.RegProtoMax)
+	}
+	if flags := natFlags(x.Random, x.FullyRandom, x.Persistent); len(flags) > 0 {
+		nat["flags"] = flags
+	}
+	return jsonObject{name: nat}
+}
+
+func (f *ruleFormatter) rejectJSON(x *expr.Reject) jsonObject {
+	switch x.Type {
+	case unix.NFT_REJECT_TCP_RST:
+		return jsonObject{"reject": jsonObject{"type": "tcp reset"}}
+	case unix.NFT_REJECT_ICMPX_UNREACH:
+		return jsonObject{"reject": jsonObject{"type": "icmpx", "expr": x.Code}}
+	}
+	v := f.v
+	if v == "" {
+		v = "4"
+	}
+	proto := "icmp"
+	if v == "6" {
+		proto = "icmpv6"
+	}
+	name, exists := icmpRejectCodes[v][x.Code]
+	switch {
+	case name == "port-unreachable":
+		return jsonObject{"reject": nil}
+	case exists:
+		return jsonObject{"reject": jsonObject{"type": proto, "expr": name}}
+	}
+	return jsonObject{"reject": jsonObject{"type": proto, "expr": x.Code}}
+}
+
+func limitJSON(x *expr.Limit) jsonObject {
+	limit := jsonObject{"rate": x.Rate, "per": limitUnitNames[x.Unit]}
+	if x.Over {
+		limit["inv"] = true
+	}
+	if x.Burst != 0 {
+		limit["burst"] = x.Burst
+	}
+	if x.Type == expr.LimitTypePktBytes {
+		limit["rate_unit"] = "bytes"
+		if x.Burst != 0 {
+			limit["burst_unit"] = "bytes"
+		}
+	}
+	return jsonObject{"limit": limit}
+}
+
+func logJSON(x *expr.Log) jsonObject {
+	log := jsonObject{}
+	if x.Key&(1<<unix.NFTA_LOG_PREFIX) != 0 {
+		log["prefix"] = strings.TrimRight(string(x.Data), "\x00")
+	}
+	if x.Key&(1<<unix.NFTA_LOG_GROUP) != 0 {
+		log["group"] = x.Group
+	}
+	if x.Key&(1<<unix.NFTA_LOG_SNAPLEN) != 0 {
+		log["snaplen"] = x.Snaplen
+	}
+	if x.Key&(1<<unix.NFTA_LOG_LEVEL) != 0 {
+		for name, level := range logLevels {
+			if level == x.Level && name != "warning" {
+				log["level"] = name
+				break
+			}
+		}
+	}
+	if len(log) == 0 {
+		return jsonObject{"log": nil}
+	}
+	return jsonObject{"log": log}
+}
+
+func verdictJSON(x *expr.Verdict) jsonObject {
+	switch x.Kind {
+	case expr.VerdictJump:
+		return jsonObject{"jump": jsonObject{"target": x.Chain}}
+	case expr.VerdictGoto:
+		return jsonObject{"goto": jsonObject{"target": x.Chain}}
+	}
+	s := formatVerdict(x)
+	if strings.HasPrefix(s, "[") {
+		return jsonObject{"verdict": uint32(x.Kind)}
+	}
+	return jsonObject{s: nil}
+}
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestFormatRuleExprs(t *testing.T) {
+	_, v4Prefix, _ := net.ParseCIDR("10.88.0.0/16")
+	_, v6Host, _ := net.ParseCIDR("fd00::5/128")
+	limit := &MappingLimit{Rate: 10, Unit: "second", Burst: 5, Per: "connection", MaxConnections: 100}
+
+	var tests = []struct {
+		name  string
+		v     string
+		exprs []expr.Any
+		want  string
+	}{
+		{
+			name: "ipv4_prefix_match",
+			v:    "4",
+			exprs: append(
+				[]expr.Any{
+					&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+					&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName("cni-podman0")},
+				},
+				append(IPSaddrPrefixMatch("4", v4Prefix), &expr.Verdict{Kind: expr.VerdictAccept})...,
+			),
+			want: `iifname "cni-podman0" ip saddr 10.88.0.0/16 accept`,
+		},
+		{
+			name:  "ipv6_host_match",
+			v:     "6",
+			exprs: append(IPSaddrPrefixMatch("6", v6Host), &expr.Verdict{Kind: expr.VerdictDrop}),
+			want:  `ip6 saddr fd00::5 drop`,
+		},
+		{
+			name: "interface_prefix_match",
+			v:    "4",
+			exprs: []expr.Any{
+				&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+				&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: EncodeInterfaceNameMatch("veth*")},
+				&expr.Verdict{Kind: expr.VerdictJump, Chain: "cni-ffw-abc"},
+			},
+			want: `oifname != "veth*" jump cni-ffw-abc`,
+		},
+		{
+			name: "destination_nat",
+			v:    "4",
+			exprs: []expr.Any{
+				&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_TCP}},
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.BigEndian.PutUint16(8080)},
+				&expr.Immediate{Register: 1, Data: net.ParseIP("10.88.0.5").To4()},
+				&expr.Immediate{Register: 2, Data: binaryutil.BigEndian.PutUint16(80)},
+				&expr.NAT{Type: expr.NATTypeDestNAT, Family: unix.NFPROTO_IPV4, RegAddrMin: 1, RegProtoMin: 2},
+			},
+			want: `meta l4proto tcp tcp dport 8080 dnat to 10.88.0.5:80`,
+		},
+		{
+			name: "ipv6_destination_nat",
+			v:    "6",
+			exprs: []expr.Any{
+				&expr.Immediate{Register: 1, Data: net.ParseIP("fd00::5").To16()},
+				&expr.Immediate{Register: 2, Data: binaryutil.BigEndian.PutUint16(80)},
+				&expr.NAT{Type: expr.NATTypeDestNAT, Family: unix.NFPROTO_IPV6, RegAddrMin: 1, RegProtoMin: 2},
+			},
+			want: `dnat to [fd00::5]:80`,
+		},
+		{
+			name:  "masquerade_to_ports",
+			v:     "4",
+			exprs: SourceNatExprs("4", &SourceNatConfig{Mode: NatModeMasquerade, Ports: "1024-65535"}),
+			want:  `masquerade to :1024-65535`,
+		},
+		{
+			name:  "snat_address_range",
+			v:     "4",
+			exprs: SourceNatExprs("4", &SourceNatConfig{Mode: NatModeSnat, Addresses: []string{"203.0.113.10-203.0.113.20"}, Flags: []string{"fully-random"}}),
+			want:  `snat to 203.0.113.10-203.0.113.20 fully-random`,
+		},
+		{
+			name:  "mapping_rate_limit",
+			v:     "4",
+			exprs: MappingLimitDropRules(limit, "nat")[0],
+			want:  `ct state new limit rate over 10/second burst 5 packets counter packets 0 bytes 0 drop`,
+		},
+		{
+			name:  "mapping_connection_limit",
+			v:     "4",
+			exprs: MappingLimitDropRules(limit, "nat")[1],
+			want:  `ct state new ct count over 100 counter packets 0 bytes 0 drop`,
+		},
+		{
+			name:  "ipv4_dscp",
+			v:     "4",
+			exprs: getSetDSCPExprs("4", 46),
+			want:  `ip dscp set 46`,
+		},
+		{
+			name:  "ipv6_dscp",
+			v:     "6",
+			exprs: getSetDSCPExprs("6", 10),
+			want:  `ip6 dscp set 10`,
+		},
+		{
+			name: "named_counter_and_reject",
+			v:    "6",
+			exprs: []expr.Any{
+				verdictCounterExpr(GetContainerCounters("3f0e8b6c2a1d4e5f"), expr.VerdictDrop),
+				&expr.Reject{Type: unix.NFT_REJECT_ICMP_UNREACH, Code: 1},
+			},
+			want: `counter name "cni-drp-3f0e8b6c2a1d4e5f" reject with icmpv6 type admin-prohibited`,
+		},
+		{
+			name: "log_group_with_snaplen",
+			v:    "4",
+			exprs: []expr.Any{
+				&expr.Log{
+					Key:     1<<unix.NFTA_LOG_PREFIX | 1<<unix.NFTA_LOG_GROUP | 1<<unix.NFTA_LOG_SNAPLEN,
+					Data:    []byte("ip4 forward drop: "),
+					Group:   5,
+					Snaplen: 64,
+				},
+			},
+			want: `log prefix "ip4 forward drop: " group 5 snaplen 64`,
+		},
+		{
+			name: "unsupported_expression",
+			v:    "4",
+			exprs: []expr.Any{
+				&expr.Notrack{},
+			},
+			want: `[notrack]`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := FormatRuleExprs(test.v, test.exprs)
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+// The placements of a rule in a chain.
+const (
+	// PlaceAtHead places a rule at the top of a chain, e.g. a jump rule
+	// to the chain of a container.
+	PlaceAtHead = "head"
+	// PlaceBeforeDeny places a rule right before the rules terminating
+	// a chain, see SetFilterForwardDenyRules, or at the end of a chain
+	// without them.
+	PlaceBeforeDeny = "before_deny"
+)
+
+// isDenyRule checks whether a rule terminates the forwarding chain of
+// filter table. Besides the tagged rules, the untagged log and drop rules
+// created by the earlier versions of the plugins are terminating rules.
+func isDenyRule(r *nftables.Rule) bool {
+	comment := DecodeRuleComment(r.UserData)
+	if comment == denyRuleComment {
+		return true
+	}
+	if comment != "" {
+		return false
+	}
+	switch len(r.Exprs) {
+	case 1:
+		_, ok := r.Exprs[0].(*expr.Log)
+		return ok
+	case 2:
+		if _, ok := r.Exprs[0].(*expr.Counter); !ok {
+			return false
+		}
+		v, ok := r.Exprs[1].(*expr.Verdict)
+		return ok && v.Kind == expr.VerdictDrop
+	}
+	return false
+}
+
+// placeRule queues the addition of a rule at the provided placement in
+// a chain having the provided rules.
+func placeRule(conn *nftables.Conn, r *nftables.Rule, rules []*nftables.Rule, placement string) {
+	if placement == PlaceBeforeDeny {
+		for _, existingRule := range rules {
+			if !isDenyRule(existingRule) {
+				continue
+			}
+			r.Position = existingRule.Handle
+			conn.InsertRule(r)
+			return
+		}
+		conn.AddRule(r)
+		return
+	}
+	r.Position = 0
+	conn.InsertRule(r)
+}
+
+// isOrdered checks whether none of the provided rules follows a
+// terminating rule.
+func isOrdered(rules []*nftables.Rule) bool {
+	terminated := false
+	for _, r := range rules {
+		if isDenyRule(r) {
+			terminated = true
+			continue
+		}
+		if terminated {
+			return false
+		}
+	}
+	return true
+}
+
+// IsFilterForwardChainOrdered checks whether the rules terminating the
+// forwarding chain of filter table are at the end of the chain.
+func IsFilterForwardChainOrdered(v, tableName, chainName string) (bool, error) {
+	chainProps, err := GetChainProps(v, tableName, chainName)
+	if err != nil {
+		return false, err
+	}
+	return isOrdered(chainProps.Rules), nil
+}
+
+// RepairFilterForwardChain moves the rules terminating the forwarding chain
+// of filter table to the end of the chain, when other rules follow them,
+// e.g. the jump rules appended by the earlier versions of the plugins.
+// The moved rules are tagged. The function returns whether the chain
+// has been repaired.
+func RepairFilterForwardChain(v, tableName, chainName string) (bool, error) {
+	chainProps, err := GetChainProps(v, tableName, chainName)
+	if err != nil {
+		return false, err
+	}
+	if isOrdered(chainProps.Rules) {
+		return false, nil
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return false, err
+	}
+
+	denyRules := []*nftables.Rule{}
+	for _, r := range chainProps.Rules {
+		if !isDenyRule(r) {
+			continue
+		}
+		if err := conn.DelRule(r); err != nil {
+			return false, err
+		}
+		denyRules = append(denyRules, r)
+	}
+
+	for _, r := range denyRules {
+		conn.AddRule(&nftables.Rule{
+			Table:    r.Table,
+			Chain:    r.Chain,
+			UserData: EncodeRuleComment(denyRuleComment),
+			Exprs:    r.Exprs,
+		})
+	}
+
+	if err := conn.Flush(); err != nil {
+		return false, fmt.Errorf(
+			"failed moving default deny rules to the end of chain %s of ipv%s %s table: %s",
+			chainName, v, tableName, err,
+		)
+	}
+	return true, nil
+}
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func getTestDenyRules(handle uint64) []*nftables.Rule {
+	rules := []*nftables.Rule{}
+	for _, exprs := range getDenyRules("4", "forward", NewDenyRuleConfig()) {
+		rules = append(rules, &nftables.Rule{
+			Handle:   handle,
+			UserData: EncodeRuleComment(denyRuleComment),
+			Exprs:    exprs,
+		})
+		handle++
+	}
+	return rules
+}
+
+func TestIsDenyRule(t *testing.T) {
+	jump := []expr.Any{&expr.Verdict{Kind: expr.VerdictJump, Chain: "cni-ffw-test"}}
+	untaggedDrop := []expr.Any{&expr.Counter{}, &expr.Verdict{Kind: expr.VerdictDrop}}
+	testcases := []struct {
+		name string
+		rule *nftables.Rule
+		want bool
+	}{
+		{name: "tagged log", rule: getTestDenyRules(1)[0], want: true},
+		{name: "tagged drop", rule: getTestDenyRules(1)[1], want: true},
+		{name: "untagged log", rule: &nftables.Rule{Exprs: []expr.Any{&expr.Log{}}}, want: true},
+		{name: "untagged drop", rule: &nftables.Rule{Exprs: untaggedDrop}, want: true},
+		{name: "other comment drop", rule: &nftables.Rule{UserData: EncodeRuleComment("cni-bw"), Exprs: untaggedDrop}},
+		{name: "jump", rule: &nftables.Rule{Exprs: jump}},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isDenyRule(tc.rule); got != tc.want {
+				t.Fatalf("unexpected result: got %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIsOrdered(t *testing.T) {
+	jump := &nftables.Rule{Exprs: []expr.Any{&expr.Verdict{Kind: expr.VerdictJump, Chain: "cni-ffw-test"}}}
+	deny := getTestDenyRules(1)
+	if !isOrdered(append([]*nftables.Rule{jump}, deny...)) {
+		t.Fatal("expected the rules followed by the deny rules to be ordered")
+	}
+	if isOrdered(append(deny, jump)) {
+		t.Fatal("expected the rules following the deny rules not to be ordered")
+	}
+}
+
+func TestPlaceRule(t *testing.T) {
+	jump := &nftables.Rule{Handle: 2, Exprs: []expr.Any{&expr.Verdict{Kind: expr.VerdictJump, Chain: "cni-ffw-test"}}}
+	testcases := []struct {
+		name         string
+		rules        []*nftables.Rule
+		placement    string
+		wantAppend   bool
+		wantPosition uint64
+	}{
+		{
+			name:         "before the deny rules",
+			rules:        append([]*nftables.Rule{jump}, getTestDenyRules(5)...),
+			placement:    PlaceBeforeDeny,
+			wantPosition: 5,
+		},
+		{
+			name:       "at the end of a chain without deny rules",
+			rules:      []*nftables.Rule{jump},
+			placement:  PlaceBeforeDeny,
+			wantAppend: true,
+		},
+		{
+			name:      "at the head of a chain",
+			rules:     append([]*nftables.Rule{jump}, getTestDenyRules(5)...),
+			placement: PlaceAtHead,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var msgs []netlink.Message
+			conn := &nftables.Conn{TestDial: func(req []netlink.Message) ([]netlink.Message, error) {
+				msgs = append(msgs, req...)
+				return nil, nil
+			}}
+			tb := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+			r := &nftables.Rule{
+				Table: tb,
+				Chain: &nftables.Chain{Name: "forward", Table: tb},
+				Exprs: []expr.Any{&expr.Counter{}, &expr.Verdict{Kind: expr.VerdictAccept}},
+			}
+			placeRule(conn, r, tc.rules, tc.placement)
+			if err := conn.Flush(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			found := false
+			for _, msg := range msgs {
+				if uint16(msg.Header.Type)&0xff != unix.NFT_MSG_NEWRULE {
+					continue
+				}
+				found = true
+				if isAppend := msg.Header.Flags&netlink.HeaderFlags(unix.NLM_F_APPEND) != 0; isAppend != tc.wantAppend {
+					t.Fatalf("unexpected append flag: got %t, want %t", isAppend, tc.wantAppend)
+				}
+				attrs, err := netlink.UnmarshalAttributes(msg.Data[4:])
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				var position uint64
+				for _, attr := range attrs {
+					if attr.Type == unix.NFTA_RULE_POSITION {
+						position = binaryutil.BigEndian.Uint64(attr.Data)
+					}
+				}
+				if position != tc.wantPosition {
+					t.Fatalf("unexpected position: got %d, want %d", position, tc.wantPosition)
+				}
+			}
+			if !found {
+				t.Fatal("no rule message")
+			}
+		})
+	}
+}
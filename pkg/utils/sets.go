@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/google/nftables"
+)
+
+func getAddrSet(v, tableName, setName string) *nftables.Set {
+	tb := &nftables.Table{
+		Name: tableName,
+	}
+	s := &nftables.Set{
+		Table: tb,
+		Name:  setName,
+	}
+	if v == "4" {
+		tb.Family = nftables.TableFamilyIPv4
+		s.KeyType = nftables.TypeIPAddr
+	} else {
+		tb.Family = nftables.TableFamilyIPv6
+		s.KeyType = nftables.TypeIP6Addr
+	}
+	return s
+}
+
+func encodeSetAddr(v string, ip net.IP) []byte {
+	if v == "4" {
+		return ip.To4()
+	}
+	return ip.To16()
+}
+
+// IsSetExists checks whether a named set exists.
+func IsSetExists(v, tableName, setName string) (bool, error) {
+	if err := isSupportedIPVersion(v); err != nil {
+		return false, err
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return false, err
+	}
+
+	s := getAddrSet(v, tableName, setName)
+	sets, err := conn.GetSets(s.Table)
+	if err != nil {
+		return false, err
+	}
+	for _, set := range sets {
+		if set.Name == setName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CreateAddrSet creates a named set holding IPv4 or IPv6 addresses.
+func CreateAddrSet(v, tableName, setName string) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+
+	s := getAddrSet(v, tableName, setName)
+	if err := conn.AddSet(s, []nftables.SetElement{}); err != nil {
+		return err
+	}
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed creating set %s in ipv%s %s table: %s",
+			setName, v, tableName, err,
+		)
+	}
+	return nil
+}
+
+// DeleteSet deletes a named set.
+func DeleteSet(v, tableName, setName string) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+
+	conn.DelSet(getAddrSet(v, tableName, setName))
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed deleting set %s in ipv%s %s table: %s",
+			setName, v, tableName, err,
+		)
+	}
+	return nil
+}
+
+// AddSetAddress adds an IP address to a named set.
+func AddSetAddress(v, tableName, setName string, ip net.IP) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+
+	s := getAddrSet(v, tableName, setName)
+	if err := conn.SetAddElements(s, []nftables.SetElement{
+		{Key: encodeSetAddr(v, ip)},
+	}); err != nil {
+		return err
+	}
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed adding %s to set %s in ipv%s %s table: %s",
+			ip, setName, v, tableName, err,
+		)
+	}
+	return nil
+}
+
+// DeleteSetAddress removes an IP address from a named set.
+func DeleteSetAddress(v, tableName, setName string, ip net.IP) error {
+	if err := isSupportedIPVersion(v); err != nil {
+		return err
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return err
+	}
+
+	s := getAddrSet(v, tableName, setName)
+	if err := conn.SetDeleteElements(s, []nftables.SetElement{
+		{Key: encodeSetAddr(v, ip)},
+	}); err != nil {
+		return err
+	}
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf(
+			"failed removing %s from set %s in ipv%s %s table: %s",
+			ip, setName, v, tableName, err,
+		)
+	}
+	return nil
+}
+
+// GetSetAddresses returns the IP addresses found in a named set.
+func GetSetAddresses(v, tableName, setName string) ([]net.IP, error) {
+	if err := isSupportedIPVersion(v); err != nil {
+		return nil, err
+	}
+
+	conn, err := initNftConn()
+	if err != nil {
+		return nil, err
+	}
+
+	elements, err := conn.GetSetElements(getAddrSet(v, tableName, setName))
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := []net.IP{}
+	for _, element := range elements {
+		addrs = append(addrs, net.IP(element.Key))
+	}
+	return addrs, nil
+}
@@ -9,8 +9,10 @@ import (
 	"github.com/google/nftables/expr"
 )
 
-// Add rules for masquarading traffic coming out of the conteiner. The
-// resulting rule looks like
+// Add rules for translating the source address of the traffic coming out
+// of the conteiner. The nat_mode option, i.e. masquerade, snat, or none,
+// overrides the mode of the source_nat option. Without both options, the
+// IPv4 traffic is masqueraded. The resulting rule looks like
 // iifname "<bridgeIntfName>" ip saddr <addr> counter masquerade
 func addPostRoutingSourceNatRule(opts map[string]interface{}) error {
 	v := opts["version"].(string)
@@ -18,18 +20,42 @@ func addPostRoutingSourceNatRule(opts map[string]interface{}) error {
 	chainName := opts["chain"].(string)
 	bridgeIntfName := opts["bridge_interface"].(string)
 	addr := opts["ip_address"].(*current.IPConfig)
+	var sourceNat *SourceNatConfig
+	if c, exists := opts["source_nat"]; exists {
+		sourceNat = c.(*SourceNatConfig)
+	}
 
-	if v != "4" {
+	if mode, exists := opts["nat_mode"].(string); exists && mode != "" {
+		if mode == NatModeNone {
+			return nil
+		}
+		c := &SourceNatConfig{Mode: mode}
+		if sourceNat != nil {
+			c.Addresses = sourceNat.Addresses
+			c.Ports = sourceNat.Ports
+			c.Flags = sourceNat.Flags
+		}
+		sourceNat = c
+	} else if v != "4" && sourceNat == nil {
+		return nil
+	}
+	natExprs := SourceNatExprs(v, sourceNat)
+	if len(natExprs) == 0 {
 		return nil
 	}
+
 	conn, err := initNftConn()
 	if err != nil {
 		return err
 	}
 
 	tb := &nftables.Table{
-		Name:   tableName,
-		Family: nftables.TableFamilyIPv4,
+		Name: tableName,
+	}
+	if v == "4" {
+		tb.Family = nftables.TableFamilyIPv4
+	} else {
+		tb.Family = nftables.TableFamilyIPv6
 	}
 
 	ch := &nftables.Chain{
@@ -54,27 +80,21 @@ func addPostRoutingSourceNatRule(opts map[string]interface{}) error {
 	})
 
 	// payload load 4b @ network header + 12 => reg 1
-	r.Exprs = append(r.Exprs, &expr.Payload{
-		DestRegister: 1,
-		Base:         expr.PayloadBaseNetworkHeader,
-		Offset:       12,
-		Len:          4,
-	})
 	// cmp eq reg 1 0x0245a8c0
-	r.Exprs = append(r.Exprs, &expr.Cmp{
-		Op:       expr.CmpOpEq,
-		Register: 1,
-		Data:     addr.Address.IP.To4(),
-	})
+	host := &net.IPNet{IP: addr.Address.IP, Mask: net.CIDRMask(32, 32)}
+	if v == "6" {
+		host.Mask = net.CIDRMask(128, 128)
+	}
+	r.Exprs = append(r.Exprs, IPSaddrPrefixMatch(v, host)...)
 
 	r.Exprs = append(r.Exprs, &expr.Counter{})
-	r.Exprs = append(r.Exprs, &expr.Masq{})
+	r.Exprs = append(r.Exprs, natExprs...)
 
 	conn.AddRule(r)
 	if err := conn.Flush(); err != nil {
 		return fmt.Errorf(
-			"failed adding source NAT rule in chain %s of ipv%s %s table for %v: %s",
-			chainName, v, tableName, addr, err,
+			"failed adding source NAT rule in chain %s of ipv%s %s table for %v: %s, rule: %s",
+			chainName, v, tableName, addr, err, FormatRuleExprs(v, r.Exprs),
 		)
 	}
 	return nil
@@ -83,6 +103,8 @@ func addPostRoutingSourceNatRule(opts map[string]interface{}) error {
 // AddPostRoutingDestNatRule adds a rule for masquarading traffic into
 // the container. The resulting rule looks like
 // oifname "<bridgeIntfName>" ip daddr <addr> counter masquerade
+// or, for IPv6,
+// oifname "<bridgeIntfName>" ip6 daddr <addr> counter masquerade
 func AddPostRoutingDestNatRule(opts map[string]interface{}) error {
 	v := opts["version"].(string)
 	tableName := opts["table"].(string)
@@ -96,8 +118,12 @@ func AddPostRoutingDestNatRule(opts map[string]interface{}) error {
 	}
 
 	tb := &nftables.Table{
-		Name:   tableName,
-		Family: nftables.TableFamilyIPv4,
+		Name: tableName,
+	}
+	if v == "4" {
+		tb.Family = nftables.TableFamilyIPv4
+	} else {
+		tb.Family = nftables.TableFamilyIPv6
 	}
 
 	ch := &nftables.Chain{
@@ -131,7 +157,7 @@ func AddPostRoutingDestNatRule(opts map[string]interface{}) error {
 					&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
 					&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: EncodeInterfaceName(bridgeIntfName)},
 
-					&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 24, Len: 4},
+					&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 24, Len: 16},
 					&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: addr.IP.To16()},
 
 					&expr.Counter{},
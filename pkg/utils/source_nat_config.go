@@ -0,0 +1,238 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// The source NAT modes of the traffic of an IP version.
+const (
+	// NatModeMasquerade translates the source address to the address of
+	// the outgoing interface.
+	NatModeMasquerade = "masquerade"
+	// NatModeSnat translates the source address to the address of the
+	// IP version in the source NAT configuration.
+	NatModeSnat = "snat"
+	// NatModeNone leaves the source address, i.e. the traffic is routed
+	// and only filtered.
+	NatModeNone = "none"
+)
+
+// SourceNatConfig holds the source NAT of the traffic of the containers
+// leaving the bridge.
+type SourceNatConfig struct {
+	// Mode is masquerade (default), i.e. the source address is the
+	// address of the outgoing interface, or snat, i.e. the source
+	// address is one of Addresses.
+	Mode string `json:"mode,omitempty"`
+	// Addresses are the addresses or address ranges, at most one per IP
+	// version, the source addresses are translated to in snat mode, e.g.
+	// 203.0.113.10 or 203.0.113.10-203.0.113.20.
+	Addresses []string `json:"addresses,omitempty"`
+	// Ports is the source port or port range, e.g. 1024-65535.
+	Ports string `json:"ports,omitempty"`
+	// Flags are the port allocation flags, i.e. random, fully-random,
+	// and persistent.
+	Flags []string `json:"flags,omitempty"`
+}
+
+// parseSourceNatAddress parses an address or an address range, e.g.
+// 203.0.113.10 or 203.0.113.10-203.0.113.20.
+func parseSourceNatAddress(s string) (net.IP, net.IP, error) {
+	arr := strings.SplitN(s, "-", 2)
+	start := net.ParseIP(strings.TrimSpace(arr[0]))
+	if start == nil {
+		return nil, nil, fmt.Errorf("invalid source nat address: %s", s)
+	}
+	end := start
+	if len(arr) == 2 {
+		end = net.ParseIP(strings.TrimSpace(arr[1]))
+		if end == nil || (end.To4() != nil) != (start.To4() != nil) {
+			return nil, nil, fmt.Errorf("invalid source nat address range: %s", s)
+		}
+	}
+	return start, end, nil
+}
+
+// ValidateSourceNatConfig checks the source NAT configuration and sets the
+// default values.
+func ValidateSourceNatConfig(c *SourceNatConfig) error {
+	if c == nil {
+		return nil
+	}
+	switch c.Mode {
+	case "":
+		c.Mode = "masquerade"
+	case "masquerade", "snat":
+	default:
+		return fmt.Errorf("unsupported source nat mode: %s", c.Mode)
+	}
+	versions := map[bool]bool{}
+	for _, s := range c.Addresses {
+		start, _, err := parseSourceNatAddress(s)
+		if err != nil {
+			return err
+		}
+		isV4 := start.To4() != nil
+		if versions[isV4] {
+			return fmt.Errorf("found more than one source nat address of the same ip version: %s", s)
+		}
+		versions[isV4] = true
+	}
+	if c.Mode == "snat" && len(c.Addresses) == 0 {
+		return fmt.Errorf("source nat mode snat requires addresses")
+	}
+	if c.Mode == "masquerade" && len(c.Addresses) > 0 {
+		return fmt.Errorf("source nat mode masquerade does not support addresses")
+	}
+	if c.Ports != "" {
+		if _, _, err := parsePolicyPort(c.Ports); err != nil {
+			return fmt.Errorf("invalid source nat ports: %s", err)
+		}
+	}
+	for _, flag := range c.Flags {
+		switch flag {
+		case "random", "fully-random", "persistent":
+		default:
+			return fmt.Errorf("unsupported source nat flag: %s", flag)
+		}
+	}
+	if c.Mode == "masquerade" && c.Ports != "" && len(c.Flags) > 0 {
+		return fmt.Errorf("source nat mode masquerade does not support both ports and flags")
+	}
+	return nil
+}
+
+// ValidateNatMode checks the source NAT mode of the provided IP version and
+// returns it. By default, the mode is snat when the source NAT
+// configuration is in snat mode and has an address of the IP version.
+// Otherwise, it is masquerade for IPv4, unless the configuration is in
+// snat mode, and none for IPv6. The routed mode is an alias of none.
+func ValidateNatMode(v, mode string, c *SourceNatConfig) (string, error) {
+	if err := isSupportedIPVersion(v); err != nil {
+		return "", err
+	}
+	switch mode {
+	case "":
+		mode = NatModeNone
+		if c != nil && c.Mode == NatModeSnat {
+			if start, _ := getSourceNatAddressRange(v, c); start != nil {
+				mode = NatModeSnat
+			}
+		} else if v == "4" {
+			mode = NatModeMasquerade
+		}
+	case "routed":
+		mode = NatModeNone
+	case NatModeMasquerade, NatModeSnat, NatModeNone:
+	default:
+		return "", fmt.Errorf("unsupported ipv%s nat mode: %s", v, mode)
+	}
+	switch mode {
+	case NatModeSnat:
+		if c == nil {
+			return "", fmt.Errorf("ipv%s nat mode snat requires source nat config", v)
+		}
+		if start, _ := getSourceNatAddressRange(v, c); start == nil {
+			return "", fmt.Errorf("ipv%s nat mode snat requires an ipv%s source nat address", v, v)
+		}
+	case NatModeMasquerade:
+		if c != nil && c.Ports != "" && len(c.Flags) > 0 {
+			return "", fmt.Errorf("ipv%s nat mode masquerade does not support both ports and flags", v)
+		}
+	}
+	return mode, nil
+}
+
+// getSourceNatAddressRange returns the address range of the provided IP
+// version, if any.
+func getSourceNatAddressRange(v string, c *SourceNatConfig) (net.IP, net.IP) {
+	for _, s := range c.Addresses {
+		start, end, err := parseSourceNatAddress(s)
+		if err != nil {
+			continue
+		}
+		if (start.To4() != nil) != (v == "4") {
+			continue
+		}
+		if v == "4" {
+			return start.To4(), end.To4()
+		}
+		return start.To16(), end.To16()
+	}
+	return nil, nil
+}
+
+// SourceNatExprs returns the exprs translating the source address of the
+// packets of the provided IP version, e.g. `snat to 203.0.113.10:1024-65535
+// fully-random` or `masquerade random`. In snat mode, the function returns
+// no exprs when there is no address of the provided IP version.
+func SourceNatExprs(v string, c *SourceNatConfig) []expr.Any {
+	if c == nil {
+		return []expr.Any{&expr.Masq{}}
+	}
+
+	flags := map[string]bool{}
+	for _, flag := range c.Flags {
+		flags[flag] = true
+	}
+
+	exprs := []expr.Any{}
+	var portMin, portMax uint16
+	if c.Ports != "" {
+		portMin, portMax, _ = parsePolicyPort(c.Ports)
+	}
+
+	if c.Mode == "masquerade" {
+		e := &expr.Masq{
+			Random:      flags["random"],
+			FullyRandom: flags["fully-random"],
+			Persistent:  flags["persistent"],
+		}
+		if c.Ports != "" {
+			exprs = append(exprs,
+				&expr.Immediate{Register: 1, Data: binaryutil.BigEndian.PutUint16(portMin)},
+				&expr.Immediate{Register: 2, Data: binaryutil.BigEndian.PutUint16(portMax)},
+			)
+			e.ToPorts = true
+			e.RegProtoMin = 1
+			e.RegProtoMax = 2
+		}
+		return append(exprs, e)
+	}
+
+	start, end := getSourceNatAddressRange(v, c)
+	if start == nil {
+		return nil
+	}
+	e := &expr.NAT{
+		Type:        expr.NATTypeSourceNAT,
+		Family:      unix.NFPROTO_IPV4,
+		RegAddrMin:  1,
+		Random:      flags["random"],
+		FullyRandom: flags["fully-random"],
+		Persistent:  flags["persistent"],
+	}
+	if v == "6" {
+		e.Family = unix.NFPROTO_IPV6
+	}
+	exprs = append(exprs, &expr.Immediate{Register: 1, Data: start})
+	if !start.Equal(end) {
+		exprs = append(exprs, &expr.Immediate{Register: 2, Data: end})
+		e.RegAddrMax = 2
+	}
+	if c.Ports != "" {
+		exprs = append(exprs,
+			&expr.Immediate{Register: 3, Data: binaryutil.BigEndian.PutUint16(portMin)},
+			&expr.Immediate{Register: 4, Data: binaryutil.BigEndian.PutUint16(portMax)},
+		)
+		e.RegProtoMin = 3
+		e.RegProtoMax = 4
+	}
+	return append(exprs, e)
+}
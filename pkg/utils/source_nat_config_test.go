@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestValidateNatMode(t *testing.T) {
+	snat := &SourceNatConfig{Mode: "snat", Addresses: []string{"203.0.113.10"}}
+	snat6 := &SourceNatConfig{Mode: "snat", Addresses: []string{"2001:db8::10"}}
+	masquerade := &SourceNatConfig{Mode: "masquerade", Flags: []string{"fully-random"}}
+	testcases := []struct {
+		name      string
+		version   string
+		mode      string
+		sourceNat *SourceNatConfig
+		want      string
+		shouldErr bool
+	}{
+		{name: "ipv4 masquerade by default", version: "4", want: NatModeMasquerade},
+		{name: "ipv6 routed by default", version: "6", want: NatModeNone},
+		{name: "ipv4 masquerade with flags", version: "4", sourceNat: masquerade, want: NatModeMasquerade},
+		{name: "ipv6 routed with masquerade flags", version: "6", sourceNat: masquerade, want: NatModeNone},
+		{name: "routed alias", version: "4", mode: "routed", want: NatModeNone},
+		{name: "ipv4 snat", version: "4", sourceNat: snat, want: NatModeSnat},
+		{name: "ipv6 routed with ipv4 snat", version: "6", sourceNat: snat, want: NatModeNone},
+		{name: "ipv6 snat", version: "6", sourceNat: snat6, want: NatModeSnat},
+		{name: "ipv4 routed with ipv6 snat", version: "4", sourceNat: snat6, want: NatModeNone},
+		{name: "ipv6 snat without ipv6 address", version: "6", mode: NatModeSnat, sourceNat: snat, shouldErr: true},
+		{name: "snat without source nat", version: "6", mode: NatModeSnat, shouldErr: true},
+		{
+			name:      "masquerade with ports and flags",
+			version:   "6",
+			mode:      NatModeMasquerade,
+			sourceNat: &SourceNatConfig{Mode: "snat", Addresses: []string{"2001:db8::10"}, Ports: "1024-65535", Flags: []string{"random"}},
+			shouldErr: true,
+		},
+		{name: "unsupported mode", version: "6", mode: "nat66", shouldErr: true},
+		{name: "unsupported version", version: "5", shouldErr: true},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ValidateNatMode(tc.version, tc.mode, tc.sourceNat)
+			if tc.shouldErr {
+				if err == nil {
+					t.Fatalf("expected error, got mode %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tc.want {
+				t.Fatalf("unexpected mode: got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	current "github.com/containernetworking/cni/pkg/types/040"
+)
+
+// getSourceNatRuleCommands returns the nft commands adding the source NAT
+// rule of the provided address to a nat table without rules.
+func getSourceNatRuleCommands(t *testing.T, opts map[string]interface{}) []string {
+	t.Helper()
+	v := opts["version"].(string)
+	return getDryRunCommands(t, func() error {
+		if err := CreateTable(v, "nat"); err != nil {
+			return err
+		}
+		if err := CreateChain(v, "nat", "cni-npo-test", "none", "none", "none"); err != nil {
+			return err
+		}
+		return addPostRoutingSourceNatRule(opts)
+	})
+}
+
+func getTestIPConfig(s string) *current.IPConfig {
+	ip, ipNet, _ := net.ParseCIDR(s)
+	return &current.IPConfig{Address: net.IPNet{IP: ip, Mask: ipNet.Mask}}
+}
+
+func TestAddPostRoutingSourceNatRule(t *testing.T) {
+	testcases := []struct {
+		name      string
+		natMode   string
+		sourceNat *SourceNatConfig
+		want      string
+	}{
+		{
+			name: "masquerade by default",
+			want: `add rule ip nat cni-npo-test iifname "cni-podman0" ip saddr 10.88.0.5 counter packets 0 bytes 0 masquerade`,
+		},
+		{
+			name:      "masquerade with flags",
+			sourceNat: &SourceNatConfig{Mode: "masquerade", Flags: []string{"random", "persistent"}},
+			want:      `add rule ip nat cni-npo-test iifname "cni-podman0" ip saddr 10.88.0.5 counter packets 0 bytes 0 masquerade random,persistent`,
+		},
+		{
+			name:      "masquerade to ports",
+			sourceNat: &SourceNatConfig{Mode: "masquerade", Ports: "1024-65535"},
+			want:      `add rule ip nat cni-npo-test iifname "cni-podman0" ip saddr 10.88.0.5 counter packets 0 bytes 0 masquerade to :1024-65535`,
+		},
+		{
+			name:      "snat to address",
+			sourceNat: &SourceNatConfig{Mode: "snat", Addresses: []string{"203.0.113.10", "2001:db8::10"}},
+			want:      `add rule ip nat cni-npo-test iifname "cni-podman0" ip saddr 10.88.0.5 counter packets 0 bytes 0 snat to 203.0.113.10`,
+		},
+		{
+			name: "snat to address range and ports",
+			sourceNat: &SourceNatConfig{
+				Mode:      "snat",
+				Addresses: []string{"203.0.113.10-203.0.113.20"},
+				Ports:     "1024-65535",
+				Flags:     []string{"fully-random"},
+			},
+			want: `add rule ip nat cni-npo-test iifname "cni-podman0" ip saddr 10.88.0.5 counter packets 0 bytes 0 snat to 203.0.113.10-203.0.113.20:1024-65535 fully-random`,
+		},
+		{
+			name:      "nat mode overrides source nat mode",
+			natMode:   NatModeMasquerade,
+			sourceNat: &SourceNatConfig{Mode: "snat", Addresses: []string{"203.0.113.10"}},
+			want:      `add rule ip nat cni-npo-test iifname "cni-podman0" ip saddr 10.88.0.5 counter packets 0 bytes 0 masquerade`,
+		},
+		{
+			name:      "nat mode none",
+			natMode:   NatModeNone,
+			sourceNat: &SourceNatConfig{Mode: "masquerade"},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := map[string]interface{}{
+				"version":          "4",
+				"table":            "nat",
+				"chain":            "cni-npo-test",
+				"bridge_interface": "cni-podman0",
+				"ip_address":       getTestIPConfig("10.88.0.5/16"),
+				"nat_mode":         tc.natMode,
+			}
+			if tc.sourceNat != nil {
+				opts["source_nat"] = tc.sourceNat
+			}
+			want := []string{"add table ip nat", "add chain ip nat cni-npo-test"}
+			if tc.want != "" {
+				want = append(want, tc.want)
+			}
+			got := getSourceNatRuleCommands(t, opts)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("unexpected rules\ngot:\n%#v\nwant:\n%#v", got, want)
+			}
+		})
+	}
+}
+
+func TestAddPostRoutingNoMasqueradeRule(t *testing.T) {
+	destinations := map[string][]string{
+		"4": {"192.0.2.1", "10.0.0.0/8", "10.1.0.0/16"},
+		"6": {"fd00::/8"},
+	}
+	addrs := map[string]string{"4": "10.88.0.5/16", "6": "fd00::5/64"}
+	got := getDryRunCommands(t, func() error {
+		for _, v := range []string{"4", "6"} {
+			if err := CreateTable(v, "nat"); err != nil {
+				return err
+			}
+			if err := CreateChain(v, "nat", "cni-npo-test", "none", "none", "none"); err != nil {
+				return err
+			}
+			if err := SetNoMasqueradeDestinations(v, "nat", "cni-nomasq", destinations[v]); err != nil {
+				return err
+			}
+			opts := map[string]interface{}{
+				"version":           v,
+				"table":             "nat",
+				"chain":             "cni-npo-test",
+				"bridge_interface":  "cni-podman0",
+				"ip_address":        getTestIPConfig(addrs[v]),
+				"no_masquerade_set": "cni-nomasq",
+			}
+			if err := addPostRoutingNoMasqueradeRule(opts); err != nil {
+				return err
+			}
+			// The traffic left as is by the nat mode has no exception.
+			opts["nat_mode"] = NatModeNone
+			if err := addPostRoutingNoMasqueradeRule(opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	want := []string{
+		"add table ip nat",
+		"add chain ip nat cni-npo-test",
+		"add set ip nat cni-nomasq { type ipv4_addr; flags interval; }",
+		"add element ip nat cni-nomasq { 10.0.0.0/8, 192.0.2.1 }",
+		`add rule ip nat cni-npo-test iifname "cni-podman0" ip saddr 10.88.0.5 ip daddr @cni-nomasq counter packets 0 bytes 0 return`,
+		"add table ip6 nat",
+		"add chain ip6 nat cni-npo-test",
+		"add set ip6 nat cni-nomasq { type ipv6_addr; flags interval; }",
+		"add element ip6 nat cni-nomasq { fd00::/8 }",
+		`add rule ip6 nat cni-npo-test iifname "cni-podman0" ip6 saddr fd00::5 ip6 daddr @cni-nomasq counter packets 0 bytes 0 return`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected rules\ngot:\n%#v\nwant:\n%#v", got, want)
+	}
+}
+
+func TestAddPostRoutingSourceNatRuleIPv6(t *testing.T) {
+	testcases := []struct {
+		name      string
+		natMode   string
+		sourceNat *SourceNatConfig
+		want      string
+	}{
+		{
+			name: "routed by default",
+		},
+		{
+			name:    "masquerade",
+			natMode: NatModeMasquerade,
+			want:    `add rule ip6 nat cni-npo-test iifname "cni-podman0" ip6 saddr fd00::5 counter packets 0 bytes 0 masquerade`,
+		},
+		{
+			name:      "masquerade of source nat",
+			sourceNat: &SourceNatConfig{Mode: "masquerade", Flags: []string{"fully-random"}},
+			want:      `add rule ip6 nat cni-npo-test iifname "cni-podman0" ip6 saddr fd00::5 counter packets 0 bytes 0 masquerade fully-random`,
+		},
+		{
+			name:      "snat to address",
+			natMode:   NatModeSnat,
+			sourceNat: &SourceNatConfig{Mode: "masquerade", Addresses: []string{"203.0.113.10", "2001:db8::10"}},
+			want:      `add rule ip6 nat cni-npo-test iifname "cni-podman0" ip6 saddr fd00::5 counter packets 0 bytes 0 snat to 2001:db8::10`,
+		},
+		{
+			name:      "snat without ipv6 address",
+			sourceNat: &SourceNatConfig{Mode: "snat", Addresses: []string{"203.0.113.10"}},
+		},
+		{
+			name:      "routed with source nat",
+			natMode:   NatModeNone,
+			sourceNat: &SourceNatConfig{Mode: "snat", Addresses: []string{"2001:db8::10"}},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := map[string]interface{}{
+				"version":          "6",
+				"table":            "nat",
+				"chain":            "cni-npo-test",
+				"bridge_interface": "cni-podman0",
+				"ip_address":       getTestIPConfig("fd00::5/64"),
+				"nat_mode":         tc.natMode,
+			}
+			if tc.sourceNat != nil {
+				opts["source_nat"] = tc.sourceNat
+			}
+			want := []string{"add table ip6 nat", "add chain ip6 nat cni-npo-test"}
+			if tc.want != "" {
+				want = append(want, tc.want)
+			}
+			got := getSourceNatRuleCommands(t, opts)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("unexpected rules\ngot:\n%#v\nwant:\n%#v", got, want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"fmt"
 	"io/ioutil"
 	"path"
 	"regexp"
@@ -15,6 +16,37 @@ func EncodeInterfaceName(s string) []byte {
 	return b
 }
 
+// EncodeInterfaceNameMatch returns the data matching the provided
+// interface name. The name may end with a wildcard, e.g. eth*, in
+// which case the data is the prefix of the name without the null
+// terminator, i.e. any interface starting with the prefix matches.
+func EncodeInterfaceNameMatch(s string) []byte {
+	if strings.HasSuffix(s, "*") {
+		return []byte(strings.TrimSuffix(s, "*"))
+	}
+	return EncodeInterfaceName(s)
+}
+
+// DecodeInterfaceNameMatch returns the interface name, possibly ending
+// with a wildcard, matched by the provided data, i.e. the reverse of
+// EncodeInterfaceNameMatch.
+func DecodeInterfaceNameMatch(b []byte) string {
+	if i := strings.IndexByte(string(b), 0); i >= 0 {
+		return string(b[:i])
+	}
+	return string(b) + "*"
+}
+
+// ValidateInterfaceNameMatch checks whether the provided interface name,
+// possibly ending with a wildcard, is a valid interface name.
+func ValidateInterfaceNameMatch(s string) error {
+	name := strings.TrimSuffix(s, "*")
+	if name == "" || len(name) > 15 || strings.ContainsAny(name, "*/ \t\n") {
+		return fmt.Errorf("invalid interface name: %s", s)
+	}
+	return nil
+}
+
 // GetChainName returns nftables chain name based
 // on the provided namespace and interface.
 func GetChainName(prefix, containerID string) string {
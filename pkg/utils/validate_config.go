@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// MaxNftNameLength is the maximum length of the name of a table, a chain,
+// a set, an object, or a flowtable, i.e. NFT_NAME_MAXLEN without the null
+// terminator.
+const MaxNftNameLength = 255
+
+// PluginConfigEntry is a plugin configuration read from a network
+// configuration file, i.e. a conflist or a single plugin configuration.
+type PluginConfigEntry struct {
+	// Label identifies the configuration in the file, e.g. plugins[1].
+	Label string
+	// Data is the configuration the runtime passes to the plugin, i.e.
+	// the entry of a conflist with the name and the CNI version of the
+	// network.
+	Data []byte
+}
+
+// ReadPluginConfigs reads the configurations of the plugin of the
+// provided type from a conflist or a single plugin configuration. The
+// path "-" is the standard input.
+func ReadPluginConfigs(fp, pluginType string) ([]*PluginConfigEntry, error) {
+	var data []byte
+	var err error
+	if fp == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(fp)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParsePluginConfigs(data, pluginType)
+}
+
+// ParsePluginConfigs returns the configurations of the plugin of the
+// provided type in a conflist or a single plugin configuration. The
+// entries of a conflist inherit the name and the CNI version of the
+// network, as the runtime does.
+func ParsePluginConfigs(data []byte, pluginType string) ([]*PluginConfigEntry, error) {
+	network := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &network); err != nil {
+		return nil, fmt.Errorf("failed to load conf: %v", err)
+	}
+
+	if _, isList := network["plugins"]; !isList {
+		var netType string
+		json.Unmarshal(network["type"], &netType)
+		if netType != pluginType {
+			return nil, fmt.Errorf("the type of the plugin configuration is %q, not %q", netType, pluginType)
+		}
+		return []*PluginConfigEntry{{Label: "plugin", Data: data}}, nil
+	}
+
+	var plugins []map[string]json.RawMessage
+	if err := json.Unmarshal(network["plugins"], &plugins); err != nil {
+		return nil, fmt.Errorf("failed to load plugins of conflist: %v", err)
+	}
+	entries := []*PluginConfigEntry{}
+	for i, plugin := range plugins {
+		var entryType string
+		json.Unmarshal(plugin["type"], &entryType)
+		if entryType != pluginType {
+			continue
+		}
+		for _, k := range []string{"name", "cniVersion"} {
+			if v, exists := network[k]; exists {
+				plugin[k] = v
+			}
+		}
+		b, err := json.Marshal(plugin)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &PluginConfigEntry{
+			Label: fmt.Sprintf("plugins[%d]", i),
+			Data:  b,
+		})
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("the conflist has no %q plugin", pluginType)
+	}
+	return entries, nil
+}
+
+// ConfigProblems holds the problems found in a plugin configuration.
+type ConfigProblems []string
+
+// Add records the provided error, if any, as a problem. The error
+// formatted with a prefix, e.g. "invalid dscp: %v", keeps the messages
+// the same as the ones of the plugin at runtime.
+func (p *ConfigProblems) Add(format string, err error) {
+	if err == nil {
+		return
+	}
+	if format == "" {
+		format = "%v"
+	}
+	p.Addf(format, err)
+}
+
+// Addf records a problem, unless the same problem is already recorded.
+func (p *ConfigProblems) Addf(format string, args ...interface{}) {
+	s := fmt.Sprintf(format, args...)
+	for _, problem := range *p {
+		if problem == s {
+			return
+		}
+	}
+	*p = append(*p, s)
+}
+
+// CheckNftName records a problem when the name of a table, a chain, or
+// a flowtable in the provided option is empty or longer than the kernel
+// allows.
+func (p *ConfigProblems) CheckNftName(option, name string) {
+	switch {
+	case name == "":
+		p.Addf("%s is empty", option)
+	case len(name) > MaxNftNameLength:
+		p.Addf("%s is %d characters long, the limit is %d", option, len(name), MaxNftNameLength)
+	}
+}
+
+// CheckInterfaceName records a problem when the interface name in the
+// provided option is invalid.
+func (p *ConfigProblems) CheckInterfaceName(option, name string) {
+	if err := ValidateInterfaceNameMatch(name); err != nil {
+		p.Addf("%s: %v", option, err)
+	}
+}
+
+// ConfigChain is a chain the plugin creates in one of its tables. The
+// options are the configuration options holding the names of the table
+// and the chain, e.g. filter_table_name and forward_chain_name.
+type ConfigChain struct {
+	Family      string
+	TableOption string
+	Table       string
+	ChainOption string
+	Chain       string
+}
+
+// CheckChains records a problem when two chains of the plugin are the
+// same chain of a table, or when the name of a chain is the name of one
+// of the tables of the plugin of the same family, which usually means
+// the options of the table and the chain are swapped.
+func (p *ConfigProblems) CheckChains(chains []*ConfigChain) {
+	for i, c := range chains {
+		for _, other := range chains[:i] {
+			if c.Family == other.Family && c.Table == other.Table && c.Chain == other.Chain {
+				p.Addf(
+					"%s and %s refer to the same chain %s of %s table %s",
+					other.ChainOption, c.ChainOption, c.Chain, c.Family, c.Table,
+				)
+			}
+		}
+	}
+	for _, c := range chains {
+		for _, t := range chains {
+			if c.Family == t.Family && c.Chain == t.Table {
+				p.Addf("%s %s clashes with %s %s", c.ChainOption, c.Chain, t.TableOption, t.Table)
+				break
+			}
+		}
+	}
+}
+
+// CheckNetworks records a problem for each invalid address or network
+// in the provided option.
+func (p *ConfigProblems) CheckNetworks(format string, networks []string) {
+	for _, s := range networks {
+		if _, err := ParseMappingSource(s); err != nil {
+			p.Add(format, err)
+		}
+	}
+}
+
+// WriteConfigProblems writes the problems found in the configurations
+// of a file, one per line, or that the file is valid.
+func WriteConfigProblems(w io.Writer, fp string, entries []*PluginConfigEntry, problems []ConfigProblems) int {
+	count := 0
+	for i, entry := range entries {
+		for _, problem := range problems[i] {
+			fmt.Fprintf(w, "%s: %s: %s\n", fp, entry.Label, problem)
+			count++
+		}
+	}
+	if count == 0 {
+		fmt.Fprintf(w, "%s: valid\n", fp)
+	}
+	return count
+}